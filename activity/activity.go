@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sync"
 
+	"github.com/qor/oss"
 	"github.com/qor5/admin/presets"
 	"github.com/qor5/web"
 	"gorm.io/gorm"
@@ -33,8 +35,19 @@ type ActivityBuilder struct {
 	lmb      *presets.ModelBuilder // log model builder
 	logModel ActivityLogInterface  // log model
 
-	models     []*ModelBuilder                   // registered model builders
-	tabHeading func(ActivityLogInterface) string // tab heading format
+	models        []*ModelBuilder                   // registered model builders
+	tabHeading    func(ActivityLogInterface) string // tab heading format
+	diffRenderers map[string]DiffValueRenderer      // diff value renderers by type
+	presetSaving  *sync.Map                         // objects being saved or deleted by the presets, skipped by the plugin
+	tabPerPage    int                               // logs per page of the activity tab
+	hashChain     bool                              // chain the logs by hashes
+	sinks         []*SinkBuilder                    // sinks the saved logs are emitted to
+	commitHooks   *sync.Map                         // the emits held until the transactions are committed, by the conn pools of the transactions
+	auditSecret   []byte                            // the key of the audit report hashes
+
+	archiveStorage   oss.StorageInterface // storage of the archived logs
+	archiveDir       string               // dir of the archived logs in the storage
+	archiveBatchSize int                  // logs per archive file
 }
 
 // @snippet_end
@@ -44,6 +57,9 @@ func New(b *presets.Builder, db *gorm.DB, logModel ...ActivityLogInterface) *Act
 		db:                db,
 		creatorContextKey: CreatorContextKey,
 		dbContextKey:      DBContextKey,
+		presetSaving:      &sync.Map{},
+		commitHooks:       &sync.Map{},
+		tabPerPage:        10,
 	}
 
 	if len(logModel) > 0 {
@@ -52,7 +68,7 @@ func New(b *presets.Builder, db *gorm.DB, logModel ...ActivityLogInterface) *Act
 		ab.logModel = &ActivityLog{}
 	}
 
-	if err := db.AutoMigrate(ab.logModel); err != nil {
+	if err := db.AutoMigrate(ab.logModel, &ActivityLogChainHead{}, &ActivityLogChainAnchor{}, &ActivityLogLock{}); err != nil {
 		panic(err)
 	}
 
@@ -100,6 +116,41 @@ func (ab ActivityBuilder) GetCustomizeActivityLogs(m interface{}, db *gorm.DB) i
 	return logs
 }
 
+// GetCustomizeActivityLogsByPage get a page of the customize activity logs from the latest, and the number of all the logs
+func (ab ActivityBuilder) GetCustomizeActivityLogsByPage(m interface{}, db *gorm.DB, page, perPage int) (logs interface{}, total int64) {
+	mb, ok := ab.GetModelBuilder(m)
+	if !ok {
+		return nil, 0
+	}
+
+	if db == nil {
+		db = ab.db
+	}
+
+	logs, total, err := ab.findLogsByPage(db, mb.typ.Name(), mb.KeysValue(m), page, perPage)
+	if err != nil {
+		return nil, 0
+	}
+	return logs, total
+}
+
+func (ab ActivityBuilder) findLogsByPage(db *gorm.DB, modelName, modelKeys string, page, perPage int) (logs interface{}, total int64, err error) {
+	if page < 1 {
+		page = 1
+	}
+
+	query := func() *gorm.DB {
+		return db.Model(ab.logModel).Where("model_name = ? AND model_keys = ?", modelName, modelKeys)
+	}
+	if err = query().Count(&total).Error; err != nil {
+		return
+	}
+
+	logs = ab.NewLogModelSlice()
+	err = query().Order("id DESC").Offset((page - 1) * perPage).Limit(perPage).Find(logs).Error
+	return
+}
+
 // NewLogModelData new a log model data
 func (ab ActivityBuilder) NewLogModelData() interface{} {
 	return reflect.New(reflect.Indirect(reflect.ValueOf(ab.logModel)).Type()).Interface()
@@ -113,6 +164,12 @@ func (ab ActivityBuilder) NewLogModelSlice() interface{} {
 	return slice.Interface()
 }
 
+// SetTabPerPage set the number of logs loaded at once in the activity tab, the default is 10
+func (ab *ActivityBuilder) SetTabPerPage(n int) *ActivityBuilder {
+	ab.tabPerPage = n
+	return ab
+}
+
 // SetCreatorContextKey change the default creator context key
 func (ab *ActivityBuilder) SetCreatorContextKey(key interface{}) *ActivityBuilder {
 	ab.creatorContextKey = key
@@ -130,6 +187,15 @@ func (ab *ActivityBuilder) SetTabHeading(f func(log ActivityLogInterface) string
 	return ab
 }
 
+// RegisterDiffValueRenderer render the changed values of the type of v with f on the activity pages
+func (ab *ActivityBuilder) RegisterDiffValueRenderer(v interface{}, f DiffValueRenderer) *ActivityBuilder {
+	if ab.diffRenderers == nil {
+		ab.diffRenderers = map[string]DiffValueRenderer{}
+	}
+	ab.diffRenderers[typeName(reflect.TypeOf(v))] = f
+	return ab
+}
+
 // RegisterModels register mutiple models
 func (ab *ActivityBuilder) RegisterModels(models ...interface{}) *ActivityBuilder {
 	for _, model := range models {
@@ -174,7 +240,10 @@ func (ab *ActivityBuilder) RegisterModel(m interface{}) (mb *ModelBuilder) {
 		)
 
 		editing.SaveFunc(func(obj interface{}, id string, ctx *web.EventContext) (err error) {
-			if mb.skip&Update != 0 && mb.skip&Create != 0 {
+			ab.presetSaving.Store(obj, struct{}{})
+			defer ab.presetSaving.Delete(obj)
+
+			if mb.skip&Update != 0 && mb.skip&Create != 0 || isReverting(ctx.R.Context()) {
 				return oldSaver(obj, id, ctx)
 			}
 
@@ -195,6 +264,9 @@ func (ab *ActivityBuilder) RegisterModel(m interface{}) (mb *ModelBuilder) {
 		})
 
 		editing.DeleteFunc(func(obj interface{}, id string, ctx *web.EventContext) (err error) {
+			ab.presetSaving.Store(obj, struct{}{})
+			defer ab.presetSaving.Delete(obj)
+
 			if mb.skip&Delete != 0 {
 				return oldDeleter(obj, id, ctx)
 			}
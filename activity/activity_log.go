@@ -37,18 +37,29 @@ type ActivityLogInterface interface {
 	GetModelDiffs() string
 }
 
+// HashChainInterface is implemented by the log models that support the hash chain, see ActivityBuilder.EnableHashChain
+type HashChainInterface interface {
+	SetPrevHash(string)
+	GetPrevHash() string
+	SetHash(string)
+	GetHash() string
+}
+
 type ActivityLog struct {
 	ID         uint `gorm:"primary_key"`
 	UserID     uint
-	CreatedAt  time.Time
+	CreatedAt  time.Time `gorm:"index"`
 	Creator    string
 	Action     string
-	ModelKeys  string `gorm:"index"`
-	ModelName  string `gorm:"index"`
+	ModelKeys  string `gorm:"index;index:,composite:record,priority:2"`
+	ModelName  string `gorm:"index;index:,composite:record,priority:1"`
 	ModelLabel string
 
 	ModelLink  string
 	ModelDiffs string `sql:"type:text;"`
+
+	PrevHash string `gorm:"size:64"`
+	Hash     string `gorm:"size:64;index"`
 }
 
 func (al *ActivityLog) SetCreatedAt(t time.Time) {
@@ -125,3 +136,19 @@ func (al *ActivityLog) SetModelDiffs(s string) {
 func (al *ActivityLog) GetModelDiffs() string {
 	return al.ModelDiffs
 }
+
+func (al *ActivityLog) SetPrevHash(s string) {
+	al.PrevHash = s
+}
+
+func (al *ActivityLog) GetPrevHash() string {
+	return al.PrevHash
+}
+
+func (al *ActivityLog) SetHash(s string) {
+	al.Hash = s
+}
+
+func (al *ActivityLog) GetHash() string {
+	return al.Hash
+}
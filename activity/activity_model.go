@@ -2,7 +2,6 @@ package activity
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -15,6 +14,7 @@ import (
 	"github.com/qor5/x/i18n"
 	h "github.com/theplant/htmlgo"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // @snippet_begin(ActivityModelBuilder)
@@ -30,6 +30,10 @@ type ModelBuilder struct {
 	ignoredFields []string                     // ignored fields
 	typeHanders   map[reflect.Type]TypeHandler // type handlers
 	link          func(interface{}) string     // display the model link on the admin detail page
+	retention     RetentionPolicy              // how long the logs are kept
+	recordViews   bool                         // record the views of the presetModel
+	viewWindow    time.Duration                // the views of a user on a record within the window are recorded once
+	sensitive     bool                         // the records are sensitive, e.g. containing personal data
 }
 
 // @snippet_end
@@ -113,31 +117,14 @@ func (mb *ModelBuilder) EnableActivityInfoTab() *ModelBuilder {
 
 	editing := mb.presetModel.Editing()
 	editing.AppendTabsPanelFunc(func(obj interface{}, ctx *web.EventContext) (c h.HTMLComponent) {
-		logs := mb.activity.GetCustomizeActivityLogs(obj, mb.activity.getDBFromContext(ctx.R.Context()))
 		msgr := i18n.MustGetModuleMessages(ctx.R, I18nActivityKey, Messages_en_US).(*Messages)
 
-		logsvalues := reflect.Indirect(reflect.ValueOf(logs))
-		var panels []h.HTMLComponent
-
-		for i := 0; i < logsvalues.Len(); i++ {
-			log := logsvalues.Index(i).Interface().(ActivityLogInterface)
-			var headerText string
-			if mb.activity.tabHeading != nil {
-				headerText = mb.activity.tabHeading(log)
-			} else {
-				headerText = fmt.Sprintf("%s %s at %s", log.GetCreator(), strings.ToLower(log.GetAction()), log.GetCreatedAt().Format("2006-01-02 15:04:05 MST"))
-			}
-
-			panels = append(panels, vuetify.VExpansionPanel(
-				vuetify.VExpansionPanelHeader(h.Span(headerText)),
-				vuetify.VExpansionPanelContent(DiffComponent(log.GetModelDiffs(), ctx.R)),
-			))
-		}
-
 		return h.Components(
 			vuetify.VTab(h.Text(msgr.Activities)),
 			vuetify.VTabItem(
-				vuetify.VExpansionPanels(panels...).Attr("style", "padding:10px;"),
+				vuetify.VExpansionPanels(
+					mb.activity.tabLogs(ctx, mb.typ.Name(), mb.KeysValue(obj), 1),
+				).Attr("style", "padding:10px;"),
 			),
 		)
 	})
@@ -292,21 +279,21 @@ func (mb *ModelBuilder) AddEditRecordWithOld(creator interface{}, old, now inter
 }
 
 func (mb *ModelBuilder) addDiff(action string, creator, old, now interface{}, db *gorm.DB) error {
-	diffs, err := mb.Diff(old, now)
+	changes, err := mb.Changes(old, now)
 	if err != nil {
 		return err
 	}
 
-	if len(diffs) == 0 {
+	if len(changes) == 0 {
 		return nil
 	}
 
-	b, err := json.Marshal(diffs)
+	diffs, err := marshalDiffs(changes)
 	if err != nil {
 		return err
 	}
 
-	return mb.save(creator, ActivityEdit, now, db, string(b))
+	return mb.save(creator, action, now, db, diffs)
 }
 
 // Diff get diffs between old and now value
@@ -314,8 +301,26 @@ func (mb *ModelBuilder) Diff(old, now interface{}) ([]Diff, error) {
 	return NewDiffBuilder(mb).Diff(old, now)
 }
 
+// Changes get the typed changes between old and now value, they are stored in ModelDiffs
+func (mb *ModelBuilder) Changes(old, now interface{}) ([]Change, error) {
+	return NewDiffBuilder(mb).Changes(old, now)
+}
+
+// label is the model label of the logs
+func (mb *ModelBuilder) label() string {
+	if mb.presetModel != nil && mb.presetModel.Info().URIName() != "" {
+		return mb.presetModel.Info().URIName()
+	}
+	return "-"
+}
+
 // save log into db
 func (mb *ModelBuilder) save(creator interface{}, action string, v interface{}, db *gorm.DB, diffs string) error {
+	return mb.saveUnless(creator, action, v, db, diffs, nil)
+}
+
+// saveUnless saves the log unless dedup finds the duplicated logs, nil dedup saves it anyway
+func (mb *ModelBuilder) saveUnless(creator interface{}, action string, v interface{}, db *gorm.DB, diffs string, dedup *logDedup) error {
 	var m = mb.activity.NewLogModelData()
 	log, ok := m.(ActivityLogInterface)
 	if !ok {
@@ -337,11 +342,7 @@ func (mb *ModelBuilder) save(creator interface{}, action string, v interface{},
 	log.SetModelName(mb.typ.Name())
 	log.SetModelKeys(mb.KeysValue(v))
 
-	if mb.presetModel != nil && mb.presetModel.Info().URIName() != "" {
-		log.SetModelLabel(mb.presetModel.Info().URIName())
-	} else {
-		log.SetModelLabel("-")
-	}
+	log.SetModelLabel(mb.label())
 
 	if f := mb.link; f != nil {
 		log.SetModelLink(f(v))
@@ -351,12 +352,74 @@ func (mb *ModelBuilder) save(creator interface{}, action string, v interface{},
 		return nil
 	}
 
-	if action == ActivityEdit {
+	if diffs != "" {
 		log.SetModelDiffs(diffs)
 	}
 
-	if db.Save(log).Error != nil {
-		return db.Error
+	var committed []func()
+	save := func(tx *gorm.DB) (err error) {
+		var saved bool
+		if mb.activity.hashChain {
+			saved, err = mb.activity.saveChained(tx, log, dedup)
+		} else {
+			saved, err = mb.activity.insertLog(tx, log, dedup)
+		}
+		if err != nil || !saved {
+			return
+		}
+		committed, err = mb.activity.emitInTx(tx, log)
+		return
 	}
+	// the hash chain, the dedup lock and the TxSinks write along with the log
+	var err error
+	if mb.activity.hashChain || dedup != nil || mb.activity.hasTxSinks() {
+		err = db.Transaction(save)
+	} else {
+		err = save(db)
+	}
+	if err != nil {
+		return err
+	}
+
+	mb.activity.afterCommit(db, committed...)
 	return nil
 }
+
+// logDedup skips the log if the logs of the query of exists are found,
+// the check runs after locking the key so that the concurrent saves with the same key don't both pass it
+type logDedup struct {
+	key    string
+	exists func(db *gorm.DB) *gorm.DB
+}
+
+// insertLog inserts the log in tx, with dedup it locks the dedup key and inserts the log unless the duplicated logs are found
+func (ab ActivityBuilder) insertLog(tx *gorm.DB, log ActivityLogInterface, dedup *logDedup) (inserted bool, err error) {
+	if dedup != nil {
+		if err = lockLogKey(tx, dedup.key); err != nil {
+			return
+		}
+		var count int64
+		if err = dedup.exists(tx.Session(&gorm.Session{NewDB: true})).Count(&count).Error; err != nil || count > 0 {
+			return
+		}
+	}
+	return true, tx.Save(log).Error
+}
+
+// ActivityLogLock is a row per dedup key, the saves of the logs with the key lock it in their transactions
+// so that the deduplication holds across the processes sharing the database
+type ActivityLogLock struct {
+	LockKey  string `gorm:"primarykey;size:64"`
+	LockedAt time.Time
+}
+
+// lockLogKey creates the lock row of the key if it doesn't exist and updates it,
+// the update blocks the other transactions locking the key until tx ends
+func lockLogKey(tx *gorm.DB, key string) error {
+	now := tx.NowFunc()
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&ActivityLogLock{LockKey: key, LockedAt: now}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&ActivityLogLock{}).Where("lock_key = ?", key).Update("locked_at", now).Error
+}
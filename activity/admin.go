@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/qor5/admin/presets"
@@ -36,11 +37,31 @@ func (ab *ActivityBuilder) configureAdmin(b *presets.Builder) {
 		detailing = mb.Detailing("ModelDiffs")
 	)
 	ab.lmb = mb
+	mb.RegisterEventFunc(eventRevert, ab.eventRevert)
+	mb.RegisterEventFunc(eventActivityTabPage, ab.eventActivityTabPage)
+	mb.RegisterEventFunc(eventUserTimeline, ab.eventUserTimeline)
+	mb.RegisterEventFunc(eventVerifyHashChain, ab.eventVerifyHashChain)
+	mb.RegisterEventFunc(eventSensitiveViews, ab.eventSensitiveViews)
+	b.AddWrapHandler("activity_audit_report", ab.auditReportHandler)
 	listing.Field("CreatedAt").Label(Messages_en_US.ModelCreatedAt).ComponentFunc(
 		func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
 			return h.Td(h.Text(obj.(*ActivityLog).CreatedAt.Format("2006-01-02 15:04:05 MST")))
 		},
 	)
+	// the logs are never changed through the admin
+	listing.NewButtonFunc(func(ctx *web.EventContext) h.HTMLComponent { return nil })
+	listing.RowMenu().Empty()
+	mb.Editing().
+		SaveFunc(func(obj interface{}, id string, ctx *web.EventContext) error { return ErrActivityLogReadOnly }).
+		DeleteFunc(func(obj interface{}, id string, ctx *web.EventContext) error { return ErrActivityLogReadOnly })
+	listing.Action("VerifyHashChain").ButtonCompFunc(ab.verifyHashChainButton)
+	listing.Action("SensitiveViews").ButtonCompFunc(ab.sensitiveViewsButton)
+
+	listing.Field("Creator").ComponentFunc(
+		func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) h.HTMLComponent {
+			return h.Td(ab.userTimelineLink(obj.(ActivityLogInterface)))
+		},
+	)
 	listing.Field("ModelKeys").Label(Messages_en_US.ModelKeys)
 	listing.Field("ModelName").Label(Messages_en_US.ModelName)
 	listing.Field("ModelLabel").Label(Messages_en_US.ModelLabel).ComponentFunc(
@@ -160,7 +181,7 @@ func (ab *ActivityBuilder) configureAdmin(b *presets.Builder) {
 				),
 				vuetify.VSimpleTable(
 					h.Tbody(
-						h.Tr(h.Td(h.Text(msgr.ModelCreator)), h.Td(h.Text(record.GetCreator()))),
+						h.Tr(h.Td(h.Text(msgr.ModelCreator)), h.Td(ab.userTimelineLink(record))),
 						h.Tr(h.Td(h.Text(msgr.ModelUserID)), h.Td(h.Text(fmt.Sprintf("%v", record.GetUserID())))),
 						h.Tr(h.Td(h.Text(msgr.ModelAction)), h.Td(h.Text(record.GetAction()))),
 						h.Tr(h.Td(h.Text(msgr.ModelName)), h.Td(h.Text(record.GetModelName()))),
@@ -173,7 +194,7 @@ func (ab *ActivityBuilder) configureAdmin(b *presets.Builder) {
 			).Attr("style", "margin-top:15px;margin-bottom:15px;"))
 
 			if d := field.Value(obj).(string); d != "" {
-				detailElems = append(detailElems, DiffComponent(d, ctx.R))
+				detailElems = append(detailElems, ab.revertButtons(record, ctx), ab.DiffComponent(d, ctx.R))
 			}
 
 			return h.Components(detailElems...)
@@ -187,46 +208,71 @@ func fixSpecialChars(str string) string {
 	return str
 }
 
+// DiffComponent renders the diffs of an activity log with the default renderers
 func DiffComponent(diffstr string, req *http.Request) h.HTMLComponent {
-	var diffs []Diff
-	err := json.Unmarshal([]byte(diffstr), &diffs)
+	return renderDiffs(diffstr, req, DefaultDiffValueRenderers)
+}
+
+// DiffComponent renders the diffs of an activity log with the renderers registered on the activity builder
+func (ab *ActivityBuilder) DiffComponent(diffstr string, req *http.Request) h.HTMLComponent {
+	renderers := make(map[string]DiffValueRenderer)
+	for t, r := range DefaultDiffValueRenderers {
+		renderers[t] = r
+	}
+	for t, r := range ab.diffRenderers {
+		renderers[t] = r
+	}
+	return renderDiffs(diffstr, req, renderers)
+}
+
+func renderDiffs(diffstr string, req *http.Request, renderers map[string]DiffValueRenderer) h.HTMLComponent {
+	ds, err := ParseDiffs(diffstr)
 	if err != nil {
 		return nil
 	}
 
-	if len(diffs) == 0 {
+	if len(ds.Changes) == 0 {
 		return nil
 	}
 
 	var (
-		newdiffs    []Diff
-		changediffs []Diff
-		deletediffs []Diff
+		newdiffs    []Change
+		changediffs []Change
+		deletediffs []Change
+		movediffs   []Change
 	)
 
-	for _, diff := range diffs {
-		if diff.Now == "" && diff.Old != "" {
-			deletediffs = append(deletediffs, diff)
-			continue
-		}
-
-		if diff.Now != "" && diff.Old == "" {
-			newdiffs = append(newdiffs, diff)
-			continue
+	for _, c := range ds.Changes {
+		var (
+			old = textValue(c.Old)
+			now = textValue(c.New)
+		)
+		switch {
+		case c.Op == ChangeMove:
+			movediffs = append(movediffs, c)
+		case c.Op == ChangeAdd || old == "" && now != "":
+			newdiffs = append(newdiffs, c)
+		case c.Op == ChangeRemove || old != "" && now == "":
+			deletediffs = append(deletediffs, c)
+		case old != "" && now != "":
+			changediffs = append(changediffs, c)
 		}
+	}
 
-		if diff.Now != "" && diff.Old != "" {
-			changediffs = append(changediffs, diff)
-			continue
+	renderValue := func(c Change, value json.RawMessage) h.HTMLComponent {
+		if r := renderers[c.Type]; r != nil && !c.Text {
+			return r(value, req)
 		}
+		return h.Text(fixSpecialChars(textValue(value)))
 	}
+
 	var diffsElems []h.HTMLComponent
 	msgr := i18n.MustGetModuleMessages(req, I18nActivityKey, Messages_en_US).(*Messages)
 
 	if len(newdiffs) > 0 {
 		var elems []h.HTMLComponent
 		for _, d := range newdiffs {
-			elems = append(elems, h.Tr(h.Td(h.Text(d.Field)), h.Td(h.Text(fixSpecialChars(d.Now)))))
+			elems = append(elems, h.Tr(h.Td(h.Text(d.Field())), h.Td(renderValue(d, d.New))))
 		}
 
 		diffsElems = append(diffsElems,
@@ -242,7 +288,7 @@ func DiffComponent(diffstr string, req *http.Request) h.HTMLComponent {
 	if len(deletediffs) > 0 {
 		var elems []h.HTMLComponent
 		for _, d := range deletediffs {
-			elems = append(elems, h.Tr(h.Td(h.Text(d.Field)), h.Td(h.Text(fixSpecialChars(d.Old)))))
+			elems = append(elems, h.Tr(h.Td(h.Text(d.Field())), h.Td(renderValue(d, d.Old))))
 		}
 
 		diffsElems = append(diffsElems,
@@ -258,7 +304,7 @@ func DiffComponent(diffstr string, req *http.Request) h.HTMLComponent {
 	if len(changediffs) > 0 {
 		var elems []h.HTMLComponent
 		for _, d := range changediffs {
-			elems = append(elems, h.Tr(h.Td(h.Text(d.Field)), h.Td(h.Text(fixSpecialChars(d.Old)), h.Td(h.Text(fixSpecialChars(d.Now))))))
+			elems = append(elems, h.Tr(h.Td(h.Text(d.Field())), h.Td(renderValue(d, d.Old)), h.Td(renderValue(d, d.New))))
 		}
 
 		diffsElems = append(diffsElems,
@@ -270,5 +316,96 @@ func DiffComponent(diffstr string, req *http.Request) h.HTMLComponent {
 				),
 			).Attr("style", "margin-top:15px;margin-bottom:15px;"))
 	}
+
+	if len(movediffs) > 0 {
+		var elems []h.HTMLComponent
+		for _, d := range movediffs {
+			elems = append(elems, h.Tr(
+				h.Td(h.Text(strings.Join(d.Path[:len(d.Path)-1], "."))),
+				h.Td(h.Text(d.From[len(d.From)-1])),
+				h.Td(h.Text(d.Path[len(d.Path)-1])),
+				h.Td(renderValue(d, d.New)),
+			))
+		}
+
+		diffsElems = append(diffsElems,
+			vuetify.VCard(
+				vuetify.VCardTitle(h.Text(msgr.DiffMoved)),
+				vuetify.VSimpleTable(
+					h.Thead(h.Tr(h.Th(msgr.DiffField), h.Th(msgr.DiffFrom), h.Th(msgr.DiffTo), h.Th(msgr.DiffValue))),
+					h.Tbody(elems...),
+				),
+			).Attr("style", "margin-top:15px;margin-bottom:15px;"))
+	}
 	return h.Components(diffsElems...)
 }
+
+const eventActivityTabPage = "activity_tab_page"
+
+func tabPagePortalName(modelName, modelKeys string, page int) string {
+	return fmt.Sprintf("activity-tab-%s-%s-%d", modelName, modelKeys, page)
+}
+
+// tabLogs renders the log panels of the page of a record in the activity tab, and the portal that loads the next page
+func (ab *ActivityBuilder) tabLogs(ctx *web.EventContext, modelName, modelKeys string, page int) h.HTMLComponent {
+	msgr := i18n.MustGetModuleMessages(ctx.R, I18nActivityKey, Messages_en_US).(*Messages)
+
+	logs, total, err := ab.findLogsByPage(ab.getDBFromContext(ctx.R.Context()), modelName, modelKeys, page, ab.tabPerPage)
+	if err != nil {
+		return h.Text(err.Error())
+	}
+
+	logsvalues := reflect.Indirect(reflect.ValueOf(logs))
+	var panels []h.HTMLComponent
+	for i := 0; i < logsvalues.Len(); i++ {
+		log := logsvalues.Index(i).Interface().(ActivityLogInterface)
+		var headerText string
+		if ab.tabHeading != nil {
+			headerText = ab.tabHeading(log)
+		} else {
+			headerText = fmt.Sprintf("%s %s at %s", log.GetCreator(), strings.ToLower(log.GetAction()), log.GetCreatedAt().Format("2006-01-02 15:04:05 MST"))
+		}
+
+		panels = append(panels, vuetify.VExpansionPanel(
+			vuetify.VExpansionPanelHeader(h.Span(headerText)),
+			vuetify.VExpansionPanelContent(
+				ab.revertButtons(log, ctx),
+				ab.DiffComponent(log.GetModelDiffs(), ctx.R),
+			),
+		))
+	}
+
+	if remaining := total - int64(page*ab.tabPerPage); remaining > 0 {
+		panels = append(panels, web.Portal(
+			h.Div(
+				vuetify.VBtn(fmt.Sprintf(msgr.LoadMoreActivities, remaining)).Text(true).Small(true).Color("primary").
+					Attr("@click", web.Plaid().
+						URL(ab.lmb.Info().ListingHref()).
+						EventFunc(eventActivityTabPage).
+						Query("modelName", modelName).
+						Query("modelKeys", modelKeys).
+						Query("page", fmt.Sprint(page+1)).
+						Go()),
+			).Class("text-center mt-2"),
+		).Name(tabPagePortalName(modelName, modelKeys, page+1)))
+	}
+
+	return h.Components(panels...)
+}
+
+func (ab *ActivityBuilder) eventActivityTabPage(ctx *web.EventContext) (r web.EventResponse, err error) {
+	var (
+		modelName = ctx.R.FormValue("modelName")
+		modelKeys = ctx.R.FormValue("modelKeys")
+	)
+	page, err := strconv.Atoi(ctx.R.FormValue("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	r.UpdatePortals = append(r.UpdatePortals, &web.PortalUpdate{
+		Name: tabPagePortalName(modelName, modelKeys, page),
+		Body: ab.tabLogs(ctx, modelName, modelKeys, page),
+	})
+	return r, nil
+}
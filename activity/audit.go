@@ -0,0 +1,521 @@
+package activity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/ui/vuetify"
+	"github.com/qor5/web"
+	"github.com/qor5/x/i18n"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+)
+
+const (
+	AuditReportCSV  = "csv"
+	AuditReportJSON = "json"
+
+	auditReportQueryParam = "audit_report"
+	auditReportHashHeader = "X-Audit-Report-Hash"
+	auditDateLayout       = "2006-01-02"
+	userTimelineLimit     = 100
+	auditReportBatchSize  = 500
+)
+
+var (
+	ErrAuditReportTampered = errors.New("the audit report is tampered")
+	// ErrAuditReportSecretRequired is returned by the export and verification of the audit reports without AuditReportSecret
+	ErrAuditReportSecretRequired = errors.New("the audit report secret is not set")
+)
+
+// AuditQuery selects the activities of a user in a time range, the zero fields are not filtered
+type AuditQuery struct {
+	UserID  uint
+	Creator string
+	From    time.Time // inclusive
+	To      time.Time // exclusive
+}
+
+// ActivityCount is the number of the activities of an action on a model
+type ActivityCount struct {
+	ModelName string
+	Action    string
+	Count     int64
+}
+
+// AuditReportRow is a row of the audit report, Hash is the HMAC-SHA256 of the row with the hash of the previous row
+// keyed by AuditReportSecret, so that editing, removing or reordering the rows breaks the chain
+type AuditReportRow struct {
+	ID         uint
+	CreatedAt  time.Time
+	UserID     uint
+	Creator    string
+	Action     string
+	ModelName  string
+	ModelLabel string
+	ModelKeys  string
+	ModelDiffs string
+	PrevHash   string
+	Hash       string
+}
+
+// AuditReport is the json format of the audit report, Hash is the hash of the last row
+type AuditReport struct {
+	Rows []*AuditReportRow
+	Hash string
+}
+
+var auditReportCSVHeader = []string{"ID", "CreatedAt", "UserID", "Creator", "Action", "ModelName", "ModelLabel", "ModelKeys", "ModelDiffs", "PrevHash", "Hash"}
+
+func (q AuditQuery) scope(db *gorm.DB) *gorm.DB {
+	if q.UserID != 0 {
+		db = db.Where("user_id = ?", q.UserID)
+	}
+	if q.Creator != "" {
+		db = db.Where("creator = ?", q.Creator)
+	}
+	if !q.From.IsZero() {
+		db = db.Where("created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		db = db.Where("created_at < ?", q.To)
+	}
+	return db
+}
+
+// auditQueryFromRequest reads the query from the userID, creator, from and to params,
+// the dates are inclusive days like 2006-01-02
+func auditQueryFromRequest(r *http.Request) (q AuditQuery) {
+	if id, err := strconv.ParseUint(r.FormValue("userID"), 10, 64); err == nil {
+		q.UserID = uint(id)
+	}
+	q.Creator = r.FormValue("creator")
+	if t, err := time.ParseInLocation(auditDateLayout, r.FormValue("from"), time.Local); err == nil {
+		q.From = t
+	}
+	if t, err := time.ParseInLocation(auditDateLayout, r.FormValue("to"), time.Local); err == nil {
+		q.To = t.AddDate(0, 0, 1)
+	}
+	return
+}
+
+// GetUserActivityLogs get the activity logs of the query from the latest, a limit <= 0 loads all of them
+func (ab ActivityBuilder) GetUserActivityLogs(db *gorm.DB, q AuditQuery, limit int) (interface{}, error) {
+	if db == nil {
+		db = ab.db
+	}
+	logs := ab.NewLogModelSlice()
+	db = q.scope(db.Model(ab.logModel)).Order("created_at DESC, id DESC")
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+	if err := db.Find(logs).Error; err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// GetUserActivityCounts get the number of the activity logs of the query by model and action
+func (ab ActivityBuilder) GetUserActivityCounts(db *gorm.DB, q AuditQuery) (counts []*ActivityCount, err error) {
+	if db == nil {
+		db = ab.db
+	}
+	err = q.scope(db.Model(ab.logModel)).
+		Select("model_name, action, COUNT(*) AS count").
+		Group("model_name, action").
+		Order("model_name, action").
+		Scan(&counts).Error
+	return
+}
+
+// AuditReportSecret sets the key of the hashes of the audit reports, the reports can't be exported without it.
+// Keep it on the server, whoever has it can forge a report.
+func (ab *ActivityBuilder) AuditReportSecret(secret []byte) *ActivityBuilder {
+	ab.auditSecret = secret
+	return ab
+}
+
+// ExportAuditReport writes the activity logs of the query as the audit report in the format, from the oldest.
+// The logs are read and written in batches, it returns the hash of the last row that proves the report is complete.
+func (ab ActivityBuilder) ExportAuditReport(db *gorm.DB, q AuditQuery, format string, w io.Writer) (hash string, err error) {
+	if len(ab.auditSecret) == 0 {
+		return "", ErrAuditReportSecretRequired
+	}
+	if db == nil {
+		db = ab.db
+	}
+	rw, err := newAuditReportWriter(format, w)
+	if err != nil {
+		return
+	}
+
+	logs := ab.NewLogModelSlice()
+	err = q.scope(db.Model(ab.logModel)).FindInBatches(logs, auditReportBatchSize, func(tx *gorm.DB, batch int) error {
+		values := reflect.Indirect(reflect.ValueOf(logs))
+		for i := 0; i < values.Len(); i++ {
+			log := values.Index(i).Interface().(ActivityLogInterface)
+			row := &AuditReportRow{
+				ID:         getLogID(log),
+				CreatedAt:  log.GetCreatedAt(),
+				UserID:     log.GetUserID(),
+				Creator:    log.GetCreator(),
+				Action:     log.GetAction(),
+				ModelName:  log.GetModelName(),
+				ModelLabel: log.GetModelLabel(),
+				ModelKeys:  log.GetModelKeys(),
+				ModelDiffs: log.GetModelDiffs(),
+				PrevHash:   hash,
+			}
+			row.Hash = row.computeHash(ab.auditSecret)
+			hash = row.Hash
+			if err := rw.writeRow(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return
+	}
+	return hash, rw.close(hash)
+}
+
+// VerifyAuditReport verifies the hash chain of an audit report and returns the hash of the last row,
+// compare it with the one returned when the report was exported to find out removed rows at the end
+func (ab ActivityBuilder) VerifyAuditReport(format string, r io.Reader) (hash string, err error) {
+	if len(ab.auditSecret) == 0 {
+		return "", ErrAuditReportSecretRequired
+	}
+	var rows []*AuditReportRow
+	switch format {
+	case AuditReportJSON:
+		var report AuditReport
+		if err = json.NewDecoder(r).Decode(&report); err != nil {
+			return
+		}
+		rows = report.Rows
+		defer func() {
+			if err == nil && report.Hash != hash {
+				err = fmt.Errorf("%w: the hash of the report mismatches the last row", ErrAuditReportTampered)
+			}
+		}()
+	case AuditReportCSV:
+		if rows, err = readAuditReportCSV(r); err != nil {
+			return
+		}
+	default:
+		return "", fmt.Errorf("unknown audit report format %q", format)
+	}
+
+	for i, row := range rows {
+		if row.PrevHash != hash || !hmac.Equal([]byte(row.computeHash(ab.auditSecret)), []byte(row.Hash)) {
+			return "", fmt.Errorf("%w: row %d", ErrAuditReportTampered, i+1)
+		}
+		hash = row.Hash
+	}
+	return
+}
+
+func (row *AuditReportRow) computeHash(secret []byte) string {
+	fields := row.csvRecord()[:len(auditReportCSVHeader)-1]
+	b, _ := json.Marshal(fields)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (row *AuditReportRow) csvRecord() []string {
+	return []string{
+		fmt.Sprint(row.ID),
+		row.CreatedAt.UTC().Format(time.RFC3339Nano),
+		fmt.Sprint(row.UserID),
+		row.Creator,
+		row.Action,
+		row.ModelName,
+		row.ModelLabel,
+		row.ModelKeys,
+		row.ModelDiffs,
+		row.PrevHash,
+		row.Hash,
+	}
+}
+
+// auditReportWriter streams the rows of a report, the json format is the one of AuditReport
+type auditReportWriter struct {
+	format string
+	w      io.Writer
+	cw     *csv.Writer
+	rows   int
+}
+
+func newAuditReportWriter(format string, w io.Writer) (*auditReportWriter, error) {
+	rw := &auditReportWriter{format: format, w: w}
+	switch format {
+	case AuditReportJSON:
+		_, err := io.WriteString(w, `{"Rows":[`)
+		return rw, err
+	case AuditReportCSV:
+		rw.cw = csv.NewWriter(w)
+		return rw, rw.cw.Write(auditReportCSVHeader)
+	}
+	return nil, fmt.Errorf("unknown audit report format %q", format)
+}
+
+func (rw *auditReportWriter) writeRow(row *AuditReportRow) error {
+	rw.rows++
+	if rw.cw != nil {
+		if err := rw.cw.Write(row.csvRecord()); err != nil {
+			return err
+		}
+		// flush every batch, so that the rows are not buffered until the end
+		if rw.rows%auditReportBatchSize == 0 {
+			rw.cw.Flush()
+			return rw.cw.Error()
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if rw.rows > 1 {
+		b = append([]byte(","), b...)
+	}
+	_, err = rw.w.Write(b)
+	return err
+}
+
+func (rw *auditReportWriter) close(hash string) error {
+	if rw.cw != nil {
+		rw.cw.Flush()
+		return rw.cw.Error()
+	}
+	b, err := json.Marshal(hash)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(rw.w, `],"Hash":%s}`+"\n", b)
+	return err
+}
+
+func readAuditReportCSV(r io.Reader) (rows []*AuditReportRow, err error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || strings.Join(records[0], ",") != strings.Join(auditReportCSVHeader, ",") {
+		return nil, fmt.Errorf("%w: the header mismatches", ErrAuditReportTampered)
+	}
+	for i, record := range records[1:] {
+		if len(record) != len(auditReportCSVHeader) {
+			return nil, fmt.Errorf("%w: row %d", ErrAuditReportTampered, i+1)
+		}
+		row := &AuditReportRow{
+			Creator:    record[3],
+			Action:     record[4],
+			ModelName:  record[5],
+			ModelLabel: record[6],
+			ModelKeys:  record[7],
+			ModelDiffs: record[8],
+			PrevHash:   record[9],
+			Hash:       record[10],
+		}
+		id, err := strconv.ParseUint(record[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: row %d: %v", ErrAuditReportTampered, i+1, err)
+		}
+		if row.CreatedAt, err = time.Parse(time.RFC3339Nano, record[1]); err != nil {
+			return nil, fmt.Errorf("%w: row %d: %v", ErrAuditReportTampered, i+1, err)
+		}
+		userID, err := strconv.ParseUint(record[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: row %d: %v", ErrAuditReportTampered, i+1, err)
+		}
+		row.ID, row.UserID = uint(id), uint(userID)
+		rows = append(rows, row)
+	}
+	return
+}
+
+// auditReportHandler serves the audit report downloads on the activity listing url,
+// so that they go through the same middlewares and permissions as the admin
+func (ab *ActivityBuilder) auditReportHandler(in http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get(auditReportQueryParam)
+		if format == "" || r.Method != http.MethodGet || r.URL.Path != ab.lmb.Info().ListingHref() {
+			in.ServeHTTP(w, r)
+			return
+		}
+		if format != AuditReportCSV && format != AuditReportJSON {
+			http.NotFound(w, r)
+			return
+		}
+		if ab.lmb.Info().Verifier().Do(presets.PermList).WithReq(r).IsAllowed() != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if len(ab.auditSecret) == 0 {
+			http.Error(w, ErrAuditReportSecretRequired.Error(), http.StatusNotImplemented)
+			return
+		}
+
+		name := fmt.Sprintf("audit-report-%s.%s", time.Now().Format("20060102150405"), format)
+		if format == AuditReportCSV {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		// the report is streamed, so the hash of the last row is sent as a trailer
+		w.Header().Set("Trailer", auditReportHashHeader)
+
+		hash, err := ab.ExportAuditReport(ab.getDBFromContext(r.Context()), auditQueryFromRequest(r), format, w)
+		if err != nil {
+			// the status is sent already, abort the response so that the report is not taken as complete
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set(auditReportHashHeader, hash)
+	})
+}
+
+const eventUserTimeline = "activity_user_timeline"
+
+// userTimelineLink opens the timeline of the creator of the log
+func (ab *ActivityBuilder) userTimelineLink(log ActivityLogInterface) h.HTMLComponent {
+	return h.A(h.Text(log.GetCreator())).Href("javascript:void(0)").
+		Attr("@click.stop", web.Plaid().
+			URL(ab.lmb.Info().ListingHref()).
+			EventFunc(eventUserTimeline).
+			Query("userID", fmt.Sprint(log.GetUserID())).
+			Query("creator", log.GetCreator()).
+			Go())
+}
+
+func (ab *ActivityBuilder) eventUserTimeline(ctx *web.EventContext) (r web.EventResponse, err error) {
+	var (
+		msgr = i18n.MustGetModuleMessages(ctx.R, I18nActivityKey, Messages_en_US).(*Messages)
+		db   = ab.getDBFromContext(ctx.R.Context())
+		q    = auditQueryFromRequest(ctx.R)
+	)
+	if q.UserID == 0 && q.Creator == "" {
+		return r, errors.New("the user of the timeline is required")
+	}
+
+	counts, err := ab.GetUserActivityCounts(db, q)
+	if err != nil {
+		return
+	}
+	logs, err := ab.GetUserActivityLogs(db, q, userTimelineLimit)
+	if err != nil {
+		return
+	}
+
+	var (
+		total     int64
+		countRows []h.HTMLComponent
+		logRows   []h.HTMLComponent
+	)
+	for _, c := range counts {
+		total += c.Count
+		countRows = append(countRows, h.Tr(h.Td(h.Text(c.ModelName)), h.Td(h.Text(c.Action)), h.Td(h.Text(fmt.Sprint(c.Count)))))
+	}
+	values := reflect.Indirect(reflect.ValueOf(logs))
+	for i := 0; i < values.Len(); i++ {
+		log := values.Index(i).Interface().(ActivityLogInterface)
+		logRows = append(logRows, h.Tr(
+			h.Td(h.Text(log.GetCreatedAt().Format("2006-01-02 15:04:05 MST"))),
+			h.Td(h.Text(log.GetAction())),
+			h.Td(h.Text(log.GetModelName())),
+			h.Td(h.Text(log.GetModelLabel())),
+			h.Td(h.A(h.Text(log.GetModelKeys())).Href(fmt.Sprintf("%s/%d", ab.lmb.Info().ListingHref(), getLogID(log)))),
+		))
+	}
+
+	var (
+		from = ctx.R.FormValue("from")
+		to   = ctx.R.FormValue("to")
+	)
+	exportHref := func(format string) string {
+		return ab.lmb.Info().ListingHref() + "?" + url.Values{
+			auditReportQueryParam: []string{format},
+			"userID":              []string{fmt.Sprint(q.UserID)},
+			"creator":             []string{q.Creator},
+			"from":                []string{from},
+			"to":                  []string{to},
+		}.Encode()
+	}
+	title := q.Creator
+	if title == "" {
+		title = fmt.Sprint(q.UserID)
+	}
+
+	r.UpdatePortals = append(r.UpdatePortals, &web.PortalUpdate{
+		Name: presets.DialogPortalName,
+		Body: web.Scope(
+			vuetify.VDialog(
+				vuetify.VCard(
+					vuetify.VCardTitle(
+						h.Text(fmt.Sprintf(msgr.UserTimelineTitle, title)),
+						vuetify.VSpacer(),
+						vuetify.VBtn("").Icon(true).Children(
+							vuetify.VIcon("close"),
+						).Attr("@click.stop", "vars.presetsDialog=false"),
+					),
+					vuetify.VCardText(
+						vuetify.VRow(
+							vuetify.VCol(
+								vuetify.VTextField().Type("date").Label(msgr.UserTimelineFrom).FieldName("from").Value(from).Dense(true),
+							),
+							vuetify.VCol(
+								vuetify.VTextField().Type("date").Label(msgr.UserTimelineTo).FieldName("to").Value(to).Dense(true),
+							),
+							vuetify.VCol(
+								vuetify.VBtn(msgr.UserTimelineApply).Color("primary").
+									Attr("@click", web.Plaid().
+										URL(ab.lmb.Info().ListingHref()).
+										EventFunc(eventUserTimeline).
+										Query("userID", fmt.Sprint(q.UserID)).
+										Query("creator", q.Creator).
+										Go()),
+								h.If(len(ab.auditSecret) > 0,
+									vuetify.VBtn(msgr.ExportCSV).Text(true).Href(exportHref(AuditReportCSV)),
+									vuetify.VBtn(msgr.ExportJSON).Text(true).Href(exportHref(AuditReportJSON)),
+								),
+							),
+						),
+						h.H4(fmt.Sprintf(msgr.UserTimelineCounts, total)),
+						vuetify.VSimpleTable(
+							h.Thead(h.Tr(h.Th(msgr.ModelName), h.Th(msgr.ModelAction), h.Th(msgr.UserTimelineCount))),
+							h.Tbody(countRows...),
+						).Dense(true).Class("mb-4"),
+						h.H4(fmt.Sprintf(msgr.UserTimelineLatest, values.Len())),
+						vuetify.VSimpleTable(
+							h.Thead(h.Tr(h.Th(msgr.ModelCreatedAt), h.Th(msgr.ModelAction), h.Th(msgr.ModelName), h.Th(msgr.ModelLabel), h.Th(msgr.ModelKeys))),
+							h.Tbody(logRows...),
+						).Dense(true),
+					),
+				),
+			).
+				Attr("v-model", "vars.presetsDialog").
+				Width("900"),
+		).VSlot("{ plaidForm }"),
+	})
+	r.VarsScript = "setTimeout(function(){vars.presetsDialog = true; }, 100)"
+	return
+}
@@ -0,0 +1,109 @@
+package activity
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type auditUser struct {
+	id   uint
+	name string
+}
+
+func (u auditUser) GetID() uint {
+	return u.id
+}
+
+func (u auditUser) GetName() string {
+	return u.name
+}
+
+func TestAuditReport(t *testing.T) {
+	builder := New(pb, db, &TestActivityLog{})
+	builder.RegisterModel(&TestActivityModel{})
+	builder.RegisterModel(&Page{})
+	resetDB()
+
+	if _, err := builder.ExportAuditReport(db, AuditQuery{}, AuditReportCSV, &bytes.Buffer{}); !errors.Is(err, ErrAuditReportSecretRequired) {
+		t.Errorf("want the report not exported without the secret, but got %v", err)
+	}
+	builder.AuditReportSecret([]byte("secret"))
+
+	u := auditUser{id: 1, name: "user a"}
+	builder.AddCreateRecord(u, TestActivityModel{ID: 1, Title: "a"}, db)
+	builder.AddEditRecordWithOld(u, TestActivityModel{ID: 1, Title: "a"}, TestActivityModel{ID: 1, Title: "b"}, db)
+	builder.AddCreateRecord(u, Page{ID: 1, Title: "a"}, db)
+	builder.AddCreateRecord(auditUser{id: 2, name: "user b"}, TestActivityModel{ID: 2, Title: "c"}, db)
+	builder.AddCreateRecord(u, TestActivityModel{ID: 3, Title: "d"}, db)
+	// out of the time range
+	db.Model(&TestActivityLog{}).Where("model_keys = ?", "3").Update("created_at", time.Now().AddDate(0, 0, -10))
+
+	q := AuditQuery{UserID: 1, From: time.Now().AddDate(0, 0, -1)}
+	counts, err := builder.GetUserActivityCounts(db, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCounts := []*ActivityCount{
+		{ModelName: "Page", Action: ActivityCreate, Count: 1},
+		{ModelName: "TestActivityModel", Action: ActivityCreate, Count: 1},
+		{ModelName: "TestActivityModel", Action: ActivityEdit, Count: 1},
+	}
+	if !reflect.DeepEqual(counts, wantCounts) {
+		t.Errorf("want the counts %v, but got %v", wantCounts, counts)
+	}
+
+	logs, err := builder.GetUserActivityLogs(db, q, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(*logs.(*[]*TestActivityLog)); got != 3 {
+		t.Errorf("want 3 logs, but got %d", got)
+	}
+
+	for _, format := range []string{AuditReportCSV, AuditReportJSON} {
+		var buf bytes.Buffer
+		hash, err := builder.ExportAuditReport(db, q, format, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := buf.String()
+		if got, err := builder.VerifyAuditReport(format, strings.NewReader(report)); err != nil || got != hash {
+			t.Errorf("want the %s report verified with hash %s, but got %s %v", format, hash, got, err)
+		}
+		if !strings.Contains(report, `Title`) {
+			t.Errorf("want the diffs in the %s report, but got %s", format, report)
+		}
+
+		tampered := strings.Replace(report, "user a", "user c", 1)
+		if _, err := builder.VerifyAuditReport(format, strings.NewReader(tampered)); !errors.Is(err, ErrAuditReportTampered) {
+			t.Errorf("want the tampered %s report detected, but got %v", format, err)
+		}
+
+		// the hashes can't be recomputed without the secret
+		other := New(pb, db, &TestActivityLog{}).AuditReportSecret([]byte("other"))
+		if _, err := other.VerifyAuditReport(format, strings.NewReader(report)); !errors.Is(err, ErrAuditReportTampered) {
+			t.Errorf("want the %s report rejected with another secret, but got %v", format, err)
+		}
+	}
+
+	// the report is downloaded from the activity listing url
+	h := builder.auditReportHandler(http.NotFoundHandler())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, builder.lmb.Info().ListingHref()+"?audit_report=csv&creator=user+b", nil))
+	hash := w.Result().Trailer.Get("X-Audit-Report-Hash")
+	if w.Code != http.StatusOK || hash == "" {
+		t.Fatalf("want the report downloaded, but got %d %s", w.Code, w.Body.String())
+	}
+	if lines := strings.Count(strings.TrimSpace(w.Body.String()), "\n"); lines != 1 {
+		t.Errorf("want 1 row of user b, but got %d:\n%s", lines, w.Body.String())
+	}
+	if got, err := builder.VerifyAuditReport(AuditReportCSV, w.Body); err != nil || got != hash {
+		t.Errorf("want the downloaded report verified, but got %s %v", got, err)
+	}
+}
@@ -0,0 +1,699 @@
+package activity
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/media/media_library"
+)
+
+// DiffsVersion is the version of the ModelDiffs format written by the model builders,
+// the logs written before versioning are a json array of Diff and read as version 1.
+const DiffsVersion = 2
+
+// maxSliceLCS is the max size of the table comparing slice elements to find the moved ones,
+// larger slices are compared by index
+const maxSliceLCS = 1 << 20
+
+type ChangeOp string
+
+const (
+	ChangeReplace ChangeOp = "replace"
+	ChangeAdd     ChangeOp = "add"
+	ChangeRemove  ChangeOp = "remove"
+	ChangeMove    ChangeOp = "move"
+)
+
+// Change is a typed change at the path of struct fields, slice indexes and map keys.
+// The changes are applied in order, the indexes of an add, remove or move are the ones at the time it is applied.
+type Change struct {
+	Op   ChangeOp
+	Path []string
+	// From is the path a moved element is moved from
+	From []string `json:",omitempty"`
+	// Type is the go type of the values, the renderers are registered by it
+	Type string `json:",omitempty"`
+	// Old and New are the json of the values, New of a move is the moved element
+	Old json.RawMessage `json:",omitempty"`
+	New json.RawMessage `json:",omitempty"`
+	// Text is true if Old and New are strings formatted by a TypeHandler or read from a version 1 log
+	Text bool `json:",omitempty"`
+}
+
+// Diffs is the versioned format of ModelDiffs
+type Diffs struct {
+	Version int
+	Changes []Change
+}
+
+// Field is the path joined by dots like the Field of Diff
+func (c Change) Field() string {
+	return strings.Join(c.Path, ".")
+}
+
+// Diff returns the change in the string format of version 1
+func (c Change) Diff() Diff {
+	if c.Op == ChangeMove {
+		return Diff{Field: c.Field(), Old: strings.Join(c.From, "."), Now: c.Field()}
+	}
+	return Diff{Field: c.Field(), Old: textValue(c.Old), Now: textValue(c.New)}
+}
+
+// ParseDiffs reads ModelDiffs of all the versions
+func ParseDiffs(s string) (*Diffs, error) {
+	ds := &Diffs{Version: DiffsVersion}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ds, nil
+	}
+
+	if strings.HasPrefix(s, "[") {
+		var diffs []Diff
+		if err := json.Unmarshal([]byte(s), &diffs); err != nil {
+			return nil, err
+		}
+		ds.Version = 1
+		ds.Changes = textChanges(diffs)
+		return ds, nil
+	}
+
+	if err := json.Unmarshal([]byte(s), ds); err != nil {
+		return nil, err
+	}
+	if ds.Version > DiffsVersion {
+		return nil, fmt.Errorf("unsupported diffs version %d", ds.Version)
+	}
+	return ds, nil
+}
+
+func marshalDiffs(changes []Change) (string, error) {
+	b, err := json.Marshal(Diffs{Version: DiffsVersion, Changes: changes})
+	return string(b), err
+}
+
+// textChanges converts the string diffs, an empty old value is an add and an empty now value is a remove
+func textChanges(diffs []Diff) []Change {
+	var changes []Change
+	for _, d := range diffs {
+		c := Change{
+			Op:   ChangeReplace,
+			Path: strings.Split(d.Field, "."),
+			Text: true,
+		}
+		switch {
+		case d.Old == "" && d.Now != "":
+			c.Op = ChangeAdd
+		case d.Old != "" && d.Now == "":
+			c.Op = ChangeRemove
+		}
+		if d.Old != "" {
+			c.Old, _ = json.Marshal(d.Old)
+		}
+		if d.Now != "" {
+			c.New, _ = json.Marshal(d.Now)
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// textValue is the value as a plain string, strings are unquoted and null is empty
+func textValue(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var s string
+	if raw[0] == '"' && json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func jsonValue(v reflect.Value) json.RawMessage {
+	if !v.IsValid() {
+		return json.RawMessage("null")
+	}
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("%+v", v.Interface()))
+	}
+	return b
+}
+
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
+func appendPath(path []string, s string) []string {
+	return append(append(make([]string, 0, len(path)+1), path...), s)
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	mediaBoxType      = reflect.TypeOf(media_library.MediaBox{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// isJSONValue reports if the values of the type are compared and changed as a whole,
+// e.g. a media box which is rendered as one value, or a decimal which marshals itself
+func isJSONValue(t reflect.Type) bool {
+	if t == mediaBoxType {
+		return true
+	}
+	for _, it := range []reflect.Type{jsonMarshalerType, textMarshalerType} {
+		if t.Implements(it) || reflect.PtrTo(t).Implements(it) {
+			return true
+		}
+	}
+	return false
+}
+
+// Changes get the typed changes between old and now value
+func (db *DiffBuilder) Changes(old, now interface{}) ([]Change, error) {
+	err := db.changesLoop(reflect.Indirect(reflect.ValueOf(old)), reflect.Indirect(reflect.ValueOf(now)), nil)
+	return db.changes, err
+}
+
+func (db *DiffBuilder) replace(path []string, old, now reflect.Value) {
+	db.changes = append(db.changes, Change{
+		Op:   ChangeReplace,
+		Path: path,
+		Type: typeName(now.Type()),
+		Old:  jsonValue(old),
+		New:  jsonValue(now),
+	})
+}
+
+func (db *DiffBuilder) isIgnored(name string) bool {
+	for _, f := range DefaultIgnoredFields {
+		if f == name {
+			return true
+		}
+	}
+	for _, f := range db.mb.ignoredFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DiffBuilder) changesLoop(old, now reflect.Value, path []string) error {
+	if old.Type() != now.Type() {
+		return fmt.Errorf("old and now type mismatch: %v != %v", old.Type(), now.Type())
+	}
+
+	if kind := now.Kind(); len(path) > 0 && kind != reflect.Invalid && kind != reflect.Interface && kind != reflect.Ptr &&
+		now.Type() != timeType && isJSONValue(now.Type()) {
+		if !bytes.Equal(jsonValue(old), jsonValue(now)) {
+			db.replace(path, old, now)
+		}
+		return nil
+	}
+
+	switch now.Kind() {
+	case reflect.Invalid, reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Uintptr:
+		return nil
+	case reflect.Interface, reflect.Ptr:
+		if old.IsNil() && now.IsNil() {
+			return nil
+		}
+		if old.IsNil() || now.IsNil() || old.Elem().Type() != now.Elem().Type() {
+			db.replace(path, old, now)
+			return nil
+		}
+		return db.changesLoop(old.Elem(), now.Elem(), path)
+	case reflect.Struct:
+		// times are compared in seconds like the default type handler of Diff
+		if now.Type() == timeType {
+			if old.Interface().(time.Time).Format(time.RFC3339) != now.Interface().(time.Time).Format(time.RFC3339) {
+				db.replace(path, old, now)
+			}
+			return nil
+		}
+		for i := 0; i < now.Type().NumField(); i++ {
+			if !old.Field(i).CanInterface() {
+				continue
+			}
+			field := now.Type().Field(i)
+			if db.isIgnored(field.Name) {
+				continue
+			}
+
+			fieldPath := appendPath(path, field.Name)
+			// the registered type handlers only format strings, DefaultTypeHandles are replaced by the typed changes
+			if f := db.mb.typeHanders[field.Type]; f != nil && field.Type != timeType {
+				db.changes = append(db.changes, textChanges(f(old.Field(i).Interface(), now.Field(i).Interface(), strings.Join(fieldPath, ".")))...)
+				continue
+			}
+			if err := db.changesLoop(old.Field(i), now.Field(i), fieldPath); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < now.Len(); i++ {
+			if err := db.changesLoop(old.Index(i), now.Index(i), appendPath(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if old.IsNil() && now.IsNil() {
+			return nil
+		}
+		if old.IsNil() || now.IsNil() {
+			db.replace(path, old, now)
+			return nil
+		}
+		return db.sliceChanges(old, now, path)
+	case reflect.Map:
+		if old.IsNil() && now.IsNil() {
+			return nil
+		}
+		if old.IsNil() || now.IsNil() {
+			db.replace(path, old, now)
+			return nil
+		}
+		return db.mapChanges(old, now, path)
+	default:
+		if old.Interface() != now.Interface() {
+			db.replace(path, old, now)
+		}
+	}
+	return nil
+}
+
+func (db *DiffBuilder) mapChanges(old, now reflect.Value, path []string) error {
+	keys := make(map[string]reflect.Value)
+	for _, k := range old.MapKeys() {
+		keys[fmt.Sprint(k.Interface())] = k
+	}
+	for _, k := range now.MapKeys() {
+		keys[fmt.Sprint(k.Interface())] = k
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	elemType := typeName(now.Type().Elem())
+	for _, name := range names {
+		var (
+			k        = keys[name]
+			oldValue = old.MapIndex(k)
+			nowValue = now.MapIndex(k)
+			keyPath  = appendPath(path, name)
+		)
+		switch {
+		case !oldValue.IsValid():
+			db.changes = append(db.changes, Change{Op: ChangeAdd, Path: keyPath, Type: elemType, New: jsonValue(nowValue)})
+		case !nowValue.IsValid():
+			db.changes = append(db.changes, Change{Op: ChangeRemove, Path: keyPath, Type: elemType, Old: jsonValue(oldValue)})
+		default:
+			if err := db.changesLoop(oldValue, nowValue, keyPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sliceChanges finds the unchanged elements by the longest common subsequence, the other equal elements are moved,
+// the rest are modified in place if they are between the same unchanged elements, or removed and added.
+func (db *DiffBuilder) sliceChanges(old, now reflect.Value, path []string) error {
+	n, m := old.Len(), now.Len()
+	oldMatch := make([]int, n)
+	nowMatch := make([]int, m)
+	for i := range oldMatch {
+		oldMatch[i] = -1
+	}
+	for j := range nowMatch {
+		nowMatch[j] = -1
+	}
+	modified := make(map[int]bool)
+
+	if n*m <= maxSliceLCS {
+		equal := func(i, j int) bool {
+			return reflect.DeepEqual(old.Index(i).Interface(), now.Index(j).Interface())
+		}
+		lcs := make([][]int, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if equal(i, j) {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		type anchor struct{ i, j int }
+		var anchors []anchor
+		for i, j := 0, 0; i < n && j < m; {
+			switch {
+			case equal(i, j):
+				oldMatch[i], nowMatch[j] = j, i
+				anchors = append(anchors, anchor{i, j})
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				i++
+			default:
+				j++
+			}
+		}
+
+		for i := 0; i < n; i++ {
+			if oldMatch[i] != -1 {
+				continue
+			}
+			for j := 0; j < m; j++ {
+				if nowMatch[j] == -1 && equal(i, j) {
+					oldMatch[i], nowMatch[j] = j, i
+					break
+				}
+			}
+		}
+
+		prev := anchor{-1, -1}
+		for _, a := range append(anchors, anchor{n, m}) {
+			j := prev.j + 1
+			for i := prev.i + 1; i < a.i; i++ {
+				if oldMatch[i] != -1 {
+					continue
+				}
+				for j < a.j && nowMatch[j] != -1 {
+					j++
+				}
+				if j >= a.j {
+					break
+				}
+				oldMatch[i], nowMatch[j] = j, i
+				modified[j] = true
+				j++
+			}
+			prev = a
+		}
+	} else {
+		for i := 0; i < n && i < m; i++ {
+			oldMatch[i], nowMatch[i] = i, i
+			modified[i] = true
+		}
+	}
+
+	elemType := typeName(now.Type().Elem())
+	for i := n - 1; i >= 0; i-- {
+		if oldMatch[i] == -1 {
+			db.changes = append(db.changes, Change{Op: ChangeRemove, Path: appendPath(path, strconv.Itoa(i)), Type: elemType, Old: jsonValue(old.Index(i))})
+		}
+	}
+	// cur is the old indexes of the elements after the changes so far, -1 for the added ones
+	var cur []int
+	for i := 0; i < n; i++ {
+		if oldMatch[i] != -1 {
+			cur = append(cur, i)
+		}
+	}
+	for j := 0; j < m; j++ {
+		k := nowMatch[j]
+		if k == -1 {
+			db.changes = append(db.changes, Change{Op: ChangeAdd, Path: appendPath(path, strconv.Itoa(j)), Type: elemType, New: jsonValue(now.Index(j))})
+			cur = append(cur[:j], append([]int{-1}, cur[j:]...)...)
+			continue
+		}
+		p := j
+		for cur[p] != k {
+			p++
+		}
+		if p != j {
+			db.changes = append(db.changes, Change{Op: ChangeMove, From: appendPath(path, strconv.Itoa(p)), Path: appendPath(path, strconv.Itoa(j)), Type: elemType, New: jsonValue(now.Index(j))})
+			cur = append(cur[:p], cur[p+1:]...)
+			cur = append(cur[:j], append([]int{k}, cur[j:]...)...)
+		}
+	}
+
+	for j := 0; j < m; j++ {
+		if modified[j] {
+			if err := db.changesLoop(old.Index(nowMatch[j]), now.Index(j), appendPath(path, strconv.Itoa(j))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// changeConflict is returned by revertChange if the value at the path is not the one the change made
+type changeConflict struct {
+	current string
+}
+
+func (c *changeConflict) Error() string {
+	return fmt.Sprintf("changed since, now %s", c.current)
+}
+
+func conflictOf(v reflect.Value) error {
+	if !v.IsValid() {
+		return &changeConflict{}
+	}
+	return &changeConflict{current: textValue(jsonValue(v))}
+}
+
+// revertChange applies the inverse of the change onto v
+func revertChange(v reflect.Value, c Change) error {
+	if len(c.Path) == 0 {
+		return errors.New("empty change path")
+	}
+	if c.Op == ChangeReplace {
+		return updateAt(v, c.Path, func(v reflect.Value) error {
+			if !sameJSON(v, c.New) {
+				return conflictOf(v)
+			}
+			return setJSON(v, c.Old)
+		})
+	}
+
+	parent, key := c.Path[:len(c.Path)-1], c.Path[len(c.Path)-1]
+	return updateAt(v, parent, func(v reflect.Value) error {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return conflictOf(reflect.Value{})
+			}
+			v = v.Elem()
+		}
+
+		if v.Kind() == reflect.Map {
+			k, err := mapKey(v.Type().Key(), key)
+			if err != nil {
+				return err
+			}
+			elem := v.MapIndex(k)
+			switch c.Op {
+			case ChangeAdd:
+				if !elem.IsValid() || !sameJSON(elem, c.New) {
+					return conflictOf(elem)
+				}
+				v.SetMapIndex(k, reflect.Value{})
+			case ChangeRemove:
+				if elem.IsValid() {
+					return conflictOf(elem)
+				}
+				elem = reflect.New(v.Type().Elem()).Elem()
+				if err = setJSON(elem, c.Old); err != nil {
+					return err
+				}
+				if v.IsNil() {
+					v.Set(reflect.MakeMap(v.Type()))
+				}
+				v.SetMapIndex(k, elem)
+			default:
+				return fmt.Errorf("can't %s a map element", c.Op)
+			}
+			return nil
+		}
+
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("can't %s an element of %s", c.Op, v.Type())
+		}
+		i, err := strconv.Atoi(key)
+		if err != nil {
+			return err
+		}
+		switch c.Op {
+		case ChangeAdd:
+			if i >= v.Len() {
+				return conflictOf(reflect.Value{})
+			}
+			if !sameJSON(v.Index(i), c.New) {
+				return conflictOf(v.Index(i))
+			}
+			v.Set(removeIndex(v, i))
+		case ChangeRemove:
+			if i > v.Len() {
+				return conflictOf(reflect.Value{})
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err = setJSON(elem, c.Old); err != nil {
+				return err
+			}
+			v.Set(insertIndex(v, i, elem))
+		case ChangeMove:
+			if len(c.From) == 0 {
+				return errors.New("empty move path")
+			}
+			from, err := strconv.Atoi(c.From[len(c.From)-1])
+			if err != nil {
+				return err
+			}
+			if i >= v.Len() {
+				return conflictOf(reflect.Value{})
+			}
+			if !sameJSON(v.Index(i), c.New) {
+				return conflictOf(v.Index(i))
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.Index(i))
+			v.Set(removeIndex(v, i))
+			if from > v.Len() {
+				return conflictOf(reflect.Value{})
+			}
+			v.Set(insertIndex(v, from, elem))
+		default:
+			return fmt.Errorf("unknown change op %q", c.Op)
+		}
+		return nil
+	})
+}
+
+// updateAt calls f with the settable value at the path, the map elements are copied and set back
+func updateAt(v reflect.Value, path []string, f func(v reflect.Value) error) error {
+	if len(path) == 0 {
+		return f(v)
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return conflictOf(reflect.Value{})
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		fv := v.FieldByName(path[0])
+		if !fv.IsValid() {
+			return fmt.Errorf("no field %s in %s", path[0], v.Type())
+		}
+		return updateAt(fv, path[1:], f)
+	case reflect.Slice, reflect.Array:
+		i, err := strconv.Atoi(path[0])
+		if err != nil {
+			return err
+		}
+		if i >= v.Len() {
+			return conflictOf(reflect.Value{})
+		}
+		return updateAt(v.Index(i), path[1:], f)
+	case reflect.Map:
+		k, err := mapKey(v.Type().Key(), path[0])
+		if err != nil {
+			return err
+		}
+		elem := v.MapIndex(k)
+		if !elem.IsValid() {
+			return conflictOf(reflect.Value{})
+		}
+		tmp := reflect.New(elem.Type()).Elem()
+		tmp.Set(elem)
+		if err = updateAt(tmp, path[1:], f); err != nil {
+			return err
+		}
+		v.SetMapIndex(k, tmp)
+		return nil
+	}
+	return fmt.Errorf("no field %s in %s", path[0], v.Type())
+}
+
+func mapKey(t reflect.Type, s string) (reflect.Value, error) {
+	k := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		k.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return k, err
+		}
+		k.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return k, err
+		}
+		k.SetUint(i)
+	default:
+		return k, fmt.Errorf("unsupported map key type %s", t)
+	}
+	return k, nil
+}
+
+func parseJSON(t reflect.Type, raw json.RawMessage) (reflect.Value, error) {
+	v := reflect.New(t)
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, v.Interface()); err != nil {
+			return v, err
+		}
+	}
+	return v.Elem(), nil
+}
+
+func setJSON(v reflect.Value, raw json.RawMessage) error {
+	if !v.CanSet() {
+		return fmt.Errorf("can't set %s", v.Type())
+	}
+	nv, err := parseJSON(v.Type(), raw)
+	if err != nil {
+		return err
+	}
+	v.Set(nv)
+	return nil
+}
+
+// sameJSON reports if v is the value of the json, the times are compared in seconds like the diffs
+func sameJSON(v reflect.Value, raw json.RawMessage) bool {
+	nv, err := parseJSON(v.Type(), raw)
+	if err != nil {
+		return false
+	}
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Unix() == nv.Interface().(time.Time).Unix()
+	}
+	return bytes.Equal(jsonValue(v), jsonValue(nv))
+}
+
+func removeIndex(v reflect.Value, i int) reflect.Value {
+	r := reflect.MakeSlice(v.Type(), 0, v.Len()-1)
+	r = reflect.AppendSlice(r, v.Slice(0, i))
+	return reflect.AppendSlice(r, v.Slice(i+1, v.Len()))
+}
+
+func insertIndex(v reflect.Value, i int, elem reflect.Value) reflect.Value {
+	r := reflect.MakeSlice(v.Type(), 0, v.Len()+1)
+	r = reflect.AppendSlice(r, v.Slice(0, i))
+	r = reflect.Append(r, elem)
+	return reflect.AppendSlice(r, v.Slice(i, v.Len()))
+}
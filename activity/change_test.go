@@ -0,0 +1,178 @@
+package activity
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/media/media_library"
+)
+
+// legacyDiffs is the json of the diffs in the string format of version 1
+func legacyDiffs(s string) string {
+	ds, err := ParseDiffs(s)
+	if err != nil {
+		return err.Error()
+	}
+	var diffs []Diff
+	for _, c := range ds.Changes {
+		diffs = append(diffs, c.Diff())
+	}
+	b, _ := json.Marshal(diffs)
+	return string(b)
+}
+
+func TestChanges(t *testing.T) {
+	published := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	testCases := []struct {
+		description string
+		mb          *ModelBuilder
+		old         Post
+		now         Post
+		want        string
+	}{
+		{
+			description: "Typed values",
+			old:         Post{Title: "a", Author: Author{Age: 1}},
+			now:         Post{Title: "b", Author: Author{Age: 2}, PublishedDate: published},
+			want:        `[{"Op":"replace","Path":["PublishedDate"],"Type":"time.Time","Old":"0001-01-01T00:00:00Z","New":"2023-01-02T03:04:05Z"},{"Op":"replace","Path":["Title"],"Type":"string","Old":"a","New":"b"},{"Op":"replace","Path":["Author","Age"],"Type":"int","Old":1,"New":2}]`,
+		},
+		{
+			description: "Media boxes",
+			old:         Post{Image: media_library.MediaBox{Url: "1.jpg"}},
+			now:         Post{Image: media_library.MediaBox{Url: "2.jpg", Description: "two"}},
+			want:        `[{"Op":"replace","Path":["Image"],"Type":"media_library.MediaBox","Old":{"ID":0,"Url":"1.jpg","VideoLink":"","FileName":"","Description":""},"New":{"ID":0,"Url":"2.jpg","VideoLink":"","FileName":"","Description":"two"}}]`,
+		},
+		{
+			description: "Registered type handlers",
+			mb: (&ModelBuilder{}).AddTypeHanders(Author{}, func(old, now interface{}, prefixField string) []Diff {
+				if old.(Author).Name == now.(Author).Name {
+					return nil
+				}
+				return []Diff{{Field: prefixField + ".Name", Old: old.(Author).Name, Now: now.(Author).Name}}
+			}),
+			old:  Post{Author: Author{Name: "a"}},
+			now:  Post{Author: Author{Name: "b"}},
+			want: `[{"Op":"replace","Path":["Author","Name"],"Old":"a","New":"b","Text":true}]`,
+		},
+		{
+			description: "Slice elements added, removed and modified",
+			old:         Post{Comments: []Comment{{Text: "1"}, {Text: "2"}, {Text: "3"}, {Text: "5"}}},
+			now:         Post{Comments: []Comment{{Text: "1"}, {Text: "3"}, {Text: "5.1"}, {Text: "6"}}},
+			want:        `[{"Op":"remove","Path":["Comments","1"],"Type":"activity.Comment","Old":{"Text":"2"}},{"Op":"add","Path":["Comments","3"],"Type":"activity.Comment","New":{"Text":"6"}},{"Op":"replace","Path":["Comments","2","Text"],"Type":"string","Old":"5","New":"5.1"}]`,
+		},
+		{
+			description: "Slice elements moved",
+			old:         Post{Comments: []Comment{{Text: "1"}, {Text: "2"}, {Text: "3"}}},
+			now:         Post{Comments: []Comment{{Text: "3"}, {Text: "1"}, {Text: "2"}}},
+			want:        `[{"Op":"move","Path":["Comments","0"],"From":["Comments","2"],"Type":"activity.Comment","New":{"Text":"3"}}]`,
+		},
+		{
+			description: "Map keys",
+			old:         Post{Tags: map[string]Tag{"a": {Name: "A"}, "b": {Name: "B"}}},
+			now:         Post{Tags: map[string]Tag{"b": {Name: "B2"}, "c": {Name: "C"}}},
+			want:        `[{"Op":"remove","Path":["Tags","a"],"Type":"activity.Tag","Old":{"Name":"A"}},{"Op":"replace","Path":["Tags","b","Name"],"Type":"string","Old":"B","New":"B2"},{"Op":"add","Path":["Tags","c"],"Type":"activity.Tag","New":{"Name":"C"}}]`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.description, func(t *testing.T) {
+			if test.mb == nil {
+				test.mb = &ModelBuilder{}
+			}
+			changes, err := NewDiffBuilder(test.mb).Changes(test.old, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := json.Marshal(changes); string(b) != test.want {
+				t.Fatalf("want: %v, but got: %v", test.want, string(b))
+			}
+
+			// reverting the changes backwards restores the old value
+			reverted := test.now
+			for i := len(changes) - 1; i >= 0; i-- {
+				if changes[i].Text {
+					if conflict, err := revertTextChange(reflect.ValueOf(&reverted), changes[i].Diff()); err != nil || conflict != nil {
+						t.Fatalf("revert %+v: %v %v", changes[i], err, conflict)
+					}
+					continue
+				}
+				if err := revertChange(reflect.ValueOf(&reverted), changes[i]); err != nil {
+					t.Fatalf("revert %+v: %v", changes[i], err)
+				}
+			}
+			if again, _ := NewDiffBuilder(test.mb).Changes(test.old, reverted); len(again) != 0 {
+				t.Errorf("want reverted to the old value, got changes %+v", again)
+			}
+		})
+	}
+}
+
+// testDecimal keeps its value unexported like the decimal types, which marshal themselves
+type testDecimal struct {
+	value string
+}
+
+func (d testDecimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.value), nil
+}
+
+func (d *testDecimal) UnmarshalJSON(b []byte) error {
+	d.value = string(b)
+	return nil
+}
+
+type testPrice struct {
+	Amount   testDecimal
+	Discount *testDecimal
+}
+
+func TestChangesOfMarshalers(t *testing.T) {
+	old := testPrice{Amount: testDecimal{"1.10"}}
+	now := testPrice{Amount: testDecimal{"1.20"}, Discount: &testDecimal{"0.5"}}
+	changes, err := NewDiffBuilder(&ModelBuilder{}).Changes(old, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"Op":"replace","Path":["Amount"],"Type":"activity.testDecimal","Old":1.10,"New":1.20},{"Op":"replace","Path":["Discount"],"Type":"activity.testDecimal","Old":null,"New":0.5}]`
+	if b, _ := json.Marshal(changes); string(b) != want {
+		t.Fatalf("want: %v, but got: %v", want, string(b))
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		if err = revertChange(reflect.ValueOf(&now), changes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if now.Amount.value != "1.10" || now.Discount != nil {
+		t.Errorf("want reverted to the old value, got %+v", now)
+	}
+}
+
+func TestRevertChangeConflict(t *testing.T) {
+	changes, _ := NewDiffBuilder(&ModelBuilder{}).Changes(
+		Post{Comments: []Comment{{Text: "1"}}},
+		Post{Comments: []Comment{{Text: "1"}, {Text: "2"}}},
+	)
+	now := Post{Comments: []Comment{{Text: "1"}, {Text: "2.1"}}}
+	var conflict *changeConflict
+	if err := revertChange(reflect.ValueOf(&now), changes[0]); !errors.As(err, &conflict) || conflict.current != `{"Text":"2.1"}` {
+		t.Errorf("want a conflict of the modified element, got %v", err)
+	}
+}
+
+func TestParseDiffs(t *testing.T) {
+	ds, err := ParseDiffs(`[{"Field":"Title","Old":"a","Now":"b"},{"Field":"Comments.1","Old":"","Now":"{Text:2}"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Version != 1 || len(ds.Changes) != 2 || !ds.Changes[0].Text ||
+		ds.Changes[0].Op != ChangeReplace || ds.Changes[1].Op != ChangeAdd || ds.Changes[1].Field() != "Comments.1" {
+		t.Errorf("unexpected version 1 diffs %+v", ds)
+	}
+
+	if _, err = ParseDiffs(`{"Version":3,"Changes":[]}`); err == nil {
+		t.Errorf("want an error for the unknown version")
+	}
+}
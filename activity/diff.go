@@ -1,12 +1,15 @@
 package activity
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"reflect"
 	"strconv"
 	"time"
 
 	"github.com/qor5/admin/media/media_library"
+	h "github.com/theplant/htmlgo"
 )
 
 var (
@@ -48,6 +51,37 @@ var (
 	// @snippet_end
 )
 
+// DefaultDiffValueRenderers render the values of the changes by the go type
+var DefaultDiffValueRenderers = map[string]DiffValueRenderer{
+	"time.Time": func(value json.RawMessage, req *http.Request) h.HTMLComponent {
+		var t time.Time
+		if err := json.Unmarshal(value, &t); err != nil || t.IsZero() {
+			return h.Text(textValue(value))
+		}
+		return h.Text(t.Format("2006-01-02 15:04:05 MST"))
+	},
+	"media_library.MediaBox": func(value json.RawMessage, req *http.Request) h.HTMLComponent {
+		var box media_library.MediaBox
+		if err := json.Unmarshal(value, &box); err != nil {
+			return h.Text(textValue(value))
+		}
+		if box.Url == "" {
+			return h.Text(box.VideoLink)
+		}
+		name := box.FileName
+		if name == "" {
+			name = box.Url
+		}
+		if box.IsImage() {
+			return h.A(h.Img(box.Url).Attr("alt", box.Description).Style("max-height:80px;max-width:160px")).Href(box.Url).Target("_blank")
+		}
+		return h.A(h.Text(name)).Href(box.Url).Target("_blank")
+	},
+}
+
+// DiffValueRenderer renders the json value of a change on the activity pages
+type DiffValueRenderer func(value json.RawMessage, req *http.Request) h.HTMLComponent
+
 // @snippet_begin(ActivityTypeHandle)
 type TypeHandler func(old, now interface{}, prefixField string) []Diff
 
@@ -60,8 +94,9 @@ type Diff struct {
 }
 
 type DiffBuilder struct {
-	mb    *ModelBuilder
-	diffs []Diff
+	mb      *ModelBuilder
+	diffs   []Diff
+	changes []Change
 }
 
 func NewDiffBuilder(mb *ModelBuilder) *DiffBuilder {
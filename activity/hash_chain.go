@@ -0,0 +1,333 @@
+package activity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/ui/vuetify"
+	"github.com/qor5/web"
+	"github.com/qor5/x/i18n"
+	"github.com/qor5/x/perm"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	HashChainBreakContent = "content" // the content of the log mismatches its hash
+	HashChainBreakLink    = "link"    // the previous hash of the log mismatches the hash of the previous log
+	HashChainBreakTail    = "tail"    // the latest chained logs are removed, the ID is the one of the chain head
+
+	hashChainBatchSize = 1000
+)
+
+var ErrActivityLogReadOnly = errors.New("activity logs are read only")
+
+// HashChainBreak is a log where the hash chain breaks
+type HashChainBreak struct {
+	ID     uint
+	Reason string
+}
+
+// HashChainReport is the result of the verification of the hash chain
+type HashChainReport struct {
+	Checked int64
+	FirstID uint
+	LastID  uint
+	Breaks  []*HashChainBreak
+}
+
+// Intact reports whether there is no break in the hash chain
+func (r *HashChainReport) Intact() bool {
+	return len(r.Breaks) == 0
+}
+
+// EnableHashChain makes every new log store the hash of its content and the hash of the previous log,
+// so that a log edited or removed afterwards is found by VerifyHashChain. The log model must implement HashChainInterface.
+func (ab *ActivityBuilder) EnableHashChain() *ActivityBuilder {
+	if _, ok := ab.logModel.(HashChainInterface); !ok {
+		panic(fmt.Sprintf("log model %T is not implement HashChainInterface", ab.logModel))
+	}
+	ab.hashChain = true
+	return ab
+}
+
+// LogHash computes the hash of the content of the log and its previous hash
+func LogHash(log ActivityLogInterface) string {
+	var prevHash string
+	if l, ok := log.(HashChainInterface); ok {
+		prevHash = l.GetPrevHash()
+	}
+	b, _ := json.Marshal([]interface{}{
+		log.GetCreatedAt().UnixMilli(),
+		log.GetUserID(),
+		log.GetCreator(),
+		log.GetAction(),
+		log.GetModelKeys(),
+		log.GetModelName(),
+		log.GetModelLabel(),
+		log.GetModelLink(),
+		log.GetModelDiffs(),
+		prevHash,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// ActivityLogChainHead is the latest chained log of a log table, the chained saves lock it in their transactions
+// so that the logs are chained one by one across the processes sharing the database
+type ActivityLogChainHead struct {
+	LogTable string `gorm:"primarykey;size:64"`
+	LogID    uint
+	Hash     string `gorm:"size:64"`
+	LockedAt time.Time
+}
+
+// lockChainHead creates the head row of the log table if it doesn't exist and updates it,
+// the update blocks the other transactions locking the head until tx ends, so the head read after it is the latest
+func (ab ActivityBuilder) lockChainHead(tx *gorm.DB) (*ActivityLogChainHead, error) {
+	table := ab.logTable()
+	now := tx.NowFunc()
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&ActivityLogChainHead{LogTable: table, LockedAt: now}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&ActivityLogChainHead{}).Where("log_table = ?", table).Update("locked_at", now).Error; err != nil {
+		return nil, err
+	}
+
+	head := &ActivityLogChainHead{}
+	if err := tx.Where("log_table = ?", table).First(head).Error; err != nil {
+		return nil, err
+	}
+	if head.LogID == 0 {
+		// the logs chained before the head was kept
+		lasts := ab.NewLogModelSlice()
+		if err := tx.Where("hash <> ?", "").Order("id DESC").Limit(1).Find(lasts).Error; err != nil {
+			return nil, err
+		}
+		if values := reflect.Indirect(reflect.ValueOf(lasts)); values.Len() > 0 {
+			last := values.Index(0).Interface().(ActivityLogInterface)
+			head.LogID = getLogID(last)
+			head.Hash = last.(HashChainInterface).GetHash()
+		}
+	}
+	return head, nil
+}
+
+// ActivityLogChainAnchor is a chained log removed by the retention whose next log is kept,
+// VerifyHashChain links the next log to it instead of reporting a break
+type ActivityLogChainAnchor struct {
+	ID        uint   `gorm:"primarykey"`
+	LogTable  string `gorm:"size:64;index:idx_activity_log_chain_anchors_hash"`
+	LogID     uint
+	Hash      string `gorm:"size:64;index:idx_activity_log_chain_anchors_hash"`
+	CreatedAt time.Time
+}
+
+// anchorRemovedLogs keeps the anchors of the chained logs being removed in tx,
+// the removed logs whose next log or the chain head is kept become anchors, and the anchors before the removed logs are dropped
+func (ab ActivityBuilder) anchorRemovedLogs(tx *gorm.DB, logs reflect.Value, ids []uint) error {
+	var (
+		hashes     []string
+		prevHashes []string
+		removed    = map[string]uint{}
+	)
+	for i := 0; i < logs.Len(); i++ {
+		log := logs.Index(i).Interface().(ActivityLogInterface)
+		chained, ok := log.(HashChainInterface)
+		if !ok || chained.GetHash() == "" {
+			continue
+		}
+		hashes = append(hashes, chained.GetHash())
+		prevHashes = append(prevHashes, chained.GetPrevHash())
+		removed[chained.GetHash()] = getLogID(log)
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	// lock the head so that no log is chained to the removed ones meanwhile
+	head, err := ab.lockChainHead(tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Where("log_table = ? AND hash IN ?", head.LogTable, prevHashes).Delete(&ActivityLogChainAnchor{}).Error; err != nil {
+		return err
+	}
+
+	var linked []string
+	if err := tx.Model(ab.logModel).Where("prev_hash IN ? AND id NOT IN ?", hashes, ids).Pluck("prev_hash", &linked).Error; err != nil {
+		return err
+	}
+	linked = append(linked, head.Hash)
+
+	var anchors []*ActivityLogChainAnchor
+	for _, hash := range linked {
+		if id, ok := removed[hash]; ok {
+			anchors = append(anchors, &ActivityLogChainAnchor{LogTable: head.LogTable, LogID: id, Hash: hash})
+			delete(removed, hash)
+		}
+	}
+	if len(anchors) == 0 {
+		return nil
+	}
+	return tx.Create(anchors).Error
+}
+
+// isAnchored reports whether hash is the hash of a log removed by the retention
+func (ab ActivityBuilder) isAnchored(db *gorm.DB, hash string) (bool, error) {
+	var count int64
+	err := db.Model(&ActivityLogChainAnchor{}).Where("log_table = ? AND hash = ?", ab.logTable(), hash).Count(&count).Error
+	return count > 0, err
+}
+
+// logTable is the table name of the log model
+func (ab ActivityBuilder) logTable() string {
+	stmt := &gorm.Statement{DB: ab.db}
+	if err := stmt.Parse(ab.logModel); err != nil {
+		panic(err)
+	}
+	return stmt.Schema.Table
+}
+
+// saveChained saves the log after the chain head and moves the head to it in the transaction tx,
+// the head is locked until tx ends so that the concurrent logs are chained one by one. See insertLog for dedup.
+func (ab *ActivityBuilder) saveChained(tx *gorm.DB, log ActivityLogInterface, dedup *logDedup) (saved bool, err error) {
+	head, err := ab.lockChainHead(tx)
+	if err != nil {
+		return
+	}
+
+	// the databases keep the time in milliseconds at least
+	log.SetCreatedAt(log.GetCreatedAt().Truncate(time.Millisecond))
+	chained := log.(HashChainInterface)
+	chained.SetPrevHash(head.Hash)
+	chained.SetHash(LogHash(log))
+	if saved, err = ab.insertLog(tx, log, dedup); err != nil || !saved {
+		return
+	}
+	return true, tx.Model(&ActivityLogChainHead{}).Where("log_table = ?", head.LogTable).
+		Updates(map[string]interface{}{"log_id": getLogID(log), "hash": chained.GetHash()}).Error
+}
+
+// VerifyHashChain walks the chained logs from the oldest and reports the logs whose content or link mismatches the hashes.
+// The logs removed by the retention leave anchors that the next logs link to, any other removed log is a break.
+// The walk must reach the chain head read before it, so that removing the latest logs or all of them is a break too.
+func (ab ActivityBuilder) VerifyHashChain(ctx context.Context) (report *HashChainReport, err error) {
+	report = &HashChainReport{}
+	if _, ok := ab.logModel.(HashChainInterface); !ok {
+		return report, nil
+	}
+
+	var (
+		db       = ab.getDBFromContext(ctx).WithContext(ctx)
+		heads    []*ActivityLogChainHead
+		prevHash string
+		lastID   uint
+	)
+	if err = db.Where("log_table = ?", ab.logTable()).Limit(1).Find(&heads).Error; err != nil {
+		return
+	}
+	// the logs chained after the head is read are walked too, so the head is reached if its log is found
+	headReached := len(heads) == 0 || heads[0].Hash == ""
+
+	for {
+		logs := ab.NewLogModelSlice()
+		if err = db.Where("hash <> ? AND id > ?", "", lastID).Order("id").Limit(hashChainBatchSize).Find(logs).Error; err != nil {
+			return
+		}
+		values := reflect.Indirect(reflect.ValueOf(logs))
+		for i := 0; i < values.Len(); i++ {
+			log := values.Index(i).Interface().(ActivityLogInterface)
+			chained := log.(HashChainInterface)
+			id := getLogID(log)
+
+			if report.Checked == 0 {
+				report.FirstID = id
+			}
+			if chained.GetPrevHash() != prevHash {
+				var anchored bool
+				if anchored, err = ab.isAnchored(db, chained.GetPrevHash()); err != nil {
+					return
+				}
+				if !anchored {
+					report.Breaks = append(report.Breaks, &HashChainBreak{ID: id, Reason: HashChainBreakLink})
+				}
+			}
+			if LogHash(log) != chained.GetHash() {
+				report.Breaks = append(report.Breaks, &HashChainBreak{ID: id, Reason: HashChainBreakContent})
+			}
+
+			prevHash = chained.GetHash()
+			headReached = headReached || prevHash == heads[0].Hash
+			report.Checked++
+			report.LastID = id
+			lastID = id
+		}
+		if values.Len() < hashChainBatchSize {
+			break
+		}
+	}
+
+	if headReached {
+		return
+	}
+	// the head log removed by the retention is anchored
+	var anchored bool
+	if anchored, err = ab.isAnchored(db, heads[0].Hash); err != nil || anchored {
+		return
+	}
+	report.Breaks = append(report.Breaks, &HashChainBreak{ID: heads[0].LogID, Reason: HashChainBreakTail})
+	return
+}
+
+const eventVerifyHashChain = "activity_verify_hash_chain"
+
+func (ab *ActivityBuilder) verifyHashChainButton(ctx *web.EventContext) h.HTMLComponent {
+	if !ab.hashChain || ab.lmb.Info().Verifier().Do(presets.PermList).WithReq(ctx.R).IsAllowed() != nil {
+		return nil
+	}
+	msgr := i18n.MustGetModuleMessages(ctx.R, I18nActivityKey, Messages_en_US).(*Messages)
+	return vuetify.VBtn(msgr.VerifyHashChain).Color(presets.ColorPrimary).Depressed(true).Dark(true).Class("ml-2").
+		Attr("@click", web.Plaid().
+			URL(ab.lmb.Info().ListingHref()).
+			EventFunc(eventVerifyHashChain).
+			Go())
+}
+
+func (ab *ActivityBuilder) eventVerifyHashChain(ctx *web.EventContext) (r web.EventResponse, err error) {
+	if ab.lmb.Info().Verifier().Do(presets.PermList).WithReq(ctx.R).IsAllowed() != nil {
+		presets.ShowMessage(&r, perm.PermissionDenied.Error(), "warning")
+		return
+	}
+	msgr := i18n.MustGetModuleMessages(ctx.R, I18nActivityKey, Messages_en_US).(*Messages)
+
+	report, err := ab.VerifyHashChain(ctx.R.Context())
+	if err != nil {
+		return
+	}
+	if report.Intact() {
+		presets.ShowMessage(&r, fmt.Sprintf(msgr.HashChainIntact, report.Checked), "")
+		return
+	}
+
+	var breaks []string
+	for i, b := range report.Breaks {
+		if i == 10 {
+			breaks = append(breaks, "...")
+			break
+		}
+		breaks = append(breaks, fmt.Sprintf("#%d (%s)", b.ID, b.Reason))
+	}
+	presets.ShowMessage(&r, fmt.Sprintf(msgr.HashChainBroken, len(report.Breaks), strings.Join(breaks, ", ")), "error")
+	return
+}
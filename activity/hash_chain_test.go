@@ -0,0 +1,192 @@
+package activity
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestHashChain(t *testing.T) {
+	builder := New(pb, db, &TestActivityLog{})
+	builder.RegisterModel(&TestActivityModel{})
+	resetDB()
+
+	// the logs before the hash chain is enabled are not chained
+	builder.AddCreateRecord("creator a", TestActivityModel{ID: 1, Title: "a"}, db)
+	builder.EnableHashChain()
+	for _, title := range []string{"b", "c", "d", "e"} {
+		if err := builder.AddEditRecordWithOld("creator a", TestActivityModel{ID: 1, Title: "a"}, TestActivityModel{ID: 1, Title: title}, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var logs []*TestActivityLog
+	db.Order("id").Find(&logs)
+	if logs[0].Hash != "" || logs[1].PrevHash != "" || logs[2].PrevHash != logs[1].Hash || logs[4].Hash == "" {
+		t.Fatalf("want the logs chained, but got %#+v", logs)
+	}
+
+	report, err := builder.VerifyHashChain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Intact() || report.Checked != 4 || report.FirstID != logs[1].ID || report.LastID != logs[4].ID {
+		t.Errorf("want the hash chain of 4 logs intact, but got %#+v", report)
+	}
+
+	db.Model(&TestActivityLog{}).Where("id = ?", logs[2].ID).Update("creator", "creator b")
+	db.Delete(&TestActivityLog{}, logs[3].ID)
+	report, err = builder.VerifyHashChain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*HashChainBreak{
+		{ID: logs[2].ID, Reason: HashChainBreakContent},
+		{ID: logs[4].ID, Reason: HashChainBreakLink},
+	}
+	if !reflect.DeepEqual(report.Breaks, want) {
+		t.Errorf("want the breaks %#+v, but got %#+v", want, report.Breaks)
+	}
+
+	// removing the oldest logs out of the retention breaks the chain too
+	db.Delete(&TestActivityLog{}, []uint{logs[1].ID, logs[2].ID, logs[3].ID})
+	report, err = builder.VerifyHashChain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*HashChainBreak{{ID: logs[4].ID, Reason: HashChainBreakLink}}; !reflect.DeepEqual(report.Breaks, want) {
+		t.Errorf("want the breaks %#+v, but got %#+v", want, report.Breaks)
+	}
+
+	// removing the latest logs breaks the chain at the tail
+	for _, title := range []string{"f", "g"} {
+		if err := builder.AddEditRecordWithOld("creator a", TestActivityModel{ID: 1, Title: "a"}, TestActivityModel{ID: 1, Title: title}, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logs = nil
+	db.Order("id").Find(&logs)
+	last := logs[len(logs)-1]
+	db.Delete(&TestActivityLog{}, last.ID)
+	if report, err = builder.VerifyHashChain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want = []*HashChainBreak{
+		{ID: logs[1].ID, Reason: HashChainBreakLink},
+		{ID: last.ID, Reason: HashChainBreakTail},
+	}
+	if !reflect.DeepEqual(report.Breaks, want) {
+		t.Errorf("want the breaks %#+v, but got %#+v", want, report.Breaks)
+	}
+
+	// removing all the chained logs too
+	db.Where("hash <> ?", "").Delete(&TestActivityLog{})
+	if report, err = builder.VerifyHashChain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []*HashChainBreak{{ID: last.ID, Reason: HashChainBreakTail}}; report.Checked != 0 || !reflect.DeepEqual(report.Breaks, want) {
+		t.Errorf("want the breaks %#+v, but got %#+v", want, report.Breaks)
+	}
+}
+
+func TestHashChainWithRetention(t *testing.T) {
+	builder := New(pb, db, &TestActivityLog{}).EnableHashChain()
+	mb := builder.RegisterModel(&TestActivityModel{}).SetRetention(RetentionPolicy{MaxCount: 1})
+	resetDB()
+
+	// the logs of the records are interleaved, so the retention removes the logs from the middle of the chain
+	for _, id := range []uint{1, 2, 1, 2, 1} {
+		if err := builder.AddCreateRecord("creator a", TestActivityModel{ID: id, Title: "a"}, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var logs []*TestActivityLog
+	db.Order("id").Find(&logs)
+
+	if n, err := builder.ApplyRetention(context.Background()); err != nil || n != 3 {
+		t.Fatalf("want 3 logs removed, but got %d %v", n, err)
+	}
+	report, err := builder.VerifyHashChain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Intact() || report.Checked != 2 || report.FirstID != logs[3].ID {
+		t.Errorf("want the hash chain of the remaining logs intact, but got %#+v", report)
+	}
+
+	// the retention removes all the logs, the next log links to the removed head
+	ctx := context.WithValue(context.Background(), DBContextKey, db.Session(&gorm.Session{NowFunc: func() time.Time {
+		return time.Now().Add(48 * time.Hour)
+	}}))
+	mb.SetRetention(RetentionPolicy{MaxAge: 24 * time.Hour})
+	if n, err := builder.ApplyRetention(ctx); err != nil || n != 2 {
+		t.Fatalf("want 2 logs removed, but got %d %v", n, err)
+	}
+	for _, title := range []string{"b", "c", "d"} {
+		if err := builder.AddCreateRecord("creator a", TestActivityModel{ID: 3, Title: title}, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if report, err = builder.VerifyHashChain(context.Background()); err != nil || !report.Intact() || report.Checked != 3 {
+		t.Errorf("want the hash chain after the retention intact, but got %#+v %v", report, err)
+	}
+
+	// the logs removed out of the retention are still breaks
+	logs = nil
+	db.Order("id").Find(&logs)
+	db.Delete(&TestActivityLog{}, logs[1].ID)
+	report, err = builder.VerifyHashChain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []*HashChainBreak{{ID: logs[2].ID, Reason: HashChainBreakLink}}; !reflect.DeepEqual(report.Breaks, want) {
+		t.Errorf("want the breaks %#+v, but got %#+v", want, report.Breaks)
+	}
+}
+
+func TestHashChainAcrossBuilders(t *testing.T) {
+	// the builders stand for the processes sharing the database
+	var builders []*ActivityBuilder
+	for i := 0; i < 2; i++ {
+		builder := New(pb, db, &TestActivityLog{}).EnableHashChain()
+		builder.RegisterModel(&TestActivityModel{})
+		builders = append(builders, builder)
+	}
+	resetDB()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := builders[i%2].AddCreateRecord("creator a", TestActivityModel{ID: uint(i + 1), Title: "a"}, db); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	report, err := builders[0].VerifyHashChain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Intact() || report.Checked != 10 {
+		t.Errorf("want the hash chain of 10 logs intact, but got %#+v", report)
+	}
+}
+
+func TestActivityLogReadOnly(t *testing.T) {
+	builder := New(pb, db, &TestActivityLog{})
+	editing := builder.GetPresetModelBuilder().Editing()
+	if err := editing.Saver(&TestActivityLog{}, "", nil); !errors.Is(err, ErrActivityLogReadOnly) {
+		t.Errorf("want the logs read only, but got %v", err)
+	}
+	if err := editing.Deleter(&TestActivityLog{}, "1", nil); !errors.Is(err, ErrActivityLogReadOnly) {
+		t.Errorf("want the logs read only, but got %v", err)
+	}
+}
@@ -1,12 +1,13 @@
 package activity
 
 type Messages struct {
-	Activities   string
-	ActionAll    string
-	ActionView   string
-	ActionEdit   string
-	ActionCreate string
-	ActionDelete string
+	Activities         string
+	LoadMoreActivities string
+	ActionAll          string
+	ActionView         string
+	ActionEdit         string
+	ActionCreate       string
+	ActionDelete       string
 
 	ModelUserID    string
 	ModelCreatedAt string
@@ -31,15 +32,45 @@ type Messages struct {
 	DiffOld     string
 	DiffNow     string
 	DiffValue   string
+	DiffMoved   string
+	DiffFrom    string
+	DiffTo      string
+
+	RevertLog           string
+	RevertSince         string
+	RevertConfirm       string
+	RevertSuccessfully  string
+	RevertNothing       string
+	RevertConflicts     string
+	RevertConflictField string
+
+	UserTimelineTitle  string
+	UserTimelineFrom   string
+	UserTimelineTo     string
+	UserTimelineApply  string
+	UserTimelineCounts string
+	UserTimelineCount  string
+	UserTimelineLatest string
+	ExportCSV          string
+	ExportJSON         string
+
+	VerifyHashChain string
+	HashChainIntact string
+	HashChainBroken string
+
+	SensitiveViews      string
+	SensitiveViewsCount string
+	SensitiveViewsLast  string
 }
 
 var Messages_en_US = &Messages{
-	Activities:   "Activities",
-	ActionAll:    "All",
-	ActionView:   "View",
-	ActionEdit:   "Edit",
-	ActionCreate: "Create",
-	ActionDelete: "Delete",
+	Activities:         "Activities",
+	LoadMoreActivities: "Load more (%d)",
+	ActionAll:          "All",
+	ActionView:         "View",
+	ActionEdit:         "Edit",
+	ActionCreate:       "Create",
+	ActionDelete:       "Delete",
 
 	ModelUserID:    "Creator ID",
 	ModelCreatedAt: "Date Time",
@@ -64,15 +95,45 @@ var Messages_en_US = &Messages{
 	DiffOld:     "Old",
 	DiffNow:     "Now",
 	DiffValue:   "Value",
+	DiffMoved:   "Moved",
+	DiffFrom:    "From",
+	DiffTo:      "To",
+
+	RevertLog:           "Revert this change",
+	RevertSince:         "Revert to before this change",
+	RevertConfirm:       "Are you sure you want to revert the record?",
+	RevertSuccessfully:  "Successfully reverted",
+	RevertNothing:       "Nothing to revert",
+	RevertConflicts:     "Can't revert, these fields have changed since:",
+	RevertConflictField: "%s (expected %q, now %q)",
+
+	UserTimelineTitle:  "Activities of %s",
+	UserTimelineFrom:   "From",
+	UserTimelineTo:     "To",
+	UserTimelineApply:  "Apply",
+	UserTimelineCounts: "Summary (%d)",
+	UserTimelineCount:  "Count",
+	UserTimelineLatest: "Latest %d",
+	ExportCSV:          "Export CSV",
+	ExportJSON:         "Export JSON",
+
+	VerifyHashChain: "Verify Hash Chain",
+	HashChainIntact: "The hash chain of %d logs is intact",
+	HashChainBroken: "The hash chain breaks at %d logs: %s",
+
+	SensitiveViews:      "Sensitive Views",
+	SensitiveViewsCount: "Views",
+	SensitiveViewsLast:  "Last Viewed",
 }
 
 var Messages_zh_CN = &Messages{
-	Activities:   "活动",
-	ActionAll:    "全部",
-	ActionView:   "查看",
-	ActionEdit:   "编辑",
-	ActionCreate: "创建",
-	ActionDelete: "删除",
+	Activities:         "活动",
+	LoadMoreActivities: "加载更多 (%d)",
+	ActionAll:          "全部",
+	ActionView:         "查看",
+	ActionEdit:         "编辑",
+	ActionCreate:       "创建",
+	ActionDelete:       "删除",
 
 	ModelUserID:    "操作者ID",
 	ModelCreatedAt: "日期时间",
@@ -96,4 +157,33 @@ var Messages_zh_CN = &Messages{
 	DiffOld:         "之前的值",
 	DiffNow:         "当前的值",
 	DiffValue:       "值",
+	DiffMoved:       "移动",
+	DiffFrom:        "原位置",
+	DiffTo:          "新位置",
+
+	RevertLog:           "撤销此修改",
+	RevertSince:         "恢复到此修改之前",
+	RevertConfirm:       "确定要恢复该记录吗？",
+	RevertSuccessfully:  "恢复成功",
+	RevertNothing:       "没有需要恢复的内容",
+	RevertConflicts:     "无法恢复，以下字段在此之后已被修改：",
+	RevertConflictField: "%s（应为 %q，当前为 %q）",
+
+	UserTimelineTitle:  "%s 的活动",
+	UserTimelineFrom:   "开始日期",
+	UserTimelineTo:     "结束日期",
+	UserTimelineApply:  "应用",
+	UserTimelineCounts: "汇总 (%d)",
+	UserTimelineCount:  "次数",
+	UserTimelineLatest: "最近 %d 条",
+	ExportCSV:          "导出 CSV",
+	ExportJSON:         "导出 JSON",
+
+	VerifyHashChain: "校验哈希链",
+	HashChainIntact: "%d 条日志的哈希链完整",
+	HashChainBroken: "哈希链在 %d 条日志处断开：%s",
+
+	SensitiveViews:      "敏感数据查看记录",
+	SensitiveViewsCount: "查看次数",
+	SensitiveViewsLast:  "最后查看时间",
 }
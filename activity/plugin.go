@@ -0,0 +1,239 @@
+package activity
+
+import (
+	"context"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+const (
+	pluginOldKey      = "activity:old"
+	pluginConnPoolKey = "activity:conn_pool"
+	gormStartedTxKey  = "gorm:started_transaction"
+)
+
+type skipPluginContextKey struct{}
+
+// Plugin is a gorm plugin that records the creates, updates and deletes of the registered models in the same transaction,
+// the logs are emitted to the sinks after the transaction of the statement is committed.
+// The creator is taken from the context of the statement, e.g. db.WithContext(activity.ContextWithCreator(ctx, name)).
+// Only the statements on records with primary keys are recorded, batch updates and deletes by conditions are not.
+type Plugin struct {
+	ab *ActivityBuilder
+}
+
+// Plugin returns the gorm plugin that captures the activities, it is opt-in:
+//
+//	db.Use(activityBuilder.Plugin())
+//
+// The saves through the presets model builders are still recorded by their SaveFunc and DeleteFunc, not twice.
+func (ab *ActivityBuilder) Plugin() *Plugin {
+	return &Plugin{ab: ab}
+}
+
+func (p *Plugin) Name() string {
+	return "qor5:activity"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	create := db.Callback().Create()
+	if err := create.After("gorm:begin_transaction").Before("gorm:before_create").
+		Register("activity:hold_until_commit", p.holdUntilCommit(Create)); err != nil {
+		return err
+	}
+	if err := create.After("gorm:after_create").Before("gorm:commit_or_rollback_transaction").
+		Register("activity:after_create", p.afterCreate); err != nil {
+		return err
+	}
+	if err := create.After("gorm:commit_or_rollback_transaction").
+		Register("activity:release_committed", p.releaseCommitted); err != nil {
+		return err
+	}
+
+	update := db.Callback().Update()
+	if err := update.After("gorm:begin_transaction").Before("gorm:before_update").
+		Register("activity:hold_until_commit", p.holdUntilCommit(Update)); err != nil {
+		return err
+	}
+	if err := update.After("gorm:begin_transaction").Before("gorm:before_update").
+		Register("activity:before_update", p.loadOld(Update)); err != nil {
+		return err
+	}
+	if err := update.After("gorm:after_update").Before("gorm:commit_or_rollback_transaction").
+		Register("activity:after_update", p.afterUpdate); err != nil {
+		return err
+	}
+	if err := update.After("gorm:commit_or_rollback_transaction").
+		Register("activity:release_committed", p.releaseCommitted); err != nil {
+		return err
+	}
+
+	del := db.Callback().Delete()
+	if err := del.After("gorm:begin_transaction").Before("gorm:before_delete").
+		Register("activity:hold_until_commit", p.holdUntilCommit(Delete)); err != nil {
+		return err
+	}
+	if err := del.After("gorm:begin_transaction").Before("gorm:before_delete").
+		Register("activity:before_delete", p.loadOld(Delete)); err != nil {
+		return err
+	}
+	if err := del.After("gorm:after_delete").Before("gorm:commit_or_rollback_transaction").
+		Register("activity:after_delete", p.afterDelete); err != nil {
+		return err
+	}
+	return del.After("gorm:commit_or_rollback_transaction").
+		Register("activity:release_committed", p.releaseCommitted)
+}
+
+// SkipActivity returns a context that the statements with it are not recorded by the plugin
+func SkipActivity(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipPluginContextKey{}, true)
+}
+
+// modelBuilder returns the model builder of the statement, the one registered with the model itself is preferred
+func (p *Plugin) modelBuilder(db *gorm.DB, action uint8) (*ModelBuilder, bool) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return nil, false
+	}
+	if skip, _ := db.Statement.Context.Value(skipPluginContextKey{}).(bool); skip {
+		return nil, false
+	}
+	// the map keys must be comparable, the presets save the records by pointers
+	if reflect.ValueOf(db.Statement.Dest).Kind() == reflect.Ptr {
+		if _, saving := p.ab.presetSaving.Load(db.Statement.Dest); saving {
+			return nil, false
+		}
+	}
+
+	var found *ModelBuilder
+	for _, mb := range p.ab.models {
+		if mb.typ != db.Statement.Schema.ModelType || mb.skip&action != 0 {
+			continue
+		}
+		if mb.presetModel == nil {
+			return mb, true
+		}
+		if found == nil {
+			found = mb
+		}
+	}
+	return found, found != nil
+}
+
+// records are the addressable records of the statement
+func records(db *gorm.DB) []interface{} {
+	var vs []interface{}
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if v := reflect.Indirect(rv.Index(i)); v.Kind() == reflect.Struct && v.CanAddr() {
+				vs = append(vs, v.Addr().Interface())
+			}
+		}
+	case reflect.Struct:
+		if rv.CanAddr() {
+			vs = append(vs, rv.Addr().Interface())
+		}
+	}
+	return vs
+}
+
+// session is a new statement in the transaction of db, the plugin skips it
+func session(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true, Context: SkipActivity(db.Statement.Context)})
+}
+
+func (p *Plugin) afterCreate(db *gorm.DB) {
+	mb, ok := p.modelBuilder(db, Create)
+	if !ok {
+		return
+	}
+	creator := p.ab.getCreatorFromContext(db.Statement.Context)
+	for _, v := range records(db) {
+		if err := mb.AddCreateRecord(creator, v, session(db)); err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+}
+
+// holdUntilCommit returns the callback that holds the logs of the statement until its own transaction is committed
+func (p *Plugin) holdUntilCommit(action uint8) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if _, started := db.InstanceGet(gormStartedTxKey); !started {
+			return
+		}
+		if _, ok := p.modelBuilder(db, action); !ok {
+			return
+		}
+		p.ab.holdUntilCommit(db)
+		db.InstanceSet(pluginConnPoolKey, db.Statement.ConnPool)
+	}
+}
+
+// releaseCommitted emits the held logs after the transaction of the statement is committed, or drops them if it is rolled back
+func (p *Plugin) releaseCommitted(db *gorm.DB) {
+	if v, ok := db.InstanceGet(pluginConnPoolKey); ok {
+		p.ab.releaseCommitted(v.(gorm.ConnPool), db.Error == nil)
+	}
+}
+
+// loadOld returns the callback that loads the records before they are updated or deleted, the missing ones are nil
+func (p *Plugin) loadOld(action uint8) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if _, ok := p.modelBuilder(db, action); !ok {
+			return
+		}
+		var olds []interface{}
+		for _, v := range records(db) {
+			old, _ := findOld(v, session(db))
+			olds = append(olds, old)
+		}
+		db.InstanceSet(pluginOldKey, olds)
+	}
+}
+
+func (p *Plugin) afterUpdate(db *gorm.DB) {
+	mb, ok := p.modelBuilder(db, Update)
+	if !ok {
+		return
+	}
+	v, _ := db.InstanceGet(pluginOldKey)
+	olds, _ := v.([]interface{})
+	creator := p.ab.getCreatorFromContext(db.Statement.Context)
+	for i, v := range records(db) {
+		if i >= len(olds) || olds[i] == nil {
+			continue
+		}
+		// the record is reloaded since an update by a map or by columns changes only part of it
+		now, ok := findOld(v, session(db))
+		if !ok {
+			continue
+		}
+		if err := mb.AddEditRecordWithOld(creator, olds[i], now, session(db)); err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+}
+
+func (p *Plugin) afterDelete(db *gorm.DB) {
+	mb, ok := p.modelBuilder(db, Delete)
+	if !ok {
+		return
+	}
+	v, _ := db.InstanceGet(pluginOldKey)
+	olds, _ := v.([]interface{})
+	creator := p.ab.getCreatorFromContext(db.Statement.Context)
+	for _, old := range olds {
+		if old == nil {
+			continue
+		}
+		if err := mb.AddDeleteRecord(creator, old, session(db)); err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+}
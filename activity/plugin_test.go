@@ -0,0 +1,96 @@
+package activity
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestPlugin(t *testing.T) {
+	builder := New(pb, db, &TestActivityLog{})
+	builder.RegisterModel(&TestActivityModel{})
+	resetDB()
+
+	pdb, err := gorm.Open(db.Dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pdb.Use(builder.Plugin()); err != nil {
+		t.Fatal(err)
+	}
+	pdb = pdb.WithContext(ContextWithCreator(context.Background(), "creator a"))
+
+	getLogs := func() (logs []TestActivityLog) {
+		t.Helper()
+		if err := db.Order("id").Find(&logs).Error; err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	m := &TestActivityModel{VersionName: "v1", Title: "test"}
+	if err := pdb.Create(m).Error; err != nil {
+		t.Fatal(err)
+	}
+	m.Title = "test1"
+	if err := pdb.Save(m).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := pdb.Model(m).Update("Description", "desc").Error; err != nil {
+		t.Fatal(err)
+	}
+	// nothing changes, no log
+	if err := pdb.Save(m).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := pdb.WithContext(SkipActivity(context.Background())).Model(m).Update("Title", "skipped").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := pdb.Delete(m).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	logs := getLogs()
+	wants := []struct {
+		action string
+		diffs  string
+	}{
+		{ActivityCreate, ""},
+		{ActivityEdit, `[{"Field":"Title","Old":"test","Now":"test1"}]`},
+		{ActivityEdit, `[{"Field":"Description","Old":"","Now":"desc"}]`},
+		{ActivityDelete, ""},
+	}
+	if len(logs) != len(wants) {
+		t.Fatalf("want %d logs, but got %d: %#+v", len(wants), len(logs), logs)
+	}
+	for i, want := range wants {
+		log := logs[i]
+		if log.Action != want.action || log.Creator != "creator a" || log.ModelName != "TestActivityModel" {
+			t.Errorf("want the log %d %s by creator a, but got %#+v", i, want.action, log)
+		}
+		got := log.ModelDiffs
+		if got != "" {
+			got = legacyDiffs(got)
+		}
+		if got != want.diffs {
+			t.Errorf("want the diffs of log %d %v, but got %v", i, want.diffs, got)
+		}
+	}
+
+	// the log is rolled back with the transaction
+	resetDB()
+	errRollback := errors.New("rollback")
+	if err := pdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&TestActivityModel{Title: "rollback"}).Error; err != nil {
+			return err
+		}
+		return errRollback
+	}); !errors.Is(err, errRollback) {
+		t.Fatalf("want the rollback error, but got %v", err)
+	}
+	if logs := getLogs(); len(logs) != 0 {
+		t.Errorf("want no logs after the rollback, but got %#+v", logs)
+	}
+}
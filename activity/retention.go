@@ -0,0 +1,221 @@
+package activity
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"time"
+
+	"github.com/qor/oss"
+	"gorm.io/gorm"
+)
+
+const defaultArchiveBatchSize = 1000
+
+// RetentionPolicy defines which logs of a model are expired, the zero value keeps them all
+type RetentionPolicy struct {
+	MaxAge   time.Duration // the logs older than it are expired
+	MaxCount int           // the logs of a record except the latest MaxCount ones are expired
+}
+
+// IsZero reports whether the policy keeps all the logs
+func (p RetentionPolicy) IsZero() bool {
+	return p.MaxAge <= 0 && p.MaxCount <= 0
+}
+
+// SetRetention sets the retention policy of the logs of the model, they are removed by ApplyRetention
+func (mb *ModelBuilder) SetRetention(p RetentionPolicy) *ModelBuilder {
+	mb.retention = p
+	return mb
+}
+
+// GetRetention get the retention policy of the logs of the model
+func (mb *ModelBuilder) GetRetention() RetentionPolicy {
+	return mb.retention
+}
+
+// SetArchiveStorage archives the expired logs to the storage under dir before they are removed,
+// every batch is a gzip compressed json lines file at dir/<model label or name>/<first id>-<last id>.jsonl.gz.
+// Without a storage the expired logs are removed directly.
+func (ab *ActivityBuilder) SetArchiveStorage(storage oss.StorageInterface, dir string) *ActivityBuilder {
+	ab.archiveStorage = storage
+	ab.archiveDir = dir
+	return ab
+}
+
+// SetArchiveBatchSize sets how many logs are archived into a file, the default is 1000
+func (ab *ActivityBuilder) SetArchiveBatchSize(n int) *ActivityBuilder {
+	ab.archiveBatchSize = n
+	return ab
+}
+
+// ApplyRetention archives and removes the expired logs of every model with a retention policy,
+// it returns the number of the removed logs. It is meant to be run periodically, e.g. by publish.Scheduler.
+func (ab *ActivityBuilder) ApplyRetention(ctx context.Context) (n int64, err error) {
+	for _, mb := range ab.models {
+		var removed int64
+		removed, err = mb.ApplyRetention(ctx)
+		n += removed
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ApplyRetention archives and removes the expired logs of the model
+func (mb *ModelBuilder) ApplyRetention(ctx context.Context) (n int64, err error) {
+	if mb.retention.IsZero() {
+		return 0, nil
+	}
+	db := mb.activity.getDBFromContext(ctx).WithContext(ctx)
+
+	if mb.retention.MaxAge > 0 {
+		before := db.NowFunc().Add(-mb.retention.MaxAge)
+		if n, err = mb.archive(db, func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("created_at < ?", before)
+		}); err != nil {
+			return
+		}
+	}
+
+	if mb.retention.MaxCount > 0 {
+		var keys []string
+		if err = mb.logs(db).Group("model_keys").Having("COUNT(*) > ?", mb.retention.MaxCount).
+			Pluck("model_keys", &keys).Error; err != nil {
+			return
+		}
+		for _, key := range keys {
+			// the logs before the oldest one of the latest MaxCount are expired
+			var ids []uint
+			if err = mb.logs(db).Where("model_keys = ?", key).Order("id DESC").
+				Offset(mb.retention.MaxCount-1).Limit(1).Pluck("id", &ids).Error; err != nil {
+				return
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			var removed int64
+			removed, err = mb.archive(db, func(tx *gorm.DB) *gorm.DB {
+				return tx.Where("model_keys = ? AND id < ?", key, ids[0])
+			})
+			n += removed
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// logs is the query of the logs of the model
+func (mb *ModelBuilder) logs(db *gorm.DB) *gorm.DB {
+	labels := []string{mb.label()}
+	if labels[0] == "-" {
+		// the logs saved without a label
+		labels = append(labels, "")
+	}
+	return db.Model(mb.activity.logModel).Where("model_name = ? AND model_label IN ?", mb.typ.Name(), labels)
+}
+
+// archive writes the logs matched by the scope to the archive storage in batches and removes them
+func (mb *ModelBuilder) archive(db *gorm.DB, scope func(*gorm.DB) *gorm.DB) (n int64, err error) {
+	ab := mb.activity
+	size := ab.archiveBatchSize
+	if size <= 0 {
+		size = defaultArchiveBatchSize
+	}
+
+	for {
+		logs := ab.NewLogModelSlice()
+		if err = scope(mb.logs(db)).Order("id").Limit(size).Find(logs).Error; err != nil {
+			return
+		}
+		values := reflect.Indirect(reflect.ValueOf(logs))
+		if values.Len() == 0 {
+			return
+		}
+
+		var ids []uint
+		for i := 0; i < values.Len(); i++ {
+			ids = append(ids, getLogID(values.Index(i).Interface().(ActivityLogInterface)))
+		}
+
+		if ab.archiveStorage != nil {
+			name := mb.label()
+			if name == "-" {
+				name = mb.typ.Name()
+			}
+			p := path.Join(ab.archiveDir, name, fmt.Sprintf("%d-%d.jsonl.gz", ids[0], ids[len(ids)-1]))
+			if err = writeArchive(ab.archiveStorage, p, values); err != nil {
+				return
+			}
+		}
+
+		var removed int64
+		if err = db.Transaction(func(tx *gorm.DB) error {
+			if err := ab.anchorRemovedLogs(tx, values, ids); err != nil {
+				return err
+			}
+			result := tx.Where("id IN ?", ids).Delete(ab.NewLogModelData())
+			removed = result.RowsAffected
+			return result.Error
+		}); err != nil {
+			return
+		}
+		n += removed
+
+		if values.Len() < size {
+			return
+		}
+	}
+}
+
+func writeArchive(storage oss.StorageInterface, p string, logs reflect.Value) error {
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		enc := json.NewEncoder(zw)
+		for i := 0; i < logs.Len(); i++ {
+			if err := enc.Encode(logs.Index(i).Interface()); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(zw.Close())
+	}()
+
+	_, err := storage.Put(p, pr)
+	pr.CloseWithError(err)
+	return err
+}
+
+// ReadArchive reads the logs of an archive file written by ApplyRetention, it returns a pointer to a slice of the log model
+func (ab ActivityBuilder) ReadArchive(r io.Reader) (interface{}, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	logs := ab.NewLogModelSlice()
+	values := reflect.ValueOf(logs).Elem()
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		log := ab.NewLogModelData()
+		if err := json.Unmarshal(scanner.Bytes(), log); err != nil {
+			return nil, err
+		}
+		values.Set(reflect.Append(values, reflect.ValueOf(log)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
@@ -0,0 +1,96 @@
+package activity
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/qor/oss/filesystem"
+)
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+	builder := New(pb, db, &TestActivityLog{}).SetArchiveStorage(filesystem.New(dir), "activities").SetArchiveBatchSize(2)
+	builder.RegisterModel(&TestActivityModel{}).SetRetention(RetentionPolicy{MaxAge: 24 * time.Hour, MaxCount: 3})
+	resetDB()
+
+	for i := 0; i < 5; i++ {
+		builder.AddCreateRecord("creator a", TestActivityModel{ID: 1, Title: "a"}, db)
+	}
+	builder.AddCreateRecord("creator a", TestActivityModel{ID: 2, Title: "b"}, db)
+	builder.AddCreateRecord("creator a", TestActivityModel{ID: 3, Title: "c"}, db)
+	// the log of the record 3 is expired by age
+	db.Model(&TestActivityLog{}).Where("model_keys = ?", "3").Update("created_at", time.Now().Add(-48*time.Hour))
+
+	var all []*TestActivityLog
+	db.Order("id").Find(&all)
+
+	n, err := builder.ApplyRetention(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("want 3 logs removed, but got %d", n)
+	}
+
+	var remains []*TestActivityLog
+	db.Order("id").Find(&remains)
+	var remainIDs []uint
+	for _, log := range remains {
+		remainIDs = append(remainIDs, log.ID)
+	}
+	if want := []uint{all[2].ID, all[3].ID, all[4].ID, all[5].ID}; !reflect.DeepEqual(remainIDs, want) {
+		t.Errorf("want the remaining logs %v, but got %v", want, remainIDs)
+	}
+
+	objects, err := builder.archiveStorage.List("activities/TestActivityModel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archived []uint
+	for _, o := range objects {
+		f, err := builder.archiveStorage.GetStream(o.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs, err := builder.ReadArchive(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, log := range *logs.(*[]*TestActivityLog) {
+			if log.ModelName != "TestActivityModel" || log.Creator != "creator a" {
+				t.Errorf("want the archived log of TestActivityModel, but got %#+v", log)
+			}
+			archived = append(archived, log.ID)
+		}
+	}
+	sort.Slice(archived, func(i, j int) bool { return archived[i] < archived[j] })
+	if want := []uint{all[0].ID, all[1].ID, all[6].ID}; !reflect.DeepEqual(archived, want) {
+		t.Errorf("want the archived logs %v, but got %v", want, archived)
+	}
+}
+
+func TestGetCustomizeActivityLogsByPage(t *testing.T) {
+	builder := New(pb, db, &TestActivityLog{})
+	builder.RegisterModel(&TestActivityModel{})
+	resetDB()
+
+	for _, title := range []string{"a", "b", "c"} {
+		builder.AddCreateRecord("creator a", TestActivityModel{ID: 1, Title: title}, db)
+	}
+	builder.AddCreateRecord("creator a", TestActivityModel{ID: 2}, db)
+
+	logs, total := builder.GetCustomizeActivityLogsByPage(TestActivityModel{ID: 1}, db, 2, 2)
+	if total != 3 {
+		t.Errorf("want 3 logs, but got %d", total)
+	}
+	page := *logs.(*[]*TestActivityLog)
+	var all []*TestActivityLog
+	db.Where("model_keys = ?", "1").Order("id").Find(&all)
+	if len(page) != 1 || page[0].ID != all[0].ID {
+		t.Errorf("want the oldest log on the second page, but got %#+v", page)
+	}
+}
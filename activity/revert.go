@@ -0,0 +1,474 @@
+package activity
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/ui/vuetify"
+	"github.com/qor5/web"
+	"github.com/qor5/x/i18n"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+)
+
+const ActivityRevert = "Revert"
+
+type revertContextKey struct{}
+
+var (
+	ErrRevertNotSupported = errors.New("the activity can't be reverted")
+	ErrRevertNothing      = errors.New("nothing to revert")
+)
+
+// RevertConflict is a field that has changed since the reverted activity
+type RevertConflict struct {
+	Field    string
+	Expected string
+	Current  string
+}
+
+// RevertConflictError is returned if the record has changed since the reverted activity, nothing is reverted
+type RevertConflictError struct {
+	Conflicts []RevertConflict
+}
+
+func (e *RevertConflictError) Error() string {
+	var fields []string
+	for _, c := range e.Conflicts {
+		fields = append(fields, c.Field)
+	}
+	return fmt.Sprintf("changed since the activity: %s", strings.Join(fields, ", "))
+}
+
+// RevertLog reverts the changes of the edit log back onto the record through the presets SaveFunc of its model
+func (ab *ActivityBuilder) RevertLog(ctx *web.EventContext, log ActivityLogInterface) error {
+	return ab.revert(ctx, log, false)
+}
+
+// RevertSince reverts the record to its state before the log, all the changes of the log and the later ones are reverted
+func (ab *ActivityBuilder) RevertSince(ctx *web.EventContext, log ActivityLogInterface) error {
+	return ab.revert(ctx, log, true)
+}
+
+// CanRevert reports if the log is an edit of a presets model that can be reverted
+func (ab *ActivityBuilder) CanRevert(log ActivityLogInterface) bool {
+	if log.GetModelDiffs() == "" {
+		return false
+	}
+	_, ok := ab.getModelBuilderByLog(log)
+	return ok
+}
+
+func (ab *ActivityBuilder) getModelBuilderByLog(log ActivityLogInterface) (*ModelBuilder, bool) {
+	for _, mb := range ab.models {
+		if mb.presetModel != nil && mb.typ.Name() == log.GetModelName() && mb.presetModel.Info().URIName() == log.GetModelLabel() {
+			return mb, true
+		}
+	}
+	return nil, false
+}
+
+func (ab *ActivityBuilder) revert(ctx *web.EventContext, log ActivityLogInterface, since bool) error {
+	mb, ok := ab.getModelBuilderByLog(log)
+	if !ok || log.GetModelDiffs() == "" {
+		return ErrRevertNotSupported
+	}
+
+	db := ab.getDBFromContext(ctx.R.Context())
+	obj, err := mb.findByKeys(log.GetModelKeys(), db)
+	if err != nil {
+		return err
+	}
+	if err = mb.presetModel.Info().Verifier().Do(presets.PermUpdate).ObjectOn(obj).WithReq(ctx.R).IsAllowed(); err != nil {
+		return err
+	}
+	old, _ := findOld(obj, db)
+
+	logs := []ActivityLogInterface{log}
+	if since {
+		if logs, err = ab.getLogsSince(log, db); err != nil {
+			return err
+		}
+	}
+
+	objValue := reflect.ValueOf(obj)
+	var conflicts []RevertConflict
+	for _, l := range logs {
+		ds, err := ParseDiffs(l.GetModelDiffs())
+		if err != nil {
+			return err
+		}
+		// the changes are applied in order, so they are reverted backwards
+		for i := len(ds.Changes) - 1; i >= 0; i-- {
+			c := ds.Changes[i]
+			if c.Text {
+				if conflict, err := revertTextChange(objValue, c.Diff()); err != nil {
+					return err
+				} else if conflict != nil {
+					conflicts = append(conflicts, *conflict)
+				}
+				continue
+			}
+
+			err = revertChange(objValue, c)
+			var conflict *changeConflict
+			if errors.As(err, &conflict) {
+				conflicts = append(conflicts, RevertConflict{Field: c.Field(), Expected: textValue(c.New), Current: conflict.current})
+				continue
+			}
+			// the later changes may fail once a former one is skipped for a conflict
+			if err != nil && len(conflicts) == 0 {
+				return err
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return &RevertConflictError{Conflicts: conflicts}
+	}
+
+	changes, err := mb.Changes(old, obj)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return ErrRevertNothing
+	}
+
+	// the revert is recorded as its own activity instead of an edit
+	saveCtx := *ctx
+	saveCtx.R = ctx.R.WithContext(context.WithValue(ctx.R.Context(), revertContextKey{}, true))
+	if err = mb.presetModel.Editing().Saver(obj, mb.slug(obj, db), &saveCtx); err != nil {
+		return err
+	}
+	return mb.addDiff(ActivityRevert, ab.getCreatorFromContext(ctx.R.Context()), old, obj, db)
+}
+
+// revertTextChange reverts a change of strings formatted by the DiffBuilder
+func revertTextChange(v reflect.Value, d Diff) (*RevertConflict, error) {
+	path := strings.Split(d.Field, ".")
+	current, err := getDiffValue(v, path)
+	if err != nil {
+		return nil, err
+	}
+	// a pointer set from nil is formatted with a leading & by the DiffBuilder
+	if current != d.Now && "&"+current != d.Now {
+		return &RevertConflict{Field: d.Field, Expected: d.Now, Current: current}, nil
+	}
+	return nil, setDiffValue(v, path, d.Old)
+}
+
+func isReverting(ctx context.Context) bool {
+	v, _ := ctx.Value(revertContextKey{}).(bool)
+	return v
+}
+
+// getLogsSince returns the logs of the same record from the log on, the latest first
+func (ab *ActivityBuilder) getLogsSince(log ActivityLogInterface, db *gorm.DB) ([]ActivityLogInterface, error) {
+	logs := ab.NewLogModelSlice()
+	if err := db.Where("model_name = ? AND model_label = ? AND model_keys = ? AND id >= ?",
+		log.GetModelName(), log.GetModelLabel(), log.GetModelKeys(), getLogID(log)).
+		Order("id DESC").
+		Find(logs).Error; err != nil {
+		return nil, err
+	}
+	values := reflect.Indirect(reflect.ValueOf(logs))
+	var r []ActivityLogInterface
+	for i := 0; i < values.Len(); i++ {
+		r = append(r, values.Index(i).Interface().(ActivityLogInterface))
+	}
+	return r, nil
+}
+
+func getLogID(log ActivityLogInterface) uint {
+	return uint(reflect.Indirect(reflect.ValueOf(log)).FieldByName("ID").Uint())
+}
+
+// findByKeys finds the record by the keys value of the log
+func (mb *ModelBuilder) findByKeys(keys string, db *gorm.DB) (interface{}, error) {
+	obj := mb.presetModel.NewModel()
+	values := strings.Split(keys, ":")
+	if len(values) != len(mb.keys) {
+		return nil, fmt.Errorf("can't find the record of keys %q", keys)
+	}
+	v := reflect.ValueOf(obj)
+	for i, key := range mb.keys {
+		if err := setDiffValue(v, []string{key}, values[i]); err != nil {
+			return nil, err
+		}
+	}
+	current, ok := findOld(obj, db)
+	if !ok {
+		return nil, fmt.Errorf("can't find the record of keys %q", keys)
+	}
+	return current, nil
+}
+
+func (mb *ModelBuilder) slug(obj interface{}, db *gorm.DB) string {
+	if s, ok := obj.(presets.SlugEncoder); ok {
+		return s.PrimarySlug()
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(obj); err != nil || stmt.Schema.PrioritizedPrimaryField == nil {
+		return ""
+	}
+	value, _ := stmt.Schema.PrioritizedPrimaryField.ValueOf(context.Background(), reflect.ValueOf(obj))
+	return fmt.Sprint(value)
+}
+
+// getDiffValue formats the value at the path the way the DiffBuilder does, the missing values are empty
+func getDiffValue(v reflect.Value, path []string) (string, error) {
+	for _, p := range path {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return "", nil
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			v = v.FieldByName(p)
+			if !v.IsValid() {
+				return "", fmt.Errorf("no field %s", p)
+			}
+		case reflect.Slice, reflect.Array:
+			i, err := strconv.Atoi(p)
+			if err != nil {
+				return "", err
+			}
+			if i >= v.Len() {
+				return "", nil
+			}
+			v = v.Index(i)
+		case reflect.Map:
+			v = v.MapIndex(reflect.ValueOf(p).Convert(v.Type().Key()))
+			if !v.IsValid() {
+				return "", nil
+			}
+		default:
+			return "", fmt.Errorf("no field %s in %s", p, v.Type())
+		}
+	}
+	return formatDiffValue(v), nil
+}
+
+func formatDiffValue(v reflect.Value) string {
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return ""
+		}
+		return formatDiffValue(v.Elem())
+	case reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return ""
+		}
+		return fmt.Sprintf("%+v", v.Interface())
+	case reflect.Struct, reflect.Array:
+		return fmt.Sprintf("%+v", v.Interface())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+// setDiffValue sets the value formatted by the DiffBuilder at the path,
+// an empty value of a pointer, slice or map, or of an appended element or key removes it
+func setDiffValue(v reflect.Value, path []string, value string) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			if value == "" {
+				return nil
+			}
+			if v.Kind() == reflect.Interface || !v.CanSet() {
+				return fmt.Errorf("can't set %s", strings.Join(path, "."))
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if len(path) == 0 && value == "" && v.CanSet() {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		v = v.Elem()
+	}
+	if len(path) == 0 {
+		return parseDiffValue(v, value)
+	}
+
+	p := path[0]
+	switch v.Kind() {
+	case reflect.Struct:
+		f := v.FieldByName(p)
+		if !f.IsValid() {
+			return fmt.Errorf("no field %s", p)
+		}
+		return setDiffValue(f, path[1:], value)
+	case reflect.Slice, reflect.Array:
+		i, err := strconv.Atoi(p)
+		if err != nil {
+			return err
+		}
+		if len(path) == 1 && v.Kind() == reflect.Slice {
+			switch {
+			case value == "" && i == v.Len()-1:
+				v.Set(v.Slice(0, i))
+				return nil
+			case i == v.Len():
+				elem := reflect.New(v.Type().Elem()).Elem()
+				if err = parseDiffValue(elem, value); err != nil {
+					return err
+				}
+				v.Set(reflect.Append(v, elem))
+				return nil
+			}
+		}
+		if i >= v.Len() {
+			return fmt.Errorf("index %d out of range of %s", i, v.Type())
+		}
+		return setDiffValue(v.Index(i), path[1:], value)
+	case reflect.Map:
+		key := reflect.ValueOf(p).Convert(v.Type().Key())
+		if len(path) == 1 && value == "" {
+			v.SetMapIndex(key, reflect.Value{})
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if cur := v.MapIndex(key); cur.IsValid() {
+			elem.Set(cur)
+		}
+		if err := setDiffValue(elem, path[1:], value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		return nil
+	}
+	return fmt.Errorf("no field %s in %s", p, v.Type())
+}
+
+func parseDiffValue(v reflect.Value, value string) error {
+	if !v.CanSet() {
+		return fmt.Errorf("can't set %s", v.Type())
+	}
+	if _, ok := v.Interface().(time.Time); ok {
+		if value == "" {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+		return nil
+	case reflect.Slice, reflect.Map:
+		if value == "" {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+	}
+	return fmt.Errorf("can't revert the value %q of %s", value, v.Type())
+}
+
+const eventRevert = "activity_revert"
+
+func (ab *ActivityBuilder) eventRevert(ctx *web.EventContext) (r web.EventResponse, err error) {
+	msgr := i18n.MustGetModuleMessages(ctx.R, I18nActivityKey, Messages_en_US).(*Messages)
+
+	log := ab.NewLogModelData().(ActivityLogInterface)
+	if err = ab.getDBFromContext(ctx.R.Context()).Where("id = ?", ctx.R.FormValue("logID")).First(log).Error; err != nil {
+		return
+	}
+
+	if ctx.R.FormValue("since") == "true" {
+		err = ab.RevertSince(ctx, log)
+	} else {
+		err = ab.RevertLog(ctx, log)
+	}
+
+	var conflictErr *RevertConflictError
+	switch {
+	case err == nil:
+		presets.ShowMessage(&r, msgr.RevertSuccessfully, "")
+		r.Reload = true
+	case errors.As(err, &conflictErr):
+		var fields []string
+		for _, c := range conflictErr.Conflicts {
+			fields = append(fields, fmt.Sprintf(msgr.RevertConflictField, c.Field, c.Expected, c.Current))
+		}
+		presets.ShowMessage(&r, msgr.RevertConflicts+" "+strings.Join(fields, "; "), "error")
+	case errors.Is(err, ErrRevertNothing):
+		presets.ShowMessage(&r, msgr.RevertNothing, "warning")
+	default:
+		presets.ShowMessage(&r, err.Error(), "error")
+	}
+	return r, nil
+}
+
+func (ab *ActivityBuilder) revertButtons(log ActivityLogInterface, ctx *web.EventContext) h.HTMLComponent {
+	if !ab.CanRevert(log) {
+		return nil
+	}
+	msgr := i18n.MustGetModuleMessages(ctx.R, I18nActivityKey, Messages_en_US).(*Messages)
+
+	revert := func(since bool) string {
+		return fmt.Sprintf("confirm(%s) && %s", h.JSONString(msgr.RevertConfirm), web.Plaid().
+			URL(ab.lmb.Info().ListingHref()).
+			EventFunc(eventRevert).
+			Query("logID", fmt.Sprint(getLogID(log))).
+			Query("since", fmt.Sprint(since)).
+			Go())
+	}
+	return h.Div(
+		vuetify.VBtn(msgr.RevertLog).Small(true).Outlined(true).Color("primary").Class("mr-2").
+			Attr("@click", revert(false)),
+		vuetify.VBtn(msgr.RevertSince).Small(true).Outlined(true).Color("primary").
+			Attr("@click", revert(true)),
+	).Class("my-2")
+}
@@ -0,0 +1,110 @@
+package activity
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qor5/admin/presets/gorm2op"
+	"github.com/qor5/web"
+)
+
+func TestRevert(t *testing.T) {
+	builder := New(pb, db, &TestActivityLog{}).SetCreatorContextKey("creator")
+	pb.DataOperator(gorm2op.DataOperator(db))
+	pageModel4 := pb.Model(&TestActivityModel{}).URIName("page-04").Label("Page-04")
+	builder.RegisterModel(pageModel4).SetKeys("ID")
+	resetDB()
+
+	newCtx := func() *web.EventContext {
+		return &web.EventContext{R: httptest.NewRequest("POST", "/admin/page-04/1", nil).WithContext(context.WithValue(context.Background(), "creator", "Test User"))}
+	}
+	save := func(title, description string) {
+		t.Helper()
+		data := &TestActivityModel{ID: 1, VersionName: "v1", Title: title, Description: description}
+		if err := pageModel4.Editing().Saver(data, "1", newCtx()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	getLogs := func() []*TestActivityLog {
+		var logs []*TestActivityLog
+		db.Where("model_label = ? AND action IN ?", "page-04", []string{ActivityEdit, ActivityRevert}).Order("id").Find(&logs)
+		return logs
+	}
+	current := func() *TestActivityModel {
+		var m TestActivityModel
+		db.First(&m, 1)
+		return &m
+	}
+
+	db.Create(&TestActivityModel{ID: 1, VersionName: "v1", Title: "t1", Description: "d1"})
+	save("t2", "d1")
+	save("t2", "d2")
+	save("t3", "d2")
+	logs := getLogs()
+	if len(logs) != 3 {
+		t.Fatalf("want 3 edit logs, got %d", len(logs))
+	}
+
+	var conflictErr *RevertConflictError
+	err := builder.RevertLog(newCtx(), logs[0])
+	if !errors.As(err, &conflictErr) || len(conflictErr.Conflicts) != 1 ||
+		conflictErr.Conflicts[0] != (RevertConflict{Field: "Title", Expected: "t2", Current: "t3"}) {
+		t.Fatalf("want a conflict of the title, got %v", err)
+	}
+	if m := current(); m.Title != "t3" || m.Description != "d2" {
+		t.Errorf("want nothing reverted on conflicts, got %+v", m)
+	}
+
+	if err = builder.RevertLog(newCtx(), logs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if m := current(); m.Title != "t3" || m.Description != "d1" {
+		t.Errorf("want the description reverted, got %+v", m)
+	}
+	if err = builder.RevertLog(newCtx(), logs[1]); err == nil {
+		t.Errorf("want a conflict once reverted")
+	}
+
+	if err = builder.RevertSince(newCtx(), logs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if m := current(); m.Title != "t1" || m.Description != "d1" {
+		t.Errorf("want reverted to the state before the first edit, got %+v", m)
+	}
+
+	logs = getLogs()
+	if len(logs) != 5 {
+		t.Fatalf("want the reverts recorded, got %d logs", len(logs))
+	}
+	want := `[{"Field":"Title","Old":"t3","Now":"t1"}]`
+	if l := logs[4]; l.Action != ActivityRevert || l.Creator != "Test User" || legacyDiffs(l.ModelDiffs) != want {
+		t.Errorf("want the revert log %v, got %+v", want, l)
+	}
+}
+
+func TestSetDiffValue(t *testing.T) {
+	p := &Post{
+		Title:    "now",
+		Author:   Author{Age: 3},
+		Comments: []Comment{{Text: "a"}, {Text: "b"}},
+		Tags:     map[string]Tag{"go": {Name: "Go"}},
+	}
+	for _, d := range []Diff{
+		{Field: "Title", Old: "old", Now: "now"},
+		{Field: "Author.Age", Old: "2", Now: "3"},
+		{Field: "Comments.1", Old: "", Now: "{Text:b}"},
+		{Field: "Comments.0.Text", Old: "c", Now: "a"},
+		{Field: "Tags.go.Name", Old: "Golang", Now: "Go"},
+	} {
+		if err := setDiffValue(reflect.ValueOf(p), strings.Split(d.Field, "."), d.Old); err != nil {
+			t.Fatalf("%s: %v", d.Field, err)
+		}
+	}
+	if p.Title != "old" || p.Author.Age != 2 || len(p.Comments) != 1 || p.Comments[0].Text != "c" || p.Tags["go"].Name != "Golang" {
+		t.Errorf("unexpected reverted post %+v", p)
+	}
+}
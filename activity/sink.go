@@ -0,0 +1,272 @@
+package activity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ActivityEvent is a saved log emitted to the sinks
+type ActivityEvent struct {
+	ID         uint
+	CreatedAt  time.Time
+	UserID     uint
+	Creator    string
+	Action     string
+	ModelName  string
+	ModelLabel string
+	ModelKeys  string
+	ModelLink  string
+	ModelDiffs string // the diffs in the format read by ParseDiffs
+}
+
+// Sink receives the saved logs, e.g. to forward them to the external systems
+type Sink interface {
+	Emit(ctx context.Context, e *ActivityEvent) error
+}
+
+// SinkFunc is an in-process sink
+type SinkFunc func(ctx context.Context, e *ActivityEvent) error
+
+func (f SinkFunc) Emit(ctx context.Context, e *ActivityEvent) error {
+	return f(ctx, e)
+}
+
+// TxSink is a sink that writes the events in the transaction of the saved logs, e.g. enqueues them as jobs,
+// so that they are rolled back with the logs. The returned func, if any, is run after the transaction is committed.
+type TxSink interface {
+	Sink
+	EmitInTx(ctx context.Context, tx *gorm.DB, e *ActivityEvent) (committed func(), err error)
+}
+
+// SinkBuilder is a registered sink with its filters
+type SinkBuilder struct {
+	sink    Sink
+	models  map[string]bool
+	actions map[string]bool
+}
+
+// RegisterSink registers a sink that every saved log is emitted to after it is committed,
+// the errors of the sink are logged and don't fail the save. A TxSink emits the log in its transaction instead, its errors fail the save.
+// The logs saved in a transaction are held until it is committed when the transaction is started by Transaction or by a statement of the gorm plugin,
+// in any other transaction they are emitted right after they are saved.
+func (ab *ActivityBuilder) RegisterSink(s Sink) *SinkBuilder {
+	sb := &SinkBuilder{sink: s}
+	ab.sinks = append(ab.sinks, sb)
+	return sb
+}
+
+// Models emits only the logs of the models, by the model name or the model label, default is all
+func (sb *SinkBuilder) Models(names ...string) *SinkBuilder {
+	sb.models = make(map[string]bool)
+	for _, name := range names {
+		sb.models[name] = true
+	}
+	return sb
+}
+
+// Actions emits only the logs of the actions, default is all
+func (sb *SinkBuilder) Actions(actions ...string) *SinkBuilder {
+	sb.actions = make(map[string]bool)
+	for _, action := range actions {
+		sb.actions[action] = true
+	}
+	return sb
+}
+
+func (sb *SinkBuilder) match(e *ActivityEvent) bool {
+	if sb.models != nil && !sb.models[e.ModelName] && !sb.models[e.ModelLabel] {
+		return false
+	}
+	if sb.actions != nil && !sb.actions[e.Action] {
+		return false
+	}
+	return true
+}
+
+// NewActivityEvent returns the event of the log
+func NewActivityEvent(log ActivityLogInterface) *ActivityEvent {
+	return &ActivityEvent{
+		ID:         getLogID(log),
+		CreatedAt:  log.GetCreatedAt(),
+		UserID:     log.GetUserID(),
+		Creator:    log.GetCreator(),
+		Action:     log.GetAction(),
+		ModelName:  log.GetModelName(),
+		ModelLabel: log.GetModelLabel(),
+		ModelKeys:  log.GetModelKeys(),
+		ModelLink:  log.GetModelLink(),
+		ModelDiffs: log.GetModelDiffs(),
+	}
+}
+
+func (ab *ActivityBuilder) hasTxSinks() bool {
+	for _, sb := range ab.sinks {
+		if _, ok := sb.sink.(TxSink); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// emitInTx emits the log to the TxSinks in tx and returns the funcs emitting it to the other sinks, to be run after tx is committed
+func (ab *ActivityBuilder) emitInTx(tx *gorm.DB, l ActivityLogInterface) (committed []func(), err error) {
+	if len(ab.sinks) == 0 {
+		return nil, nil
+	}
+	ctx := tx.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	e := NewActivityEvent(l)
+	for _, sb := range ab.sinks {
+		if !sb.match(e) {
+			continue
+		}
+		if ts, ok := sb.sink.(TxSink); ok {
+			var f func()
+			if f, err = ts.EmitInTx(ctx, tx, e); err != nil {
+				return nil, fmt.Errorf("activity sink %T emit log %d error: %w", sb.sink, e.ID, err)
+			}
+			if f != nil {
+				committed = append(committed, f)
+			}
+			continue
+		}
+		sink := sb.sink
+		committed = append(committed, func() {
+			if err := sink.Emit(ctx, e); err != nil {
+				log.Printf("activity sink %T emit log %d error: %v\n", sink, e.ID, err)
+			}
+		})
+	}
+	return
+}
+
+// commitHooks are the funcs to run after a transaction is committed
+type commitHooks struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+func (h *commitHooks) add(fns ...func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fns = append(h.fns, fns...)
+}
+
+func (h *commitHooks) len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.fns)
+}
+
+// truncate drops the funcs added after the first n, e.g. by a rolled back savepoint
+func (h *commitHooks) truncate(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n < len(h.fns) {
+		h.fns = h.fns[:n]
+	}
+}
+
+func (h *commitHooks) run() {
+	h.mu.Lock()
+	fns := h.fns
+	h.fns = nil
+	h.mu.Unlock()
+	for _, f := range fns {
+		f()
+	}
+}
+
+// afterCommit runs the funcs after the transaction of db is committed if it holds them, otherwise right away
+func (ab *ActivityBuilder) afterCommit(db *gorm.DB, fns ...func()) {
+	if len(fns) == 0 {
+		return
+	}
+	if h, ok := ab.commitHooks.Load(db.Statement.ConnPool); ok {
+		h.(*commitHooks).add(fns...)
+		return
+	}
+	for _, f := range fns {
+		f()
+	}
+}
+
+// holdUntilCommit makes the logs saved in the transaction tx emitted after it is committed, see releaseCommitted
+func (ab *ActivityBuilder) holdUntilCommit(tx *gorm.DB) {
+	ab.commitHooks.LoadOrStore(tx.Statement.ConnPool, &commitHooks{})
+}
+
+// releaseCommitted emits the logs held in the transaction of the conn pool if it is committed, or drops them
+func (ab *ActivityBuilder) releaseCommitted(connPool gorm.ConnPool, committed bool) {
+	h, ok := ab.commitHooks.LoadAndDelete(connPool)
+	if ok && committed {
+		h.(*commitHooks).run()
+	}
+}
+
+// Transaction runs fc in a transaction of db like gorm, the logs saved in it are emitted to the sinks after it is committed
+// and dropped if it is rolled back. In a transaction of Transaction it runs fc in a savepoint.
+func (ab *ActivityBuilder) Transaction(db *gorm.DB, fc func(tx *gorm.DB) error) error {
+	var held gorm.ConnPool
+	err := db.Transaction(func(tx *gorm.DB) (err error) {
+		if h, ok := ab.commitHooks.Load(tx.Statement.ConnPool); ok {
+			// the logs of a rolled back savepoint are dropped
+			n := h.(*commitHooks).len()
+			defer func() {
+				if err != nil {
+					h.(*commitHooks).truncate(n)
+				}
+			}()
+			return fc(tx)
+		}
+
+		held = tx.Statement.ConnPool
+		ab.holdUntilCommit(tx)
+		return fc(tx)
+	})
+	if held != nil {
+		ab.releaseCommitted(held, err == nil)
+	}
+	return err
+}
+
+// FileSink appends the events to a file as json lines
+type FileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileSink opens the file to append the events, it is created if it doesn't exist
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+func (s *FileSink) Emit(ctx context.Context, e *ActivityEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
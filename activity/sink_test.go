@@ -0,0 +1,154 @@
+package activity
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestSinks(t *testing.T) {
+	builder := New(pb, db, &TestActivityLog{})
+	builder.RegisterModel(&TestActivityModel{})
+	builder.RegisterModel(pageModel)
+	resetDB()
+
+	var events []*ActivityEvent
+	builder.RegisterSink(SinkFunc(func(ctx context.Context, e *ActivityEvent) error {
+		events = append(events, e)
+		return nil
+	})).Models("TestActivityModel").Actions(ActivityEdit)
+
+	path := filepath.Join(t.TempDir(), "activities.jsonl")
+	fileSink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.RegisterSink(fileSink).Models("pages")
+
+	builder.AddCreateRecord("creator a", &TestActivityModel{ID: 1, Title: "a"}, db)
+	builder.AddEditRecordWithOld("creator a", &TestActivityModel{ID: 1, Title: "a"}, &TestActivityModel{ID: 1, Title: "b"}, db)
+	builder.AddCreateRecord("creator b", &Page{ID: 2, Title: "p"}, db)
+	if err := fileSink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("want 1 event of the edit, but got %#+v", events)
+	}
+	if e := events[0]; e.ID == 0 || e.Action != ActivityEdit || e.ModelKeys != "1" || e.Creator != "creator a" ||
+		legacyDiffs(e.ModelDiffs) != `[{"Field":"Title","Old":"a","Now":"b"}]` {
+		t.Errorf("want the event of the edit, but got %#+v", e)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []*ActivityEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := &ActivityEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, e)
+	}
+	if len(lines) != 1 || lines[0].ModelLabel != "pages" || lines[0].ModelKeys != "2" || lines[0].Creator != "creator b" {
+		t.Errorf("want 1 line of the page, but got %#+v", lines)
+	}
+}
+
+type testTxSink struct {
+	inTx      []*ActivityEvent
+	committed []*ActivityEvent
+}
+
+func (s *testTxSink) Emit(ctx context.Context, e *ActivityEvent) error {
+	return errors.New("want EmitInTx")
+}
+
+func (s *testTxSink) EmitInTx(ctx context.Context, tx *gorm.DB, e *ActivityEvent) (func(), error) {
+	s.inTx = append(s.inTx, e)
+	return func() { s.committed = append(s.committed, e) }, nil
+}
+
+func TestSinksAfterCommit(t *testing.T) {
+	builder := New(pb, db, &TestActivityLog{})
+	builder.RegisterModel(&TestActivityModel{})
+	resetDB()
+
+	var events []*ActivityEvent
+	builder.RegisterSink(SinkFunc(func(ctx context.Context, e *ActivityEvent) error {
+		events = append(events, e)
+		return nil
+	}))
+	txSink := &testTxSink{}
+	builder.RegisterSink(txSink)
+
+	pdb, err := gorm.Open(db.Dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pdb.Use(builder.Plugin()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the logs of a rolled back transaction are not emitted
+	rollback := errors.New("rollback")
+	err = builder.Transaction(pdb, func(tx *gorm.DB) error {
+		if err := tx.Create(&TestActivityModel{ID: 1, Title: "a"}).Error; err != nil {
+			return err
+		}
+		return builder.Transaction(tx, func(tx *gorm.DB) error {
+			if err := builder.AddCreateRecord("creator a", &TestActivityModel{ID: 2, Title: "b"}, tx); err != nil {
+				return err
+			}
+			return rollback
+		})
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("want the transaction rolled back, but got %v", err)
+	}
+	var count int64
+	db.Model(&TestActivityLog{}).Count(&count)
+	if count != 0 || len(events) != 0 || len(txSink.inTx) != 2 || len(txSink.committed) != 0 {
+		t.Fatalf("want nothing emitted, but got %d logs %#+v %#+v", count, events, txSink)
+	}
+
+	// the logs are held until the transaction is committed
+	err = builder.Transaction(pdb, func(tx *gorm.DB) error {
+		if err := tx.Create(&TestActivityModel{ID: 1, Title: "a"}).Error; err != nil {
+			return err
+		}
+		// the logs of a rolled back savepoint are dropped
+		builder.Transaction(tx, func(tx *gorm.DB) error {
+			builder.AddCreateRecord("creator a", &TestActivityModel{ID: 2, Title: "b"}, tx)
+			return rollback
+		})
+		if len(events) != 0 || len(txSink.committed) != 0 {
+			t.Errorf("want the logs held until the commit, but got %#+v %#+v", events, txSink.committed)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ModelKeys != "1" || len(txSink.committed) != 1 {
+		t.Fatalf("want the committed log emitted, but got %#+v %#+v", events, txSink.committed)
+	}
+
+	// the log of a statement of the plugin is emitted after its own transaction
+	if err := pdb.Create(&TestActivityModel{ID: 3, Title: "c"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].ModelKeys != "3" || len(txSink.committed) != 2 {
+		t.Errorf("want the log of the plugin emitted, but got %#+v %#+v", events, txSink.committed)
+	}
+}
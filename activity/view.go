@@ -0,0 +1,305 @@
+package activity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/admin/presets/actions"
+	"github.com/qor5/ui/vuetify"
+	"github.com/qor5/web"
+	"github.com/qor5/x/i18n"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+)
+
+const eventSensitiveViews = "activity_sensitive_views"
+
+// the requests opening a record, the detailing page, the detailing drawer and the editing form,
+// the other events fetching the record, e.g. saving the form, are not views
+var viewEvents = map[string]bool{
+	"":                      true,
+	actions.DetailingDrawer: true,
+	actions.Edit:            true,
+}
+
+// ViewReportRow is the number of the views of a record by a user
+type ViewReportRow struct {
+	UserID       uint
+	Creator      string
+	ModelName    string
+	ModelLabel   string
+	ModelKeys    string
+	Views        int64
+	LastViewedAt time.Time
+}
+
+// RecordViews records a view log when the detailing page, the detailing drawer or the editing form of the record is opened.
+// The views of the same user on the same record within the window are recorded once, 0 records every view.
+// It wraps the fetch funcs of the detailing and the editing, a fetch func set after it should call AddViewRecord itself.
+func (mb *ModelBuilder) RecordViews(window time.Duration) *ModelBuilder {
+	if mb.presetModel == nil {
+		return mb
+	}
+	mb.viewWindow = window
+	if mb.recordViews {
+		return mb
+	}
+	mb.recordViews = true
+
+	detailing := mb.presetModel.Detailing()
+	if f := detailing.GetFetchFunc(); f != nil {
+		detailing.FetchFunc(mb.viewRecordingFetchFunc(f))
+	}
+	editing := mb.presetModel.Editing()
+	if f := editing.Fetcher; f != nil {
+		editing.FetchFunc(mb.viewRecordingFetchFunc(f))
+	}
+	return mb
+}
+
+// viewRecordingFetchFunc returns the fetch func that records the view of the fetched record if the request opens it
+// and the user is permitted to get it
+func (mb *ModelBuilder) viewRecordingFetchFunc(fetch presets.FetchFunc) presets.FetchFunc {
+	return func(obj interface{}, id string, ctx *web.EventContext) (r interface{}, err error) {
+		if r, err = fetch(obj, id, ctx); err != nil {
+			return
+		}
+		if id == "" || !viewEvents[ctx.R.FormValue(web.EventFuncIDName)] || mb.KeysValue(r) == "" ||
+			mb.presetModel.Info().Verifier().Do(presets.PermGet).ObjectOn(r).WithReq(ctx.R).IsAllowed() != nil {
+			return
+		}
+		rctx := ctx.R.Context()
+		if err := mb.addViewRecordOnce(mb.activity.getCreatorFromContext(rctx), r, mb.activity.getDBFromContext(rctx)); err != nil {
+			log.Printf("activity record the view of %s %s error: %v\n", mb.typ.Name(), mb.KeysValue(r), err)
+		}
+		return
+	}
+}
+
+// Sensitive marks the records of the model sensitive, e.g. containing personal data, their views are in the sensitive view report
+func (mb *ModelBuilder) Sensitive() *ModelBuilder {
+	mb.sensitive = true
+	return mb
+}
+
+// IsSensitive returns whether the records of the model are sensitive
+func (mb *ModelBuilder) IsSensitive() bool {
+	return mb.sensitive
+}
+
+// addViewRecordOnce adds the view record unless the user has viewed the record within the view window,
+// the views of a record by a user lock the same key so that the concurrent views are checked one by one
+func (mb *ModelBuilder) addViewRecordOnce(creator interface{}, v interface{}, db *gorm.DB) error {
+	if mb.viewWindow <= 0 {
+		return mb.AddViewRecord(creator, v, db)
+	}
+	keys, since := mb.KeysValue(v), time.Now().Add(-mb.viewWindow)
+	var viewer string
+	switch user := creator.(type) {
+	case string:
+		viewer = "creator:" + user
+	case CreatorInterface:
+		viewer = fmt.Sprintf("user:%d", user.GetID())
+	default:
+		viewer = "creator:unknown"
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{mb.activity.logTable(), ActivityView, mb.typ.Name(), keys, viewer}, "\x00")))
+
+	return mb.saveUnless(creator, ActivityView, v, db, "", &logDedup{
+		key: hex.EncodeToString(sum[:]),
+		exists: func(db *gorm.DB) *gorm.DB {
+			q := mb.logs(db).Where("action = ? AND model_keys = ? AND created_at >= ?", ActivityView, keys, since)
+			switch user := creator.(type) {
+			case string:
+				return q.Where("creator = ?", user)
+			case CreatorInterface:
+				return q.Where("user_id = ?", user.GetID())
+			default:
+				return q.Where("creator = ?", "unknown")
+			}
+		},
+	})
+}
+
+func (ab ActivityBuilder) hasSensitiveModels() bool {
+	for _, mb := range ab.models {
+		if mb.sensitive {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSensitiveViewReport get who viewed the records of the sensitive models in the query,
+// by user and record from the latest viewed
+func (ab ActivityBuilder) GetSensitiveViewReport(db *gorm.DB, q AuditQuery) (rows []*ViewReportRow, err error) {
+	if db == nil {
+		db = ab.db
+	}
+
+	var models *gorm.DB
+	for _, mb := range ab.models {
+		if !mb.sensitive {
+			continue
+		}
+		if models == nil {
+			models = mb.logs(db.Session(&gorm.Session{NewDB: true}))
+		} else {
+			models = models.Or(mb.logs(db.Session(&gorm.Session{NewDB: true})))
+		}
+	}
+	if models == nil {
+		return nil, nil
+	}
+
+	var groups []struct {
+		UserID     uint
+		Creator    string
+		ModelName  string
+		ModelLabel string
+		ModelKeys  string
+		Views      int64
+		LastID     uint
+	}
+	err = q.scope(db.Model(ab.logModel)).
+		Where("action = ?", ActivityView).
+		Where(models).
+		Select("user_id, creator, model_name, model_label, model_keys, COUNT(*) AS views, MAX(id) AS last_id").
+		Group("user_id, creator, model_name, model_label, model_keys").
+		Order("last_id DESC").
+		Scan(&groups).Error
+	if err != nil || len(groups) == 0 {
+		return
+	}
+
+	// the times are loaded from the logs, the aggregated times are not scanned the same by all the dialects
+	var (
+		ids   []uint
+		times []struct {
+			ID        uint
+			CreatedAt time.Time
+		}
+	)
+	for _, g := range groups {
+		ids = append(ids, g.LastID)
+	}
+	if err = db.Model(ab.logModel).Select("id, created_at").Where("id IN ?", ids).Scan(&times).Error; err != nil {
+		return
+	}
+	viewedAt := map[uint]time.Time{}
+	for _, t := range times {
+		viewedAt[t.ID] = t.CreatedAt
+	}
+
+	for _, g := range groups {
+		rows = append(rows, &ViewReportRow{
+			UserID:       g.UserID,
+			Creator:      g.Creator,
+			ModelName:    g.ModelName,
+			ModelLabel:   g.ModelLabel,
+			ModelKeys:    g.ModelKeys,
+			Views:        g.Views,
+			LastViewedAt: viewedAt[g.LastID],
+		})
+	}
+	return
+}
+
+func (ab *ActivityBuilder) sensitiveViewsButton(ctx *web.EventContext) h.HTMLComponent {
+	if !ab.hasSensitiveModels() {
+		return nil
+	}
+	msgr := i18n.MustGetModuleMessages(ctx.R, I18nActivityKey, Messages_en_US).(*Messages)
+	return vuetify.VBtn(msgr.SensitiveViews).Color(presets.ColorPrimary).Depressed(true).Dark(true).Class("ml-2").
+		Attr("@click", web.Plaid().
+			URL(ab.lmb.Info().ListingHref()).
+			EventFunc(eventSensitiveViews).
+			Go())
+}
+
+func (ab *ActivityBuilder) eventSensitiveViews(ctx *web.EventContext) (r web.EventResponse, err error) {
+	if !ab.hasSensitiveModels() {
+		return r, errors.New("no sensitive models")
+	}
+	var (
+		msgr = i18n.MustGetModuleMessages(ctx.R, I18nActivityKey, Messages_en_US).(*Messages)
+		db   = ab.getDBFromContext(ctx.R.Context())
+		q    = auditQueryFromRequest(ctx.R)
+	)
+
+	rows, err := ab.GetSensitiveViewReport(db, q)
+	if err != nil {
+		return
+	}
+
+	var trs []h.HTMLComponent
+	for _, row := range rows {
+		trs = append(trs, h.Tr(
+			h.Td(h.Text(row.Creator)),
+			h.Td(h.Text(row.ModelName)),
+			h.Td(h.Text(row.ModelLabel)),
+			h.Td(h.Text(row.ModelKeys)),
+			h.Td(h.Text(fmt.Sprint(row.Views))),
+			h.Td(h.Text(row.LastViewedAt.Format("2006-01-02 15:04:05 MST"))),
+		))
+	}
+
+	var (
+		from = ctx.R.FormValue("from")
+		to   = ctx.R.FormValue("to")
+	)
+	r.UpdatePortals = append(r.UpdatePortals, &web.PortalUpdate{
+		Name: presets.DialogPortalName,
+		Body: web.Scope(
+			vuetify.VDialog(
+				vuetify.VCard(
+					vuetify.VCardTitle(
+						h.Text(msgr.SensitiveViews),
+						vuetify.VSpacer(),
+						vuetify.VBtn("").Icon(true).Children(
+							vuetify.VIcon("close"),
+						).Attr("@click.stop", "vars.presetsDialog=false"),
+					),
+					vuetify.VCardText(
+						vuetify.VRow(
+							vuetify.VCol(
+								vuetify.VTextField().Type("date").Label(msgr.UserTimelineFrom).FieldName("from").Value(from).Dense(true),
+							),
+							vuetify.VCol(
+								vuetify.VTextField().Type("date").Label(msgr.UserTimelineTo).FieldName("to").Value(to).Dense(true),
+							),
+							vuetify.VCol(
+								vuetify.VBtn(msgr.UserTimelineApply).Color("primary").
+									Attr("@click", web.Plaid().
+										URL(ab.lmb.Info().ListingHref()).
+										EventFunc(eventSensitiveViews).
+										Go()),
+							),
+						),
+						vuetify.VSimpleTable(
+							h.Thead(h.Tr(
+								h.Th(msgr.ModelCreator),
+								h.Th(msgr.ModelName),
+								h.Th(msgr.ModelLabel),
+								h.Th(msgr.ModelKeys),
+								h.Th(msgr.SensitiveViewsCount),
+								h.Th(msgr.SensitiveViewsLast),
+							)),
+							h.Tbody(trs...),
+						).Dense(true),
+					),
+				),
+			).
+				Attr("v-model", "vars.presetsDialog").
+				Width("900"),
+		).VSlot("{ plaidForm }"),
+	})
+	r.VarsScript = "setTimeout(function(){vars.presetsDialog = true; }, 100)"
+	return
+}
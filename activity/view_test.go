@@ -0,0 +1,135 @@
+package activity
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/admin/presets/actions"
+	"github.com/qor5/admin/presets/gorm2op"
+	"github.com/qor5/web"
+)
+
+func TestRecordViews(t *testing.T) {
+	builder := New(pb, db, &TestActivityLog{})
+	vpb := presets.New().DataOperator(gorm2op.DataOperator(db))
+	customerModel := vpb.Model(&TestActivityModel{}).URIName("view-customers")
+	builder.RegisterModel(customerModel).RecordViews(time.Hour).Sensitive()
+	builder.RegisterModel(&Page{})
+	resetDB()
+	for _, m := range []*TestActivityModel{{ID: 1, Title: "a"}, {ID: 2, Title: "b"}, {ID: 3, Title: "c"}} {
+		db.Create(m)
+	}
+
+	open := func(u auditUser, event string, id string) {
+		r := httptest.NewRequest("GET", "/admin/view-customers/"+id+"?__execute_event__="+event, nil)
+		ctx := &web.EventContext{R: r.WithContext(context.WithValue(r.Context(), CreatorContextKey, u))}
+		fetch := customerModel.Editing().Fetcher
+		if event == "" || event == actions.DetailingDrawer {
+			fetch = customerModel.Detailing().GetFetchFunc()
+		}
+		obj, err := fetch(&TestActivityModel{}, id, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := obj.(*TestActivityModel); fmt.Sprint(m.ID) != id {
+			t.Errorf("want the record %s fetched, but got %#+v", id, m)
+		}
+	}
+
+	a, b := auditUser{id: 1, name: "user a"}, auditUser{id: 2, name: "user b"}
+	open(a, "", "1")
+	open(a, actions.DetailingDrawer, "1") // in the window
+	open(a, actions.Update, "2")          // saving is not a view
+	open(a, actions.AddRowEvent, "2")     // rendering the form again is not a view
+	open(b, actions.Edit, "1")
+	open(a, "", "3")
+	// out of the window
+	db.Model(&TestActivityLog{}).Where("model_keys = ?", "3").Update("created_at", time.Now().Add(-2*time.Hour))
+	open(a, "", "3")
+	// not sensitive
+	builder.AddViewRecord(a, Page{ID: 1}, db)
+
+	var count int64
+	db.Model(&TestActivityLog{}).Where("action = ? AND model_label = ?", ActivityView, "view-customers").Count(&count)
+	if count != 4 {
+		t.Errorf("want 4 views recorded, but got %d", count)
+	}
+
+	rows, err := builder.GetSensitiveViewReport(db, AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type row struct {
+		Creator   string
+		ModelKeys string
+		Views     int64
+	}
+	var got []row
+	for _, r := range rows {
+		if r.LastViewedAt.IsZero() || r.ModelName != "TestActivityModel" {
+			t.Errorf("want the last viewed time of the customer, but got %#+v", r)
+		}
+		got = append(got, row{r.Creator, r.ModelKeys, r.Views})
+	}
+	want := []row{{"user a", "3", 2}, {"user b", "1", 1}, {"user a", "1", 1}}
+	if len(got) != len(want) {
+		t.Fatalf("want the report %v, but got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("want the report %v, but got %v", want, got)
+			break
+		}
+	}
+
+	rows, err = builder.GetSensitiveViewReport(db, AuditQuery{UserID: 2})
+	if err != nil || len(rows) != 1 || rows[0].Creator != "user b" {
+		t.Errorf("want the views of user b, but got %#+v %v", rows, err)
+	}
+}
+
+func TestRecordViewsConcurrently(t *testing.T) {
+	for _, hashChain := range []bool{false, true} {
+		builder := New(pb, db, &TestActivityLog{})
+		if hashChain {
+			builder.EnableHashChain()
+		}
+		vpb := presets.New().DataOperator(gorm2op.DataOperator(db))
+		customerModel := vpb.Model(&TestActivityModel{}).URIName("view-customers")
+		builder.RegisterModel(customerModel).RecordViews(time.Hour)
+		resetDB()
+		db.Create(&TestActivityModel{ID: 1, Title: "a"})
+
+		fetch := customerModel.Detailing().GetFetchFunc()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r := httptest.NewRequest("GET", "/admin/view-customers/1", nil)
+				ctx := &web.EventContext{R: r.WithContext(context.WithValue(r.Context(), CreatorContextKey, auditUser{id: 1, name: "user a"}))}
+				if _, err := fetch(&TestActivityModel{}, "1", ctx); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		var logs []*TestActivityLog
+		db.Where("action = ?", ActivityView).Find(&logs)
+		if len(logs) != 1 || logs[0].ID == 0 || (logs[0].Hash != "") != hashChain {
+			t.Fatalf("want 1 view recorded with the hash chain %v, but got %#+v", hashChain, logs)
+		}
+		if !hashChain {
+			continue
+		}
+		if report, err := builder.VerifyHashChain(context.Background()); err != nil || !report.Intact() || report.Checked != 1 {
+			t.Errorf("want the hash chain intact, but got %#+v %v", report, err)
+		}
+	}
+}
@@ -518,6 +518,8 @@ func NewConfig() Config {
 	l10nM, l10nVM := configL10nModel(b)
 	_ = l10nM
 	publish_view.Configure(b, db, ab, publisher, m, l, product, category, l10nVM)
+	publish_view.ConfigureDependentsJob(w, publisher)
+	publish_view.ConfigureBulkActions(w, db, publisher, ab, product, category)
 
 	initLoginBuilder(db, b, ab)
 
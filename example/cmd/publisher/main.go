@@ -1,6 +1,13 @@
 package main
 
 import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/qor5/admin/example/admin"
 	"github.com/qor5/admin/publish"
 )
@@ -9,6 +16,17 @@ func main() {
 	db := admin.ConnectDB()
 	config := admin.NewConfig()
 	storage := admin.PublishStorage
-	publish.RunPublisher(db, storage, config.Publisher)
-	select {}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	scheduler := publish.NewPublisherScheduler(db, storage, config.Publisher)
+	if addr := os.Getenv("PUBLISHER_HEALTH_ADDR"); addr != "" {
+		go func() {
+			log.Println(http.ListenAndServe(addr, scheduler.HealthHandler()))
+		}()
+	}
+	if err := scheduler.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
 }
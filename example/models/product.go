@@ -48,6 +48,19 @@ func (p *Product) GetUnPublishActions(db *gorm.DB, ctx context.Context, storage
 	return
 }
 
+// GetPublishDependents returns the online categories listing the product
+func (p *Product) GetPublishDependents(db *gorm.DB, ctx context.Context) (dependents []interface{}, err error) {
+	var categories []*Category
+	if err = db.Where("? = ANY(products) AND status = ?", strconv.Itoa(int(p.ID)), publish.StatusOnline).
+		Find(&categories).Error; err != nil {
+		return
+	}
+	for _, c := range categories {
+		dependents = append(dependents, c)
+	}
+	return
+}
+
 func (p *Product) PermissionRN() []string {
 	return []string{"products", strconv.Itoa(int(p.ID)), p.Code, p.Version.Version}
 }
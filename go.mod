@@ -41,6 +41,7 @@ require (
 	go.uber.org/zap v1.24.0
 	goji.io v2.0.2+incompatible
 	golang.org/x/text v0.9.0
+	golang.org/x/time v0.3.0
 	gorm.io/driver/postgres v1.4.8
 	gorm.io/driver/sqlite v1.4.4
 	gorm.io/gorm v1.24.2
@@ -96,7 +97,6 @@ require (
 	golang.org/x/image v0.7.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/oauth2 v0.8.0 // indirect
-	golang.org/x/time v0.3.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
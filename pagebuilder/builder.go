@@ -41,6 +41,8 @@ type RenderInput struct {
 	IsEditor   bool
 	IsReadonly bool
 	Device     string
+	// the rendered containers nested in the slots of the container by the slot names
+	Slots map[string]h.HTMLComponent
 }
 
 type RenderFunc func(obj interface{}, input *RenderInput, ctx *web.EventContext) h.HTMLComponent
@@ -78,6 +80,7 @@ type Builder struct {
 	publishBtnColor   string
 	duplicateBtnColor string
 	templateEnabled   bool
+	l10nB             *l10n.Builder
 }
 
 const (
@@ -215,6 +218,7 @@ func (b *Builder) Configure(pb *presets.Builder, db *gorm.DB, l10nB *l10n.Builde
 	}
 
 	b.mb = pm
+	b.l10nB = l10nB
 	pm.Listing("ID", "Online", "Title", "Slug")
 	dp := pm.Detailing("Overview")
 	dp.Field("Overview").ComponentFunc(settings(db, pm))
@@ -1789,6 +1793,7 @@ type ContainerBuilder struct {
 	modelType  reflect.Type
 	renderFunc RenderFunc
 	cover      string
+	slots      []string
 }
 
 func (b *Builder) RegisterContainer(name string) (r *ContainerBuilder) {
@@ -1837,6 +1842,26 @@ func (b *ContainerBuilder) Cover(v string) *ContainerBuilder {
 	return b
 }
 
+// Slots defines the slots that other containers can be nested in, e.g. "Left" and "Right" of a two columns container.
+// The nested containers are rendered into RenderInput.Slots by the slot names.
+func (b *ContainerBuilder) Slots(names ...string) *ContainerBuilder {
+	b.slots = names
+	return b
+}
+
+func (b *ContainerBuilder) GetSlots() []string {
+	return b.slots
+}
+
+func (b *ContainerBuilder) hasSlot(name string) bool {
+	for _, s := range b.slots {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *ContainerBuilder) NewModel() interface{} {
 	return reflect.New(b.modelType).Interface()
 }
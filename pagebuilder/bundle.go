@@ -0,0 +1,735 @@
+package pagebuilder
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/l10n"
+	"github.com/qor5/admin/media/media_library"
+	"github.com/qor5/admin/publish"
+	"github.com/qor5/admin/seo"
+	"github.com/sunfmin/reflectutils"
+	"gorm.io/gorm"
+)
+
+// PageBundleVersion is the version of the bundle format written by ExportPage
+const PageBundleVersion = 1
+
+type PageBundleFormat string
+
+const (
+	// PageBundleFormatJSON is the bundle in a JSON file, the media are referenced by their urls
+	PageBundleFormatJSON PageBundleFormat = "json"
+	// PageBundleFormatZip is the bundle in a zip file with the media files
+	PageBundleFormatZip PageBundleFormat = "zip"
+
+	pageBundleFileName = "page.json"
+
+	// the path of the media library files by the ids, see the url tag of media_library.MediaLibrary
+	mediaLibraryFilePath = "/system/media_libraries/%d/file"
+)
+
+var (
+	ErrPageImportConflicts = errors.New("the imported page conflicts with the existing ones")
+	ErrPageBundleTooLarge  = errors.New("the page bundle is too large")
+
+	// the max size of the bundle read by ImportPage and of a file in the zip bundle
+	maxPageBundleSize     = 256 << 20
+	maxPageBundleFileSize = 64 << 20
+
+	errPageImportDryRun = errors.New("page import dry run")
+	mediaBoxType        = reflect.TypeOf(media_library.MediaBox{})
+)
+
+// PageBundle is a page version with everything it references, to move the page to another database
+type PageBundle struct {
+	Version          int
+	ExportedAt       time.Time
+	Page             *PageBundlePage
+	Category         *PageBundleCategory `json:",omitempty"`
+	Containers       []*PageBundleContainer
+	SharedContainers []*PageBundleSharedContainer `json:",omitempty"`
+	Media            []*PageBundleMedia           `json:",omitempty"`
+}
+
+type PageBundlePage struct {
+	ID         uint
+	Version    string
+	LocaleCode string
+	Title      string
+	Slug       string
+	SEO        seo.Setting
+}
+
+// PageBundleCategory is the category of the page, it is matched by the path when imported
+type PageBundleCategory struct {
+	ID          uint
+	Name        string
+	Path        string
+	Description string
+}
+
+// PageBundleContainer is a container of the page, the parents are before their nested containers.
+// The model of a shared container is in the shared containers of the bundle.
+type PageBundleContainer struct {
+	ID           uint
+	ParentID     uint
+	Slot         string
+	ModelName    string
+	ModelID      uint
+	DisplayName  string
+	DisplayOrder float64
+	Shared       bool
+	Hidden       bool
+	Model        json.RawMessage `json:",omitempty"`
+}
+
+type PageBundleSharedContainer struct {
+	ModelName   string
+	ModelID     uint
+	DisplayName string
+	Model       json.RawMessage
+}
+
+// PageBundleMedia is a media library record referenced by the page or the containers
+type PageBundleMedia struct {
+	ID           uint
+	SelectedType string
+	File         media_library.MediaLibraryStorage
+	// the names of the files in the zip bundle by their urls
+	Files map[string]string `json:",omitempty"`
+}
+
+type PageImportOptions struct {
+	// the locale of the imported page, the locale of the bundle by default
+	LocaleCode string
+	// replaces the slug of the bundle, e.g. to resolve the slug conflict
+	Slug string
+	// creates the category of the page when there is no category with the path
+	CreateCategory bool
+	// only reports the conflicts without importing the page
+	DryRun bool
+}
+
+type PageImportConflict struct {
+	// Slug or Category
+	Field   string
+	Value   string
+	Message string
+}
+
+type PageImportResult struct {
+	// the imported draft page, nil when it is a dry run or there are conflicts
+	Page      *Page
+	Conflicts []*PageImportConflict
+}
+
+// walkMediaBoxes calls f with the media boxes in the exported fields of v, including the nested structs and slices
+func walkMediaBoxes(v reflect.Value, f func(box *media_library.MediaBox)) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			walkMediaBoxes(v.Elem(), f)
+		}
+	case reflect.Struct:
+		if v.Type() == mediaBoxType {
+			if v.CanAddr() {
+				f(v.Addr().Interface().(*media_library.MediaBox))
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				walkMediaBoxes(v.Field(i), f)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walkMediaBoxes(v.Index(i), f)
+		}
+	}
+}
+
+func (b *Builder) findContainerBuilder(name string) *ContainerBuilder {
+	for _, cb := range b.containerBuilders {
+		if cb.name == name {
+			return cb
+		}
+	}
+	return nil
+}
+
+// NewPageBundle collects the page version in the locale with its category, containers, shared containers and media
+func (b *Builder) NewPageBundle(db *gorm.DB, pageID uint, version, locale string) (bundle *PageBundle, err error) {
+	if db == nil {
+		db = b.db
+	}
+
+	p := &Page{}
+	if err = db.First(p, "id = ? AND version = ? AND locale_code = ?", pageID, version, locale).Error; err != nil {
+		return
+	}
+	bundle = &PageBundle{
+		Version:    PageBundleVersion,
+		ExportedAt: db.NowFunc(),
+		Page: &PageBundlePage{
+			ID:         p.ID,
+			Version:    p.GetVersion(),
+			LocaleCode: p.LocaleCode,
+			Title:      p.Title,
+			Slug:       p.Slug,
+			SEO:        p.SEO,
+		},
+	}
+
+	mediaIDs := map[string]bool{}
+	collectMedia := func(box *media_library.MediaBox) {
+		if id := box.ID.String(); id != "" && id != "0" {
+			mediaIDs[id] = true
+		}
+	}
+	walkMediaBoxes(reflect.ValueOf(&bundle.Page.SEO), collectMedia)
+
+	category, err := p.GetCategory(db)
+	if err != nil {
+		return
+	}
+	if category.ID != 0 {
+		bundle.Category = &PageBundleCategory{
+			ID:          category.ID,
+			Name:        category.Name,
+			Path:        category.Path,
+			Description: category.Description,
+		}
+	}
+
+	var cons []*Container
+	err = db.Order("display_order ASC").Find(&cons, "page_id = ? AND page_version = ? AND locale_code = ?", pageID, version, locale).Error
+	if err != nil {
+		return
+	}
+	shared := map[string]bool{}
+	for _, c := range sortContainersByParents(cons) {
+		cb := b.findContainerBuilder(c.ModelName)
+		if cb == nil {
+			return nil, fmt.Errorf("no container: %s", c.ModelName)
+		}
+		model := cb.NewModel()
+		if err = db.First(model, "id = ?", c.ModelID).Error; err != nil {
+			return
+		}
+		walkMediaBoxes(reflect.ValueOf(model), collectMedia)
+		var data json.RawMessage
+		if data, err = json.Marshal(model); err != nil {
+			return
+		}
+
+		bc := &PageBundleContainer{
+			ID:           c.ID,
+			ParentID:     c.ParentID,
+			Slot:         c.Slot,
+			ModelName:    c.ModelName,
+			ModelID:      c.ModelID,
+			DisplayName:  c.DisplayName,
+			DisplayOrder: c.DisplayOrder,
+			Shared:       c.Shared,
+			Hidden:       c.Hidden,
+		}
+		bundle.Containers = append(bundle.Containers, bc)
+		if !c.Shared {
+			bc.Model = data
+			continue
+		}
+		key := fmt.Sprintf("%s_%d", c.ModelName, c.ModelID)
+		if shared[key] {
+			continue
+		}
+		shared[key] = true
+		bundle.SharedContainers = append(bundle.SharedContainers, &PageBundleSharedContainer{
+			ModelName:   c.ModelName,
+			ModelID:     c.ModelID,
+			DisplayName: c.DisplayName,
+			Model:       data,
+		})
+	}
+
+	if len(mediaIDs) == 0 {
+		return
+	}
+	var ids []string
+	for id := range mediaIDs {
+		ids = append(ids, id)
+	}
+	var medias []*media_library.MediaLibrary
+	if err = db.Order("id ASC").Find(&medias, "id IN ?", ids).Error; err != nil {
+		return
+	}
+	for _, m := range medias {
+		bundle.Media = append(bundle.Media, &PageBundleMedia{
+			ID:           m.ID,
+			SelectedType: m.SelectedType,
+			File:         m.File,
+		})
+	}
+	return
+}
+
+// mediaFileURLs returns the urls of the file and its sizes
+func mediaFileURLs(file media_library.MediaLibraryStorage) (urls []string) {
+	if file.Url == "" {
+		return
+	}
+	urls = append(urls, file.URL())
+	for key := range file.FileSizes {
+		if key != "default" {
+			urls = append(urls, file.URL(key))
+		}
+	}
+	return
+}
+
+// ExportPage writes the bundle of the page version in the locale to w,
+// the zip bundle contains the media files, the JSON bundle references them by the urls
+func (b *Builder) ExportPage(db *gorm.DB, w io.Writer, pageID uint, version, locale string, format PageBundleFormat) (err error) {
+	bundle, err := b.NewPageBundle(db, pageID, version, locale)
+	if err != nil {
+		return
+	}
+
+	switch format {
+	case PageBundleFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(bundle)
+	case PageBundleFormatZip:
+	default:
+		return fmt.Errorf("unknown page bundle format: %s", format)
+	}
+
+	zw := zip.NewWriter(w)
+	for _, m := range bundle.Media {
+		m.Files = map[string]string{}
+		for i, url := range mediaFileURLs(m.File) {
+			var f io.ReadCloser
+			if f, err = m.File.Retrieve(url); err != nil {
+				// the sizes may not be stored, e.g. the original of an image that is not cropped
+				if i > 0 {
+					err = nil
+					continue
+				}
+				return fmt.Errorf("retrieve media %d file %s: %w", m.ID, url, err)
+			}
+			name := fmt.Sprintf("media/%d/%s", m.ID, path.Base(url))
+			var fw io.Writer
+			if fw, err = zw.Create(name); err == nil {
+				_, err = io.Copy(fw, f)
+			}
+			f.Close()
+			if err != nil {
+				return
+			}
+			m.Files[url] = name
+		}
+	}
+
+	fw, err := zw.Create(pageBundleFileName)
+	if err != nil {
+		return
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(bundle); err != nil {
+		return
+	}
+	return zw.Close()
+}
+
+// ImportPage creates a draft page with the containers in the bundle exported by ExportPage, the format is detected from the content.
+// The ids of the containers and the media are remapped, the shared containers are reused by the names in the locale or created.
+// The conflicts of the slug or the category are reported in the result with ErrPageImportConflicts, and nothing is imported,
+// a dry run reports them without the error.
+func (b *Builder) ImportPage(db *gorm.DB, r io.Reader, opts PageImportOptions) (result *PageImportResult, err error) {
+	if db == nil {
+		db = b.db
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(maxPageBundleSize)+1))
+	if err != nil {
+		return
+	}
+	if len(data) > maxPageBundleSize {
+		return nil, ErrPageBundleTooLarge
+	}
+
+	var (
+		bundle = &PageBundle{}
+		files  = map[string]*zip.File{}
+	)
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		var zr *zip.Reader
+		if zr, err = zip.NewReader(bytes.NewReader(data), int64(len(data))); err != nil {
+			return
+		}
+		for _, f := range zr.File {
+			files[f.Name] = f
+		}
+		bf, ok := files[pageBundleFileName]
+		if !ok {
+			return nil, fmt.Errorf("no %s in the page bundle", pageBundleFileName)
+		}
+		if data, err = readZipFile(bf); err != nil {
+			return
+		}
+	}
+	if err = json.Unmarshal(data, bundle); err != nil {
+		return
+	}
+	if bundle.Page == nil || bundle.Version > PageBundleVersion {
+		return nil, fmt.Errorf("unsupported page bundle version %d", bundle.Version)
+	}
+
+	result = &PageImportResult{}
+	err = db.Transaction(func(tx *gorm.DB) (inerr error) {
+		result.Page, result.Conflicts, inerr = b.importPage(tx, bundle, files, opts)
+		if inerr == nil && len(result.Conflicts) > 0 {
+			inerr = ErrPageImportConflicts
+		}
+		return
+	})
+	if err != nil {
+		result.Page = nil
+	}
+	if err == errPageImportDryRun || (opts.DryRun && err == ErrPageImportConflicts) {
+		err = nil
+	}
+	return
+}
+
+func readZipFile(f *zip.File) (data []byte, err error) {
+	rc, err := openZipFile(f)
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	if data, err = ioutil.ReadAll(io.LimitReader(rc, int64(maxPageBundleFileSize)+1)); err == nil && len(data) > maxPageBundleFileSize {
+		err = fmt.Errorf("%w: %s", ErrPageBundleTooLarge, f.Name)
+	}
+	return
+}
+
+// openZipFile opens the file in the zip bundle unless its size is over the max file size
+func openZipFile(f *zip.File) (rc io.ReadCloser, err error) {
+	if f.UncompressedSize64 > uint64(maxPageBundleFileSize) {
+		return nil, fmt.Errorf("%w: %s", ErrPageBundleTooLarge, f.Name)
+	}
+	return f.Open()
+}
+
+func (b *Builder) importPage(tx *gorm.DB, bundle *PageBundle, files map[string]*zip.File, opts PageImportOptions) (p *Page, conflicts []*PageImportConflict, err error) {
+	locale := opts.LocaleCode
+	if locale == "" && l10nON {
+		locale = bundle.Page.LocaleCode
+	}
+	slug := opts.Slug
+	if slug == "" {
+		slug = bundle.Page.Slug
+	}
+	if slug != "" {
+		slug = path.Clean(slug)
+	}
+	version := fmt.Sprintf("%s-v01", tx.NowFunc().Format("2006-01-02"))
+	p = &Page{
+		Title:   bundle.Page.Title,
+		Slug:    slug,
+		SEO:     bundle.Page.SEO,
+		Status:  publish.Status{Status: publish.StatusDraft},
+		Version: publish.Version{Version: version, VersionName: version},
+		Locale:  l10n.Locale{LocaleCode: locale},
+	}
+
+	if bc := bundle.Category; bc != nil {
+		category := &Category{}
+		categoryPath := path.Clean(bc.Path)
+		err = tx.Where("path = ? AND locale_code = ?", categoryPath, locale).First(category).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = nil
+			category = &Category{
+				Name:        bc.Name,
+				Path:        categoryPath,
+				Description: bc.Description,
+				Locale:      l10n.Locale{LocaleCode: locale},
+			}
+			if !opts.CreateCategory {
+				conflicts = append(conflicts, &PageImportConflict{Field: "Category", Value: categoryPath, Message: "Category Not Found"})
+			} else if vErr := categoryValidator(category, tx, b.l10nB); vErr.HaveErrors() {
+				for _, msg := range vErr.GetFieldErrors("Category.Category") {
+					conflicts = append(conflicts, &PageImportConflict{Field: "Category", Value: categoryPath, Message: msg})
+				}
+			} else if err = tx.Create(category).Error; err != nil {
+				return
+			}
+		}
+		p.CategoryID = category.ID
+	}
+
+	if vErr := pageValidator(p, tx, b.l10nB); vErr.HaveErrors() {
+		for _, msg := range vErr.GetFieldErrors("Page.Slug") {
+			conflicts = append(conflicts, &PageImportConflict{Field: "Slug", Value: slug, Message: msg})
+		}
+	}
+	if len(conflicts) > 0 {
+		return
+	}
+	if opts.DryRun {
+		err = errPageImportDryRun
+		return
+	}
+
+	medias, puts, err := importPageMedia(tx, bundle.Media, files)
+	if err != nil {
+		return
+	}
+	remapMedia := func(box *media_library.MediaBox) {
+		if m, ok := medias[box.ID.String()]; ok {
+			box.ID = json.Number(fmt.Sprint(m.ID))
+			box.Url = m.File.Url
+		}
+	}
+	walkMediaBoxes(reflect.ValueOf(&p.SEO), remapMedia)
+	if err = tx.Create(p).Error; err != nil {
+		return
+	}
+
+	newModel := func(name string, data json.RawMessage) (id uint, err error) {
+		cb := b.findContainerBuilder(name)
+		if cb == nil {
+			return 0, fmt.Errorf("no container: %s", name)
+		}
+		model := cb.NewModel()
+		if err = json.Unmarshal(data, model); err != nil {
+			return
+		}
+		if err = reflectutils.Set(model, "ID", uint(0)); err != nil {
+			return
+		}
+		walkMediaBoxes(reflect.ValueOf(model), remapMedia)
+		if err = tx.Create(model).Error; err != nil {
+			return
+		}
+		return reflectutils.MustGet(model, "ID").(uint), nil
+	}
+
+	type sharedContainer struct {
+		modelID     uint
+		displayName string
+	}
+	shared := map[string]sharedContainer{}
+	for _, sc := range bundle.SharedContainers {
+		var c Container
+		err = tx.Where("model_name = ? AND display_name = ? AND locale_code = ? AND shared = ?", sc.ModelName, sc.DisplayName, locale, true).First(&c).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return
+		}
+		if err == nil {
+			shared[fmt.Sprintf("%s_%d", sc.ModelName, sc.ModelID)] = sharedContainer{c.ModelID, c.DisplayName}
+			continue
+		}
+		var id uint
+		if id, err = newModel(sc.ModelName, sc.Model); err != nil {
+			return
+		}
+		shared[fmt.Sprintf("%s_%d", sc.ModelName, sc.ModelID)] = sharedContainer{id, sc.DisplayName}
+	}
+
+	// the nested containers are created after their parents to be nested in the created ones
+	var (
+		cons    []*Container
+		bundled = map[uint]*PageBundleContainer{}
+		newIDs  = map[uint]uint{}
+	)
+	for _, bc := range bundle.Containers {
+		cons = append(cons, &Container{Model: gorm.Model{ID: bc.ID}, ParentID: bc.ParentID, DisplayOrder: bc.DisplayOrder})
+		bundled[bc.ID] = bc
+	}
+	for _, c := range sortContainersByParents(cons) {
+		bc := bundled[c.ID]
+		newCon := &Container{
+			PageID:       p.ID,
+			PageVersion:  p.GetVersion(),
+			ModelName:    bc.ModelName,
+			DisplayName:  bc.DisplayName,
+			DisplayOrder: bc.DisplayOrder,
+			Shared:       bc.Shared,
+			Hidden:       bc.Hidden,
+			ParentID:     newIDs[bc.ParentID],
+			Slot:         bc.Slot,
+			Locale:       l10n.Locale{LocaleCode: locale},
+		}
+		if bc.Shared {
+			sc, ok := shared[fmt.Sprintf("%s_%d", bc.ModelName, bc.ModelID)]
+			if !ok {
+				err = fmt.Errorf("no shared container %s %d in the page bundle", bc.ModelName, bc.ModelID)
+				return
+			}
+			newCon.ModelID = sc.modelID
+			newCon.DisplayName = sc.displayName
+		} else if newCon.ModelID, err = newModel(bc.ModelName, bc.Model); err != nil {
+			return
+		}
+		if err = tx.Create(newCon).Error; err != nil {
+			return
+		}
+		newIDs[bc.ID] = newCon.ID
+	}
+
+	// the files are stored at last, so that nothing is stored when the records are not created
+	for url, f := range puts {
+		if err = putMediaFile(url, f); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// importPageMedia reuses the media library records with the same files or creates them, returns them by the ids in the bundle.
+// A record is the same with the same file name, and the same url in the JSON bundle or the same content in the zip bundle.
+// The urls of the created records with the files in the bundle are changed by the new ids, the files are returned by the new urls.
+func importPageMedia(tx *gorm.DB, bundled []*PageBundleMedia, files map[string]*zip.File) (medias map[string]*media_library.MediaLibrary, puts map[string]*zip.File, err error) {
+	medias = map[string]*media_library.MediaLibrary{}
+	puts = map[string]*zip.File{}
+	for _, bm := range bundled {
+		oldID := fmt.Sprint(bm.ID)
+
+		var candidates []*media_library.MediaLibrary
+		quoted, _ := json.Marshal(bm.File.FileName)
+		if err = tx.Where("file LIKE ?", "%"+string(quoted)+"%").Order("id ASC").Find(&candidates).Error; err != nil {
+			return
+		}
+		for _, m := range candidates {
+			if m.File.FileName != bm.File.FileName {
+				continue
+			}
+			var same bool
+			if same, err = sameMediaFile(m, bm, files); err != nil {
+				return
+			}
+			if same {
+				medias[oldID] = m
+				break
+			}
+		}
+		if medias[oldID] != nil {
+			continue
+		}
+
+		m := &media_library.MediaLibrary{SelectedType: bm.SelectedType, File: bm.File}
+		if err = tx.Create(m).Error; err != nil {
+			return
+		}
+		medias[oldID] = m
+		if len(bm.Files) == 0 {
+			continue
+		}
+
+		// the files are stored by the urls of the created record, the bundle only tells the files of the urls
+		var stored map[string]string
+		if m.File.Url, stored, err = importedMediaFileURLs(bm, m.ID); err != nil {
+			return
+		}
+		for bundled, url := range stored {
+			name, ok := bm.Files[bundled]
+			if !ok {
+				// the sizes may not be in the bundle, see ExportPage
+				continue
+			}
+			f, ok := files[name]
+			if !ok {
+				err = fmt.Errorf("no media file %s in the page bundle", name)
+				return
+			}
+			puts[url] = f
+		}
+		if err = tx.Model(m).Update("file", m.File).Error; err != nil {
+			return
+		}
+	}
+	return
+}
+
+// importedMediaFileURLs rebuilds the url of the bundled media file with the id of the created record by the media library path,
+// returns it and the paths to store the file and its sizes by their urls in the bundle.
+// The bundled url must be a clean media library path of the bundled id, so that the files are only stored under the path of the record.
+func importedMediaFileURLs(bm *PageBundleMedia, id uint) (fileURL string, stored map[string]string, err error) {
+	u, err := url.Parse(bm.File.Url)
+	if err != nil {
+		return
+	}
+	oldPath, newPath := fmt.Sprintf(mediaLibraryFilePath, bm.ID), fmt.Sprintf(mediaLibraryFilePath, id)
+	ext := strings.TrimPrefix(u.Path, oldPath)
+	if path.Clean(u.Path) != u.Path || !strings.HasPrefix(u.Path, oldPath) || ext != path.Ext(u.Path) {
+		return "", nil, fmt.Errorf("invalid media %d file url %s in the page bundle", bm.ID, bm.File.Url)
+	}
+
+	file := bm.File
+	file.Url = newPath + ext
+	stored = map[string]string{bm.File.URL(): file.URL()}
+	for key := range bm.File.FileSizes {
+		if key == "default" {
+			continue
+		}
+		if p := file.URL(key); path.Clean(p) != p || path.Dir(p) != path.Dir(newPath) {
+			return "", nil, fmt.Errorf("invalid media %d file size %s in the page bundle", bm.ID, key)
+		}
+		stored[bm.File.URL(key)] = file.URL(key)
+	}
+
+	u.Path = file.Url
+	return u.String(), stored, nil
+}
+
+func sameMediaFile(m *media_library.MediaLibrary, bm *PageBundleMedia, files map[string]*zip.File) (same bool, err error) {
+	name, ok := bm.Files[bm.File.URL()]
+	if !ok {
+		return m.File.Url == bm.File.Url, nil
+	}
+	f, ok := files[name]
+	if !ok || m.File.Url == "" {
+		return
+	}
+	bundled, err := readZipFile(f)
+	if err != nil {
+		return
+	}
+	rc, err := m.File.Retrieve(m.File.URL())
+	if err != nil {
+		// the file of the existing record is missing
+		return false, nil
+	}
+	defer rc.Close()
+	existing, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return
+	}
+	return bytes.Equal(bundled, existing), nil
+}
+
+func putMediaFile(url string, f *zip.File) (err error) {
+	rc, err := openZipFile(f)
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	return media_library.MediaLibraryStorage{}.Store(url, nil, rc)
+}
@@ -0,0 +1,251 @@
+package pagebuilder
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qor/oss/filesystem"
+	"github.com/qor5/admin/media/media_library"
+	"github.com/qor5/admin/media/oss"
+	"github.com/qor5/admin/publish"
+	"github.com/qor5/admin/seo"
+	"github.com/qor5/web"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+)
+
+type testImage struct {
+	ID    uint
+	Image media_library.MediaBox `sql:"type:text;"`
+}
+
+func (*testImage) TableName() string {
+	return "test_container_images"
+}
+
+func resetPageBundleTables(t *testing.T, db *gorm.DB) {
+	for _, table := range []string{"page_builder_pages", "page_builder_categories", "page_builder_containers", "media_libraries",
+		"test_container_columns", "test_container_texts", "test_container_images"} {
+		if err := db.Exec(fmt.Sprintf("DELETE FROM %s", table)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func renderPageBundleTestPage(t *testing.T, b *Builder, p *Page) string {
+	ctx := &web.EventContext{R: httptest.NewRequest("GET", "/", nil)}
+	comps, err := b.renderContainers(ctx, p.ID, p.GetVersion(), p.LocaleCode, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(tagSpaces.ReplaceAllString(h.MustString(h.Components(comps...), context.Background()), "><"))
+}
+
+func TestPageBundle(t *testing.T) {
+	b, db := newNestedTestBuilder(t)
+	b.RegisterContainer("Image").
+		RenderFunc(func(obj interface{}, input *RenderInput, ctx *web.EventContext) h.HTMLComponent {
+			return h.Img(obj.(*testImage).Image.Url)
+		}).
+		Model(&testImage{})
+	if err := db.AutoMigrate(&testImage{}, &media_library.MediaLibrary{}); err != nil {
+		t.Fatal(err)
+	}
+	resetPageBundleTables(t, db)
+
+	storage := oss.Storage
+	defer func() { oss.Storage = storage }()
+	oss.Storage = filesystem.New(t.TempDir())
+
+	// the source page
+	m := &media_library.MediaLibrary{}
+	m.File.FileName = "a.jpg"
+	db.Create(m)
+	m.File.Url = fmt.Sprintf("/system/media_libraries/%d/file.jpg", m.ID)
+	db.Save(m)
+	if _, err := oss.Storage.Put(m.File.Url, strings.NewReader("image a")); err != nil {
+		t.Fatal(err)
+	}
+	box := media_library.MediaBox{ID: json.Number(fmt.Sprint(m.ID)), Url: m.File.Url, FileName: "a.jpg"}
+
+	category := &Category{Name: "News", Path: "/news"}
+	db.Create(category)
+	db.Create(&Page{
+		Model:      gorm.Model{ID: 1},
+		Title:      "Hello",
+		Slug:       "/hello",
+		CategoryID: category.ID,
+		SEO:        seo.Setting{Title: "Hello", OpenGraphImageFromMediaLibrary: box},
+		Version:    publish.Version{Version: "v1"},
+	})
+	if _, err := b.AddContainerToPage(1, "v1", "", "Columns"); err != nil {
+		t.Fatal(err)
+	}
+	columns := &Container{}
+	db.Where("model_name = ?", "Columns").First(columns)
+	addText(t, b, db, "a", columns.ID, "Left")
+	imageID, err := b.AddContainerToSlot(1, "v1", "", "Image", columns.ID, "Right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&testImage{}).Where("id = ?", imageID).Update("image", box)
+	addText(t, b, db, "d", 0, "")
+	footer := &testText{Body: "footer"}
+	db.Create(footer)
+	db.Create(&Container{PageID: 1, PageVersion: "v1", ModelName: "Text", ModelID: footer.ID, Shared: true, DisplayName: "Footer", DisplayOrder: 3})
+
+	var jsonBundle bytes.Buffer
+	if err := b.ExportPage(db, &jsonBundle, 1, "v1", "", PageBundleFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	bundle := &PageBundle{}
+	if err := json.Unmarshal(jsonBundle.Bytes(), bundle); err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Containers) != 5 || len(bundle.SharedContainers) != 1 || len(bundle.Media) != 1 || bundle.Category.Path != "/news" {
+		t.Errorf("want the containers, the shared container, the media and the category in the bundle, but got %s", jsonBundle.String())
+	}
+
+	var zipBundle bytes.Buffer
+	if err := b.ExportPage(db, &zipBundle, 1, "v1", "", PageBundleFormatZip); err != nil {
+		t.Fatal(err)
+	}
+
+	// the target database has a shared footer and no category
+	resetPageBundleTables(t, db)
+	oss.Storage = filesystem.New(t.TempDir())
+	targetFooter := &testText{Body: "target footer"}
+	db.Create(targetFooter)
+	db.Create(&Container{PageID: 99, PageVersion: "v1", ModelName: "Text", ModelID: targetFooter.ID, Shared: true, DisplayName: "Footer"})
+
+	result, err := b.ImportPage(db, bytes.NewReader(zipBundle.Bytes()), PageImportOptions{})
+	if err != ErrPageImportConflicts || len(result.Conflicts) != 1 || result.Conflicts[0].Field != "Category" || result.Page != nil {
+		t.Fatalf("want the conflict of the category, but got %#+v %v", result, err)
+	}
+	var count int64
+	db.Model(&Page{}).Count(&count)
+	if count != 0 {
+		t.Errorf("want nothing imported with the conflicts, but got %d pages", count)
+	}
+
+	result, err = b.ImportPage(db, bytes.NewReader(zipBundle.Bytes()), PageImportOptions{CreateCategory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := result.Page
+	if p.Title != "Hello" || p.Slug != "/hello" || p.GetStatus() != publish.StatusDraft {
+		t.Errorf("want the draft page imported, but got %#+v", p)
+	}
+	imported := &media_library.MediaLibrary{}
+	db.First(imported)
+	if imported.File.Url != fmt.Sprintf("/system/media_libraries/%d/file.jpg", imported.ID) || imported.File.FileName != "a.jpg" {
+		t.Errorf("want the media created with the new id, but got %#+v", imported.File)
+	}
+	f, err := oss.Storage.GetStream(imported.File.Url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(content) != "image a" {
+		t.Errorf("want the media file stored, but got %s", content)
+	}
+	if p.SEO.OpenGraphImageFromMediaLibrary.ID.String() != fmt.Sprint(imported.ID) {
+		t.Errorf("want the media of the seo remapped, but got %#+v", p.SEO.OpenGraphImageFromMediaLibrary)
+	}
+	var newCategory Category
+	db.First(&newCategory, "path = ?", "/news")
+	if newCategory.ID != p.CategoryID || newCategory.Name != "News" {
+		t.Errorf("want the category created, but got %#+v", newCategory)
+	}
+
+	want := fmt.Sprintf(`<div class='columns'><div class='left'><p>a</p></div><div class='right'><img src='%s'></div></div><p>d</p><p>target footer</p>`, imported.File.Url)
+	if got := renderPageBundleTestPage(t, b, p); got != want {
+		t.Errorf("want the page imported %s, but got %s", want, got)
+	}
+
+	// the slug is taken by the imported page
+	result, err = b.ImportPage(db, bytes.NewReader(zipBundle.Bytes()), PageImportOptions{DryRun: true})
+	if err != nil || len(result.Conflicts) != 1 || result.Conflicts[0].Field != "Slug" || result.Page != nil {
+		t.Errorf("want the conflict of the slug reported, but got %#+v %v", result, err)
+	}
+	result, err = b.ImportPage(db, bytes.NewReader(zipBundle.Bytes()), PageImportOptions{Slug: "/hello-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := renderPageBundleTestPage(t, b, result.Page); got != want {
+		t.Errorf("want the page imported again with the media reused %s, but got %s", want, got)
+	}
+	db.Model(&media_library.MediaLibrary{}).Count(&count)
+	if count != 1 {
+		t.Errorf("want the media reused, but got %d", count)
+	}
+}
+
+func TestImportedMediaFileURLs(t *testing.T) {
+	bm := &PageBundleMedia{ID: 3}
+	bm.File.Url = "//cdn.example.com/system/media_libraries/3/file.jpg"
+	bm.File.FileSizes = map[string]int{"default": 1, "thumb": 1}
+	fileURL, stored, err := importedMediaFileURLs(bm, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"//cdn.example.com/system/media_libraries/3/file.jpg":       "/system/media_libraries/8/file.jpg",
+		"//cdn.example.com/system/media_libraries/3/file.thumb.jpg": "/system/media_libraries/8/file.thumb.jpg",
+	}
+	if fileURL != "//cdn.example.com/system/media_libraries/8/file.jpg" || fmt.Sprint(stored) != fmt.Sprint(want) {
+		t.Errorf("want the urls rebuilt with the new id, but got %s %v", fileURL, stored)
+	}
+
+	for _, url := range []string{
+		"/system/media_libraries/3/../../../etc/file.jpg",
+		"/system/media_libraries/4/file.jpg",
+		"/system/media_libraries/3/file/../../x.jpg",
+		"/tmp/3/file.jpg",
+	} {
+		bm := &PageBundleMedia{ID: 3}
+		bm.File.Url = url
+		if _, _, err := importedMediaFileURLs(bm, 8); err == nil {
+			t.Errorf("want the media file url %s rejected", url)
+		}
+	}
+	bm.File.FileSizes = map[string]int{"/../../x": 1}
+	if _, _, err := importedMediaFileURLs(bm, 8); err == nil {
+		t.Errorf("want the media file size rejected")
+	}
+}
+
+func TestImportPageTooLarge(t *testing.T) {
+	defer func(size, fileSize int) { maxPageBundleSize, maxPageBundleFileSize = size, fileSize }(maxPageBundleSize, maxPageBundleFileSize)
+	maxPageBundleSize, maxPageBundleFileSize = 1<<20, 1<<10
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, err := zw.Create(pageBundleFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fw.Write(bytes.Repeat([]byte(" "), maxPageBundleFileSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (&Builder{}).ImportPage(nil, &buf, PageImportOptions{}); !errors.Is(err, ErrPageBundleTooLarge) {
+		t.Errorf("want the page bundle file too large, but got %v", err)
+	}
+
+	r := strings.NewReader("{}" + strings.Repeat(" ", maxPageBundleSize))
+	if _, err = (&Builder{}).ImportPage(nil, r, PageImportOptions{}); !errors.Is(err, ErrPageBundleTooLarge) {
+		t.Errorf("want the page bundle too large, but got %v", err)
+	}
+}
@@ -46,6 +46,8 @@ const (
 	paramContainerName   = "containerName"
 	paramSharedContainer = "sharedContainer"
 	paramModelID         = "modelID"
+	paramParentID        = "parentID"
+	paramSlot            = "slot"
 
 	DevicePhone    = "phone"
 	DeviceTablet   = "tablet"
@@ -235,7 +237,13 @@ func (b *Builder) renderPageOrTemplate(ctx *web.EventContext, isTpl bool, pageOr
 	.wrapper-shadow.hover {
 		cursor: pointer;
 		opacity: 1;
-    }`))
+    }
+	[data-container-id] [data-container-id] > .wrapper-shadow {
+		z-index: 10000;
+	}
+	[data-container-id] [data-container-id] [data-container-id] > .wrapper-shadow {
+		z-index: 10001;
+	}`))
 			input.FreeStyleBottomJs = []string{`
 	function scrolltoCurrentContainer(event) {
 		const current = document.querySelector("div[data-container-id='"+event.data+"']");
@@ -246,7 +254,7 @@ func (b *Builder) renderPageOrTemplate(ctx *web.EventContext, isTpl bool, pageOr
 		if (hover) {
 			hover.classList.remove('hover');
 		}
-		window.parent.scroll({top: current.offsetTop, behavior: "smooth"});
+		window.parent.scroll({top: current.getBoundingClientRect().top + window.scrollY, behavior: "smooth"});
 		current.querySelector(".wrapper-shadow").classList.add('hover');
 	}
 	document.querySelectorAll('.wrapper-shadow').forEach(shadow => {
@@ -332,11 +340,21 @@ func (b *Builder) renderContainers(ctx *web.EventContext, pageID uint, pageVersi
 		return
 	}
 
-	cbs := b.getContainerBuilders(cons)
-
 	device, _ := b.getDevice(ctx)
+	return b.renderNestedContainers(ctx, containerChildren(cons), 0, "", &RenderInput{
+		IsEditor:   isEditor,
+		IsReadonly: isReadonly,
+		Device:     device,
+	})
+}
+
+// renderNestedContainers renders the containers in the slot of the parent, 0 is the page,
+// the containers in their slots are rendered first into RenderInput.Slots
+func (b *Builder) renderNestedContainers(ctx *web.EventContext, children map[uint][]*Container, parentID uint, slot string, in *RenderInput) (r []h.HTMLComponent, err error) {
+	cbs := b.getContainerBuilders(children[parentID])
+
 	for _, ec := range cbs {
-		if ec.container.Hidden {
+		if ec.container.Hidden || ec.container.Slot != slot {
 			continue
 		}
 		obj := ec.builder.NewModel()
@@ -346,9 +364,20 @@ func (b *Builder) renderContainers(ctx *web.EventContext, pageID uint, pageVersi
 		}
 
 		input := RenderInput{
-			IsEditor:   isEditor,
-			IsReadonly: isReadonly,
-			Device:     device,
+			IsEditor:   in.IsEditor,
+			IsReadonly: in.IsReadonly,
+			Device:     in.Device,
+		}
+		if len(ec.builder.slots) > 0 {
+			input.Slots = make(map[string]h.HTMLComponent)
+			for _, s := range ec.builder.slots {
+				var comps []h.HTMLComponent
+				comps, err = b.renderNestedContainers(ctx, children, ec.container.ID, s, in)
+				if err != nil {
+					return
+				}
+				input.Slots[s] = h.Components(comps...)
+			}
 		}
 		pure := ec.builder.renderFunc(obj, &input, ctx)
 		r = append(r, pure)
@@ -357,21 +386,74 @@ func (b *Builder) renderContainers(ctx *web.EventContext, pageID uint, pageVersi
 	return
 }
 
+// containerChildren groups the containers by their parents in the original order
+func containerChildren(cons []*Container) map[uint][]*Container {
+	children := make(map[uint][]*Container)
+	for _, c := range cons {
+		children[c.ParentID] = append(children[c.ParentID], c)
+	}
+	return children
+}
+
+// sortContainersByParents returns the containers nested in the page with the parents before their children,
+// the ones whose parents are removed are left out
+func sortContainersByParents(cons []*Container) (r []*Container) {
+	children := containerChildren(cons)
+	parents := []uint{0}
+	for len(parents) > 0 {
+		var next []uint
+		for _, id := range parents {
+			for _, c := range children[id] {
+				r = append(r, c)
+				next = append(next, c.ID)
+			}
+		}
+		parents = next
+	}
+	return
+}
+
 type ContainerSorterItem struct {
-	Index          int    `json:"index"`
-	Label          string `json:"label"`
-	ModelName      string `json:"model_name"`
-	ModelID        string `json:"model_id"`
-	DisplayName    string `json:"display_name"`
-	ContainerID    string `json:"container_id"`
-	URL            string `json:"url"`
-	Shared         bool   `json:"shared"`
-	VisibilityIcon string `json:"visibility_icon"`
-	ParamID        string `json:"param_id"`
+	Index          int                   `json:"index"`
+	Label          string                `json:"label"`
+	ModelName      string                `json:"model_name"`
+	ModelID        string                `json:"model_id"`
+	DisplayName    string                `json:"display_name"`
+	ContainerID    string                `json:"container_id"`
+	URL            string                `json:"url"`
+	Shared         bool                  `json:"shared"`
+	VisibilityIcon string                `json:"visibility_icon"`
+	ParamID        string                `json:"param_id"`
+	Slots          []ContainerSorterSlot `json:"slots"`
+}
+
+type ContainerSorterSlot struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Key   string `json:"key"`
 }
 
 type ContainerSorter struct {
 	Items []ContainerSorterItem `json:"items"`
+	// the items nested in the slots by the slot keys
+	Slots map[string][]ContainerSorterItem `json:"slots"`
+}
+
+// containerSlotKey is the key of the slot of the container in ContainerSorter.Slots
+func containerSlotKey(containerID uint, slot string) string {
+	return fmt.Sprintf("%d_%s", containerID, slot)
+}
+
+func parseContainerSlotKey(key string) (containerID uint, slot string, err error) {
+	segs := strings.SplitN(key, "_", 2)
+	if len(segs) != 2 {
+		return 0, "", fmt.Errorf("wrong slot key %q", key)
+	}
+	id, err := strconv.Atoi(segs[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("wrong slot key %q", key)
+	}
+	return uint(id), segs[1], nil
 }
 
 func (b *Builder) renderContainersList(ctx *web.EventContext, pageID uint, pageVersion, locale string, isReadonly bool) (r h.HTMLComponent, err error) {
@@ -381,115 +463,62 @@ func (b *Builder) renderContainersList(ctx *web.EventContext, pageID uint, pageV
 		return
 	}
 
-	var sorterData ContainerSorter
-	for i, c := range cons {
-		vicon := "visibility"
-		if c.Hidden {
-			vicon = "visibility_off"
-		}
-		var displayName = i18n.T(ctx.R, presets.ModelsI18nModuleKey, c.DisplayName)
+	var (
+		children   = containerChildren(cons)
+		sorterData = ContainerSorter{Slots: map[string][]ContainerSorterItem{}}
+		index      int
+		maxLevel   int
+		sorterList func(parentID uint, slot string, level int) []ContainerSorterItem
+	)
+	sorterList = func(parentID uint, slot string, level int) (items []ContainerSorterItem) {
+		items = []ContainerSorterItem{}
+		for _, c := range children[parentID] {
+			if c.Slot != slot {
+				continue
+			}
+			vicon := "visibility"
+			if c.Hidden {
+				vicon = "visibility_off"
+			}
+			var displayName = i18n.T(ctx.R, presets.ModelsI18nModuleKey, c.DisplayName)
+			cb := b.ContainerByName(c.ModelName)
 
-		sorterData.Items = append(sorterData.Items,
-			ContainerSorterItem{
-				Index:          i,
+			item := ContainerSorterItem{
+				Index:          index,
 				Label:          displayName,
 				ModelName:      inflection.Plural(strcase.ToKebab(c.ModelName)),
 				ModelID:        strconv.Itoa(int(c.ModelID)),
 				DisplayName:    displayName,
 				ContainerID:    strconv.Itoa(int(c.ID)),
-				URL:            b.ContainerByName(c.ModelName).mb.Info().ListingHref(),
+				URL:            cb.mb.Info().ListingHref(),
 				Shared:         c.Shared,
 				VisibilityIcon: vicon,
 				ParamID:        c.PrimarySlug(),
-			},
-		)
+			}
+			index++
+			if level > maxLevel {
+				maxLevel = level
+			}
+			for _, s := range cb.slots {
+				key := containerSlotKey(c.ID, s)
+				item.Slots = append(item.Slots, ContainerSorterSlot{
+					Name:  s,
+					Label: i18n.T(ctx.R, presets.ModelsI18nModuleKey, s),
+					Key:   key,
+				})
+				sorterData.Slots[key] = sorterList(c.ID, s, level+1)
+			}
+			items = append(items, item)
+		}
+		return
 	}
+	sorterData.Items = sorterList(0, "", 0)
 	msgr := i18n.MustGetModuleMessages(ctx.R, I18nPageBuilderKey, Messages_en_US).(*Messages)
 
 	r = web.Scope(
 		VSheet(
 			VCard(
-				h.Tag("vx-draggable").
-					Attr("v-model", "locals.items", "handle", ".handle", "animation", "300").
-					Attr("@end", web.Plaid().
-						URL(fmt.Sprintf("%s/editors", b.prefix)).
-						EventFunc(MoveContainerEvent).
-						FieldValue(paramMoveResult, web.Var("JSON.stringify(locals.items)")).
-						Go()).Children(
-					// VList(
-					h.Div(
-						VListItem(
-							h.If(!isReadonly,
-								VListItemIcon(VBtn("").Icon(true).Children(VIcon("drag_indicator"))).Class("handle my-2 ml-1 mr-1"),
-							).Else(
-								VListItemIcon().Class("my-2 ml-1 mr-1"),
-							),
-							VListItemContent(
-								VListItemTitle(h.Text("{{item.label}}")).Attr(":style", "[item.shared ? {'color':'green'}:{}]"),
-							),
-							h.If(!isReadonly,
-								VListItemIcon(VBtn("").Icon(true).Children(VIcon("edit").Small(true))).Attr("@click",
-									web.Plaid().
-										URL(web.Var("item.url")).
-										EventFunc(actions.Edit).
-										Query(presets.ParamOverlay, actions.Drawer).
-										Query(presets.ParamID, web.Var("item.model_id")).
-										Go(),
-								).Class("my-2"),
-								VListItemIcon(VBtn("").Icon(true).Children(VIcon("{{item.visibility_icon}}").Small(true))).Attr("@click",
-									web.Plaid().
-										URL(web.Var("item.url")).
-										EventFunc(ToggleContainerVisibilityEvent).
-										Query(paramContainerID, web.Var("item.param_id")).
-										Go(),
-								).Class("my-2"),
-							),
-							h.If(!isReadonly,
-								VMenu(
-									web.Slot(
-										VBtn("").Children(
-											VIcon("more_horiz"),
-										).Attr("v-on", "on").Text(true).Fab(true).Small(true),
-									).Name("activator").Scope("{ on }"),
-
-									VList(
-										VListItem(
-											VListItemIcon(VIcon("edit_note")).Class("pl-0 mr-2"),
-											VListItemTitle(h.Text("Rename")),
-										).Attr("@click",
-											web.Plaid().
-												URL(web.Var("item.url")).
-												EventFunc(RenameContainerDialogEvent).
-												Query(paramContainerID, web.Var("item.param_id")).
-												Query(paramContainerName, web.Var("item.display_name")).
-												Go(),
-										),
-										VListItem(
-											VListItemIcon(VIcon("delete")).Class("pl-0 mr-2"),
-											VListItemTitle(h.Text("Delete")),
-										).Attr("@click", web.Plaid().
-											URL(web.Var("item.url")).
-											EventFunc(DeleteContainerConfirmationEvent).
-											Query(paramContainerID, web.Var("item.param_id")).
-											Query(paramContainerName, web.Var("item.display_name")).
-											Go(),
-										),
-										VListItem(
-											VListItemIcon(VIcon("share")).Class("pl-1 mr-2"),
-											VListItemTitle(h.Text("Mark As Shared Container")),
-										).Attr("@click",
-											web.Plaid().
-												URL(web.Var("item.url")).
-												EventFunc(MarkAsSharedContainerEvent).
-												Query(paramContainerID, web.Var("item.param_id")).
-												Go(),
-										).Attr("v-if", "!item.shared"),
-									).Dense(true),
-								).Left(true),
-							),
-						).Class("pl-0").Attr("@click", fmt.Sprintf(`document.querySelector("iframe").contentWindow.postMessage(%s+"_"+%s,"*");`, web.Var("item.model_name"), web.Var("item.model_id"))),
-						VDivider().Attr("v-if", "index < locals.items.length "),
-					).Attr("v-for", "(item, index) in locals.items", ":key", "item.index"),
+				b.renderContainersSorter(msgr, "locals.items", 0, maxLevel, isReadonly,
 					h.If(!isReadonly,
 						VListItem(
 							VListItemIcon(VIcon("add").Color("primary")).Class("ma-4"),
@@ -504,7 +533,7 @@ func (b *Builder) renderContainersList(ctx *web.EventContext, pageID uint, pageV
 								Go(),
 						),
 					),
-					// ).Class("py-0"),
+					pageID, pageVersion, locale,
 				),
 			).Outlined(true),
 		).Class("pa-4 pt-2"),
@@ -512,6 +541,118 @@ func (b *Builder) renderContainersList(ctx *web.EventContext, pageID uint, pageV
 	return
 }
 
+// renderContainersSorter renders the draggable list of the items, the slots of the items are nested lists
+// in the same group so that the containers can be dragged across them. They are rendered until the deepest level
+// of the containers, the page is reloaded after moving.
+func (b *Builder) renderContainersSorter(msgr *Messages, list string, level, maxLevel int, isReadonly bool, footer h.HTMLComponent, pageID uint, pageVersion, locale string) h.HTMLComponent {
+	var slots h.HTMLComponent
+	if level <= maxLevel {
+		slots = h.Div(
+			h.Div(h.Text("{{slot.label}}")).Class("text-caption grey--text pl-4"),
+			b.renderContainersSorter(msgr, "locals.slots[slot.key]", level+1, maxLevel, isReadonly,
+				h.If(!isReadonly,
+					VBtn(msgr.AddContainers).Color("primary").Text(true).Small(true).Class("ml-2 mb-2").Attr("@click.stop",
+						web.Plaid().
+							URL(fmt.Sprintf("%s/editors/%d?version=%s&locale=%s", b.prefix, pageID, pageVersion, locale)).
+							EventFunc(AddContainerDialogEvent).
+							Query(paramPageID, pageID).
+							Query(paramPageVersion, pageVersion).
+							Query(paramLocale, locale).
+							Query(paramParentID, web.Var("item.container_id")).
+							Query(paramSlot, web.Var("slot.name")).
+							Go(),
+					),
+				),
+				pageID, pageVersion, locale,
+			),
+		).Class("ml-6").Attr("v-for", "slot in item.slots", ":key", "slot.key")
+	}
+
+	return h.Tag("vx-draggable").
+		Attr("v-model", list, "handle", ".handle", "animation", "300", "group", "page-builder-containers").
+		Attr("@end", web.Plaid().
+			URL(fmt.Sprintf("%s/editors", b.prefix)).
+			EventFunc(MoveContainerEvent).
+			FieldValue(paramMoveResult, web.Var("JSON.stringify({items: locals.items, slots: locals.slots})")).
+			Go()).Children(
+		h.Div(
+			VListItem(
+				h.If(!isReadonly,
+					VListItemIcon(VBtn("").Icon(true).Children(VIcon("drag_indicator"))).Class("handle my-2 ml-1 mr-1"),
+				).Else(
+					VListItemIcon().Class("my-2 ml-1 mr-1"),
+				),
+				VListItemContent(
+					VListItemTitle(h.Text("{{item.label}}")).Attr(":style", "[item.shared ? {'color':'green'}:{}]"),
+				),
+				h.If(!isReadonly,
+					VListItemIcon(VBtn("").Icon(true).Children(VIcon("edit").Small(true))).Attr("@click",
+						web.Plaid().
+							URL(web.Var("item.url")).
+							EventFunc(actions.Edit).
+							Query(presets.ParamOverlay, actions.Drawer).
+							Query(presets.ParamID, web.Var("item.model_id")).
+							Go(),
+					).Class("my-2"),
+					VListItemIcon(VBtn("").Icon(true).Children(VIcon("{{item.visibility_icon}}").Small(true))).Attr("@click",
+						web.Plaid().
+							URL(web.Var("item.url")).
+							EventFunc(ToggleContainerVisibilityEvent).
+							Query(paramContainerID, web.Var("item.param_id")).
+							Go(),
+					).Class("my-2"),
+				),
+				h.If(!isReadonly,
+					VMenu(
+						web.Slot(
+							VBtn("").Children(
+								VIcon("more_horiz"),
+							).Attr("v-on", "on").Text(true).Fab(true).Small(true),
+						).Name("activator").Scope("{ on }"),
+
+						VList(
+							VListItem(
+								VListItemIcon(VIcon("edit_note")).Class("pl-0 mr-2"),
+								VListItemTitle(h.Text("Rename")),
+							).Attr("@click",
+								web.Plaid().
+									URL(web.Var("item.url")).
+									EventFunc(RenameContainerDialogEvent).
+									Query(paramContainerID, web.Var("item.param_id")).
+									Query(paramContainerName, web.Var("item.display_name")).
+									Go(),
+							),
+							VListItem(
+								VListItemIcon(VIcon("delete")).Class("pl-0 mr-2"),
+								VListItemTitle(h.Text("Delete")),
+							).Attr("@click", web.Plaid().
+								URL(web.Var("item.url")).
+								EventFunc(DeleteContainerConfirmationEvent).
+								Query(paramContainerID, web.Var("item.param_id")).
+								Query(paramContainerName, web.Var("item.display_name")).
+								Go(),
+							),
+							VListItem(
+								VListItemIcon(VIcon("share")).Class("pl-1 mr-2"),
+								VListItemTitle(h.Text("Mark As Shared Container")),
+							).Attr("@click",
+								web.Plaid().
+									URL(web.Var("item.url")).
+									EventFunc(MarkAsSharedContainerEvent).
+									Query(paramContainerID, web.Var("item.param_id")).
+									Go(),
+							).Attr("v-if", "!item.shared"),
+						).Dense(true),
+					).Left(true),
+				),
+			).Class("pl-0").Attr("@click.stop", fmt.Sprintf(`document.querySelector("iframe").contentWindow.postMessage(%s+"_"+%s,"*");`, web.Var("item.model_name"), web.Var("item.model_id"))),
+			slots,
+			VDivider().Attr("v-if", fmt.Sprintf("index < %s.length ", list)),
+		).Attr("v-for", fmt.Sprintf("(item, index) in %s", list), ":key", "item.index"),
+		footer,
+	)
+}
+
 func (b *Builder) AddContainer(ctx *web.EventContext) (r web.EventResponse, err error) {
 	pageID := ctx.QueryAsInt(paramPageID)
 	pageVersion := ctx.R.FormValue(paramPageVersion)
@@ -519,12 +660,17 @@ func (b *Builder) AddContainer(ctx *web.EventContext) (r web.EventResponse, err
 	containerName := ctx.R.FormValue(paramContainerName)
 	sharedContainer := ctx.R.FormValue(paramSharedContainer)
 	modelID := ctx.QueryAsInt(paramModelID)
+	parentID := ctx.QueryAsInt(paramParentID)
+	slot := ctx.R.FormValue(paramSlot)
 	var newModelID uint
 	if sharedContainer == "true" {
-		err = b.AddSharedContainerToPage(pageID, pageVersion, locale, containerName, uint(modelID))
+		err = b.AddSharedContainerToSlot(pageID, pageVersion, locale, containerName, uint(modelID), uint(parentID), slot)
 		r.PushState = web.Location(url.Values{})
 	} else {
-		newModelID, err = b.AddContainerToPage(pageID, pageVersion, locale, containerName)
+		newModelID, err = b.AddContainerToSlot(pageID, pageVersion, locale, containerName, uint(parentID), slot)
+		if err != nil {
+			return
+		}
 		r.VarsScript = web.Plaid().
 			URL(b.ContainerByName(containerName).mb.Info().ListingHref()).
 			EventFunc(actions.Edit).
@@ -539,24 +685,97 @@ func (b *Builder) AddContainer(ctx *web.EventContext) (r web.EventResponse, err
 func (b *Builder) MoveContainer(ctx *web.EventContext) (r web.EventResponse, err error) {
 	moveResult := ctx.R.FormValue(paramMoveResult)
 
-	var result []ContainerSorterItem
+	var result ContainerSorter
 	err = json.Unmarshal([]byte(moveResult), &result)
 	if err != nil {
 		return
 	}
 	err = b.db.Transaction(func(tx *gorm.DB) (inerr error) {
-		for i, r := range result {
-			if inerr = tx.Model(&Container{}).Where("id = ?", r.ContainerID).Update("display_order", i+1).Error; inerr != nil {
-				return
-			}
-		}
-		return
+		return b.moveContainers(tx, &result)
 	})
+	if err != nil {
+		return
+	}
 
 	r.PushState = web.Location(url.Values{})
 	return
 }
 
+// moveContainers saves the orders, the parents and the slots of the containers in the lists of the sorter
+func (b *Builder) moveContainers(tx *gorm.DB, sorter *ContainerSorter) (err error) {
+	type position struct {
+		parentID uint
+		slot     string
+		order    int
+	}
+	var (
+		positions = map[string]position{}
+		parentOf  = map[uint]uint{}
+	)
+	for i, item := range sorter.Items {
+		positions[item.ParamID] = position{order: i + 1}
+	}
+	for key, items := range sorter.Slots {
+		var (
+			parentID uint
+			slot     string
+		)
+		if parentID, slot, err = parseContainerSlotKey(key); err != nil {
+			return
+		}
+		for i, item := range items {
+			positions[item.ParamID] = position{parentID: parentID, slot: slot, order: i + 1}
+		}
+	}
+
+	var container Container
+	for paramID, pos := range positions {
+		cs := container.PrimaryColumnValuesBySlug(paramID)
+		id, _ := strconv.Atoi(cs["id"])
+		parentOf[uint(id)] = pos.parentID
+		if pos.parentID == 0 {
+			continue
+		}
+		if err = b.checkContainerSlot(tx, pos.parentID, cs["locale_code"], pos.slot); err != nil {
+			return
+		}
+	}
+	// a container can't be nested in itself
+	for id := range parentOf {
+		visited := map[uint]bool{}
+		for p := id; p != 0; p = parentOf[p] {
+			if visited[p] {
+				return fmt.Errorf("container %d is nested in itself", id)
+			}
+			visited[p] = true
+		}
+	}
+
+	for paramID, pos := range positions {
+		cs := container.PrimaryColumnValuesBySlug(paramID)
+		if err = tx.Model(&Container{}).Where("id = ? AND locale_code = ?", cs["id"], cs["locale_code"]).Updates(map[string]interface{}{
+			"parent_id":     pos.parentID,
+			"slot":          pos.slot,
+			"display_order": pos.order,
+		}).Error; err != nil {
+			return
+		}
+	}
+	return
+}
+
+// checkContainerSlot checks that the container of the parent has the slot
+func (b *Builder) checkContainerSlot(db *gorm.DB, parentID uint, locale, slot string) (err error) {
+	var parent Container
+	if err = db.First(&parent, "id = ? AND locale_code = ?", parentID, locale).Error; err != nil {
+		return
+	}
+	if !b.ContainerByName(parent.ModelName).hasSlot(slot) {
+		return fmt.Errorf("container %s has no slot %q", parent.ModelName, slot)
+	}
+	return
+}
+
 func (b *Builder) ToggleContainerVisibility(ctx *web.EventContext) (r web.EventResponse, err error) {
 	var container Container
 	paramID := ctx.R.FormValue(paramContainerID)
@@ -614,7 +833,19 @@ func (b *Builder) DeleteContainer(ctx *web.EventContext) (r web.EventResponse, e
 	containerID := cs["id"]
 	locale := cs["locale_code"]
 
-	err = b.db.Delete(&Container{}, "id = ? AND locale_code = ?", containerID, locale).Error
+	// the nested containers are deleted with their parents
+	ids := []string{containerID}
+	for parentIDs := ids; len(parentIDs) > 0; {
+		var childIDs []string
+		err = b.db.Model(&Container{}).Where("parent_id IN ? AND locale_code = ?", parentIDs, locale).Pluck("id", &childIDs).Error
+		if err != nil {
+			return
+		}
+		ids = append(ids, childIDs...)
+		parentIDs = childIDs
+	}
+
+	err = b.db.Delete(&Container{}, "id IN ? AND locale_code = ?", ids, locale).Error
 	if err != nil {
 		return
 	}
@@ -623,6 +854,16 @@ func (b *Builder) DeleteContainer(ctx *web.EventContext) (r web.EventResponse, e
 }
 
 func (b *Builder) AddContainerToPage(pageID int, pageVersion, locale, containerName string) (modelID uint, err error) {
+	return b.AddContainerToSlot(pageID, pageVersion, locale, containerName, 0, "")
+}
+
+// AddContainerToSlot adds the container to the end of the slot of the parent container, parentID 0 is the page
+func (b *Builder) AddContainerToSlot(pageID int, pageVersion, locale, containerName string, parentID uint, slot string) (modelID uint, err error) {
+	if parentID != 0 {
+		if err = b.checkContainerSlot(b.db, parentID, locale, slot); err != nil {
+			return
+		}
+	}
 	model := b.ContainerByName(containerName).NewModel()
 	var dc DemoContainer
 	b.db.Where("model_name = ? AND locale_code = ?", containerName, locale).First(&dc)
@@ -637,7 +878,7 @@ func (b *Builder) AddContainerToPage(pageID int, pageVersion, locale, containerN
 	}
 
 	var maxOrder sql.NullFloat64
-	err = b.db.Model(&Container{}).Select("MAX(display_order)").Where("page_id = ? and page_version = ? and locale_code = ?", pageID, pageVersion, locale).Scan(&maxOrder).Error
+	err = b.db.Model(&Container{}).Select("MAX(display_order)").Where("page_id = ? and page_version = ? and locale_code = ? and parent_id = ? and slot = ?", pageID, pageVersion, locale, parentID, slot).Scan(&maxOrder).Error
 	if err != nil {
 		return
 	}
@@ -650,6 +891,8 @@ func (b *Builder) AddContainerToPage(pageID int, pageVersion, locale, containerN
 		DisplayName:  containerName,
 		ModelID:      modelID,
 		DisplayOrder: maxOrder.Float64 + 1,
+		ParentID:     parentID,
+		Slot:         slot,
 		Locale: l10n.Locale{
 			LocaleCode: locale,
 		},
@@ -661,13 +904,23 @@ func (b *Builder) AddContainerToPage(pageID int, pageVersion, locale, containerN
 }
 
 func (b *Builder) AddSharedContainerToPage(pageID int, pageVersion, locale, containerName string, modelID uint) (err error) {
+	return b.AddSharedContainerToSlot(pageID, pageVersion, locale, containerName, modelID, 0, "")
+}
+
+// AddSharedContainerToSlot adds the shared container to the end of the slot of the parent container, parentID 0 is the page
+func (b *Builder) AddSharedContainerToSlot(pageID int, pageVersion, locale, containerName string, modelID uint, parentID uint, slot string) (err error) {
+	if parentID != 0 {
+		if err = b.checkContainerSlot(b.db, parentID, locale, slot); err != nil {
+			return
+		}
+	}
 	var c Container
 	err = b.db.First(&c, "model_name = ? AND model_id = ? AND shared = true", containerName, modelID).Error
 	if err != nil {
 		return
 	}
 	var maxOrder sql.NullFloat64
-	err = b.db.Model(&Container{}).Select("MAX(display_order)").Where("page_id = ? and page_version = ? and locale_code = ?", pageID, pageVersion, locale).Scan(&maxOrder).Error
+	err = b.db.Model(&Container{}).Select("MAX(display_order)").Where("page_id = ? and page_version = ? and locale_code = ? and parent_id = ? and slot = ?", pageID, pageVersion, locale, parentID, slot).Scan(&maxOrder).Error
 	if err != nil {
 		return
 	}
@@ -680,6 +933,8 @@ func (b *Builder) AddSharedContainerToPage(pageID int, pageVersion, locale, cont
 		ModelID:      modelID,
 		Shared:       true,
 		DisplayOrder: maxOrder.Float64 + 1,
+		ParentID:     parentID,
+		Slot:         slot,
 		Locale: l10n.Locale{
 			LocaleCode: locale,
 		},
@@ -701,7 +956,9 @@ func (b *Builder) copyContainersToAnotherPage(db *gorm.DB, pageID int, pageVersi
 		return
 	}
 
-	for _, c := range cons {
+	// the nested containers are copied after their parents to be nested in the copied ones
+	newIDs := map[uint]uint{}
+	for _, c := range sortContainersByParents(cons) {
 		newModelID := c.ModelID
 		if !c.Shared {
 			model := b.ContainerByName(c.ModelName).NewModel()
@@ -717,7 +974,7 @@ func (b *Builder) copyContainersToAnotherPage(db *gorm.DB, pageID int, pageVersi
 			newModelID = reflectutils.MustGet(model, "ID").(uint)
 		}
 
-		if err = db.Create(&Container{
+		newCon := &Container{
 			PageID:       uint(toPageID),
 			PageVersion:  toPageVersion,
 			ModelName:    c.ModelName,
@@ -725,12 +982,16 @@ func (b *Builder) copyContainersToAnotherPage(db *gorm.DB, pageID int, pageVersi
 			ModelID:      newModelID,
 			DisplayOrder: c.DisplayOrder,
 			Shared:       c.Shared,
+			ParentID:     newIDs[c.ParentID],
+			Slot:         c.Slot,
 			Locale: l10n.Locale{
 				LocaleCode: toPageLocale,
 			},
-		}).Error; err != nil {
+		}
+		if err = db.Create(newCon).Error; err != nil {
 			return
 		}
+		newIDs[c.ID] = newCon.ID
 	}
 	return
 }
@@ -798,6 +1059,9 @@ func (b *Builder) localizeContainersToAnotherPage(db *gorm.DB, pageID int, pageV
 		newCon.Shared = c.Shared
 		newCon.LocaleCode = toPageLocale
 		newCon.LocalizeFromModelID = c.ModelID
+		// the localized containers keep the ids, so that the nested ones stay in the same parents
+		newCon.ParentID = c.ParentID
+		newCon.Slot = c.Slot
 
 		if err = db.Save(&newCon).Error; err != nil {
 			return
@@ -932,6 +1196,8 @@ func (b *Builder) AddContainerDialog(ctx *web.EventContext) (r web.EventResponse
 	pageID := ctx.QueryAsInt(paramPageID)
 	pageVersion := ctx.R.FormValue(paramPageVersion)
 	locale := ctx.R.FormValue(paramLocale)
+	parentID := ctx.R.FormValue(paramParentID)
+	slot := ctx.R.FormValue(paramSlot)
 	// okAction := web.Plaid().EventFunc(RenameContainerEvent).Query(paramContainerID, containerID).Go()
 	msgr := i18n.MustGetModuleMessages(ctx.R, I18nPageBuilderKey, Messages_en_US).(*Messages)
 
@@ -958,6 +1224,8 @@ func (b *Builder) AddContainerDialog(ctx *web.EventContext) (r web.EventResponse
 								Query(paramPageVersion, pageVersion).
 								Query(paramLocale, locale).
 								Query(paramContainerName, builder.name).
+								Query(paramParentID, parentID).
+								Query(paramSlot, slot).
 								Go(),
 						),
 					),
@@ -998,6 +1266,8 @@ func (b *Builder) AddContainerDialog(ctx *web.EventContext) (r web.EventResponse
 								Query(paramContainerName, sharedC.ModelName).
 								Query(paramModelID, sharedC.ModelID).
 								Query(paramSharedContainer, "true").
+								Query(paramParentID, parentID).
+								Query(paramSlot, slot).
 								Go(),
 						),
 					),
@@ -48,6 +48,7 @@ func ConfigPageBuilder(db *gorm.DB, prefix, style string, i18nB *i18n.Builder) *
 		&containers.PageTitle{},
 		&containers.ListContentLite{},
 		&containers.ListContentWithImage{},
+		&containers.TwoColumns{},
 	)
 	if err != nil {
 		panic(err)
@@ -78,5 +79,6 @@ func ConfigPageBuilder(db *gorm.DB, prefix, style string, i18nB *i18n.Builder) *
 	containers.RegisterPageTitleContainer(pb, db)
 	containers.RegisterListContentLiteContainer(pb, db)
 	containers.RegisterListContentWithImageContainer(pb, db)
+	containers.RegisterTwoColumnsContainer(pb, db)
 	return pb
 }
@@ -0,0 +1,75 @@
+package containers
+
+import (
+	"fmt"
+
+	"github.com/iancoleman/strcase"
+	"github.com/jinzhu/inflection"
+	"github.com/qor5/admin/pagebuilder"
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/ui/vuetify"
+	"github.com/qor5/web"
+	. "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+)
+
+const (
+	TwoColumnsLeft  = "Left"
+	TwoColumnsRight = "Right"
+)
+
+type TwoColumns struct {
+	ID             uint
+	AddTopSpace    bool
+	AddBottomSpace bool
+	AnchorID       string
+
+	LeftWidth       string
+	BackgroundColor string
+}
+
+func (*TwoColumns) TableName() string {
+	return "container_two_columns"
+}
+
+func RegisterTwoColumnsContainer(pb *pagebuilder.Builder, db *gorm.DB) {
+	vb := pb.RegisterContainer("TwoColumns").
+		Slots(TwoColumnsLeft, TwoColumnsRight).
+		RenderFunc(func(obj interface{}, input *pagebuilder.RenderInput, ctx *web.EventContext) HTMLComponent {
+			v := obj.(*TwoColumns)
+			return TwoColumnsBody(v, input)
+		})
+	mb := vb.Model(&TwoColumns{})
+	eb := mb.Editing("AddTopSpace", "AddBottomSpace", "AnchorID", "LeftWidth", "BackgroundColor")
+	eb.Field("LeftWidth").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) HTMLComponent {
+		return vuetify.VSelect().
+			Items([]string{"25%", "33%", "50%", "67%", "75%"}).
+			Value(field.Value(obj)).
+			Label(field.Label).
+			FieldName(field.FormKey)
+	})
+	eb.Field("BackgroundColor").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) HTMLComponent {
+		return vuetify.VSelect().
+			Items(BackgroundColors).
+			Value(field.Value(obj)).
+			Label(field.Label).
+			FieldName(field.FormKey)
+	})
+}
+
+func TwoColumnsBody(data *TwoColumns, input *pagebuilder.RenderInput) (body HTMLComponent) {
+	leftWidth := data.LeftWidth
+	if leftWidth == "" {
+		leftWidth = "50%"
+	}
+	body = ContainerWrapper(
+		fmt.Sprintf(inflection.Plural(strcase.ToKebab("TwoColumns"))+"_%v", data.ID), data.AnchorID, "container-two_columns",
+		data.BackgroundColor, "", "",
+		"", data.AddTopSpace, data.AddBottomSpace, input.IsEditor, input.IsReadonly, "",
+		Div(
+			Div(input.Slots[TwoColumnsLeft]).Class("container-two_columns-left").Style(fmt.Sprintf("flex: 0 0 %s;", leftWidth)),
+			Div(input.Slots[TwoColumnsRight]).Class("container-two_columns-right").Style("flex: 1;"),
+		).Class("container-wrapper").Style("display: flex;"),
+	)
+	return
+}
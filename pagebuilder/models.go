@@ -139,6 +139,8 @@ type Container struct {
 	Shared       bool
 	Hidden       bool
 	DisplayName  string
+	ParentID     uint   `gorm:"default:0;index"`
+	Slot         string `gorm:"default:''"`
 
 	l10n.Locale
 	LocalizeFromModelID uint
@@ -0,0 +1,174 @@
+package pagebuilder
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/qor5/web"
+	"github.com/qor5/x/i18n"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type testColumns struct {
+	ID uint
+}
+
+func (*testColumns) TableName() string {
+	return "test_container_columns"
+}
+
+type testText struct {
+	ID   uint
+	Body string
+}
+
+func (*testText) TableName() string {
+	return "test_container_texts"
+}
+
+func newNestedTestBuilder(t *testing.T) (*Builder, *gorm.DB) {
+	db, err := gorm.Open(postgres.Open(os.Getenv("DBURL")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&testColumns{}, &testText{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"page_builder_containers", "test_container_columns", "test_container_texts"} {
+		db.Exec(fmt.Sprintf("DELETE FROM %s", table))
+	}
+
+	b := New("/page_builder", db, i18n.New())
+	b.RegisterContainer("Columns").Slots("Left", "Right").
+		RenderFunc(func(obj interface{}, input *RenderInput, ctx *web.EventContext) h.HTMLComponent {
+			return h.Div(
+				h.Div(input.Slots["Left"]).Class("left"),
+				h.Div(input.Slots["Right"]).Class("right"),
+			).Class("columns")
+		}).
+		Model(&testColumns{})
+	b.RegisterContainer("Text").
+		RenderFunc(func(obj interface{}, input *RenderInput, ctx *web.EventContext) h.HTMLComponent {
+			return h.P(h.Text(obj.(*testText).Body))
+		}).
+		Model(&testText{})
+	return b, db
+}
+
+func addText(t *testing.T, b *Builder, db *gorm.DB, body string, parentID uint, slot string) *Container {
+	modelID, err := b.AddContainerToSlot(1, "v1", "", "Text", parentID, slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&testText{}).Where("id = ?", modelID).Update("body", body)
+	c := &Container{}
+	db.Where("model_name = ? AND model_id = ?", "Text", modelID).First(c)
+	return c
+}
+
+var tagSpaces = regexp.MustCompile(`>\s+<`)
+
+func renderNestedTestPage(t *testing.T, b *Builder, version string) string {
+	ctx := &web.EventContext{R: httptest.NewRequest("GET", "/", nil)}
+	comps, err := b.renderContainers(ctx, 1, version, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(tagSpaces.ReplaceAllString(h.MustString(h.Components(comps...), context.Background()), "><"))
+}
+
+func TestNestedContainers(t *testing.T) {
+	b, db := newNestedTestBuilder(t)
+
+	if _, err := b.AddContainerToPage(1, "v1", "", "Columns"); err != nil {
+		t.Fatal(err)
+	}
+	columns := &Container{}
+	db.Where("model_name = ?", "Columns").First(columns)
+	addText(t, b, db, "a", columns.ID, "Left")
+	right := addText(t, b, db, "b", columns.ID, "Right")
+	addText(t, b, db, "c", columns.ID, "Left")
+	addText(t, b, db, "d", 0, "")
+	if _, err := b.AddContainerToSlot(1, "v1", "", "Text", columns.ID, "Middle"); err == nil {
+		t.Errorf("want the error of the unknown slot")
+	}
+
+	want := `<div class='columns'><div class='left'><p>a</p><p>c</p></div><div class='right'><p>b</p></div></div><p>d</p>`
+	if got := renderNestedTestPage(t, b, "v1"); got != want {
+		t.Errorf("want the nested containers rendered %s, but got %s", want, got)
+	}
+
+	if err := b.copyContainersToNewPageVersion(db, 1, "", "v1", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := renderNestedTestPage(t, b, "v2"); got != want {
+		t.Errorf("want the nested containers copied %s, but got %s", want, got)
+	}
+	var copied []*Container
+	db.Find(&copied, "page_version = ? AND parent_id <> 0", "v2")
+	for _, c := range copied {
+		if c.ParentID == columns.ID {
+			t.Errorf("want the copied container nested in the copied parent, but got %#+v", c)
+		}
+	}
+
+	// moves b to the left before a and the columns after d
+	var cons []*Container
+	db.Order("display_order").Find(&cons, "page_version = ?", "v1")
+	slugs := map[string]string{}
+	for _, c := range cons {
+		var text testText
+		db.First(&text, c.ModelID)
+		if c.ModelName == "Columns" {
+			text.Body = "columns"
+		}
+		slugs[text.Body] = c.PrimarySlug()
+	}
+	sorter := &ContainerSorter{
+		Items: []ContainerSorterItem{{ParamID: slugs["d"]}, {ParamID: slugs["columns"]}},
+		Slots: map[string][]ContainerSorterItem{
+			containerSlotKey(columns.ID, "Left"):  {{ParamID: slugs["b"]}, {ParamID: slugs["a"]}, {ParamID: slugs["c"]}},
+			containerSlotKey(columns.ID, "Right"): {},
+		},
+	}
+	if err := b.moveContainers(db, sorter); err != nil {
+		t.Fatal(err)
+	}
+	want = `<p>d</p><div class='columns'><div class='left'><p>b</p><p>a</p><p>c</p></div><div class='right'></div></div>`
+	if got := renderNestedTestPage(t, b, "v1"); got != want {
+		t.Errorf("want the nested containers moved %s, but got %s", want, got)
+	}
+
+	list, err := b.renderContainersList(&web.EventContext{R: httptest.NewRequest("GET", "/", nil)}, 1, "v1", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h.MustString(list, context.Background()); !strings.Contains(got, `v-model='locals.slots[slot.key]'`) || !strings.Contains(got, containerSlotKey(columns.ID, "Left")) {
+		t.Errorf("want the slots of the columns sortable, but got %s", got)
+	}
+
+	// a container can't be moved into itself
+	sorter.Items = []ContainerSorterItem{{ParamID: slugs["d"]}}
+	sorter.Slots[containerSlotKey(columns.ID, "Right")] = []ContainerSorterItem{{ParamID: slugs["columns"]}}
+	if err := b.moveContainers(db, sorter); err == nil {
+		t.Errorf("want the error of the container nested in itself")
+	}
+
+	r := httptest.NewRequest("POST", fmt.Sprintf("/page_builder/editors?%s=%s", paramContainerID, columns.PrimarySlug()), nil)
+	if _, err := b.DeleteContainer(&web.EventContext{R: r}); err != nil {
+		t.Fatal(err)
+	}
+	if got := renderNestedTestPage(t, b, "v1"); got != `<p>d</p>` {
+		t.Errorf("want the nested containers deleted with the parent, but got %s", got)
+	}
+	if err := db.First(&Container{}, right.ID).Error; err == nil {
+		t.Errorf("want the nested container deleted")
+	}
+}
@@ -2,7 +2,9 @@ package publish
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"reflect"
 	"strings"
 	"sync"
@@ -18,16 +20,45 @@ type Builder struct {
 	db      *gorm.DB
 	storage oss.StorageInterface
 	context context.Context
+
+	dependentsHandler      DependentsHandler
+	dependentsErrorHandler DependentsErrorHandler
+	dependentModels        *sync.Map
+	storageNotFound        func(err error) bool
+
+	dryRun *DryRunResult
 }
 
 func New(db *gorm.DB, storage oss.StorageInterface) *Builder {
 	return &Builder{
-		db:      db,
-		storage: storage,
-		context: context.Background(),
+		db:              db,
+		storage:         storage,
+		context:         context.Background(),
+		dependentModels: &sync.Map{},
 	}
 }
 
+// StorageNotFoundFunc sets how to tell the error of a file not found in the storage from the other errors,
+// by default os.ErrNotExist and the NoSuchKey and NotFound errors of S3 are not found errors
+func (b *Builder) StorageNotFoundFunc(f func(err error) bool) *Builder {
+	b.storageNotFound = f
+	return b
+}
+
+func (b *Builder) isStorageNotFound(err error) bool {
+	if b.storageNotFound != nil {
+		return b.storageNotFound(err)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	var coder interface{ Code() string }
+	if errors.As(err, &coder) {
+		return coder.Code() == "NoSuchKey" || coder.Code() == "NotFound"
+	}
+	return strings.HasPrefix(err.Error(), "NoSuchKey")
+}
+
 func (b *Builder) WithValue(key, val interface{}) *Builder {
 	b.context = context.WithValue(b.context, key, val)
 	return b
@@ -60,120 +91,156 @@ func (b *Builder) Context() context.Context {
 
 // 幂等
 func (b *Builder) Publish(record interface{}) (err error) {
+	return b.publish(record, true)
+}
+
+func (b *Builder) publish(record interface{}, withDependents bool) (err error) {
 	err = utils.Transact(b.db, func(tx *gorm.DB) (err error) {
-		// publish content
-		if r, ok := record.(PublishInterface); ok {
-			var objs []*PublishAction
-			objs, err = r.GetPublishActions(b.db, b.context, b.storage)
+		return b.publishRecord(record)
+	})
+	if err != nil || !withDependents {
+		return
+	}
+
+	// republish records which embed this one
+	b.afterCommitDependents(record)
+	return
+}
+
+// publishRecord uploads the content and updates the status of record with b.db,
+// the caller is responsible for the transaction
+func (b *Builder) publishRecord(record interface{}) (err error) {
+	// publish content
+	if r, ok := record.(PublishInterface); ok {
+		var objs []*PublishAction
+		objs, err = r.GetPublishActions(b.db, b.context, b.storage)
+		if err != nil {
+			return
+		}
+		if err = b.uploadOrDelete(objs); err != nil {
+			return
+		}
+	}
+
+	// update status
+	if r, ok := record.(StatusInterface); ok {
+		now := b.db.NowFunc()
+		if version, ok := record.(VersionInterface); ok {
+			var modelSchema *schema.Schema
+			modelSchema, err = schema.Parse(record, &sync.Map{}, b.db.NamingStrategy)
 			if err != nil {
 				return
 			}
-			if err = UploadOrDelete(objs, b.storage); err != nil {
+			scope := SetPrimaryKeysConditionWithoutVersion(b.db.Model(reflect.New(modelSchema.ModelType).Interface()), record, modelSchema).Where("version <> ? AND status = ?", version.GetVersion(), StatusOnline)
+			var count int64
+			if err = scope.Count(&count).Error; err != nil {
 				return
 			}
-		}
 
-		// update status
-		if r, ok := record.(StatusInterface); ok {
-			now := b.db.NowFunc()
-			if version, ok := record.(VersionInterface); ok {
-				var modelSchema *schema.Schema
-				modelSchema, err = schema.Parse(record, &sync.Map{}, b.db.NamingStrategy)
-				if err != nil {
-					return
+			// update old version
+			if count > 0 {
+				var oldVersionUpdateMap = make(map[string]interface{})
+				if _, ok := record.(ScheduleInterface); ok {
+					oldVersionUpdateMap["scheduled_end_at"] = nil
+					oldVersionUpdateMap["actual_end_at"] = &now
 				}
-				scope := SetPrimaryKeysConditionWithoutVersion(b.db.Model(reflect.New(modelSchema.ModelType).Interface()), record, modelSchema).Where("version <> ? AND status = ?", version.GetVersion(), StatusOnline)
-				var count int64
-				if err = scope.Count(&count).Error; err != nil {
-					return
+				if _, ok := record.(ListInterface); ok {
+					oldVersionUpdateMap["list_deleted"] = true
 				}
-
-				// update old version
-				if count > 0 {
-					var oldVersionUpdateMap = make(map[string]interface{})
-					if _, ok := record.(ScheduleInterface); ok {
-						oldVersionUpdateMap["scheduled_end_at"] = nil
-						oldVersionUpdateMap["actual_end_at"] = &now
-					}
-					if _, ok := record.(ListInterface); ok {
-						oldVersionUpdateMap["list_deleted"] = true
-					}
-					oldVersionUpdateMap["status"] = StatusOffline
-					if err = scope.Updates(oldVersionUpdateMap).Error; err != nil {
-						return
-					}
+				oldVersionUpdateMap["status"] = StatusOffline
+				if err = b.updateStatus(scope, record, oldVersionUpdateMap, true); err != nil {
+					return
 				}
 			}
-			var updateMap = make(map[string]interface{})
-
-			if r, ok := record.(ScheduleInterface); ok {
-				r.SetPublishedAt(&now)
-				r.SetScheduledStartAt(nil)
-				updateMap["scheduled_start_at"] = r.GetScheduledStartAt()
-				updateMap["actual_start_at"] = r.GetPublishedAt()
-			}
-			if _, ok := record.(ListInterface); ok {
-				updateMap["list_updated"] = true
-			}
-			updateMap["status"] = StatusOnline
-			updateMap["online_url"] = r.GetOnlineUrl()
-			if err = b.db.Model(record).Updates(updateMap).Error; err != nil {
-				return
-			}
 		}
+		var updateMap = make(map[string]interface{})
 
-		// publish callback
-		if r, ok := record.(AfterPublishInterface); ok {
-			if err = r.AfterPublish(b.db, b.storage, b.context); err != nil {
-				return
-			}
+		if r, ok := record.(ScheduleInterface); ok {
+			r.SetPublishedAt(&now)
+			r.SetScheduledStartAt(nil)
+			updateMap["scheduled_start_at"] = r.GetScheduledStartAt()
+			updateMap["actual_start_at"] = r.GetPublishedAt()
+		}
+		if _, ok := record.(ListInterface); ok {
+			updateMap["list_updated"] = true
 		}
+		updateMap["status"] = StatusOnline
+		updateMap["online_url"] = r.GetOnlineUrl()
+		if err = b.updateStatus(b.db.Model(record), record, updateMap, false); err != nil {
+			return
+		}
+	}
+
+	if b.dryRun != nil {
 		return
-	})
+	}
+
+	// publish callback
+	if r, ok := record.(AfterPublishInterface); ok {
+		if err = r.AfterPublish(b.db, b.storage, b.context); err != nil {
+			return
+		}
+	}
 	return
 }
 
 func (b *Builder) UnPublish(record interface{}) (err error) {
 	err = utils.Transact(b.db, func(tx *gorm.DB) (err error) {
-		// unpublish content
-		if r, ok := record.(UnPublishInterface); ok {
-			var objs []*PublishAction
-			objs, err = r.GetUnPublishActions(b.db, b.context, b.storage)
-			if err != nil {
-				return
-			}
-			if err = UploadOrDelete(objs, b.storage); err != nil {
-				return
-			}
-		}
+		return b.unPublishRecord(record)
+	})
+	if err != nil {
+		return
+	}
 
-		// update status
-		if _, ok := record.(StatusInterface); ok {
-			var updateMap = make(map[string]interface{})
-			if r, ok := record.(ScheduleInterface); ok {
-				now := b.db.NowFunc()
-				r.SetUnPublishedAt(&now)
-				r.SetScheduledEndAt(nil)
-				updateMap["scheduled_end_at"] = r.GetScheduledEndAt()
-				updateMap["actual_end_at"] = r.GetUnPublishedAt()
-			}
-			if _, ok := record.(ListInterface); ok {
-				updateMap["list_deleted"] = true
-			}
-			updateMap["status"] = StatusOffline
-			if err = b.db.Model(record).Updates(updateMap).Error; err != nil {
-				return
-			}
+	// republish records which embed this one
+	b.afterCommitDependents(record)
+	return
+}
+
+// unPublishRecord deletes the content and updates the status of record with b.db,
+// the caller is responsible for the transaction
+func (b *Builder) unPublishRecord(record interface{}) (err error) {
+	// unpublish content
+	if r, ok := record.(UnPublishInterface); ok {
+		var objs []*PublishAction
+		objs, err = r.GetUnPublishActions(b.db, b.context, b.storage)
+		if err != nil {
+			return
+		}
+		if err = b.uploadOrDelete(objs); err != nil {
+			return
 		}
+	}
 
-		// unpublish callback
-		if r, ok := record.(AfterUnPublishInterface); ok {
-			if err = r.AfterUnPublish(b.db, b.storage, b.context); err != nil {
-				return
-			}
+	// update status
+	if _, ok := record.(StatusInterface); ok {
+		var updateMap = make(map[string]interface{})
+		if r, ok := record.(ScheduleInterface); ok {
+			now := b.db.NowFunc()
+			r.SetUnPublishedAt(&now)
+			r.SetScheduledEndAt(nil)
+			updateMap["scheduled_end_at"] = r.GetScheduledEndAt()
+			updateMap["actual_end_at"] = r.GetUnPublishedAt()
+		}
+		if _, ok := record.(ListInterface); ok {
+			updateMap["list_deleted"] = true
 		}
+		updateMap["status"] = StatusOffline
+		if err = b.updateStatus(b.db.Model(record), record, updateMap, false); err != nil {
+			return
+		}
+	}
+
+	if b.dryRun != nil {
 		return
-	})
+	}
+
+	// unpublish callback
+	if r, ok := record.(AfterUnPublishInterface); ok {
+		if err = r.AfterUnPublish(b.db, b.storage, b.context); err != nil {
+			return
+		}
+	}
 	return
 }
 
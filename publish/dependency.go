@@ -0,0 +1,272 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// DependentsInterface is implemented by records that are embedded in other published records,
+// e.g. a product shown on category list pages and CMS pages.
+// After the record is published or unpublished, its online dependents get republished.
+type DependentsInterface interface {
+	GetPublishDependents(db *gorm.DB, ctx context.Context) (dependents []interface{}, err error)
+}
+
+// DependentRef identifies a dependent record by its table and primary keys,
+// so that it can be stored in job arguments and loaded again.
+type DependentRef struct {
+	Table string
+	Keys  map[string]string
+}
+
+func (ref DependentRef) String() string {
+	var keys []string
+	for k := range ref.Keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, ref.Keys[k]))
+	}
+	return fmt.Sprintf("%s(%s)", ref.Table, strings.Join(pairs, ","))
+}
+
+type Dependent struct {
+	Ref    DependentRef
+	Record interface{}
+	// Depth is 1 for records that directly embed the published record
+	Depth int
+}
+
+type DependencyGraph struct {
+	Dependents []*Dependent
+	// Cycles contains the ref paths that lead back to a record already on the path,
+	// those edges are skipped when collecting dependents
+	Cycles [][]DependentRef
+}
+
+// Urls returns the online urls of the dependents which will be republished
+func (g *DependencyGraph) Urls() (urls []string) {
+	for _, d := range g.Dependents {
+		if s, ok := d.Record.(StatusInterface); ok && s.GetOnlineUrl() != "" {
+			urls = append(urls, s.GetOnlineUrl())
+		}
+	}
+	return
+}
+
+func (g *DependencyGraph) Refs() (refs []DependentRef) {
+	for _, d := range g.Dependents {
+		refs = append(refs, d.Ref)
+	}
+	return
+}
+
+// DependentsHandler is called after a record with dependents is published or unpublished.
+// By default the dependents are republished synchronously,
+// publish/views.ConfigureDependentsJob replaces it to queue a worker job instead.
+type DependentsHandler func(ctx context.Context, graph *DependencyGraph) error
+
+func (b *Builder) DependentsHandler(h DependentsHandler) *Builder {
+	b.dependentsHandler = h
+	return b
+}
+
+// DependentsErrorHandler is called when the dependents of a published or unpublished record fail to be handled.
+// The record itself is already committed, so the error is not returned by Publish or UnPublish,
+// the handler can retry it later, e.g. with HandleDependents in a worker job. The error is logged by default.
+type DependentsErrorHandler func(ctx context.Context, record interface{}, err error)
+
+func (b *Builder) DependentsErrorHandler(h DependentsErrorHandler) *Builder {
+	b.dependentsErrorHandler = h
+	return b
+}
+
+// RegisterDependentModels registers the models that can be loaded back from a DependentRef
+// example: RegisterDependentModels(&Product{}, &Category{})
+func (b *Builder) RegisterDependentModels(models ...interface{}) *Builder {
+	for _, m := range models {
+		s, err := schema.Parse(m, &sync.Map{}, b.db.NamingStrategy)
+		if err != nil {
+			panic(err)
+		}
+		b.dependentModels.Store(s.Table, s.ModelType)
+	}
+	return b
+}
+
+// DependencyGraph collects the online records which embed the record, directly or indirectly
+func (b *Builder) DependencyGraph(record interface{}) (g *DependencyGraph, err error) {
+	g = &DependencyGraph{}
+	root, err := b.dependentRef(record)
+	if err != nil {
+		return
+	}
+	visited := map[string]bool{root.String(): true}
+	err = b.collectDependents(record, []DependentRef{root}, visited, g)
+	return
+}
+
+func (b *Builder) collectDependents(record interface{}, path []DependentRef, visited map[string]bool, g *DependencyGraph) (err error) {
+	r, ok := record.(DependentsInterface)
+	if !ok {
+		return
+	}
+	dependents, err := r.GetPublishDependents(b.db, b.context)
+	if err != nil {
+		return
+	}
+
+	for _, dep := range dependents {
+		var ref DependentRef
+		ref, err = b.dependentRef(dep)
+		if err != nil {
+			return
+		}
+		key := ref.String()
+
+		if cycleStart := indexOfRef(path, key); cycleStart >= 0 {
+			cycle := append(append([]DependentRef{}, path[cycleStart:]...), ref)
+			log.Printf("publish dependency cycle detected: %s\n", formatRefPath(cycle))
+			g.Cycles = append(g.Cycles, cycle)
+			continue
+		}
+		if visited[key] {
+			continue
+		}
+		visited[key] = true
+
+		if s, ok := dep.(StatusInterface); ok && s.GetStatus() != StatusOnline {
+			continue
+		}
+		g.Dependents = append(g.Dependents, &Dependent{
+			Ref:    ref,
+			Record: dep,
+			Depth:  len(path),
+		})
+
+		if err = b.collectDependents(dep, append(path, ref), visited, g); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// RepublishDependents loads the dependents by refs and republishes the ones still online,
+// it does not cascade to their dependents since refs are expected to come from a complete DependencyGraph
+func (b *Builder) RepublishDependents(refs []DependentRef) (err error) {
+	for _, ref := range refs {
+		record, err2 := b.loadDependent(ref)
+		if err2 != nil {
+			err = multierror.Append(err, err2).ErrorOrNil()
+			continue
+		}
+		if s, ok := record.(StatusInterface); ok && s.GetStatus() != StatusOnline {
+			continue
+		}
+		if err2 = b.publish(record, false); err2 != nil {
+			log.Printf("republish dependent %s error: %s\n", ref, err2)
+			err = multierror.Append(err, err2).ErrorOrNil()
+		}
+	}
+	return
+}
+
+// afterCommitDependents handles the dependents of the committed record, the error goes to the dependents error handler
+func (b *Builder) afterCommitDependents(record interface{}) {
+	err := b.HandleDependents(record)
+	if err == nil {
+		return
+	}
+	if b.dependentsErrorHandler != nil {
+		b.dependentsErrorHandler(b.context, record, err)
+		return
+	}
+	ref, _ := b.dependentRef(record)
+	log.Printf("handle dependents of %s error: %s\n", ref, err)
+}
+
+// HandleDependents republishes the online dependents of the record with the dependents handler,
+// it is called after the record is published or unpublished and can be called again to retry
+func (b *Builder) HandleDependents(record interface{}) (err error) {
+	if _, ok := record.(DependentsInterface); !ok {
+		return
+	}
+	g, err := b.DependencyGraph(record)
+	if err != nil {
+		return
+	}
+	if len(g.Dependents) == 0 {
+		return
+	}
+	if b.dryRun != nil {
+		b.dryRun.addDependentUrls(g.Urls())
+		return
+	}
+	if b.dependentsHandler != nil {
+		return b.dependentsHandler(b.context, g)
+	}
+	return b.RepublishDependents(g.Refs())
+}
+
+func (b *Builder) loadDependent(ref DependentRef) (record interface{}, err error) {
+	modelType, ok := b.dependentModels.Load(ref.Table)
+	if !ok {
+		return nil, fmt.Errorf("dependent model of table %s is not registered", ref.Table)
+	}
+	if len(ref.Keys) == 0 {
+		return nil, errors.New("dependent ref has no primary keys")
+	}
+
+	record = reflect.New(modelType.(reflect.Type)).Interface()
+	scope := b.db.Model(record)
+	for k, v := range ref.Keys {
+		scope = scope.Where(fmt.Sprintf("%s = ?", k), v)
+	}
+	err = scope.First(record).Error
+	return
+}
+
+func (b *Builder) dependentRef(record interface{}) (ref DependentRef, err error) {
+	s, err := schema.Parse(record, &sync.Map{}, b.db.NamingStrategy)
+	if err != nil {
+		return
+	}
+	ref.Table = s.Table
+	ref.Keys = make(map[string]string)
+	rv := reflect.ValueOf(record)
+	for _, p := range s.PrimaryFields {
+		val, _ := p.ValueOf(context.Background(), rv)
+		ref.Keys[p.DBName] = fmt.Sprint(val)
+	}
+	return
+}
+
+func indexOfRef(path []DependentRef, key string) int {
+	for i, ref := range path {
+		if ref.String() == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func formatRefPath(path []DependentRef) string {
+	var segs []string
+	for _, ref := range path {
+		segs = append(segs, ref.String())
+	}
+	return strings.Join(segs, " -> ")
+}
@@ -0,0 +1,110 @@
+package publish
+
+import (
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// DryRunResult collects what a publish would do without touching storage or committing status updates
+type DryRunResult struct {
+	Actions       []*PublishAction
+	StatusUpdates []*StatusUpdate
+	// DependentUrls are the online urls of the records which would be republished afterwards
+	DependentUrls []string
+
+	mutex sync.Mutex
+}
+
+type StatusUpdate struct {
+	Record  interface{}
+	Updates map[string]interface{}
+	// OtherVersions is true when the updates apply to the other online versions of Record
+	OtherVersions bool
+}
+
+func (r *DryRunResult) addActions(objs []*PublishAction) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Actions = append(r.Actions, objs...)
+}
+
+func (r *DryRunResult) addStatusUpdate(u *StatusUpdate) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.StatusUpdates = append(r.StatusUpdates, u)
+}
+
+func (r *DryRunResult) addDependentUrls(urls []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.DependentUrls = append(r.DependentUrls, urls...)
+}
+
+func (r *DryRunResult) Uploads() (objs []*PublishAction) {
+	for _, obj := range r.Actions {
+		if !obj.IsDelete {
+			objs = append(objs, obj)
+		}
+	}
+	return
+}
+
+func (r *DryRunResult) Deletes() (objs []*PublishAction) {
+	for _, obj := range r.Actions {
+		if obj.IsDelete {
+			objs = append(objs, obj)
+		}
+	}
+	return
+}
+
+// TotalContentSize is the size in bytes of all uploads
+func (r *DryRunResult) TotalContentSize() (size int) {
+	for _, obj := range r.Uploads() {
+		size += len(obj.Content)
+	}
+	return
+}
+
+// DryRun returns a copy of the builder which collects publish actions and status updates into result,
+// storage is not touched, status is not updated and After(Un)Publish callbacks are not called
+func (b *Builder) DryRun(result *DryRunResult) *Builder {
+	nb := *b
+	nb.dryRun = result
+	return &nb
+}
+
+func (b *Builder) uploadOrDelete(objs []*PublishAction) error {
+	if b.dryRun != nil {
+		b.dryRun.addActions(objs)
+		return nil
+	}
+	return UploadOrDelete(objs, b.storage)
+}
+
+func (b *Builder) updateStatus(scope *gorm.DB, record interface{}, updates map[string]interface{}, otherVersions bool) error {
+	if b.dryRun != nil {
+		b.dryRun.addStatusUpdate(&StatusUpdate{
+			Record:        record,
+			Updates:       updates,
+			OtherVersions: otherVersions,
+		})
+		return nil
+	}
+	return scope.Updates(updates).Error
+}
+
+// DryRun returns a copy of the builder which collects list pages publish actions and list status updates into result
+func (b *ListPublishBuilder) DryRun(result *DryRunResult) *ListPublishBuilder {
+	nb := *b
+	nb.dryRun = result
+	return &nb
+}
+
+// DryRun returns a copy of the builder which runs the publisher in dry run mode
+func (b *SchedulePublishBuilder) DryRun(result *DryRunResult) *SchedulePublishBuilder {
+	nb := *b
+	nb.publisher = b.publisher.DryRun(result)
+	return &nb
+}
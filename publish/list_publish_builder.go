@@ -20,6 +20,7 @@ type ListPublishBuilder struct {
 	getOldItemsFunc    func(record interface{}) (result []interface{}, err error)
 	totalNumberPerPage int
 	publishActionsFunc func(db *gorm.DB, lp ListPublisher, result []*OnePageItems, indexPage *OnePageItems) (objs []*PublishAction)
+	dryRun             *DryRunResult
 }
 
 func NewListPublishBuilder(db *gorm.DB, storage oss.StorageInterface) *ListPublishBuilder {
@@ -97,6 +98,11 @@ type ListPublisher interface {
 // model is a empty struct
 // example: Product{}
 func (b *ListPublishBuilder) Run(model interface{}) (err error) {
+	return b.RunWithContext(context.Background(), model)
+}
+
+// RunWithContext does not start publishing the list pages once ctx is done
+func (b *ListPublishBuilder) RunWithContext(ctx context.Context, model interface{}) (err error) {
 	//If model is Product{}
 	//Generate a records: []*Product{}
 	records := reflect.MakeSlice(reflect.SliceOf(reflect.New(reflect.TypeOf(model)).Type()), 0, 0).Interface()
@@ -165,20 +171,30 @@ func (b *ListPublishBuilder) Run(model interface{}) (err error) {
 	var objs []*PublishAction
 	objs = b.publishActionsFunc(b.db, lp, needPublishResults, indexResult)
 
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	if b.dryRun != nil {
+		b.dryRun.addActions(objs)
+	}
+
 	err = utils.Transact(b.db, func(tx *gorm.DB) (err1 error) {
-		if err1 = UploadOrDelete(objs, b.storage); err1 != nil {
-			return
+		if b.dryRun == nil {
+			if err1 = UploadOrDelete(objs, b.storage); err1 != nil {
+				return
+			}
 		}
 
 		for _, items := range needPublishResults {
 			for _, item := range items.Items {
 				if listItem, ok := item.(ListInterface); ok {
-					if err1 = b.db.Model(item).Updates(map[string]interface{}{
+					if err1 = b.updateListStatus(item, map[string]interface{}{
 						"list_updated": listItem.GetListUpdated(),
 						"list_deleted": listItem.GetListDeleted(),
 						"page_number":  listItem.GetPageNumber(),
 						"position":     listItem.GetPosition(),
-					}).Error; err1 != nil {
+					}); err1 != nil {
 						return
 					}
 				} else {
@@ -190,12 +206,12 @@ func (b *ListPublishBuilder) Run(model interface{}) (err error) {
 
 		for _, item := range deleteItems {
 			if _, ok := item.(ListInterface); ok {
-				if err1 = b.db.Model(item).Updates(map[string]interface{}{
+				if err1 = b.updateListStatus(item, map[string]interface{}{
 					"list_updated": false,
 					"list_deleted": false,
 					"page_number":  0,
 					"position":     0,
-				}).Error; err1 != nil {
+				}); err1 != nil {
 					return
 				}
 			} else {
@@ -208,6 +224,17 @@ func (b *ListPublishBuilder) Run(model interface{}) (err error) {
 	return
 }
 
+func (b *ListPublishBuilder) updateListStatus(item interface{}, updates map[string]interface{}) error {
+	if b.dryRun != nil {
+		b.dryRun.addStatusUpdate(&StatusUpdate{
+			Record:  item,
+			Updates: updates,
+		})
+		return nil
+	}
+	return b.db.Model(item).Updates(updates).Error
+}
+
 func (b *ListPublishBuilder) NeedNextPageFunc(f func(totalNumberPerPage, currentPageNumber, totalNumberOfItems int) bool) *ListPublishBuilder {
 	b.needNextPageFunc = f
 	return b
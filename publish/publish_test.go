@@ -8,11 +8,16 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/qor/oss"
+	"github.com/qor5/admin/presets"
 	"github.com/qor5/admin/publish"
+	"github.com/qor5/admin/publish/views"
+	"github.com/qor5/admin/worker"
+	"github.com/qor5/admin/worker/mock"
 	"github.com/theplant/sliceutils"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -564,3 +569,427 @@ func assertNoVersionUploadFile(p *ProductWithoutVersion, storage oss.StorageInte
 	}
 	return nil
 }
+
+type DependentPage struct {
+	gorm.Model
+	Name string
+
+	publish.Status
+
+	dependents []interface{}
+}
+
+func (p *DependentPage) GetPublishDependents(db *gorm.DB, ctx context.Context) ([]interface{}, error) {
+	return p.dependents, nil
+}
+
+func TestDependencyGraph(t *testing.T) {
+	db := ConnectDB()
+	p := publish.New(db, &MockStorage{})
+
+	product := &DependentPage{Model: gorm.Model{ID: 1}, Name: "product"}
+	category := &DependentPage{Model: gorm.Model{ID: 2}, Name: "category", Status: publish.Status{Status: publish.StatusOnline, OnlineUrl: "/category/index.html"}}
+	cms := &DependentPage{Model: gorm.Model{ID: 3}, Name: "cms", Status: publish.Status{Status: publish.StatusOnline, OnlineUrl: "/cms/index.html"}}
+	draft := &DependentPage{Model: gorm.Model{ID: 4}, Name: "draft", Status: publish.Status{Status: publish.StatusDraft}}
+
+	product.dependents = []interface{}{category, draft}
+	category.dependents = []interface{}{cms}
+	// cms embeds category list again, which makes a cycle
+	cms.dependents = []interface{}{category}
+
+	g, err := p.DependencyGraph(product)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := strings.Join(g.Urls(), ","); diff != "/category/index.html,/cms/index.html" {
+		t.Errorf("unexpected dependents urls: %s", diff)
+	}
+	if len(g.Cycles) != 1 || len(g.Cycles[0]) != 3 {
+		t.Errorf("expected 1 cycle of category -> cms -> category, got %v", g.Cycles)
+	}
+	if g.Dependents[1].Depth != 2 {
+		t.Errorf("expected depth 2 for cms, got %d", g.Dependents[1].Depth)
+	}
+}
+
+func TestPublishDependentsErrorAfterCommit(t *testing.T) {
+	db := ConnectDB()
+	db.AutoMigrate(&DependentPage{})
+	p := publish.New(db, &MockStorage{})
+
+	product := &DependentPage{Model: gorm.Model{ID: 21}, Name: "product"}
+	category := &DependentPage{Model: gorm.Model{ID: 22}, Name: "category", Status: publish.Status{Status: publish.StatusOnline, OnlineUrl: "/category/index.html"}}
+	product.dependents = []interface{}{category}
+	db.Clauses(clause.OnConflict{UpdateAll: true}).Create(product)
+
+	var failed []interface{}
+	p.DependentsHandler(func(ctx context.Context, graph *publish.DependencyGraph) error {
+		return errors.New("queue unavailable")
+	}).DependentsErrorHandler(func(ctx context.Context, record interface{}, err error) {
+		failed = append(failed, record)
+	})
+
+	// the record is published even if its dependents fail
+	if err := p.Publish(product); err != nil {
+		t.Fatalf("want the committed publish not failed by the dependents, but got %v", err)
+	}
+	var published DependentPage
+	db.First(&published, 21)
+	if published.Status.Status != publish.StatusOnline {
+		t.Errorf("want the record online, but got %s", published.Status.Status)
+	}
+	if len(failed) != 1 || failed[0] != product {
+		t.Errorf("want the dependents error handled, but got %v", failed)
+	}
+}
+
+func TestPublishDryRun(t *testing.T) {
+	db := ConnectDB()
+	db.AutoMigrate(&ProductWithoutVersion{})
+	storage := &MockStorage{}
+
+	product1 := ProductWithoutVersion{
+		Model:  gorm.Model{ID: 11},
+		Code:   "0011",
+		Name:   "dry run",
+		Status: publish.Status{Status: publish.StatusDraft},
+	}
+	db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&product1)
+
+	result := &publish.DryRunResult{}
+	p := publish.New(db, storage)
+	if err := p.DryRun(result).Publish(&product1); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(storage.Objects) != 0 {
+		t.Errorf("dry run should not touch storage, got %v", storage.Objects)
+	}
+	if err := assertNoVersionUpdateStatus(db, &product1, publish.StatusDraft, ""); err != nil {
+		t.Error(err)
+	}
+	if len(result.Uploads()) != 1 || result.Uploads()[0].Url != product1.getUrl() {
+		t.Errorf("unexpected uploads: %#+v", result.Uploads())
+	}
+	if result.TotalContentSize() != len(product1.getContent()) {
+		t.Errorf("unexpected content size: %d", result.TotalContentSize())
+	}
+	if len(result.StatusUpdates) != 1 || result.StatusUpdates[0].Updates["status"] != publish.StatusOnline {
+		t.Errorf("unexpected status updates: %#+v", result.StatusUpdates)
+	}
+
+	// the builder used for dry run is not affected
+	if err := p.Publish(&product1); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertNoVersionUploadFile(&product1, storage); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSchedulerLeaderElection(t *testing.T) {
+	db := ConnectDB()
+	db.Migrator().DropTable(&publish.SchedulerLease{})
+
+	var runs1, runs2 int64
+	s1 := publish.NewScheduler(db)
+	s1.Job("test-leader").Interval(50 * time.Millisecond).Func(func(ctx context.Context) error {
+		atomic.AddInt64(&runs1, 1)
+		return nil
+	})
+	s2 := publish.NewScheduler(db)
+	s2.Job("test-leader").Interval(50 * time.Millisecond).Func(func(ctx context.Context) error {
+		atomic.AddInt64(&runs2, 1)
+		return nil
+	})
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	done1 := make(chan struct{})
+	go func() {
+		s1.Run(ctx1)
+		close(done1)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go s2.Run(ctx2)
+	time.Sleep(300 * time.Millisecond)
+
+	if atomic.LoadInt64(&runs1) == 0 || atomic.LoadInt64(&runs2) != 0 {
+		t.Fatalf("only the leader should run, got runs1: %d, runs2: %d", runs1, runs2)
+	}
+
+	status, err := s2.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status[0].IsLeader || status[0].Runs != atomic.LoadInt64(&runs1) {
+		t.Errorf("unexpected status %#+v", status[0])
+	}
+
+	// stopping the leader releases the lease to the other instance
+	cancel1()
+	<-done1
+	time.Sleep(200 * time.Millisecond)
+	if atomic.LoadInt64(&runs2) == 0 {
+		t.Errorf("expected the other instance to take over after the leader stopped")
+	}
+}
+
+type ReleaseBrokenPage struct {
+	gorm.Model
+	publish.Status
+}
+
+func (p *ReleaseBrokenPage) GetPublishActions(db *gorm.DB, ctx context.Context, storage oss.StorageInterface) (objs []*publish.PublishAction, err error) {
+	return nil, errors.New("broken page")
+}
+
+// flakyStorage fails to get the file of the path with a transient error
+type flakyStorage struct {
+	*MockStorage
+	failedPath string
+}
+
+func (s *flakyStorage) Get(path string) (*os.File, error) {
+	if path == s.failedPath {
+		return nil, errors.New("connection reset by peer")
+	}
+	return s.MockStorage.Get(path)
+}
+
+func TestPublishRelease(t *testing.T) {
+	db := ConnectDB()
+	db.AutoMigrate(&ProductWithoutVersion{}, &ReleaseBrokenPage{})
+	if err := publish.AutoMigrateReleases(db); err != nil {
+		t.Fatal(err)
+	}
+	storage := &MockStorage{}
+	p := publish.New(db, storage)
+	p.RegisterDependentModels(&ProductWithoutVersion{}, &ReleaseBrokenPage{})
+
+	product1 := ProductWithoutVersion{Model: gorm.Model{ID: 21}, Code: "0021", Name: "campaign 1", Status: publish.Status{Status: publish.StatusDraft}}
+	product2 := ProductWithoutVersion{Model: gorm.Model{ID: 22}, Code: "0022", Name: "campaign 2", Status: publish.Status{Status: publish.StatusDraft}}
+	broken := ReleaseBrokenPage{Model: gorm.Model{ID: 23}, Status: publish.Status{Status: publish.StatusDraft}}
+	db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&product1)
+	db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&product2)
+	db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&broken)
+	storage.Put(product2.getUrl(), strings.NewReader("old content"))
+
+	// all or nothing
+	failing := &publish.Release{Name: "failing"}
+	db.Create(failing)
+	if err := p.AddReleaseItems(failing, &product1, &product2, &broken); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PublishRelease(failing); err == nil {
+		t.Fatal("expected error of broken page")
+	}
+	if _, exist := storage.Objects[product1.getUrl()]; exist {
+		t.Errorf("uploaded file should be removed on failure")
+	}
+	if storage.Objects[product2.getUrl()] != "old content" {
+		t.Errorf("overwritten file should be restored on failure, got %s", storage.Objects[product2.getUrl()])
+	}
+	if err := assertNoVersionUpdateStatus(db, &product1, publish.StatusDraft, ""); err != nil {
+		t.Error(err)
+	}
+	db.First(failing, failing.ID)
+	if failing.LastError == "" {
+		t.Errorf("expected last error of release")
+	}
+
+	// a transient storage error aborts the release instead of deleting the live file on rollback
+	flaky := publish.New(db, &flakyStorage{MockStorage: storage, failedPath: product2.getUrl()})
+	flaky.RegisterDependentModels(&ProductWithoutVersion{}, &ReleaseBrokenPage{})
+	if err := flaky.PublishRelease(failing); err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Fatalf("expected the storage error, got %v", err)
+	}
+	if storage.Objects[product2.getUrl()] != "old content" {
+		t.Errorf("live file should be kept on storage errors, got %s", storage.Objects[product2.getUrl()])
+	}
+
+	// a failing scheduled release is not retried forever
+	failingStart := db.NowFunc().Add(-time.Minute)
+	db.Model(failing).Updates(map[string]interface{}{"scheduled_start_at": &failingStart, "failed_attempts": 0})
+	scheduler := publish.NewSchedulePublishBuilder(p).ReleaseMaxAttempts(2)
+	for i := 0; i < 3; i++ {
+		err := scheduler.RunReleases(context.Background())
+		if i < 2 && err == nil {
+			t.Errorf("expected error of the failing release on run %d", i)
+		}
+		if i == 2 && err != nil {
+			t.Errorf("expected the failing release skipped after the max attempts, got %v", err)
+		}
+	}
+	db.First(failing, failing.ID)
+	if failing.FailedAttempts != 2 {
+		t.Errorf("expected 2 failed attempts, got %d", failing.FailedAttempts)
+	}
+	if err := p.RetryRelease(failing); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.RunReleases(context.Background()); err == nil {
+		t.Errorf("expected the failing release run again after retry")
+	}
+	db.Model(failing).Update("scheduled_start_at", nil)
+
+	// scheduled as a unit
+	start := db.NowFunc().Add(-time.Minute)
+	release := &publish.Release{Name: "campaign", Schedule: publish.Schedule{ScheduledStartAt: &start}}
+	db.Create(release)
+	if err := p.AddReleaseItems(release, &product1, &product2); err != nil {
+		t.Fatal(err)
+	}
+	if err := publish.NewSchedulePublishBuilder(p).RunReleases(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if storage.Objects[product1.getUrl()] != product1.getContent() || storage.Objects[product2.getUrl()] != product2.getContent() {
+		t.Errorf("release records should be published, got %v", storage.Objects)
+	}
+	if err := assertNoVersionUpdateStatus(db, &product2, publish.StatusOnline, product2.getUrl()); err != nil {
+		t.Error(err)
+	}
+	db.First(release, release.ID)
+	if release.Status.Status != publish.StatusOnline || release.ScheduledStartAt != nil {
+		t.Errorf("unexpected release status %s", release.Status.Status)
+	}
+
+	// unpublished together
+	if err := p.UnPublishRelease(release); err != nil {
+		t.Fatal(err)
+	}
+	if _, exist := storage.Objects[product1.getUrl()]; exist {
+		t.Errorf("release records should be unpublished, got %v", storage.Objects)
+	}
+	if err := assertNoVersionUpdateStatus(db, &product1, publish.StatusOffline, product1.getUrl()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSchedulerRunValidatesJobs(t *testing.T) {
+	db := ConnectDB()
+	var runs int64
+	s := publish.NewScheduler(db)
+	s.Job("test-valid").Interval(10 * time.Millisecond).Func(func(ctx context.Context) error {
+		atomic.AddInt64(&runs, 1)
+		return nil
+	})
+	s.Job("test-nil-func")
+
+	if err := s.Run(context.Background()); err == nil {
+		t.Fatal("want the error of the job without func")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt64(&runs) != 0 {
+		t.Errorf("want no job started, but got %d runs", runs)
+	}
+}
+
+func TestBulkPublishJob(t *testing.T) {
+	db := ConnectDB()
+	db.AutoMigrate(&ProductWithoutVersion{})
+	storage := &MockStorage{}
+	p := publish.New(db, storage)
+
+	draft := ProductWithoutVersion{Model: gorm.Model{ID: 31}, Code: "0031", Name: "draft", Status: publish.Status{Status: publish.StatusDraft}}
+	online := ProductWithoutVersion{Model: gorm.Model{ID: 32}, Code: "0032", Name: "online", Status: publish.Status{Status: publish.StatusOnline}}
+	db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&draft)
+	db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&online)
+	db.Unscoped().Delete(&ProductWithoutVersion{}, 33)
+
+	var (
+		logs     []string
+		progress string
+	)
+	newJob := func(args *views.BulkPublishArgs) *mock.QorJobInterfaceMock {
+		logs, progress = nil, ""
+		return &mock.QorJobInterfaceMock{
+			GetJobInfoFunc: func() (*worker.JobInfo, error) {
+				return &worker.JobInfo{Operator: "operator", Argument: args}, nil
+			},
+			AddLogFunc: func(s string) error {
+				logs = append(logs, s)
+				return nil
+			},
+			AddLogfFunc: func(format string, a ...interface{}) error {
+				logs = append(logs, fmt.Sprintf(format, a...))
+				return nil
+			},
+			SetProgressFunc: func(v uint) error { return nil },
+			SetProgressTextFunc: func(s string) error {
+				progress = s
+				return nil
+			},
+		}
+	}
+	handler := views.BulkPublishJobHandler(db, presets.New().Model(&ProductWithoutVersion{}), p, nil)
+
+	// the missing record is skipped and reported instead of failing the others
+	if err := handler(context.Background(), newJob(&views.BulkPublishArgs{Action: views.BulkActionPublish, IDs: []string{"31", "32", "33"}})); err != nil {
+		t.Fatal(err)
+	}
+	if progress != "1 succeeded, 2 skipped, 0 failed" {
+		t.Errorf("want the result of every record, but got %s %v", progress, logs)
+	}
+	if len(logs) != 3 || logs[2] != "33: skipped, not found" {
+		t.Errorf("want the missing record reported, but got %v", logs)
+	}
+	if err := assertNoVersionUpdateStatus(db, &draft, publish.StatusOnline, draft.getUrl()); err != nil {
+		t.Error(err)
+	}
+
+	// an aborted job is not done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := handler(ctx, newJob(&views.BulkPublishArgs{Action: views.BulkActionPublish, IDs: []string{"31"}})); err != context.Canceled {
+		t.Errorf("want the aborted job failed with the context error, but got %v", err)
+	}
+
+	// with the start and the end, the online record is scheduled to end and the offline one to start and end
+	db.AutoMigrate(&Product{})
+	onlineProduct := Product{Model: gorm.Model{ID: 41}, Code: "0041", Version: publish.Version{Version: "v1"}, Status: publish.Status{Status: publish.StatusOnline}}
+	draftProduct := Product{Model: gorm.Model{ID: 42}, Code: "0042", Version: publish.Version{Version: "v1"}, Status: publish.Status{Status: publish.StatusDraft}}
+	db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&onlineProduct)
+	db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&draftProduct)
+	start, end := time.Now().Add(time.Hour).Truncate(time.Second), time.Now().Add(2*time.Hour).Truncate(time.Second)
+	handler = views.BulkPublishJobHandler(db, presets.New().Model(&Product{}), p, nil)
+	if err := handler(context.Background(), newJob(&views.BulkPublishArgs{Action: views.BulkActionSchedule, IDs: []string{"41", "42"},
+		ScheduledStartAt: &start, ScheduledEndAt: &end})); err != nil {
+		t.Fatal(err)
+	}
+	if progress != "2 succeeded, 0 skipped, 0 failed" {
+		t.Errorf("want both records scheduled, but got %s %v", progress, logs)
+	}
+	db.First(&onlineProduct, "id = ?", 41)
+	db.First(&draftProduct, "id = ?", 42)
+	if onlineProduct.ScheduledStartAt != nil || onlineProduct.ScheduledEndAt == nil || !onlineProduct.ScheduledEndAt.Equal(end) {
+		t.Errorf("want the online record scheduled to end, but got %v %v", onlineProduct.ScheduledStartAt, onlineProduct.ScheduledEndAt)
+	}
+	if draftProduct.ScheduledStartAt == nil || !draftProduct.ScheduledStartAt.Equal(start) || draftProduct.ScheduledEndAt == nil {
+		t.Errorf("want the offline record scheduled to start and end, but got %v %v", draftProduct.ScheduledStartAt, draftProduct.ScheduledEndAt)
+	}
+}
+
+func TestPublisherSchedulerMigratesReleases(t *testing.T) {
+	db := ConnectDB()
+	db.Migrator().DropTable(&publish.ReleaseItem{}, &publish.Release{})
+
+	s := publish.NewPublisherScheduler(db, &MockStorage{}, publish.New(db, &MockStorage{}))
+	if !db.Migrator().HasTable(&publish.Release{}) || !db.Migrator().HasTable(&publish.ReleaseItem{}) {
+		t.Fatal("want the release tables migrated")
+	}
+	status, err := s.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registered bool
+	for _, st := range status {
+		registered = registered || st.Name == publish.ReleasePublishJobName
+	}
+	if !registered {
+		t.Errorf("want the release publisher job registered, but got %#+v", status)
+	}
+}
@@ -0,0 +1,311 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/qor/oss"
+	"github.com/qor5/admin/utils"
+	"gorm.io/gorm"
+)
+
+// Release groups specific versions of heterogeneous records, e.g. pages, products and seo settings of a campaign,
+// which are published and unpublished together as a unit.
+// The record models must be registered with RegisterDependentModels so that the items can be loaded.
+type Release struct {
+	gorm.Model
+	Name string
+
+	Status
+	Schedule
+
+	// LastError is the error of the last failed publish or unpublish, the release stays scheduled
+	// and is retried until the failed attempts reach the max release attempts of the schedule publish builder
+	LastError string `gorm:"type:text"`
+	// FailedAttempts counts the failed publishes and unpublishes since the last successful one
+	FailedAttempts int `gorm:"default:0;not null"`
+
+	Items []*ReleaseItem
+}
+
+// ReleaseItem refers to a record of a release by its table and primary keys, including the version if it has one
+type ReleaseItem struct {
+	gorm.Model
+	ReleaseID   uint `gorm:"index"`
+	RecordTable string
+	RecordKeys  string
+}
+
+func (item *ReleaseItem) Ref() (ref DependentRef, err error) {
+	ref.Table = item.RecordTable
+	err = json.Unmarshal([]byte(item.RecordKeys), &ref.Keys)
+	return
+}
+
+// AutoMigrateReleases creates or updates the tables of the releases and their items,
+// NewPublisherScheduler calls it so that the release publisher job always has its tables
+func AutoMigrateReleases(db *gorm.DB) error {
+	return db.AutoMigrate(&Release{}, &ReleaseItem{})
+}
+
+// AddReleaseItems adds the records to the release, the records must be saved already
+func (b *Builder) AddReleaseItems(release *Release, records ...interface{}) (err error) {
+	for _, record := range records {
+		var ref DependentRef
+		if ref, err = b.dependentRef(record); err != nil {
+			return
+		}
+		var keys []byte
+		if keys, err = json.Marshal(ref.Keys); err != nil {
+			return
+		}
+		item := &ReleaseItem{ReleaseID: release.ID, RecordTable: ref.Table, RecordKeys: string(keys)}
+		if err = b.db.Create(item).Error; err != nil {
+			return
+		}
+		release.Items = append(release.Items, item)
+	}
+	return
+}
+
+func (b *Builder) loadReleaseRecords(release *Release) (records []interface{}, err error) {
+	var items []*ReleaseItem
+	if err = b.db.Where("release_id = ?", release.ID).Order("id").Find(&items).Error; err != nil {
+		return
+	}
+	for _, item := range items {
+		var ref DependentRef
+		if ref, err = item.Ref(); err != nil {
+			return
+		}
+		var record interface{}
+		if record, err = b.loadDependent(ref); err != nil {
+			return nil, fmt.Errorf("load release item %s: %w", ref, err)
+		}
+		records = append(records, record)
+	}
+	return
+}
+
+// PublishRelease publishes all the records of the release inside one transaction,
+// if any of them fails the db changes are rolled back and the files written to storage are restored
+func (b *Builder) PublishRelease(release *Release) error {
+	return b.runRelease(release, func(rb *Builder, records []interface{}) (err error) {
+		for _, record := range records {
+			if err = rb.publishRecord(record); err != nil {
+				return
+			}
+		}
+		now := rb.db.NowFunc()
+		return rb.updateStatus(rb.db.Model(release), release, map[string]interface{}{
+			"status":             StatusOnline,
+			"scheduled_start_at": nil,
+			"actual_start_at":    &now,
+			"last_error":         "",
+			"failed_attempts":    0,
+		}, false)
+	})
+}
+
+// UnPublishRelease unpublishes the online records of the release together
+func (b *Builder) UnPublishRelease(release *Release) error {
+	return b.runRelease(release, func(rb *Builder, records []interface{}) (err error) {
+		for _, record := range records {
+			if s, ok := record.(StatusInterface); ok && s.GetStatus() != StatusOnline {
+				continue
+			}
+			if err = rb.unPublishRecord(record); err != nil {
+				return
+			}
+		}
+		now := rb.db.NowFunc()
+		return rb.updateStatus(rb.db.Model(release), release, map[string]interface{}{
+			"status":           StatusOffline,
+			"scheduled_end_at": nil,
+			"actual_end_at":    &now,
+			"last_error":       "",
+			"failed_attempts":  0,
+		}, false)
+	})
+}
+
+func (b *Builder) runRelease(release *Release, f func(rb *Builder, records []interface{}) error) (err error) {
+	records, err := b.loadReleaseRecords(release)
+	if err != nil {
+		return
+	}
+
+	journal := &storageJournal{StorageInterface: b.storage, isNotFound: b.isStorageNotFound}
+	err = utils.Transact(b.db, func(tx *gorm.DB) error {
+		rb := *b
+		rb.db = tx
+		rb.storage = journal
+		return f(&rb, records)
+	})
+	if err != nil {
+		if err2 := journal.rollback(); err2 != nil {
+			err = multierror.Append(err, fmt.Errorf("rollback storage: %w", err2))
+		}
+		if b.dryRun == nil {
+			if err2 := b.db.Model(release).Updates(map[string]interface{}{
+				"last_error":      err.Error(),
+				"failed_attempts": gorm.Expr("failed_attempts + 1"),
+			}).Error; err2 != nil {
+				log.Printf("update release %d error: %s\n", release.ID, err2)
+			}
+		}
+		return
+	}
+
+	// the release is committed, the errors of the dependents go to the dependents error handler
+	for _, record := range records {
+		b.afterCommitDependents(record)
+	}
+	return
+}
+
+// DefaultReleaseMaxAttempts is the number of the failed attempts after which a scheduled release is not retried
+const DefaultReleaseMaxAttempts = 3
+
+// ReleaseMaxAttempts sets the number of the failed attempts after which a scheduled release is not retried,
+// it can be retried again with RetryRelease
+func (b *SchedulePublishBuilder) ReleaseMaxAttempts(n int) *SchedulePublishBuilder {
+	b.maxReleaseAttempts = n
+	return b
+}
+
+func (b *SchedulePublishBuilder) releaseMaxAttempts() int {
+	if b.maxReleaseAttempts <= 0 {
+		return DefaultReleaseMaxAttempts
+	}
+	return b.maxReleaseAttempts
+}
+
+// RetryRelease resets the failed attempts of the release, so that it is run again at the scheduled time
+func (b *Builder) RetryRelease(release *Release) error {
+	release.FailedAttempts = 0
+	return b.db.Model(release).Update("failed_attempts", 0).Error
+}
+
+// RunReleases publishes and unpublishes the releases which reach their scheduled time,
+// the releases failed for the max attempts are skipped
+func (b *SchedulePublishBuilder) RunReleases(ctx context.Context) (err error) {
+	db := b.publisher.db
+	flagTime := db.NowFunc()
+
+	var releases []*Release
+	if err = db.Where("scheduled_end_at <= ? AND failed_attempts < ?", flagTime, b.releaseMaxAttempts()).Order("scheduled_end_at").Find(&releases).Error; err != nil {
+		return
+	}
+	for _, release := range releases {
+		if ctx.Err() != nil {
+			return multierror.Append(err, ctx.Err()).ErrorOrNil()
+		}
+		// publish first when the release is scheduled to start before it ends
+		if release.ScheduledStartAt != nil && release.ScheduledStartAt.Before(*release.ScheduledEndAt) {
+			continue
+		}
+		if err2 := b.publisher.UnPublishRelease(release); err2 != nil {
+			log.Printf("error: %s\n", err2)
+			err = multierror.Append(err, err2).ErrorOrNil()
+		}
+	}
+
+	releases = nil
+	if err = db.Where("scheduled_start_at <= ? AND failed_attempts < ?", flagTime, b.releaseMaxAttempts()).Order("scheduled_start_at").Find(&releases).Error; err != nil {
+		return
+	}
+	for _, release := range releases {
+		if ctx.Err() != nil {
+			return multierror.Append(err, ctx.Err()).ErrorOrNil()
+		}
+		if err2 := b.publisher.PublishRelease(release); err2 != nil {
+			log.Printf("error: %s\n", err2)
+			err = multierror.Append(err, err2).ErrorOrNil()
+			continue
+		}
+		if release.ScheduledEndAt != nil && !release.ScheduledEndAt.After(flagTime) {
+			if err2 := b.publisher.UnPublishRelease(release); err2 != nil {
+				log.Printf("error: %s\n", err2)
+				err = multierror.Append(err, err2).ErrorOrNil()
+			}
+		}
+	}
+	return
+}
+
+// storageJournal keeps the previous content of the files written or deleted through it,
+// so that they can be restored when the release transaction fails
+type storageJournal struct {
+	oss.StorageInterface
+	isNotFound func(err error) bool
+	entries    []*journalEntry
+}
+
+type journalEntry struct {
+	path    string
+	existed bool
+	content []byte
+}
+
+func (s *storageJournal) Put(path string, reader io.Reader) (*oss.Object, error) {
+	if err := s.snapshot(path); err != nil {
+		return nil, err
+	}
+	return s.StorageInterface.Put(path, reader)
+}
+
+func (s *storageJournal) Delete(path string) error {
+	if err := s.snapshot(path); err != nil {
+		return err
+	}
+	return s.StorageInterface.Delete(path)
+}
+
+// snapshot keeps the content of the file before it is changed, a file is recorded as not existing only if storage says so,
+// the other errors abort the release, otherwise the rollback would delete a live file
+func (s *storageJournal) snapshot(path string) (err error) {
+	for _, e := range s.entries {
+		if e.path == path {
+			return
+		}
+	}
+	e := &journalEntry{path: path}
+	f, err := s.StorageInterface.Get(path)
+	if err != nil {
+		if !s.isNotFound(err) {
+			return fmt.Errorf("snapshot %s: %w", path, err)
+		}
+		err = nil
+	} else {
+		e.content, err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("snapshot %s: %w", path, err)
+		}
+		e.existed = true
+	}
+	s.entries = append(s.entries, e)
+	return
+}
+
+func (s *storageJournal) rollback() (err error) {
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		e := s.entries[i]
+		var err2 error
+		if e.existed {
+			_, err2 = s.StorageInterface.Put(e.path, bytes.NewReader(e.content))
+		} else {
+			err2 = s.StorageInterface.Delete(e.path)
+		}
+		if err2 != nil {
+			err = multierror.Append(err, err2).ErrorOrNil()
+		}
+	}
+	return
+}
@@ -10,8 +10,9 @@ import (
 )
 
 type SchedulePublishBuilder struct {
-	publisher *Builder
-	context   context.Context
+	publisher          *Builder
+	context            context.Context
+	maxReleaseAttempts int
 }
 
 func NewSchedulePublishBuilder(publisher *Builder) *SchedulePublishBuilder {
@@ -33,6 +34,11 @@ type SchedulePublisher interface {
 // model is a empty struct
 // example: Product{}
 func (b *SchedulePublishBuilder) Run(model interface{}) (err error) {
+	return b.RunWithContext(context.Background(), model)
+}
+
+// RunWithContext stops publishing the remaining records once ctx is done
+func (b *SchedulePublishBuilder) RunWithContext(ctx context.Context, model interface{}) (err error) {
 	var scope *gorm.DB
 	if m, ok := model.(SchedulePublisher); ok {
 		scope = m.SchedulePublisherDBScope(b.publisher.db)
@@ -54,6 +60,9 @@ func (b *SchedulePublishBuilder) Run(model interface{}) (err error) {
 		}
 		needUnpublishReflectValues := reflect.ValueOf(tempRecords)
 		for i := 0; i < needUnpublishReflectValues.Len(); i++ {
+			if ctx.Err() != nil {
+				return multierror.Append(err, ctx.Err()).ErrorOrNil()
+			}
 			{
 				record := needUnpublishReflectValues.Index(i).Interface().(ScheduleInterface)
 				if record.GetScheduledStartAt() != nil && record.GetScheduledStartAt().Sub(*record.GetScheduledEndAt()) < 0 {
@@ -78,6 +87,9 @@ func (b *SchedulePublishBuilder) Run(model interface{}) (err error) {
 		}
 		needPublishReflectValues := reflect.ValueOf(tempRecords)
 		for i := 0; i < needPublishReflectValues.Len(); i++ {
+			if ctx.Err() != nil {
+				return multierror.Append(err, ctx.Err()).ErrorOrNil()
+			}
 			if record, ok := needPublishReflectValues.Index(i).Interface().(PublishInterface); ok {
 				if err2 := b.publisher.Publish(record); err2 != nil {
 					log.Printf("error: %s\n", err2)
@@ -89,6 +101,9 @@ func (b *SchedulePublishBuilder) Run(model interface{}) (err error) {
 
 	{
 		for _, interfaceRecord := range unpublishAfterPublishRecords {
+			if ctx.Err() != nil {
+				return multierror.Append(err, ctx.Err()).ErrorOrNil()
+			}
 			if record, ok := interfaceRecord.(UnPublishInterface); ok {
 				if err2 := b.publisher.UnPublish(record); err2 != nil {
 					log.Printf("error: %s\n", err2)
@@ -0,0 +1,319 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SchedulerLease is the db row used for leader election of a scheduler job,
+// only the holder of an unexpired lease runs the job, so that running
+// multiple publisher instances does not publish twice.
+type SchedulerLease struct {
+	Name      string `gorm:"primaryKey;size:255"`
+	Holder    string `gorm:"size:255"`
+	ExpiresAt time.Time
+
+	LastStartedAt  *time.Time
+	LastFinishedAt *time.Time
+	LastDurationMs int64
+	LastError      string
+	Runs           int64
+	Failures       int64
+}
+
+type SchedulerJob struct {
+	name     string
+	interval time.Duration
+	timeout  time.Duration
+	f        func(ctx context.Context) error
+}
+
+func (j *SchedulerJob) Interval(v time.Duration) *SchedulerJob {
+	j.interval = v
+	return j
+}
+
+// Timeout cancels the context passed to the job func, default is 5 minutes
+func (j *SchedulerJob) Timeout(v time.Duration) *SchedulerJob {
+	j.timeout = v
+	return j
+}
+
+func (j *SchedulerJob) Func(f func(ctx context.Context) error) *SchedulerJob {
+	j.f = f
+	return j
+}
+
+// SchedulerJobStatus is the last run of a job reported by the health handler
+type SchedulerJobStatus struct {
+	Name           string     `json:"name"`
+	Interval       string     `json:"interval"`
+	IsLeader       bool       `json:"is_leader"`
+	Holder         string     `json:"holder"`
+	LastStartedAt  *time.Time `json:"last_started_at"`
+	LastFinishedAt *time.Time `json:"last_finished_at"`
+	LastDurationMs int64      `json:"last_duration_ms"`
+	LastError      string     `json:"last_error"`
+	Runs           int64      `json:"runs"`
+	Failures       int64      `json:"failures"`
+}
+
+type Scheduler struct {
+	db     *gorm.DB
+	holder string
+	jobs   []*SchedulerJob
+
+	mutex   sync.RWMutex
+	leading map[string]bool
+}
+
+func NewScheduler(db *gorm.DB) *Scheduler {
+	if err := db.AutoMigrate(&SchedulerLease{}); err != nil {
+		panic(err)
+	}
+	hostname, _ := os.Hostname()
+	return &Scheduler{
+		db:      db,
+		holder:  fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8]),
+		leading: make(map[string]bool),
+	}
+}
+
+// Job gets or creates a job by name
+func (s *Scheduler) Job(name string) *SchedulerJob {
+	for _, j := range s.jobs {
+		if j.name == name {
+			return j
+		}
+	}
+	j := &SchedulerJob{
+		name:     name,
+		interval: time.Minute,
+		timeout:  time.Minute * 5,
+	}
+	s.jobs = append(s.jobs, j)
+	return j
+}
+
+// Run runs the jobs until ctx is done, the leases held are released before returning
+func (s *Scheduler) Run(ctx context.Context) error {
+	// validate all the jobs before starting any, so that no loop is left running on error
+	for _, j := range s.jobs {
+		if j.f == nil {
+			return fmt.Errorf("scheduler job %s func is nil", j.name)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		j := j
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *SchedulerJob) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	defer s.release(j)
+
+	for {
+		if err := s.tick(ctx, j); err != nil {
+			log.Printf("scheduler job_name: %s, error: %v\n", j.name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context, j *SchedulerJob) (err error) {
+	lease, acquired, err := s.acquire(j)
+	s.setLeading(j.name, acquired)
+	if err != nil || !acquired {
+		return
+	}
+	// tolerate ticker jitter so that the job is not delayed by a whole interval
+	if lease.LastStartedAt != nil && s.db.NowFunc().Sub(*lease.LastStartedAt) < j.interval-j.interval/10 {
+		return
+	}
+	return s.run(ctx, j)
+}
+
+func (s *Scheduler) run(ctx context.Context, j *SchedulerJob) (err error) {
+	start := s.db.NowFunc()
+	if err = s.db.Model(&SchedulerLease{}).Where("name = ? AND holder = ?", j.name, s.holder).
+		Update("last_started_at", start).Error; err != nil {
+		return
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, j.timeout)
+	defer cancel()
+
+	// keep the lease while running, cancel the job if the lease is lost
+	renewDone := make(chan struct{})
+	defer close(renewDone)
+	go func() {
+		ticker := time.NewTicker(s.leaseDuration(j) / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-renewDone:
+				return
+			case <-ticker.C:
+				if _, acquired, err := s.acquire(j); err != nil || !acquired {
+					log.Printf("scheduler job_name: %s, lease lost, cancelling\n", j.name)
+					s.setLeading(j.name, false)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	runErr := j.f(runCtx)
+	if runErr == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		runErr = runCtx.Err()
+	}
+
+	stop := s.db.NowFunc()
+	log.Printf("job_name: %s, started_at: %s, stopped_at: %s, time_spent_ms: %d\n", j.name, start, stop, int64(stop.Sub(start)/time.Millisecond))
+
+	updates := map[string]interface{}{
+		"last_finished_at": stop,
+		"last_duration_ms": int64(stop.Sub(start) / time.Millisecond),
+		"last_error":       "",
+		"runs":             gorm.Expr("runs + 1"),
+	}
+	if runErr != nil {
+		updates["last_error"] = runErr.Error()
+		updates["failures"] = gorm.Expr("failures + 1")
+	}
+	if err = s.db.Model(&SchedulerLease{}).Where("name = ?", j.name).Updates(updates).Error; err != nil {
+		return
+	}
+	return runErr
+}
+
+func (s *Scheduler) leaseDuration(j *SchedulerJob) time.Duration {
+	return j.interval * 2
+}
+
+// acquire takes or extends the lease of the job, it works on both postgres and sqlite
+func (s *Scheduler) acquire(j *SchedulerJob) (lease *SchedulerLease, acquired bool, err error) {
+	if err = s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&SchedulerLease{Name: j.name}).Error; err != nil {
+		return
+	}
+
+	now := s.db.NowFunc()
+	result := s.db.Model(&SchedulerLease{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", j.name, s.holder, now).
+		Updates(map[string]interface{}{
+			"holder":     s.holder,
+			"expires_at": now.Add(s.leaseDuration(j)),
+		})
+	if err = result.Error; err != nil {
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	lease = &SchedulerLease{}
+	if err = s.db.Where("name = ?", j.name).First(lease).Error; err != nil {
+		return
+	}
+	return lease, lease.Holder == s.holder, nil
+}
+
+func (s *Scheduler) release(j *SchedulerJob) {
+	s.setLeading(j.name, false)
+	err := s.db.Model(&SchedulerLease{}).
+		Where("name = ? AND holder = ?", j.name, s.holder).
+		Update("expires_at", time.Time{}).Error
+	if err != nil {
+		log.Printf("scheduler job_name: %s, release lease error: %v\n", j.name, err)
+	}
+}
+
+func (s *Scheduler) setLeading(name string, v bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.leading[name] = v
+}
+
+// Status returns the last run of every job, shared by all scheduler instances through the db
+func (s *Scheduler) Status() (r []*SchedulerJobStatus, err error) {
+	var leases []*SchedulerLease
+	names := make([]string, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		names = append(names, j.name)
+	}
+	if err = s.db.Where("name IN ?", names).Find(&leases).Error; err != nil {
+		return
+	}
+	leaseMap := make(map[string]*SchedulerLease)
+	for _, l := range leases {
+		leaseMap[l.Name] = l
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, j := range s.jobs {
+		st := &SchedulerJobStatus{
+			Name:     j.name,
+			Interval: j.interval.String(),
+			IsLeader: s.leading[j.name],
+		}
+		if l, ok := leaseMap[j.name]; ok {
+			st.Holder = l.Holder
+			st.LastStartedAt = l.LastStartedAt
+			st.LastFinishedAt = l.LastFinishedAt
+			st.LastDurationMs = l.LastDurationMs
+			st.LastError = l.LastError
+			st.Runs = l.Runs
+			st.Failures = l.Failures
+		}
+		r = append(r, st)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	return
+}
+
+// HealthHandler responds the Status of jobs as json
+func (s *Scheduler) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := s.Status()
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"holder": s.holder,
+			"jobs":   status,
+		})
+	})
+}
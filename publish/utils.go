@@ -1,6 +1,7 @@
 package publish
 
 import (
+	"context"
 	"log"
 	"os"
 	"time"
@@ -12,47 +13,72 @@ import (
 const (
 	schedulePublishJobNamePrefix = "schedule-publisher"
 	listPublishJobNamePrefix     = "list-publisher"
+
+	ReleasePublishJobName = "release-publisher"
 )
 
-func RunPublisher(db *gorm.DB, storage oss.StorageInterface, publisher *Builder) {
+// SchedulePublishJobName is the scheduler job name of the schedule publisher of a model
+// example: NewPublisherScheduler(db, storage, publisher).Job(SchedulePublishJobName("products")).Interval(time.Second * 30)
+func SchedulePublishJobName(modelName string) string {
+	return schedulePublishJobNamePrefix + "-" + modelName
+}
+
+// ListPublishJobName is the scheduler job name of the list publisher of a model
+func ListPublishJobName(modelName string) string {
+	return listPublishJobNamePrefix + "-" + modelName
+}
+
+// NewPublisherScheduler registers a schedule publisher job for every schedule publish model,
+// a list publisher job for every list publish model and the release publisher job after migrating the releases,
+// call Run on the result to start them
+func NewPublisherScheduler(db *gorm.DB, storage oss.StorageInterface, publisher *Builder) *Scheduler {
+	s := NewScheduler(db)
+	if err := AutoMigrateReleases(db); err != nil {
+		panic(err)
+	}
+
 	{ // schedule publisher
 		scheduleP := NewSchedulePublishBuilder(publisher)
 
-		for name, model := range NonVersionPublishModels {
-			name := name
-			model := model
-			go RunJob(schedulePublishJobNamePrefix+"-"+name, time.Minute, time.Minute*5, func() {
-				if err := scheduleP.Run(model); err != nil {
-					log.Printf("schedule publisher error: %v\n", err)
-				}
-			})
+		for _, models := range []map[string]interface{}{NonVersionPublishModels, VersionPublishModels} {
+			for name, model := range models {
+				model := model
+				s.Job(SchedulePublishJobName(name)).Func(func(ctx context.Context) error {
+					return scheduleP.RunWithContext(ctx, model)
+				})
+			}
 		}
+	}
 
-		for name, model := range VersionPublishModels {
-			name := name
-			model := model
-			go RunJob(schedulePublishJobNamePrefix+"-"+name, time.Minute, time.Minute*5, func() {
-				if err := scheduleP.Run(model); err != nil {
-					log.Printf("schedule publisher error: %v\n", err)
-				}
-			})
-		}
+	{ // release publisher
+		scheduleP := NewSchedulePublishBuilder(publisher)
+		s.Job(ReleasePublishJobName).Func(scheduleP.RunReleases)
 	}
 
 	{ // list publisher
 		listP := NewListPublishBuilder(db, storage)
 		for name, model := range ListPublishModels {
-			name := name
 			model := model
-			go RunJob(listPublishJobNamePrefix+"-"+name, time.Minute, time.Minute*5, func() {
-				if err := listP.Run(model); err != nil {
-					log.Printf("schedule publisher error: %v\n", err)
-				}
+			s.Job(ListPublishJobName(name)).Func(func(ctx context.Context) error {
+				return listP.RunWithContext(ctx, model)
 			})
 		}
 	}
+	return s
+}
+
+// RunPublisher runs the publisher scheduler in background with default intervals
+// use NewPublisherScheduler to configure the intervals and stop it by context
+func RunPublisher(db *gorm.DB, storage oss.StorageInterface, publisher *Builder) {
+	s := NewPublisherScheduler(db, storage, publisher)
+	go func() {
+		if err := s.Run(context.Background()); err != nil {
+			log.Printf("publisher scheduler error: %v\n", err)
+		}
+	}()
 }
 
+// Deprecated: RunJob exits the process on timeout and is not coordinated between instances, use Scheduler instead
 func RunJob(jobName string, interval time.Duration, timeout time.Duration, f func()) {
 	second := 1
 	ticker := time.NewTicker(interval)
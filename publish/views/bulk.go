@@ -0,0 +1,322 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/activity"
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/admin/publish"
+	"github.com/qor5/admin/utils"
+	"github.com/qor5/admin/worker"
+	. "github.com/qor5/ui/vuetify"
+	vx "github.com/qor5/ui/vuetifyx"
+	"github.com/qor5/web"
+	"github.com/qor5/x/i18n"
+	h "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+)
+
+const (
+	BulkActionPublish   = "publish"
+	BulkActionUnpublish = "unpublish"
+	BulkActionSchedule  = "schedule"
+
+	ActivitySchedule = "Schedule"
+
+	bulkPublishDialogEvent = "publish_BulkPublishDialogEvent"
+	bulkPublishEvent       = "publish_BulkPublishEvent"
+)
+
+type BulkPublishArgs struct {
+	Action           string
+	IDs              []string
+	ScheduledStartAt *time.Time
+	ScheduledEndAt   *time.Time
+}
+
+// ConfigureBulkActions adds bulk publish, unpublish and schedule actions to the listing of models,
+// the selected records are processed by a worker job which reports the result of every record
+func ConfigureBulkActions(wb *worker.Builder, db *gorm.DB, publisher *publish.Builder, ab *activity.ActivityBuilder, models ...*presets.ModelBuilder) {
+	for _, m := range models {
+		mb := m
+		actionJob := wb.ActionJob("Bulk Publish", mb, BulkPublishJobHandler(db, mb, publisher, ab)).
+			Params(&BulkPublishArgs{}).
+			DisplayLog(true)
+
+		actions := []string{BulkActionPublish, BulkActionUnpublish}
+		if _, ok := mb.NewModel().(publish.ScheduleInterface); ok {
+			actions = append(actions, BulkActionSchedule)
+		}
+		for _, action := range actions {
+			action := action
+			mb.Listing().BulkAction("Bulk " + action).ButtonCompFunc(func(ctx *web.EventContext) h.HTMLComponent {
+				msgr := i18n.MustGetModuleMessages(ctx.R, I18nPublishKey, Messages_en_US).(*Messages)
+				return VBtn(bulkActionLabel(action, msgr)).Color(presets.ColorSecondary).Depressed(true).Dark(true).Class("ml-2").
+					Attr("@click", web.Plaid().EventFunc(bulkPublishDialogEvent).Query("action", action).Go())
+			})
+		}
+
+		mb.RegisterEventFunc(bulkPublishDialogEvent, bulkPublishDialogAction(db, mb))
+		mb.RegisterEventFunc(bulkPublishEvent, bulkPublishAction(wb, actionJob))
+	}
+}
+
+func bulkActionLabel(action string, msgr *Messages) string {
+	switch action {
+	case BulkActionUnpublish:
+		return msgr.BulkUnpublish
+	case BulkActionSchedule:
+		return msgr.BulkSchedule
+	}
+	return msgr.BulkPublish
+}
+
+// canTransit reports if the record in its current status can be processed by the bulk action
+func canTransit(action string, obj interface{}, args *BulkPublishArgs) bool {
+	s, ok := obj.(publish.StatusInterface)
+	if !ok {
+		return false
+	}
+	online := s.GetStatus() == publish.StatusOnline
+	switch action {
+	case BulkActionPublish:
+		return !online
+	case BulkActionUnpublish:
+		return online
+	case BulkActionSchedule:
+		if _, ok := obj.(publish.ScheduleInterface); !ok {
+			return false
+		}
+		if args == nil {
+			return true
+		}
+		// the online records are scheduled to end, the offline ones to start
+		if online {
+			return args.ScheduledEndAt != nil
+		}
+		return args.ScheduledStartAt != nil
+	}
+	return false
+}
+
+func selectedIDs(ctx *web.EventContext) (ids []string) {
+	for _, id := range strings.Split(ctx.R.URL.Query().Get(presets.ParamSelectedIds), ",") {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return
+}
+
+func bulkPublishDialogAction(db *gorm.DB, mb *presets.ModelBuilder) web.EventFunc {
+	return func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		var (
+			msgr   = i18n.MustGetModuleMessages(ctx.R, I18nPublishKey, Messages_en_US).(*Messages)
+			pMsgr  = presets.MustGetMessages(ctx.R)
+			action = ctx.R.FormValue("action")
+			ids    = selectedIDs(ctx)
+		)
+
+		if len(ids) == 0 {
+			presets.ShowMessage(&r, pMsgr.BulkActionNoAvailableRecords, "warning")
+			return
+		}
+
+		objs, missing, err := findBulkRecords(db, mb, ids)
+		if err != nil {
+			return
+		}
+		var skipped []string
+		for i, obj := range objs {
+			// schedule times are not known yet, records are checked again in the job
+			if obj != nil && !canTransit(action, obj, nil) {
+				skipped = append(skipped, ids[i])
+			}
+		}
+		available := len(ids) - len(skipped) - len(missing)
+
+		var scheduleFields h.HTMLComponent
+		if action == BulkActionSchedule {
+			scheduleFields = VRow(
+				VCol(
+					vx.VXDateTimePicker().FieldName("ScheduledStartAt").Label(msgr.ScheduledStartAt).
+						TimePickerProps(vx.TimePickerProps{Format: "24hr", Scrollable: true}).
+						ClearText(msgr.DateTimePickerClearText).OkText(msgr.DateTimePickerOkText),
+				).Cols(6),
+				VCol(
+					vx.VXDateTimePicker().FieldName("ScheduledEndAt").Label(msgr.ScheduledEndAt).
+						TimePickerProps(vx.TimePickerProps{Format: "24hr", Scrollable: true}).
+						ClearText(msgr.DateTimePickerClearText).OkText(msgr.DateTimePickerOkText),
+				).Cols(6),
+			)
+		}
+
+		r.UpdatePortals = append(r.UpdatePortals, &web.PortalUpdate{
+			Name: presets.DialogPortalName,
+			Body: web.Scope(
+				VDialog(
+					VCard(
+						VCardTitle(h.Text(bulkActionLabel(action, msgr))),
+						VCardText(
+							h.Div(h.Text(fmt.Sprintf(msgr.BulkSelectedSummary, len(ids), available))),
+							h.If(len(skipped) > 0,
+								VAlert(h.Text(fmt.Sprintf(msgr.BulkSkippedNotice, strings.Join(skipped, ", ")))).
+									Dense(true).Type("warning").Class("mt-2"),
+							),
+							h.If(len(missing) > 0,
+								VAlert(h.Text(fmt.Sprintf(msgr.BulkMissingNotice, strings.Join(missing, ", ")))).
+									Dense(true).Type("warning").Class("mt-2"),
+							),
+							scheduleFields,
+						),
+						VCardActions(
+							VSpacer(),
+							VBtn(pMsgr.Cancel).Elevation(0).Attr("@click", "vars.presetsDialog=false"),
+							VBtn(pMsgr.OK).Color("primary").Disabled(available == 0).
+								Attr("@click", web.Plaid().
+									EventFunc(bulkPublishEvent).
+									Query("action", action).
+									Go()),
+						),
+					),
+				).Attr("v-model", "vars.presetsDialog").Width("600").Persistent(true),
+			).VSlot("{ plaidForm }"),
+		})
+		r.VarsScript = "setTimeout(function(){vars.presetsDialog = true; }, 100)"
+		return
+	}
+}
+
+// findBulkRecords finds the selected records by ids, the records not found are nil and their ids are returned as missing,
+// e.g. deleted after the listing is loaded
+func findBulkRecords(db *gorm.DB, mb *presets.ModelBuilder, ids []string) (objs []interface{}, missing []string, err error) {
+	for _, id := range ids {
+		obj := mb.NewModel()
+		if err = utils.PrimarySluggerWhere(db, obj, id).First(obj).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return
+			}
+			err = nil
+			obj = nil
+			missing = append(missing, id)
+		}
+		objs = append(objs, obj)
+	}
+	return
+}
+
+func bulkPublishAction(wb *worker.Builder, actionJob *worker.ActionJobBuilder) web.EventFunc {
+	return func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		args := &BulkPublishArgs{
+			Action: ctx.R.FormValue("action"),
+			IDs:    selectedIDs(ctx),
+		}
+		if args.ScheduledStartAt, err = parseScheduleTime(ctx.R.FormValue("ScheduledStartAt")); err != nil {
+			return
+		}
+		if args.ScheduledEndAt, err = parseScheduleTime(ctx.R.FormValue("ScheduledEndAt")); err != nil {
+			return
+		}
+
+		job, err := wb.AddJob(ctx.R.Context(), actionJob.JobName(), args, worker.DefaultOriginalPageContextHandler(ctx))
+		if err != nil {
+			return
+		}
+		r.VarsScript = actionJob.ResponseURL(job.ID)
+		return
+	}
+}
+
+func parseScheduleTime(val string) (*time.Time, error) {
+	if val == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(timeFormat, fmt.Sprintf("%v:00", val), time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// BulkPublishJobHandler processes the records of the bulk action in the job arguments and reports the result of every record,
+// the records not found are skipped
+func BulkPublishJobHandler(db *gorm.DB, mb *presets.ModelBuilder, publisher *publish.Builder, ab *activity.ActivityBuilder) worker.JobHandler {
+	return func(ctx context.Context, job worker.QorJobInterface) error {
+		jobInfo, err := job.GetJobInfo()
+		if err != nil {
+			return err
+		}
+		args := jobInfo.Argument.(*BulkPublishArgs)
+		actx := activity.ContextWithCreator(ctx, jobInfo.Operator)
+
+		var succeeded, skipped, failed int
+		for i, id := range args.IDs {
+			select {
+			case <-ctx.Done():
+				job.AddLog("job aborted")
+				return ctx.Err()
+			default:
+			}
+
+			obj := mb.NewModel()
+			if err = utils.PrimarySluggerWhere(db, obj, id).First(obj).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+				skipped++
+				job.AddLogf("%s: skipped, not found", id)
+			} else if err != nil {
+				failed++
+				job.AddLogf("%s: failed, %v", id, err)
+			} else if !canTransit(args.Action, obj, args) {
+				skipped++
+				job.AddLogf("%s: skipped, status is %s", id, obj.(publish.StatusInterface).GetStatus())
+			} else if err = bulkPublishOne(db, publisher, ab, actx, args, obj); err != nil {
+				failed++
+				job.AddLogf("%s: failed, %v", id, err)
+			} else {
+				succeeded++
+				job.AddLogf("%s: %s succeeded", id, args.Action)
+			}
+			job.SetProgress(uint((i + 1) * 100 / len(args.IDs)))
+		}
+
+		job.SetProgressText(fmt.Sprintf("%d succeeded, %d skipped, %d failed", succeeded, skipped, failed))
+		return nil
+	}
+}
+
+func bulkPublishOne(db *gorm.DB, publisher *publish.Builder, ab *activity.ActivityBuilder, ctx context.Context, args *BulkPublishArgs, obj interface{}) (err error) {
+	var activityAction string
+	switch args.Action {
+	case BulkActionPublish:
+		activityAction = ActivityPublish
+		err = publisher.Publish(obj)
+	case BulkActionUnpublish:
+		activityAction = ActivityUnPublish
+		err = publisher.UnPublish(obj)
+	case BulkActionSchedule:
+		activityAction = ActivitySchedule
+		updates := make(map[string]interface{})
+		if args.ScheduledStartAt != nil && obj.(publish.StatusInterface).GetStatus() != publish.StatusOnline {
+			updates["scheduled_start_at"] = args.ScheduledStartAt
+		}
+		if args.ScheduledEndAt != nil {
+			updates["scheduled_end_at"] = args.ScheduledEndAt
+		}
+		err = db.Model(obj).Updates(updates).Error
+	default:
+		return fmt.Errorf("unknown bulk action %s", args.Action)
+	}
+	if err != nil {
+		return
+	}
+
+	if ab != nil {
+		if _, exist := ab.GetModelBuilder(obj); exist {
+			ab.AddCustomizedRecord(activityAction, false, ctx, obj)
+		}
+	}
+	return
+}
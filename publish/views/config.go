@@ -25,6 +25,7 @@ func Configure(b *presets.Builder, db *gorm.DB, ab *activity.ActivityBuilder, pu
 		obj := m.NewModel()
 		_ = obj.(presets.SlugEncoder)
 		_ = obj.(presets.SlugDecoder)
+		publisher.RegisterDependentModels(obj)
 		if model, ok := obj.(publish.VersionInterface); ok {
 			if schedulePublishModel, ok := model.(publish.ScheduleInterface); ok {
 				publish.VersionPublishModels[m.Info().URIName()] = reflect.ValueOf(schedulePublishModel).Elem().Interface()
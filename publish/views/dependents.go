@@ -0,0 +1,49 @@
+package views
+
+import (
+	"context"
+
+	"github.com/qor5/admin/publish"
+	"github.com/qor5/admin/worker"
+)
+
+const RepublishDependentsJobName = "Republish Dependents"
+
+type RepublishDependentsArgs struct {
+	Refs []publish.DependentRef
+}
+
+// ConfigureDependentsJob republishes the dependents of published/unpublished records
+// in a worker job instead of doing it synchronously in the request
+func ConfigureDependentsJob(wb *worker.Builder, publisher *publish.Builder) *worker.JobBuilder {
+	jb := wb.NewJob(RepublishDependentsJobName).
+		Resource(&RepublishDependentsArgs{}).
+		Global(false).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			jobInfo, err := job.GetJobInfo()
+			if err != nil {
+				return err
+			}
+			args := jobInfo.Argument.(*RepublishDependentsArgs)
+			for i, ref := range args.Refs {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				job.AddLogf("republishing %s", ref)
+				if err := publisher.RepublishDependents([]publish.DependentRef{ref}); err != nil {
+					job.AddLogf("error: %s", err)
+					return err
+				}
+				job.SetProgress(uint((i + 1) * 100 / len(args.Refs)))
+			}
+			return nil
+		})
+
+	publisher.DependentsHandler(func(ctx context.Context, graph *publish.DependencyGraph) error {
+		_, err := wb.AddJob(ctx, RepublishDependentsJobName, &RepublishDependentsArgs{
+			Refs: graph.Refs(),
+		}, nil)
+		return err
+	})
+	return jb
+}
@@ -32,6 +32,16 @@ type Messages struct {
 	AllVersions             string
 	NamedVersions           string
 	RenameVersion           string
+
+	DependentsWillBeRepublished string
+	PreviewSummary              string
+
+	BulkPublish         string
+	BulkUnpublish       string
+	BulkSchedule        string
+	BulkSelectedSummary string
+	BulkSkippedNotice   string
+	BulkMissingNotice   string
 }
 
 var Messages_en_US = &Messages{
@@ -62,6 +72,16 @@ var Messages_en_US = &Messages{
 	AllVersions:             "All versions",
 	NamedVersions:           "Named versions",
 	RenameVersion:           "Rename Version",
+
+	DependentsWillBeRepublished: "%d related online pages will be republished:",
+	PreviewSummary:              "%d files (%s) will be written, %d files will be deleted:",
+
+	BulkPublish:         "Publish",
+	BulkUnpublish:       "Unpublish",
+	BulkSchedule:        "Schedule",
+	BulkSelectedSummary: "%d records selected, %d will be processed in background.",
+	BulkSkippedNotice:   "These records will be skipped because of their current status: %s",
+	BulkMissingNotice:   "These records will be skipped because they are not found: %s",
 }
 
 var Messages_zh_CN = &Messages{
@@ -92,6 +112,16 @@ var Messages_zh_CN = &Messages{
 	AllVersions:             "所有版本",
 	NamedVersions:           "已命名版本",
 	RenameVersion:           "命名版本",
+
+	DependentsWillBeRepublished: "%d 个相关的在线页面将被重新发布:",
+	PreviewSummary:              "将写入 %d 个文件 (%s), 删除 %d 个文件:",
+
+	BulkPublish:         "发布",
+	BulkUnpublish:       "取消发布",
+	BulkSchedule:        "计划发布",
+	BulkSelectedSummary: "已选择 %d 条记录, 其中 %d 条将在后台处理。",
+	BulkSkippedNotice:   "以下记录因当前状态将被跳过: %s",
+	BulkMissingNotice:   "以下记录因不存在将被跳过: %s",
 }
 
 var Messages_ja_JP = &Messages{
@@ -122,6 +152,16 @@ var Messages_ja_JP = &Messages{
 	AllVersions:             "全てのバージョン",
 	NamedVersions:           "名付け済みバージョン",
 	RenameVersion:           "バージョンの名前を変更する",
+
+	DependentsWillBeRepublished: "%d 件の関連する公開中ページが再公開されます:",
+	PreviewSummary:              "%d 件のファイル (%s) が書き込まれ、%d 件のファイルが削除されます:",
+
+	BulkPublish:         "公開する",
+	BulkUnpublish:       "非公開",
+	BulkSchedule:        "公開日時を設定する",
+	BulkSelectedSummary: "%d 件のレコードが選択され、%d 件がバックグラウンドで処理されます。",
+	BulkSkippedNotice:   "次のレコードは現在のステータスのためスキップされます: %s",
+	BulkMissingNotice:   "次のレコードは見つからないためスキップされます: %s",
 }
 
 func GetStatusText(status string, msgr *Messages) string {
@@ -0,0 +1,96 @@
+package views
+
+import (
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/admin/publish"
+	. "github.com/qor5/ui/vuetify"
+	"github.com/qor5/web"
+	"github.com/qor5/x/i18n"
+	h "github.com/theplant/htmlgo"
+)
+
+const (
+	previewEvent  = "publish_PreviewEvent"
+	previewPortal = "publish_PreviewPortal"
+)
+
+// previewAction dry runs the publish/unpublish action and lists the files which will be written and deleted
+func previewAction(mb *presets.ModelBuilder, publisher *publish.Builder) web.EventFunc {
+	return func(ctx *web.EventContext) (r web.EventResponse, err error) {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nPublishKey, Messages_en_US).(*Messages)
+
+		obj := mb.NewModel()
+		obj, err = mb.Editing().Fetcher(obj, ctx.R.FormValue(presets.ParamID), ctx)
+		if err != nil {
+			return
+		}
+
+		result := &publish.DryRunResult{}
+		dryRunPublisher := publisher.DryRun(result).WithEventContext(ctx)
+		switch ctx.R.FormValue("action") {
+		case UnpublishEvent:
+			err = dryRunPublisher.UnPublish(obj)
+		default:
+			err = dryRunPublisher.Publish(obj)
+		}
+		if err != nil {
+			return
+		}
+
+		r.UpdatePortals = append(r.UpdatePortals, &web.PortalUpdate{
+			Name: previewPortal,
+			Body: DryRunResultComponent(result, msgr),
+		})
+		return
+	}
+}
+
+// DryRunResultComponent renders the urls and content sizes of a publish.DryRunResult
+func DryRunResultComponent(result *publish.DryRunResult, msgr *Messages) h.HTMLComponent {
+	var items h.HTMLComponents
+	for _, obj := range result.Uploads() {
+		items = append(items, VListItem(
+			VListItemIcon(VIcon("upload").Small(true)),
+			VListItemContent(VListItemSubtitle(h.Text(obj.Url))),
+			VListItemAction(h.Text(humanize.Bytes(uint64(len(obj.Content))))),
+		).Dense(true))
+	}
+	for _, obj := range result.Deletes() {
+		items = append(items, VListItem(
+			VListItemIcon(VIcon("delete").Small(true)),
+			VListItemContent(VListItemSubtitle(h.Text(obj.Url))),
+		).Dense(true))
+	}
+
+	var dependentItems h.HTMLComponents
+	for _, u := range result.DependentUrls {
+		dependentItems = append(dependentItems, VListItem(
+			VListItemIcon(VIcon("autorenew").Small(true)),
+			VListItemContent(VListItemSubtitle(h.Text(u))),
+		).Dense(true))
+	}
+
+	return VCardText(
+		h.Div(h.Text(fmt.Sprintf(msgr.PreviewSummary,
+			len(result.Uploads()),
+			humanize.Bytes(uint64(result.TotalContentSize())),
+			len(result.Deletes()),
+		))).Class("mb-2"),
+		h.If(len(items) > 0,
+			VList(items...).Dense(true).Attr("style", "max-height: 240px; overflow: auto;"),
+		),
+		h.If(len(dependentItems) > 0,
+			h.Div(h.Text(fmt.Sprintf(msgr.DependentsWillBeRepublished, len(dependentItems)))).Class("mt-2 mb-2"),
+			VList(dependentItems...).Dense(true).Attr("style", "max-height: 160px; overflow: auto;"),
+		),
+	)
+}
+
+func previewComponent() h.HTMLComponent {
+	return web.Portal(
+		VCardText(VProgressLinear().Indeterminate(true)),
+	).Name(previewPortal)
+}
@@ -69,21 +69,25 @@ func StatusEditFunc() presets.FieldComponentFunc {
 		msgr := i18n.MustGetModuleMessages(ctx.R, I18nPublishKey, Messages_en_US).(*Messages)
 		utilsMsgr := i18n.MustGetModuleMessages(ctx.R, utils.I18nUtilsKey, utils.Messages_en_US).(*utils.Messages)
 
+		paramID := obj.(presets.SlugEncoder).PrimarySlug()
+		confirmWithPreview := func(action string) string {
+			return fmt.Sprintf(`locals.action="%s";locals.commonConfirmDialog = true;%s`, action,
+				web.Plaid().EventFunc(previewEvent).Query(presets.ParamID, paramID).Query("action", action).Go())
+		}
+
 		var btn h.HTMLComponent
 		switch s.GetStatus() {
 		case publish.StatusDraft, publish.StatusOffline:
 			btn = h.Div(
-				VBtn(msgr.Publish).Attr("@click", fmt.Sprintf(`locals.action="%s";locals.commonConfirmDialog = true`, PublishEvent)),
+				VBtn(msgr.Publish).Attr("@click", confirmWithPreview(PublishEvent)),
 			)
 		case publish.StatusOnline:
 			btn = h.Div(
-				VBtn(msgr.Unpublish).Attr("@click", fmt.Sprintf(`locals.action="%s";locals.commonConfirmDialog = true`, UnpublishEvent)),
-				VBtn(msgr.Republish).Attr("@click", fmt.Sprintf(`locals.action="%s";locals.commonConfirmDialog = true`, RepublishEvent)),
+				VBtn(msgr.Unpublish).Attr("@click", confirmWithPreview(UnpublishEvent)),
+				VBtn(msgr.Republish).Attr("@click", confirmWithPreview(RepublishEvent)),
 			)
 		}
 
-		paramID := obj.(presets.SlugEncoder).PrimarySlug()
-
 		return web.Scope(
 			VStepper(
 				VStepperHeader(
@@ -97,7 +101,7 @@ func StatusEditFunc() presets.FieldComponentFunc {
 			h.Br(),
 			utils.ConfirmDialog(msgr.Areyousure, web.Plaid().EventFunc(web.Var("locals.action")).
 				Query(presets.ParamID, paramID).Go(),
-				utilsMsgr),
+				utilsMsgr, previewComponent()),
 		).Init(`{ action: "", commonConfirmDialog: false}`).VSlot("{ locals }")
 	}
 }
@@ -17,10 +17,12 @@ func Configure(b *presets.Builder) {
 		RegisterForModule(language.Japanese, I18nUtilsKey, Messages_ja_JP)
 }
 
-func ConfirmDialog(msg string, okAction string, msgr *Messages) h.HTMLComponent {
+// body is optional content rendered between the title and the actions
+func ConfirmDialog(msg string, okAction string, msgr *Messages, body ...h.HTMLComponent) h.HTMLComponent {
 	return VDialog(
 		VCard(
 			VCardTitle(h.Text(msg)),
+			h.Components(body...),
 			VCardActions(
 				VSpacer(),
 				VBtn(msgr.Cancel).
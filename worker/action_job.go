@@ -106,6 +106,21 @@ func (action ActionJobBuilder) URL() string {
 	return web.Plaid().URL(action.b.mb.Info().ListingHref()).EventFunc(ActionJobInputParams).Query("jobName", action.fullname).Go()
 }
 
+func (action ActionJobBuilder) JobName() string {
+	return action.fullname
+}
+
+// ResponseURL opens the progressing dialog of a job created by Builder.AddJob
+func (action ActionJobBuilder) ResponseURL(qorJobID uint) string {
+	return web.Plaid().
+		URL(action.b.mb.Info().ListingHref()).
+		EventFunc(ActionJobResponse).
+		Query(presets.ParamID, fmt.Sprint(qorJobID)).
+		Query("jobID", fmt.Sprintf("%d", qorJobID)).
+		Query("jobName", action.fullname).
+		Go()
+}
+
 func (b *Builder) eventActionJobCreate(ctx *web.EventContext) (r web.EventResponse, err error) {
 	var (
 		jobName = ctx.R.FormValue("jobName")
@@ -121,7 +136,7 @@ func (b *Builder) eventActionJobCreate(ctx *web.EventContext) (r web.EventRespon
 	if err != nil {
 		return
 	}
-	if b.ab != nil {
+	if b.ab != nil && !job.Deduplicated {
 		b.ab.AddRecords(activity.ActivityCreate, ctx.R.Context(), job)
 	}
 
@@ -281,7 +296,7 @@ func (b *Builder) eventActionJobProgressing(ctx *web.EventContext) (er web.Event
 		h.Div(vuetify.VProgressLinear(
 			h.Strong(fmt.Sprintf("%d%%", inst.Progress)),
 		).Value(int(inst.Progress)).Height(20)).Class("mb-5"),
-		h.If(config.displayLog, actionJobLog(*config.b, inst)),
+		h.If(config.displayLog, actionJobLog(config.b, inst)),
 		h.If(inst.ProgressText != "",
 			h.Div().Class("mb-3").Children(
 				h.RawHTML(inst.ProgressText),
@@ -297,7 +312,7 @@ func (b *Builder) eventActionJobProgressing(ctx *web.EventContext) (er web.Event
 	return er, nil
 }
 
-func actionJobLog(b Builder, inst *QorJobInstance) h.HTMLComponent {
+func actionJobLog(b *Builder, inst *QorJobInstance) h.HTMLComponent {
 	var logLines []h.HTMLComponent
 	logs := make([]string, 0, 100)
 
@@ -308,7 +323,7 @@ func actionJobLog(b Builder, inst *QorJobInstance) h.HTMLComponent {
 		Find(&mLogs)
 
 	for i := len(mLogs) - 1; i >= 0; i-- {
-		logs = append(logs, mLogs[i].Log)
+		logs = append(logs, mLogs[i].entry().String())
 	}
 
 	var reverseStyle string
@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/qor5/admin/activity"
+	"gorm.io/gorm"
+)
+
+const (
+	ActivityWebhookSignatureHeader = "X-Activity-Signature"
+	ActivityWebhookTimestampHeader = "X-Activity-Timestamp"
+
+	activityWebhookJobNamePrefix = "activity-webhook-"
+)
+
+var _ activity.TxSink = (*ActivityWebhookBuilder)(nil)
+
+// ActivityWebhookBuilder is an activity sink that posts the events to a url in worker jobs, so that they are retried on failures
+type ActivityWebhookBuilder struct {
+	jb     *JobBuilder
+	url    string
+	secret string
+	client *http.Client
+}
+
+// ActivityWebhook registers the job that posts the activity events to the url and returns the sink enqueuing it,
+// the body is signed by HMAC-SHA256 with the secret. By default a failed post is retried 5 times.
+// example: ab.RegisterSink(w.ActivityWebhook("warehouse", url, secret)).Models("Product")
+func (b *Builder) ActivityWebhook(name string, url string, secret string) *ActivityWebhookBuilder {
+	wb := &ActivityWebhookBuilder{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	wb.jb = b.NewJob(activityWebhookJobNamePrefix + name).
+		Resource(&activity.ActivityEvent{}).
+		Global(false).
+		RetryPolicy(&RetryPolicy{MaxAttempts: 5}).
+		Handler(wb.handle)
+	return wb
+}
+
+// Client sets the http client of the posts
+func (wb *ActivityWebhookBuilder) Client(c *http.Client) *ActivityWebhookBuilder {
+	wb.client = c
+	return wb
+}
+
+// RetryPolicy sets the retry policy of the posts
+func (wb *ActivityWebhookBuilder) RetryPolicy(p *RetryPolicy) *ActivityWebhookBuilder {
+	wb.jb.RetryPolicy(p)
+	return wb
+}
+
+// GetJobBuilder returns the job builder of the posts
+func (wb *ActivityWebhookBuilder) GetJobBuilder() *JobBuilder {
+	return wb.jb
+}
+
+func (wb *ActivityWebhookBuilder) Emit(ctx context.Context, e *activity.ActivityEvent) error {
+	_, err := wb.jb.b.AddJob(ctx, wb.jb.name, e, nil)
+	return err
+}
+
+// EmitInTx creates the job in the transaction of the log, so that no post is sent for a rolled back log.
+// The queues that can't enqueue in a transaction enqueue the job after it is committed.
+func (wb *ActivityWebhookBuilder) EmitInTx(ctx context.Context, tx *gorm.DB, e *activity.ActivityEvent) (committed func(), err error) {
+	_, enqueue, err := wb.jb.b.addJobInTx(ctx, tx, nil, wb.jb, e, map[string]interface{}{})
+	if err != nil || enqueue == nil {
+		return nil, err
+	}
+	return func() {
+		if err := enqueue(); err != nil {
+			log.Printf("activity webhook %s enqueue log %d error: %v\n", wb.jb.name, e.ID, err)
+		}
+	}, nil
+}
+
+func (wb *ActivityWebhookBuilder) handle(ctx context.Context, job QorJobInterface) error {
+	ji, err := job.GetJobInfo()
+	if err != nil {
+		return NonRetryable(err)
+	}
+	body, err := json.Marshal(ji.Argument)
+	if err != nil {
+		return NonRetryable(err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wb.url, bytes.NewReader(body))
+	if err != nil {
+		return NonRetryable(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(ActivityWebhookTimestampHeader, timestamp)
+	req.Header.Set(ActivityWebhookSignatureHeader, SignActivityWebhook(wb.secret, timestamp, body))
+
+	resp, err := wb.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("activity webhook responds %s", resp.Status)
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout {
+		return err
+	}
+	return NonRetryable(err)
+}
+
+// SignActivityWebhook returns the signature of the webhook body in the X-Activity-Signature header,
+// it is "sha256=" and the hex of the HMAC-SHA256 of the timestamp header, a dot and the body
+func SignActivityWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyActivityWebhook verifies the signature of a received webhook request whose body is read into body,
+// the requests signed more than tolerance ago are rejected, 0 disables the check
+func VerifyActivityWebhook(r *http.Request, body []byte, secret string, tolerance time.Duration) error {
+	timestamp := r.Header.Get(ActivityWebhookTimestampHeader)
+	if !hmac.Equal([]byte(r.Header.Get(ActivityWebhookSignatureHeader)), []byte(SignActivityWebhook(secret, timestamp, body))) {
+		return fmt.Errorf("invalid activity webhook signature")
+	}
+	if tolerance > 0 {
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid activity webhook timestamp %q", timestamp)
+		}
+		if d := time.Since(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+			return fmt.Errorf("activity webhook timestamp %q is out of tolerance", timestamp)
+		}
+	}
+	return nil
+}
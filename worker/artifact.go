@@ -0,0 +1,173 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qor/oss"
+	"github.com/qor5/admin/presets"
+)
+
+// QorJobArtifact is a file produced by a job instance, the content is in the artifact storage of the builder
+type QorJobArtifact struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+
+	QorJobID         uint `gorm:"index"`
+	QorJobInstanceID uint `gorm:"index"`
+	Name             string
+	Path             string
+	Size             int64
+	// ExpiresAt is nil if the artifact is kept forever
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+const artifactQueryParam = "__worker_artifact__"
+
+// ArtifactStorage sets the storage for job artifacts, QorJobInterface.AddArtifact fails without it
+func (b *Builder) ArtifactStorage(s oss.StorageInterface) *Builder {
+	b.artifactStorage = s
+	return b
+}
+
+// ArtifactRetention sets how long artifacts are kept, 0 means forever which is the default.
+// Expired artifacts are deleted by the builder every cleanup interval.
+func (b *Builder) ArtifactRetention(v time.Duration) *Builder {
+	b.artifactRetention = v
+	return b
+}
+
+// ArtifactCleanupInterval is how often expired artifacts are deleted, default is 1 hour
+func (b *Builder) ArtifactCleanupInterval(v time.Duration) *Builder {
+	b.artifactCleanupInterval = v
+	return b
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
+
+func (job *QorJobInstance) AddArtifact(name string, r io.Reader) error {
+	b := job.jb.b
+	if b.artifactStorage == nil {
+		return errors.New("artifact storage is not configured")
+	}
+	name = path.Base(filepath.ToSlash(name))
+	if name == "." || name == "/" {
+		return errors.New("artifact name is empty")
+	}
+
+	cr := &countingReader{r: r}
+	// the artifacts of the same name are kept apart, every row has its own file
+	p := fmt.Sprintf("worker_artifacts/%d/%d/%s/%s", job.QorJobID, job.ID, uuid.New().String(), name)
+	if _, err := b.artifactStorage.Put(p, cr); err != nil {
+		return err
+	}
+
+	a := &QorJobArtifact{
+		QorJobID:         job.QorJobID,
+		QorJobInstanceID: job.ID,
+		Name:             name,
+		Path:             p,
+		Size:             cr.n,
+	}
+	if b.artifactRetention > 0 {
+		expiresAt := b.db.NowFunc().Add(b.artifactRetention)
+		a.ExpiresAt = &expiresAt
+	}
+	return b.db.Create(a).Error
+}
+
+func (b *Builder) getArtifacts(qorJobID uint) (artifacts []*QorJobArtifact, err error) {
+	err = b.db.Where("qor_job_id = ? AND (expires_at IS NULL OR expires_at > ?)", qorJobID, b.db.NowFunc()).
+		Order("id").
+		Find(&artifacts).Error
+	return
+}
+
+// DeleteExpiredArtifacts deletes the expired artifacts from the storage and the database
+func (b *Builder) DeleteExpiredArtifacts(ctx context.Context) error {
+	var artifacts []*QorJobArtifact
+	if err := b.db.WithContext(ctx).Where("expires_at <= ?", b.db.NowFunc()).
+		Find(&artifacts).Error; err != nil {
+		return err
+	}
+	for _, a := range artifacts {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := b.artifactStorage.Delete(a.Path); err != nil {
+			log.Printf("worker delete artifact %s error: %v\n", a.Path, err)
+			continue
+		}
+		if err := b.db.Delete(a).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Builder) artifactDownloadURL(a *QorJobArtifact) string {
+	return fmt.Sprintf("%s?%s=%d", path.Join(b.mb.Info().ListingHref(), fmt.Sprint(a.QorJobID)), artifactQueryParam, a.ID)
+}
+
+// artifactDownloadHandler serves the artifact downloads on the job detail url,
+// so that they go through the same middlewares and permissions as the admin
+func (b *Builder) artifactDownloadHandler(in http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get(artifactQueryParam)
+		if id == "" || r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, b.mb.Info().ListingHref()) {
+			in.ServeHTTP(w, r)
+			return
+		}
+
+		a := &QorJobArtifact{}
+		if err := b.db.Where("id = ? AND (expires_at IS NULL OR expires_at > ?)", id, b.db.NowFunc()).First(a).Error; err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		j := &QorJob{}
+		if err := b.db.Where("id = ?", a.QorJobID).First(j).Error; err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if b.mb.Info().Verifier().Do(presets.PermGet).ObjectOn(j).WithReq(r).IsAllowed() != nil ||
+			downloadArtifactIsAllowed(r, j.Job) != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		f, err := b.artifactStorage.GetStream(a.Path)
+		if err != nil {
+			log.Printf("worker get artifact %s error: %v\n", a.Path, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		contentType := mime.TypeByExtension(path.Ext(a.Name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		io.Copy(w, f)
+	})
+}
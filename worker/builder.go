@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 	"path"
@@ -12,6 +13,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dustin/go-humanize"
+	"github.com/qor/oss"
 	"github.com/qor5/admin/activity"
 	"github.com/qor5/admin/presets"
 	. "github.com/qor5/ui/vuetify"
@@ -33,6 +36,30 @@ type Builder struct {
 	mb                   *presets.ModelBuilder
 	getCurrentUserIDFunc func(r *http.Request) string
 	ab                   *activity.ActivityBuilder
+
+	recurringCheckInterval time.Duration
+
+	pool *WorkerPool
+	wfs  []*WorkflowBuilder
+
+	artifactStorage         oss.StorageInterface
+	artifactRetention       time.Duration
+	artifactCleanupInterval time.Duration
+
+	heartbeatTimeout time.Duration
+	reaperInterval   time.Duration
+
+	logRetention       time.Duration
+	logLevelRetention  map[LogLevel]time.Duration
+	logCleanupInterval time.Duration
+	logStreamInterval  time.Duration
+
+	// jds are the job definitions passed to the queue by Listen
+	jds     []*QorJobDefinition
+	metrics *metrics
+
+	// stops the background goroutines started by Listen
+	stops []func()
 }
 
 func New(db *gorm.DB) *Builder {
@@ -48,7 +75,7 @@ func newWithConfigs(db *gorm.DB, q Queue) *Builder {
 		panic("db can not be nil")
 	}
 
-	err := db.AutoMigrate(&QorJob{}, &QorJobInstance{}, &QorJobLog{}, &GoQueError{})
+	err := db.AutoMigrate(&QorJob{}, &QorJobInstance{}, &QorJobLog{}, &GoQueError{}, &QorRecurringJob{}, &QorWorkflowStep{}, &QorJobArtifact{}, &QorJobIdempotencyLock{})
 	if err != nil {
 		panic(err)
 	}
@@ -57,6 +84,15 @@ func newWithConfigs(db *gorm.DB, q Queue) *Builder {
 		db:  db,
 		q:   q,
 		jpb: presets.New(),
+
+		metrics: newMetrics(),
+
+		recurringCheckInterval:  15 * time.Second,
+		artifactCleanupInterval: time.Hour,
+		heartbeatTimeout:        time.Minute,
+		reaperInterval:          30 * time.Second,
+		logCleanupInterval:      time.Hour,
+		logStreamInterval:       time.Second,
 	}
 
 	return r
@@ -68,6 +104,13 @@ func (b *Builder) Queue(q Queue) *Builder {
 	return b
 }
 
+// WorkerPoolSize caps the number of jobs running at the same time in this process across all the jobs,
+// by default every job is only limited by its own Concurrency. The workflow jobs are not counted since they wait for their children.
+func (b *Builder) WorkerPoolSize(n int) *Builder {
+	b.pool = NewWorkerPool(n)
+	return b
+}
+
 func (b *Builder) GetCurrentUserIDFunc(f func(r *http.Request) string) *Builder {
 	b.getCurrentUserIDFunc = f
 	return b
@@ -123,7 +166,11 @@ func (b *Builder) getJobBuilderByQorJobID(id uint) (*JobBuilder, error) {
 }
 
 func (b *Builder) setStatus(id uint, status string) error {
-	return b.db.Model(&QorJob{}).Where("id = ?", id).
+	return setQorJobStatus(b.db, id, status)
+}
+
+func setQorJobStatus(db *gorm.DB, id uint, status string) error {
+	return db.Model(&QorJob{}).Where("id = ?", id).
 		Updates(map[string]interface{}{
 			"status": status,
 		}).
@@ -145,12 +192,14 @@ func (b *Builder) Configure(pb *presets.Builder) *presets.ModelBuilder {
 		MenuIcon("smart_toy")
 
 	b.mb = mb
+	pb.AddWrapHandler("worker_artifacts", b.artifactDownloadHandler)
+	pb.AddWrapHandler("worker_logs", b.jobLogsHandler)
 	mb.RegisterEventFunc("worker_selectJob", b.eventSelectJob)
 	mb.RegisterEventFunc("worker_abortJob", b.eventAbortJob)
 	mb.RegisterEventFunc("worker_rerunJob", b.eventRerunJob)
 	mb.RegisterEventFunc("worker_updateJob", b.eventUpdateJob)
 	mb.RegisterEventFunc("worker_updateJobProgressing", b.eventUpdateJobProgressing)
-	mb.RegisterEventFunc("worker_loadHiddenLogs", b.eventLoadHiddenLogs)
+	mb.RegisterEventFunc("worker_loadJobLogs", b.eventLoadJobLogs)
 	mb.RegisterEventFunc(ActionJobInputParams, b.eventActionJobInputParams)
 	mb.RegisterEventFunc(ActionJobCreate, b.eventActionJobCreate)
 	mb.RegisterEventFunc(ActionJobResponse, b.eventActionJobResponse)
@@ -175,6 +224,8 @@ func (b *Builder) Configure(pb *presets.Builder) *presets.ModelBuilder {
 					{Text: msgr.StatusDone, Value: JobStatusDone},
 					{Text: msgr.StatusException, Value: JobStatusException},
 					{Text: msgr.StatusKilled, Value: JobStatusKilled},
+					{Text: msgr.StatusRetrying, Value: JobStatusRetrying},
+					{Text: msgr.StatusDead, Value: JobStatusDead},
 				},
 			},
 		}
@@ -202,8 +253,22 @@ func (b *Builder) Configure(pb *presets.Builder) *presets.ModelBuilder {
 				Label: msgr.FilterTabErrors,
 				Query: url.Values{"status": []string{JobStatusException}},
 			},
+			{
+				Label: msgr.FilterTabDead,
+				Query: url.Values{"status": []string{JobStatusDead}},
+			},
 		}
 	})
+	lb.BulkAction("Reenqueue").Label("Re-enqueue").
+		SelectedIdsProcessorFunc(b.reenqueueableJobIDs).
+		SelectedIdsProcessorNoticeFunc(func(selectedIds []string, processedSelectedIds []string, unactionableIds []string) string {
+			return fmt.Sprintf("%d jobs will be re-enqueued, jobs which are not dead, errored or killed are skipped: %s", len(processedSelectedIds), strings.Join(unactionableIds, ", "))
+		}).
+		ComponentFunc(func(selectedIds []string, ctx *web.EventContext) HTMLComponent {
+			msgr := i18n.MustGetModuleMessages(ctx.R, I18nWorkerKey, Messages_en_US).(*Messages)
+			return Div(Text(fmt.Sprintf(msgr.NoticeJobsWillBeReenqueued, len(selectedIds))))
+		}).
+		UpdateFunc(b.reenqueueJobs)
 	lb.Field("Job").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) HTMLComponent {
 		qorJob := obj.(*QorJob)
 		return Td(Text(getTJob(ctx.R, qorJob.Job)))
@@ -263,7 +328,7 @@ func (b *Builder) Configure(pb *presets.Builder) *presets.ModelBuilder {
 		if err != nil {
 			return err
 		}
-		if b.ab != nil {
+		if b.ab != nil && !j.Deduplicated {
 			b.ab.AddRecords(activity.ActivityCreate, ctx.R.Context(), j)
 		}
 		return
@@ -328,13 +393,15 @@ func (b *Builder) Configure(pb *presets.Builder) *presets.ModelBuilder {
 			).Else(
 				Div(
 					web.Portal().
-						Loader(web.Plaid().EventFunc("worker_updateJobProgressing").
+						Loader(withJobLogQuery(web.Plaid().EventFunc("worker_updateJobProgressing").
 							URL(eURL).
 							Query("jobID", fmt.Sprintf("%d", qorJob.ID)).
-							Query("job", qorJob.Job),
+							Query("job", qorJob.Job).
+							Query("afterID", web.Var("vars.worker_logAfterID"))),
 						).
 						AutoReloadInterval("vars.worker_updateJobProgressingInterval"),
-				).Attr(web.InitContextVars, "{worker_updateJobProgressingInterval: 2000}"),
+					b.jobLogViewer(msgr, eURL, qorJob.ID),
+				).Attr(web.InitContextVars, `{worker_updateJobProgressingInterval: 2000, worker_logs: [], worker_logAfterID: 0, worker_logHasMore: false, worker_logLevel: "", worker_logSearch: ""}`),
 			),
 			web.Portal().Name("worker_snackbar"),
 		)
@@ -366,17 +433,36 @@ func (b *Builder) Configure(pb *presets.Builder) *presets.ModelBuilder {
 			})
 	}
 
+	b.configureRecurringJobs(pb)
+
 	return mb
 }
 
 func (b *Builder) Listen() {
+	for _, wf := range b.wfs {
+		wf.validate()
+	}
+	workflows := make(map[*JobBuilder]bool)
+	for _, wf := range b.wfs {
+		workflows[wf.jb] = true
+	}
 	var jds []*QorJobDefinition
 	for _, jb := range b.jbs {
-		jds = append(jds, &QorJobDefinition{
-			Name:    jb.name,
-			Handler: jb.h,
-		})
+		jd := &QorJobDefinition{
+			Name:        jb.name,
+			Handler:     jb.h,
+			Concurrency: jb.concurrency,
+			RateLimit:   jb.rateLimit,
+			Priority:    jb.priority,
+			Pool:        b.pool,
+		}
+		// the workflow jobs mostly wait for their children, they would deadlock a full pool
+		if workflows[jb] {
+			jd.Pool = nil
+		}
+		jds = append(jds, jd)
 	}
+	b.jds = jds
 	err := b.q.Listen(jds, func(qorJobID uint) (QueJobInterface, error) {
 		jb, err := b.getJobBuilderByQorJobID(qorJobID)
 		if err != nil {
@@ -391,9 +477,48 @@ func (b *Builder) Listen() {
 	if err != nil {
 		panic(err)
 	}
+	b.startRecurring()
+	if b.artifactStorage != nil && b.artifactRetention > 0 {
+		b.runEvery(b.artifactCleanupInterval, b.DeleteExpiredArtifacts, "delete expired artifacts")
+	}
+	if b.heartbeatTimeout > 0 {
+		b.runEvery(b.reaperInterval, b.ReapStaleJobs, "reap stale jobs")
+	}
+	if b.hasLogRetention() {
+		b.runEvery(b.logCleanupInterval, b.DeleteExpiredLogs, "delete expired logs")
+	}
+}
+
+// runEvery runs f in the background right away and then every interval until Shutdown
+func (b *Builder) runEvery(interval time.Duration, f func(ctx context.Context) error, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	b.stops = append(b.stops, func() {
+		cancel()
+		<-done
+	})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := f(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("worker %s error: %v\n", name, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
 }
 
 func (b *Builder) Shutdown(ctx context.Context) error {
+	for _, stop := range b.stops {
+		stop()
+	}
+	b.stops = nil
 	return b.q.Shutdown(ctx)
 }
 
@@ -430,25 +555,73 @@ func (b *Builder) createJob(ctx *web.EventContext, qorJob *QorJob) (j *QorJob, e
 		}
 	}
 
+	return b.addJob(ctx.R.Context(), ctx.R, jb, args, context)
+}
+
+// AddJob creates and enqueues a job without an admin request,
+// e.g. from a job handler or a background process.
+// args will be encoded as the job resource
+func (b *Builder) AddJob(ctx context.Context, jobName string, args interface{}, context map[string]interface{}) (j *QorJob, err error) {
+	jb := b.getJobBuilder(jobName)
+	if jb == nil {
+		return nil, fmt.Errorf("no job %s", jobName)
+	}
+	if context == nil {
+		context = make(map[string]interface{})
+	}
+	return b.addJob(ctx, nil, jb, args, context)
+}
+
+func (b *Builder) addJob(ctx context.Context, r *http.Request, jb *JobBuilder, args interface{}, context map[string]interface{}) (j *QorJob, err error) {
+	var enqueue func() error
 	err = b.db.Transaction(func(tx *gorm.DB) error {
-		j = &QorJob{
-			Job:    qorJob.Job,
-			Status: JobStatusNew,
-		}
-		err = b.db.Create(j).Error
-		if err != nil {
-			return err
-		}
-		var inst *QorJobInstance
-		inst, err = jb.newJobInstance(ctx.R, j.ID, qorJob.Job, args, context)
-		if err != nil {
-			return err
-		}
-		return b.q.Add(ctx.R.Context(), inst)
+		j, enqueue, err = b.addJobInTx(ctx, tx, r, jb, args, context)
+		return err
 	})
+	if err != nil || enqueue == nil {
+		return
+	}
+	err = enqueue()
 	return
 }
 
+// addJobInTx creates the job in the transaction tx and enqueues it in tx if the queue supports it,
+// otherwise it returns the enqueue to call after tx is committed
+func (b *Builder) addJobInTx(ctx context.Context, tx *gorm.DB, r *http.Request, jb *JobBuilder, args interface{}, context map[string]interface{}) (j *QorJob, enqueue func() error, err error) {
+	key, err := jb.getIdempotencyKey(args, context)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key != "" {
+		// the creations with the same key are serialized by the lock row until the transaction ends
+		if err = lockIdempotencyKey(tx, key); err != nil {
+			return
+		}
+		if j, err = b.findDuplicatedJob(ctx, tx, jb, key); err != nil || j != nil {
+			return
+		}
+	}
+	j = &QorJob{
+		Job:            jb.name,
+		Status:         JobStatusNew,
+		IdempotencyKey: key,
+	}
+	if err = tx.Create(j).Error; err != nil {
+		return
+	}
+	inst, err := jb.newJobInstance(tx, r, j.ID, jb.name, args, context)
+	if err != nil {
+		return
+	}
+	queued, err := b.enqueueInTx(ctx, tx, inst)
+	if err != nil || queued {
+		return
+	}
+	return j, func() error {
+		return b.enqueue(ctx, inst)
+	}, nil
+}
+
 func (b *Builder) eventSelectJob(ctx *web.EventContext) (er web.EventResponse, err error) {
 	job := ctx.R.FormValue("jobName")
 	er.UpdatePortals = append(er.UpdatePortals,
@@ -526,7 +699,7 @@ func (b *Builder) doAbortJob(ctx context.Context, inst *QorJobInstance) (err err
 	switch inst.Status {
 	case JobStatusRunning:
 		return b.q.Kill(ctx, inst)
-	case JobStatusNew, JobStatusScheduled:
+	case JobStatusNew, JobStatusScheduled, JobStatusRetrying:
 		return b.q.Remove(ctx, inst)
 	default:
 		return &cannotAbortError{
@@ -552,7 +725,7 @@ func (b *Builder) eventRerunJob(ctx *web.EventContext) (er web.EventResponse, er
 		return er, errors.New("job is not done")
 	}
 
-	inst, err := jb.newJobInstance(ctx.R, qorJobID, qorJobName, old.Args, old.Context)
+	inst, err := jb.newJobInstance(b.db, ctx.R, qorJobID, qorJobName, old.Args, old.Context)
 	if err != nil {
 		return er, err
 	}
@@ -560,7 +733,7 @@ func (b *Builder) eventRerunJob(ctx *web.EventContext) (er web.EventResponse, er
 	if err != nil {
 		return er, err
 	}
-	err = b.q.Add(ctx.R.Context(), inst)
+	err = b.enqueue(ctx.R.Context(), inst)
 	if err != nil {
 		return er, err
 	}
@@ -578,6 +751,55 @@ func (b *Builder) eventRerunJob(ctx *web.EventContext) (er web.EventResponse, er
 	return
 }
 
+func (b *Builder) reenqueueableJobIDs(selectedIds []string, ctx *web.EventContext) (ids []string, err error) {
+	var jobs []*QorJob
+	err = b.db.Where("id IN ? AND status IN ?", selectedIds, []string{JobStatusDead, JobStatusException, JobStatusKilled}).
+		Find(&jobs).Error
+	if err != nil {
+		return
+	}
+	for _, j := range jobs {
+		if editIsAllowed(ctx.R, j.Job) != nil || b.getJobBuilder(j.Job) == nil {
+			continue
+		}
+		ids = append(ids, fmt.Sprint(j.ID))
+	}
+	return
+}
+
+// reenqueueJobs performs the selected jobs again with their last arguments and a fresh retry budget
+func (b *Builder) reenqueueJobs(selectedIds []string, ctx *web.EventContext) (err error) {
+	for _, id := range selectedIds {
+		var j QorJob
+		if err = b.db.Where("id = ?", id).First(&j).Error; err != nil {
+			return
+		}
+		jb := b.mustGetJobBuilder(j.Job)
+		var old *QorJobInstance
+		if old, err = jb.getJobInstance(j.ID); err != nil {
+			return
+		}
+		var inst *QorJobInstance
+		if inst, err = jb.newJobInstance(b.db, ctx.R, j.ID, j.Job, old.Args, old.Context); err != nil {
+			return
+		}
+		if err = b.setStatus(j.ID, JobStatusNew); err != nil {
+			return
+		}
+		if err = b.enqueue(ctx.R.Context(), inst); err != nil {
+			return
+		}
+		if b.ab != nil {
+			b.ab.AddCustomizedRecord("Reenqueue", false, ctx.R.Context(), &QorJob{
+				Model: gorm.Model{
+					ID: j.ID,
+				},
+			})
+		}
+	}
+	return
+}
+
 func (b *Builder) eventUpdateJob(ctx *web.EventContext) (er web.EventResponse, err error) {
 	msgr := i18n.MustGetModuleMessages(ctx.R, I18nWorkerKey, Messages_en_US).(*Messages)
 
@@ -625,11 +847,11 @@ func (b *Builder) eventUpdateJob(ctx *web.EventContext) (er web.EventResponse, e
 		return er, nil
 	}
 
-	newInst, err := jb.newJobInstance(ctx.R, qorJobID, qorJobName, newArgs, contexts)
+	newInst, err := jb.newJobInstance(b.db, ctx.R, qorJobID, qorJobName, newArgs, contexts)
 	if err != nil {
 		return er, err
 	}
-	err = b.q.Add(ctx.R.Context(), newInst)
+	err = b.enqueue(ctx.R.Context(), newInst)
 	if err != nil {
 		return er, err
 	}
@@ -668,74 +890,29 @@ func (b *Builder) eventUpdateJobProgressing(ctx *web.EventContext) (er web.Event
 	}
 
 	canEdit := editIsAllowed(ctx.R, qorJobName) == nil
-	logs := make([]string, 0, 100)
-	hasMoreLogs := false
-	{
-		var count int64
-		err = b.db.Model(&QorJobLog{}).
-			Where("qor_job_instance_id = ?", inst.ID).
-			Count(&count).
-			Error
-		if err != nil {
-			return er, err
-		}
-		if count > 100 {
-			hasMoreLogs = true
-		}
-		if count > 0 {
-			var mLogs []*QorJobLog
-			err = b.db.Where("qor_job_instance_id = ?", inst.ID).
-				Order("created_at desc").
-				Limit(100).
-				Find(&mLogs).
-				Error
-			if err != nil {
-				return er, err
-			}
-			for i := len(mLogs) - 1; i >= 0; i-- {
-				logs = append(logs, mLogs[i].Log)
-			}
-		}
+	attempts, err := inst.GetAttempts()
+	if err != nil {
+		return er, err
 	}
-	er.Body = b.jobProgressing(canEdit, msgr, qorJobID, qorJobName, inst.Status, inst.Progress, logs, hasMoreLogs, inst.ProgressText)
-	if inst.Status != JobStatusNew && inst.Status != JobStatusRunning && inst.Status != JobStatusKilled {
-		er.VarsScript = "vars.worker_updateJobProgressingInterval = 0"
-	} else {
-		er.VarsScript = "vars.worker_updateJobProgressingInterval = 2000"
+	children, err := b.getWorkflowChildren(qorJobID)
+	if err != nil {
+		return er, err
 	}
-	return er, nil
-}
-
-func (b *Builder) eventLoadHiddenLogs(ctx *web.EventContext) (er web.EventResponse, err error) {
-	qorJobID := uint(ctx.QueryAsInt("jobID"))
-	currentCount := ctx.QueryAsInt("currentCount")
-
-	inst, err := getModelQorJobInstance(b.db, qorJobID)
+	artifacts, err := b.getArtifacts(qorJobID)
 	if err != nil {
 		return er, err
 	}
-
-	var logs []*QorJobLog
-	err = b.db.Where("qor_job_instance_id = ?", inst.ID).
-		Order("created_at desc").
-		Offset(currentCount).
-		Find(&logs).
-		Error
+	canDownload := downloadArtifactIsAllowed(ctx.R, qorJobName) == nil
+	er.Body = b.jobProgressing(canEdit, msgr, qorJobID, qorJobName, inst.Status, inst.Progress, inst.ProgressText, attempts, inst.ParentQorJobID, children, canDownload, artifacts)
+	logsScript, err := b.jobLogsVarsScript(inst.ID, jobLogQueryFromRequest(ctx.R))
 	if err != nil {
 		return er, err
 	}
-	logLines := make([]HTMLComponent, 0, len(logs))
-	for i := len(logs) - 1; i >= 0; i-- {
-		logLines = append(logLines, P().Style(`
-    margin: 0;
-    margin-bottom: 4px;`).Children(Text(logs[i].Log)))
+	if inst.Status != JobStatusNew && inst.Status != JobStatusRunning && inst.Status != JobStatusKilled && inst.Status != JobStatusRetrying {
+		er.VarsScript = logsScript + "; vars.worker_updateJobProgressingInterval = 0"
+	} else {
+		er.VarsScript = logsScript + "; vars.worker_updateJobProgressingInterval = 2000"
 	}
-	er.UpdatePortals = append(er.UpdatePortals,
-		&web.PortalUpdate{
-			Name: "worker_hiddenLogs",
-			Body: Div(logLines...),
-		},
-	)
 	return er, nil
 }
 
@@ -746,37 +923,53 @@ func (b *Builder) jobProgressing(
 	job string,
 	status string,
 	progress uint,
-	logs []string,
-	hasMoreLogs bool,
 	progressText string,
+	attempts []*JobAttempt,
+	parentID uint,
+	children []*workflowChild,
+	canDownload bool,
+	artifacts []*QorJobArtifact,
 ) HTMLComponent {
-	logLines := make([]HTMLComponent, 0, len(logs)+1)
-	if hasMoreLogs {
-		logLines = append(logLines, web.Portal(
-			VBtn("Load hidden logs").Attr("@click", web.Plaid().EventFunc("worker_loadHiddenLogs").
-				Query("jobID", id).
-				Query("currentCount", len(logs)).Go()).
-				Small(true).
-				Depressed(true).
-				Class("mb-3"),
-		).Name("worker_hiddenLogs"))
-	}
-	for _, l := range logs {
-		logLines = append(logLines, P().Style(`
-    margin: 0;
-    margin-bottom: 4px;`).Children(Text(l)))
-	}
-	// https://stackoverflow.com/a/44051405/10150757
-	var reverseStyle string
-	if len(logs) > 18 {
-		reverseStyle = "display: flex;flex-direction: column-reverse;"
-		for i, j := 0, len(logLines)-1; i < j; i, j = i+1, j-1 {
-			logLines[i], logLines[j] = logLines[j], logLines[i]
-		}
-	}
-	inRefresh := status == JobStatusNew || status == JobStatusRunning
+	inRefresh := status == JobStatusNew || status == JobStatusRunning || status == JobStatusRetrying
+	var failedAttempts []HTMLComponent
+	for _, a := range attempts {
+		if a.Error == "" {
+			continue
+		}
+		failedAttempts = append(failedAttempts, Div(
+			Text(fmt.Sprintf("#%d %s: %s", a.Attempt, a.FinishedAt.Local().Format("2006-01-02 15:04:05"), a.Error)),
+		).Class("text-body-2"))
+	}
+	var childLines []HTMLComponent
+	for _, c := range children {
+		childLines = append(childLines, Div(
+			A(Text(fmt.Sprintf("#%d", c.QorJobID))).Href(path.Join(b.mb.Info().ListingHref(), fmt.Sprint(c.QorJobID))),
+			Text(fmt.Sprintf(" %s[%d] %s: %s (%d%%)", c.Step, c.Index, c.Job, getTStatus(msgr, c.Status), c.Progress)),
+		).Class("text-body-2"))
+	}
+	var artifactLines []HTMLComponent
+	for _, a := range artifacts {
+		var name HTMLComponent = Text(a.Name)
+		if canDownload {
+			name = A(Text(a.Name)).Href(b.artifactDownloadURL(a))
+		}
+		var expires string
+		if a.ExpiresAt != nil {
+			expires = fmt.Sprintf(", %s %s", msgr.ArtifactExpiresAt, a.ExpiresAt.Local().Format("2006-01-02 15:04"))
+		}
+		artifactLines = append(artifactLines, Div(
+			name,
+			Text(fmt.Sprintf(" (%s%s)", humanize.Bytes(uint64(a.Size)), expires)),
+		).Class("text-body-2"))
+	}
 	eURL := path.Join(b.mb.Info().ListingHref(), fmt.Sprint(id))
 	return Div(
+		If(parentID != 0,
+			Div(Text(msgr.DetailTitleWorkflow)).Class("text-caption"),
+			Div(
+				A(Text(fmt.Sprintf("#%d", parentID))).Href(path.Join(b.mb.Info().ListingHref(), fmt.Sprint(parentID))),
+			).Class("mb-3"),
+		),
 		Div(Text(msgr.DetailTitleStatus)).Class("text-caption"),
 		Div().Class("d-flex align-center mb-5").Children(
 			Div().Style("width: 120px").Children(
@@ -785,28 +978,27 @@ func (b *Builder) jobProgressing(
 			VProgressLinear().Value(int(progress)),
 		),
 
-		Div(Text(msgr.DetailTitleLog)).Class("text-caption"),
-		Div().Class("mb-3").Style(fmt.Sprintf(`
-		background-color: #222;
-		color: #fff;
-		font-family: menlo,Roboto,Helvetica,Arial,sans-serif;
-		height: 300px;
-		padding: 8px;
-		overflow: auto;
-		box-sizing: border-box;
-		font-size: 12px;
-		line-height: 1;
-		%s
-		`, reverseStyle)).Children(
-			logLines...,
-		),
-
 		If(progressText != "",
 			Div().Class("mb-3").Children(
 				RawHTML(progressText),
 			),
 		),
 
+		If(len(artifactLines) > 0,
+			Div(Text(msgr.DetailTitleArtifacts)).Class("text-caption"),
+			Div(artifactLines...).Class("mb-3"),
+		),
+
+		If(len(childLines) > 0,
+			Div(Text(msgr.DetailTitleChildren)).Class("text-caption"),
+			Div(childLines...).Class("mb-3"),
+		),
+
+		If(len(failedAttempts) > 0,
+			Div(Text(msgr.DetailTitleAttempts)).Class("text-caption"),
+			Div(failedAttempts...).Class("mb-3"),
+		),
+
 		If(canEdit,
 			Div().Class("d-flex mt-3").Children(
 				VSpacer(),
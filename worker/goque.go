@@ -3,10 +3,10 @@ package worker
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"runtime/debug"
 	"strconv"
 	"time"
 
@@ -46,34 +46,80 @@ func NewGoQueQueue(db *gorm.DB) Queue {
 }
 
 func (q *goque) Add(ctx context.Context, job QueJobInterface) error {
+	return q.add(ctx, nil, job)
+}
+
+// AddInTx enqueues the job in the transaction tx, e.g. the one creating the job,
+// it returns errNotSQLTx if the conn pool of tx is not a *sql.Tx
+func (q *goque) AddInTx(ctx context.Context, tx *gorm.DB, job QueJobInterface) error {
+	sqlTx := sqlTxOf(tx)
+	if sqlTx == nil {
+		return errNotSQLTx
+	}
+	return q.add(ctx, sqlTx, job)
+}
+
+// Requeue expires the pending rows of the job and enqueues it again to run at runAt, in the transaction tx,
+// the expired rows are not delivered any more when the advisory lock of a dead worker is released
+func (q *goque) Requeue(ctx context.Context, tx *gorm.DB, job QueJobInterface, runAt time.Time) error {
+	sqlTx := sqlTxOf(tx)
+	if sqlTx == nil {
+		return errNotSQLTx
+	}
+	plan, err := q.plan(job)
+	if err != nil {
+		return err
+	}
 	jobInfo, err := job.GetJobInfo()
+	if err != nil {
+		return err
+	}
+	if err = tx.WithContext(ctx).Exec("UPDATE goque_jobs SET expired_at = ? WHERE queue = ? AND args->>0 = ? AND done_at IS NULL AND expired_at IS NULL",
+		tx.NowFunc(), plan.Queue, jobInfo.JobID).Error; err != nil {
+		return err
+	}
+	plan.RunAt = runAt
+	_, err = q.q.Enqueue(ctx, sqlTx, plan)
+	return err
+}
 
+func (q *goque) add(ctx context.Context, tx *sql.Tx, job QueJobInterface) error {
+	plan, err := q.plan(job)
 	if err != nil {
 		return err
 	}
+	_, err = q.q.Enqueue(ctx, tx, plan)
+	return err
+}
+
+// plan is the go-que plan of the job, the scheduled jobs run at their schedule time
+func (q *goque) plan(job QueJobInterface) (plan que.Plan, err error) {
+	jobInfo, err := job.GetJobInfo()
+	if err != nil {
+		return
+	}
 	runAt := time.Now()
 	if scheduler, ok := jobInfo.Argument.(Scheduler); ok && scheduler.GetScheduleTime() != nil {
 		runAt = scheduler.GetScheduleTime().In(time.Local)
 		job.SetStatus(JobStatusScheduled)
 	}
-
-	_, err = q.q.Enqueue(ctx, nil, que.Plan{
+	return que.Plan{
 		Queue: "worker_" + jobInfo.JobName,
 		Args:  que.Args(jobInfo.JobID, jobInfo.Argument),
 		RunAt: runAt,
-	})
-	if err != nil {
-		return err
-	}
-
-	return nil
+	}, nil
 }
 
-func (q *goque) run(ctx context.Context, job QueJobInterface) error {
-	job.StartRefresh()
-	defer job.StopRefresh()
-
-	return job.GetHandler()(ctx, job)
+// sqlTxOf returns the *sql.Tx of the transaction tx, nil if it is not one
+func sqlTxOf(tx *gorm.DB) *sql.Tx {
+	switch pool := tx.Statement.ConnPool.(type) {
+	case *sql.Tx:
+		return pool
+	case *gorm.PreparedStmtTX:
+		sqlTx, _ := pool.Tx.(*sql.Tx)
+		return sqlTx
+	}
+	return nil
 }
 
 func (q *goque) Kill(ctx context.Context, job QueJobInterface) error {
@@ -90,13 +136,17 @@ func (q *goque) Listen(jobDefs []*QorJobDefinition, getJob func(qorJobID uint) (
 		if jd.Handler == nil {
 			panic(fmt.Sprintf("job %s handler is nil", jd.Name))
 		}
+		maxPerformPerSecond := jd.RateLimit
+		if maxPerformPerSecond <= 0 {
+			maxPerformPerSecond = 2
+		}
 		worker, err := que.NewWorker(que.WorkerOptions{
 			Queue:                     "worker_" + jd.Name,
 			Mutex:                     q.q.Mutex(),
 			MaxLockPerSecond:          10,
 			MaxBufferJobsCount:        0,
-			MaxPerformPerSecond:       2,
-			MaxConcurrentPerformCount: 1,
+			MaxPerformPerSecond:       maxPerformPerSecond,
+			MaxConcurrentPerformCount: jd.concurrency(),
 			Perform: func(ctx context.Context, qj que.Job) (err error) {
 				var job QueJobInterface
 				{
@@ -115,77 +165,41 @@ func (q *goque) Listen(jobDefs []*QorJobDefinition, getJob func(qorJobID uint) (
 					}
 				}
 
-				defer func() {
-					if r := recover(); r != nil {
-						job.AddLog(string(debug.Stack()))
-						job.SetProgressText(fmt.Sprint(r))
-						job.SetStatus(JobStatusException)
-						panic(r)
-					}
-				}()
-
-				if job.GetStatus() == JobStatusCancelled {
-					return qj.Expire(ctx, errors.New("job is cancelled"))
-				}
-				if job.GetStatus() != JobStatusNew && job.GetStatus() != JobStatusScheduled {
-					job.SetStatus(JobStatusKilled)
-					return errors.New("invalid job status, current status: " + job.GetStatus())
-				}
-
-				err = job.SetStatus(JobStatusRunning)
-				if err != nil {
+				// go-que dequeues by run_at only, the priority is honored by the pool
+				// since the slot is taken after the job is locked
+				if err = jd.acquire(ctx); err != nil {
 					return err
 				}
-
-				hctx, cf := context.WithCancel(ctx)
-				hDoneC := make(chan struct{})
-				isAborted := false
-				go func() {
-					timer := time.NewTicker(time.Second)
-					for {
-						select {
-						case <-hDoneC:
-							return
-						case <-timer.C:
-							status, _ := job.FetchAndSetStatus()
-							if status == JobStatusKilled {
-								isAborted = true
-								cf()
-								return
-							}
-						}
-					}
-				}()
-				err = q.run(hctx, job)
-				if !isAborted {
-					hDoneC <- struct{}{}
-				}
-				if err != nil {
-					job.SetProgressText(err.Error())
-					job.SetStatus(JobStatusException)
-					return err
+				err = runJob(ctx, job)
+				jd.release()
+				var re *retryError
+				switch {
+				case err == nil:
+					return qj.Done(ctx)
+				case errors.Is(err, errJobCancelled), errors.Is(err, errJobAborted), errors.Is(err, errJobNotRunnable):
+					return qj.Expire(ctx, err)
+				case errors.As(err, &re):
+					return qj.RetryAfter(ctx, re.delay, re.err)
 				}
-				if isAborted {
-					return qj.Expire(ctx, errors.New("manually aborted"))
-				}
-
-				err = job.SetStatus(JobStatusDone)
-				if err != nil {
-					return err
-				}
-				return qj.Done(ctx)
+				return err
 			},
 		})
 		if err != nil {
 			panic(err)
 		}
 		q.wks = append(q.wks, worker)
+		jd.listenerStarted()
 		go func() {
-			if err := worker.Run(); err != nil {
+			err := worker.Run()
+			if err != nil {
 				q.db.Create(&GoQueError{
 					Error: fmt.Sprintf("worker Run() error: %s", err.Error()),
 				})
 			}
+			if errors.Is(err, que.ErrWorkerStoped) {
+				err = nil
+			}
+			jd.listenerStopped(err)
 		}()
 	}
 
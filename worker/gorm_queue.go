@@ -0,0 +1,312 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+// QorJobQueueItem is a pending job of the gorm queue, the row is deleted after the job is performed or removed
+type QorJobQueueItem struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+
+	QorJobID uint      `gorm:"index"`
+	JobName  string    `gorm:"index;size:255"`
+	Priority int       `gorm:"not null;default:0"`
+	RunAt    time.Time `gorm:"index"`
+
+	LockedBy string `gorm:"size:255"`
+	LockedAt *time.Time
+}
+
+// GormQueue is a Queue backed by a plain table, it works on any database gorm supports, e.g. SQLite, MySQL and Postgres.
+// Workers poll the table and lock a row by a conditional update, so that multiple processes can share the queue.
+type GormQueue struct {
+	db           *gorm.DB
+	holder       string
+	pollInterval time.Duration
+	lockTimeout  time.Duration
+
+	stopPolling context.CancelFunc
+	cancelRuns  context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+func NewGormQueue(db *gorm.DB) *GormQueue {
+	if db == nil {
+		panic("db can not be nil")
+	}
+	if err := db.AutoMigrate(&QorJobQueueItem{}); err != nil {
+		panic(err)
+	}
+	hostname, _ := os.Hostname()
+	return &GormQueue{
+		db:           db,
+		holder:       fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8]),
+		pollInterval: time.Second,
+		lockTimeout:  time.Minute * 5,
+	}
+}
+
+// PollInterval is how often an idle worker checks the table for due jobs, default is 1 second
+func (q *GormQueue) PollInterval(v time.Duration) *GormQueue {
+	q.pollInterval = v
+	return q
+}
+
+// LockTimeout is how long a locked row is kept from other workers without being renewed,
+// after that the row is considered abandoned by a crashed worker, default is 5 minutes
+func (q *GormQueue) LockTimeout(v time.Duration) *GormQueue {
+	q.lockTimeout = v
+	return q
+}
+
+func (q *GormQueue) Add(ctx context.Context, job QueJobInterface) error {
+	return q.AddInTx(ctx, q.db, job)
+}
+
+// AddInTx enqueues the job in the transaction tx, e.g. the one creating the job
+func (q *GormQueue) AddInTx(ctx context.Context, tx *gorm.DB, job QueJobInterface) error {
+	qorJobID, err := qorJobIDOf(job)
+	if err != nil {
+		return err
+	}
+	runAt, err := runAtOf(job)
+	if err != nil {
+		return err
+	}
+	jobInfo, err := job.GetJobInfo()
+	if err != nil {
+		return err
+	}
+	return tx.WithContext(ctx).Create(&QorJobQueueItem{
+		QorJobID: qorJobID,
+		JobName:  jobInfo.JobName,
+		Priority: priorityOf(job),
+		RunAt:    runAt,
+	}).Error
+}
+
+// Requeue replaces the rows of the job with a new one which runs at runAt, in the transaction tx
+func (q *GormQueue) Requeue(ctx context.Context, tx *gorm.DB, job QueJobInterface, runAt time.Time) error {
+	qorJobID, err := qorJobIDOf(job)
+	if err != nil {
+		return err
+	}
+	jobInfo, err := job.GetJobInfo()
+	if err != nil {
+		return err
+	}
+	tx = tx.WithContext(ctx)
+	if err = tx.Where("qor_job_id = ?", qorJobID).Delete(&QorJobQueueItem{}).Error; err != nil {
+		return err
+	}
+	return tx.Create(&QorJobQueueItem{
+		QorJobID: qorJobID,
+		JobName:  jobInfo.JobName,
+		Priority: priorityOf(job),
+		RunAt:    runAt,
+	}).Error
+}
+
+// Kill marks the job as killed, the worker running it cancels the handler context
+func (q *GormQueue) Kill(ctx context.Context, job QueJobInterface) error {
+	return job.SetStatus(JobStatusKilled)
+}
+
+// Remove cancels a job which is not started yet,
+// a job already locked by a worker is killed instead, since it may be running at the moment
+func (q *GormQueue) Remove(ctx context.Context, job QueJobInterface) error {
+	qorJobID, err := qorJobIDOf(job)
+	if err != nil {
+		return err
+	}
+	result := q.db.WithContext(ctx).Where("qor_job_id = ? AND locked_by = ?", qorJobID, "").
+		Delete(&QorJobQueueItem{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var locked int64
+		if err = q.db.WithContext(ctx).Model(&QorJobQueueItem{}).
+			Where("qor_job_id = ? AND locked_by <> ?", qorJobID, "").
+			Count(&locked).Error; err != nil {
+			return err
+		}
+		if locked > 0 {
+			return q.Kill(ctx, job)
+		}
+		return fmt.Errorf("job %d is not in the queue", qorJobID)
+	}
+	return job.SetStatus(JobStatusCancelled)
+}
+
+func (q *GormQueue) Listen(jobDefs []*QorJobDefinition, getJob func(qorJobID uint) (QueJobInterface, error)) error {
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+	q.stopPolling = stopPolling
+	q.cancelRuns = cancelRuns
+	for _, jd := range jobDefs {
+		if jd.Handler == nil {
+			panic(fmt.Sprintf("job %s handler is nil", jd.Name))
+		}
+		jd := jd
+		limiter := jd.newRateLimiter()
+		for i := 0; i < jd.concurrency(); i++ {
+			q.wg.Add(1)
+			jd.listenerStarted()
+			go func() {
+				defer q.wg.Done()
+				defer jd.listenerStopped(nil)
+				q.loop(pollCtx, runCtx, jd, limiter, getJob)
+			}()
+		}
+	}
+	return nil
+}
+
+func (q *GormQueue) loop(pollCtx context.Context, runCtx context.Context, jd *QorJobDefinition, limiter *rate.Limiter, getJob func(qorJobID uint) (QueJobInterface, error)) {
+	for pollCtx.Err() == nil {
+		item, err := q.lockNext(jd.Name)
+		if err != nil {
+			log.Printf("worker queue job_name: %s, lock error: %v\n", jd.Name, err)
+		}
+		if item != nil {
+			q.perform(pollCtx, runCtx, jd, limiter, item, getJob)
+			continue
+		}
+
+		select {
+		case <-pollCtx.Done():
+			return
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+// lockNext locks the due row of the job with the highest priority, the earliest first for equal priorities,
+// rows locked by a crashed worker are taken over after LockTimeout
+func (q *GormQueue) lockNext(jobName string) (item *QorJobQueueItem, err error) {
+	for {
+		now := q.db.NowFunc()
+		var items []*QorJobQueueItem
+		err = q.db.Where("job_name = ? AND run_at <= ? AND (locked_by = ? OR locked_at < ?)", jobName, now, "", now.Add(-q.lockTimeout)).
+			Order("priority desc, run_at, id").
+			Limit(1).
+			Find(&items).Error
+		if err != nil || len(items) == 0 {
+			return nil, err
+		}
+
+		candidate := items[0]
+		result := q.db.Model(&QorJobQueueItem{}).
+			Where("id = ? AND (locked_by = ? OR locked_at < ?)", candidate.ID, "", now.Add(-q.lockTimeout)).
+			Updates(map[string]interface{}{
+				"locked_by": q.holder,
+				"locked_at": now,
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		// another worker locked it first, try the next one
+		if result.RowsAffected == 0 {
+			continue
+		}
+		candidate.LockedBy = q.holder
+		candidate.LockedAt = &now
+		return candidate, nil
+	}
+}
+
+// perform takes a slot of the worker pool only after the row is locked, so that polling doesn't hold a slot
+func (q *GormQueue) perform(pollCtx context.Context, ctx context.Context, jd *QorJobDefinition, limiter *rate.Limiter, item *QorJobQueueItem, getJob func(qorJobID uint) (QueJobInterface, error)) {
+	// keep the lock while the job is waiting for the pool and running
+	renewDone := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(q.lockTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-renewDone:
+				return
+			case <-ticker.C:
+				if err := q.db.Model(&QorJobQueueItem{}).Where("id = ? AND locked_by = ?", item.ID, q.holder).
+					Update("locked_at", q.db.NowFunc()).Error; err != nil {
+					log.Printf("worker queue qor_job_id: %d, renew lock error: %v\n", item.QorJobID, err)
+				}
+			}
+		}
+	}()
+
+	if err := jd.acquire(pollCtx); err != nil {
+		close(renewDone)
+		// shutting down, leave the row to the other workers
+		if err := q.db.Model(&QorJobQueueItem{}).Where("id = ? AND locked_by = ?", item.ID, q.holder).
+			Updates(map[string]interface{}{
+				"locked_by": "",
+				"locked_at": nil,
+			}).Error; err != nil {
+			log.Printf("worker queue qor_job_id: %d, unlock error: %v\n", item.QorJobID, err)
+		}
+		return
+	}
+	if limiter != nil {
+		limiter.Wait(ctx)
+	}
+	job, err := getJob(item.QorJobID)
+	if err == nil {
+		err = runJob(ctx, job)
+	}
+	jd.release()
+	close(renewDone)
+
+	var re *retryError
+	if errors.As(err, &re) {
+		if err := q.db.Model(&QorJobQueueItem{}).Where("id = ? AND locked_by = ?", item.ID, q.holder).
+			Updates(map[string]interface{}{
+				"run_at":    q.db.NowFunc().Add(re.delay),
+				"locked_by": "",
+				"locked_at": nil,
+			}).Error; err != nil {
+			log.Printf("worker queue qor_job_id: %d, retry error: %v\n", item.QorJobID, err)
+		}
+		return
+	}
+	if err != nil && err != errJobCancelled && err != errJobAborted && err != errJobNotRunnable {
+		log.Printf("worker queue qor_job_id: %d, error: %v\n", item.QorJobID, err)
+	}
+
+	if err := q.db.Where("id = ? AND locked_by = ?", item.ID, q.holder).Delete(&QorJobQueueItem{}).Error; err != nil {
+		log.Printf("worker queue qor_job_id: %d, delete error: %v\n", item.QorJobID, err)
+	}
+}
+
+// Shutdown stops polling and waits for the running jobs, their handler contexts are cancelled once ctx is done
+func (q *GormQueue) Shutdown(ctx context.Context) error {
+	if q.stopPolling == nil {
+		return nil
+	}
+	q.stopPolling()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.cancelRuns()
+		return ctx.Err()
+	}
+}
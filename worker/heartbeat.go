@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var errHeartbeatExpired = errors.New("heartbeat expired, the worker running the job is gone")
+
+// the instance is saved on every status change as well, so a job that just started is not stale
+// even if its heartbeat is left from a previous attempt
+const staleJobCondition = "status = ? AND updated_at < ? AND (heartbeat_at IS NULL OR heartbeat_at < ?)"
+
+// Timeout cancels the handler context once a run takes longer than d, the run fails with ErrJobTimeout
+// and is retried according to the retry policy. 0 means no timeout which is the default.
+func (jb *JobBuilder) Timeout(d time.Duration) *JobBuilder {
+	jb.timeout = d
+	return jb
+}
+
+func (job *QorJobInstance) GetTimeout() time.Duration {
+	return job.jb.timeout
+}
+
+// HeartbeatTimeout is how long a running job can go without a heartbeat before the reaper
+// considers its worker crashed, default is 1 minute. Running jobs beat every 5 seconds.
+// 0 disables the reaper.
+func (b *Builder) HeartbeatTimeout(v time.Duration) *Builder {
+	b.heartbeatTimeout = v
+	return b
+}
+
+// ReaperInterval is how often the reaper checks for stale jobs, default is 30 seconds
+func (b *Builder) ReaperInterval(v time.Duration) *Builder {
+	b.reaperInterval = v
+	return b
+}
+
+// ReapStaleJobs recovers the running jobs whose heartbeat expired,
+// they are re-enqueued if the retry policy allows, otherwise they end in dead or exception.
+func (b *Builder) ReapStaleJobs(ctx context.Context) error {
+	if b.heartbeatTimeout <= 0 {
+		return nil
+	}
+	stale := b.db.NowFunc().Add(-b.heartbeatTimeout)
+	var insts []*QorJobInstance
+	if err := b.db.WithContext(ctx).
+		Where(staleJobCondition, JobStatusRunning, stale, stale).
+		Order("id").
+		Find(&insts).Error; err != nil {
+		return err
+	}
+	for _, inst := range insts {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := b.reapStaleJob(ctx, inst, stale); err != nil {
+			log.Printf("worker reap qor_job_id: %d, error: %v\n", inst.QorJobID, err)
+		}
+	}
+	return nil
+}
+
+func (b *Builder) reapStaleJob(ctx context.Context, inst *QorJobInstance, stale time.Time) error {
+	// claim the instance, so that it is reaped once by the processes sharing the database
+	res := b.db.WithContext(ctx).Model(&QorJobInstance{}).
+		Where("id = ? AND "+staleJobCondition, inst.ID, JobStatusRunning, stale, stale).
+		Update("heartbeat_at", b.db.NowFunc())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil
+	}
+
+	lastBeatAt := inst.UpdatedAt
+	if inst.HeartbeatAt != nil {
+		lastBeatAt = *inst.HeartbeatAt
+	}
+
+	jb := b.getJobBuilder(inst.Job)
+	latest, err := getModelQorJobInstance(b.db, inst.QorJobID)
+	if err != nil {
+		return err
+	}
+	if jb == nil || latest.ID != inst.ID {
+		// the job is not registered any more or the instance is replaced by a newer one
+		if err = b.db.Model(&QorJobInstance{}).Where("id = ?", inst.ID).Update("status", JobStatusException).Error; err != nil {
+			return err
+		}
+		if latest.ID == inst.ID {
+			return b.setStatus(inst.QorJobID, JobStatusException)
+		}
+		return nil
+	}
+
+	inst.jb = jb
+	inst.AddLogf("%v, last heartbeat at %s", errHeartbeatExpired, lastBeatAt.Format(time.RFC3339))
+	var retry, requeued bool
+	err = b.db.Transaction(func(tx *gorm.DB) (err error) {
+		inst.tx = tx
+		defer func() { inst.tx = nil }()
+		if err = inst.SetProgressText(errHeartbeatExpired.Error()); err != nil {
+			return err
+		}
+		var retryIn time.Duration
+		if retryIn, retry, err = inst.RecordAttempt(lastBeatAt, errHeartbeatExpired); err != nil || !retry {
+			return err
+		}
+		// the stale delivery is replaced, so that the job is not performed twice
+		if rq, ok := b.q.(requeuer); ok {
+			err = rq.Requeue(ctx, tx, inst, b.db.NowFunc().Add(retryIn))
+			if errors.Is(err, errNotSQLTx) {
+				return nil
+			}
+			requeued = err == nil
+		}
+		return err
+	})
+	if err != nil || !retry {
+		return err
+	}
+	if requeued {
+		b.metrics.enqueued(inst.Job)
+		return nil
+	}
+	return b.enqueue(ctx, inst)
+}
@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyKeyFunc returns the key that identifies duplicated jobs, an empty key disables the deduplication of the job
+type IdempotencyKeyFunc func(args interface{}, context map[string]interface{}) (string, error)
+
+// pendingJobStatuses are the statuses of the jobs a duplicate is deduplicated to
+var pendingJobStatuses = []string{JobStatusNew, JobStatusScheduled, JobStatusRunning, JobStatusRetrying}
+
+// DedupWindow enables the deduplication of the job, creating a job whose idempotency key equals the one of
+// a pending or running job created within the window returns that job instead of enqueuing another.
+// 0 disables the deduplication which is the default.
+func (jb *JobBuilder) DedupWindow(d time.Duration) *JobBuilder {
+	jb.dedupWindow = d
+	return jb
+}
+
+// IdempotencyKey sets how the idempotency key is derived, by default it is the hash of the job name and the arguments.
+// It has no effect unless DedupWindow is set.
+func (jb *JobBuilder) IdempotencyKey(f IdempotencyKeyFunc) *JobBuilder {
+	jb.idempotencyKey = f
+	return jb
+}
+
+func (jb *JobBuilder) getIdempotencyKey(args interface{}, context map[string]interface{}) (string, error) {
+	if jb.dedupWindow <= 0 {
+		return "", nil
+	}
+	if jb.idempotencyKey != nil {
+		key, err := jb.idempotencyKey(args, context)
+		if err != nil || key == "" {
+			return key, err
+		}
+		return hashIdempotencyKey(jb.name, key), nil
+	}
+	bArgs, ok := args.(string)
+	if !ok {
+		b, err := json.Marshal(args)
+		if err != nil {
+			return "", err
+		}
+		bArgs = string(b)
+	}
+	return hashIdempotencyKey(jb.name, bArgs), nil
+}
+
+// the key is hashed with the job name so the custom keys of different jobs never collide and fit the column
+func hashIdempotencyKey(job string, key string) string {
+	sum := sha256.Sum256([]byte(job + "\n" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// QorJobIdempotencyLock is a row per idempotency key, the creations of the jobs with the key lock it
+// in their transactions, so that the deduplication holds across the processes sharing the database
+type QorJobIdempotencyLock struct {
+	IdempotencyKey string `gorm:"primarykey;size:64"`
+	LockedAt       time.Time
+}
+
+// lockIdempotencyKey creates the lock row of the key if it doesn't exist and updates it,
+// the update blocks the other transactions locking the key until tx ends
+func lockIdempotencyKey(tx *gorm.DB, key string) error {
+	now := tx.NowFunc()
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&QorJobIdempotencyLock{IdempotencyKey: key, LockedAt: now}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&QorJobIdempotencyLock{}).Where("idempotency_key = ?", key).Update("locked_at", now).Error
+}
+
+// findDuplicatedJob returns the pending or running job with the key created within the dedup window, nil if there is none
+func (b *Builder) findDuplicatedJob(ctx context.Context, tx *gorm.DB, jb *JobBuilder, key string) (*QorJob, error) {
+	var js []*QorJob
+	if err := tx.WithContext(ctx).Where("job = ? AND idempotency_key = ? AND status IN ? AND created_at > ?",
+		jb.name, key, pendingJobStatuses, b.db.NowFunc().Add(-jb.dedupWindow)).
+		Order("id DESC").
+		Limit(1).
+		Find(&js).Error; err != nil {
+		return nil, err
+	}
+	if len(js) == 0 {
+		return nil, nil
+	}
+	js[0].Deduplicated = true
+	return js[0], nil
+}
@@ -0,0 +1,103 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/activity"
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/admin/worker"
+	"gorm.io/gorm"
+)
+
+type WebhookProduct struct {
+	ID   uint `gorm:"primary_key"`
+	Name string
+}
+
+func TestActivityWebhook(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+
+	var (
+		mu       sync.Mutex
+		requests int
+		events   []*activity.ActivityEvent
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := worker.VerifyActivityWebhook(r, body, "secret", time.Minute); err != nil {
+			t.Errorf("want the webhook signed, but got %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// fails the first post to be retried
+		if requests++; requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		e := &activity.ActivityEvent{}
+		if err := json.Unmarshal(body, e); err != nil {
+			t.Error(err)
+		}
+		events = append(events, e)
+	}))
+	defer srv.Close()
+
+	wb := worker.NewWithQueue(db, worker.NewMemoryQueue())
+	ab := activity.New(presets.New(), db)
+	ab.RegisterModel(&WebhookProduct{})
+	ab.RegisterSink(wb.ActivityWebhook("test", srv.URL, "secret").
+		RetryPolicy(&worker.RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond})).
+		Actions(activity.ActivityCreate)
+	wb.Listen()
+	defer wb.Shutdown(ctx)
+
+	if err := ab.AddRecords(activity.ActivityCreate, activity.ContextWithCreator(ctx, "creator a"), &WebhookProduct{ID: 1, Name: "p"}); err != nil {
+		t.Fatal(err)
+	}
+	// filtered out by the action
+	if err := ab.AddRecords(activity.ActivityDelete, ctx, &WebhookProduct{ID: 1, Name: "p"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var j worker.QorJob
+	if err := db.Where("job = ?", "activity-webhook-test").Order("id DESC").First(&j).Error; err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusDone)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 || len(events) != 1 {
+		t.Fatalf("want the event posted after a retry, but got %d requests %#+v", requests, events)
+	}
+	if e := events[0]; e.ModelName != "WebhookProduct" || e.ModelKeys != "1" || e.Action != activity.ActivityCreate || e.Creator != "creator a" {
+		t.Errorf("want the event of the created product, but got %#+v", e)
+	}
+
+	// the job is created in the transaction of the log, so no post is sent for a rolled back log
+	rollback := errors.New("rollback")
+	err := ab.Transaction(db, func(tx *gorm.DB) error {
+		if err := ab.AddCreateRecord("creator a", &WebhookProduct{ID: 2, Name: "q"}, tx); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("want the transaction rolled back, but got %v", err)
+	}
+
+	var count int64
+	db.Model(&worker.QorJob{}).Where("job = ?", "activity-webhook-test").Count(&count)
+	if count != 1 {
+		t.Errorf("want 1 webhook job, but got %d", count)
+	}
+}
@@ -0,0 +1,147 @@
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qor/oss/filesystem"
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/admin/presets/gorm2op"
+	"github.com/qor5/admin/worker"
+	"github.com/qor5/x/perm"
+)
+
+func TestJobArtifacts(t *testing.T) {
+	cleanData()
+	db.Exec("delete from qor_job_artifacts")
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	qpb := presets.New().DataOperator(gorm2op.DataOperator(db))
+	wb := worker.NewWithQueue(db, worker.NewMemoryQueue()).
+		ArtifactStorage(filesystem.New(dir)).
+		ArtifactRetention(time.Hour)
+	wb.Configure(qpb)
+	wb.NewJob("artifactJob").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			return job.AddArtifact("report.csv", strings.NewReader("id,name\n1,foo\n"))
+		})
+	wb.Listen()
+	defer wb.Shutdown(ctx)
+
+	j, err := wb.AddJob(ctx, "artifactJob", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusDone)
+
+	a := &worker.QorJobArtifact{}
+	if err = db.Where("qor_job_id = ?", j.ID).First(a).Error; err != nil {
+		t.Fatal(err)
+	}
+	if a.Name != "report.csv" || a.Size != 14 || a.ExpiresAt == nil {
+		t.Errorf("unexpected artifact %#+v", a)
+	}
+
+	// listed on the detail page
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/workers?__execute_event__=worker_updateJobProgressing&job=artifactJob&jobID=%d", j.ID), nil)
+	w := httptest.NewRecorder()
+	qpb.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "report.csv") {
+		t.Errorf("want artifact listed in the job detail")
+	}
+
+	// download
+	downloadURL := fmt.Sprintf("/workers/%d?__worker_artifact__=%d", j.ID, a.ID)
+	r = httptest.NewRequest(http.MethodGet, downloadURL, nil)
+	w = httptest.NewRecorder()
+	qpb.ServeHTTP(w, r)
+	if w.Body.String() != "id,name\n1,foo\n" {
+		t.Errorf("unexpected download body %q", w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=report.csv` {
+		t.Errorf("unexpected content disposition %q", cd)
+	}
+
+	// expire
+	db.Model(&worker.QorJobArtifact{}).Where("id = ?", a.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	r = httptest.NewRequest(http.MethodGet, downloadURL, nil)
+	w = httptest.NewRecorder()
+	qpb.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("want expired artifact not found, got %d", w.Code)
+	}
+	if err = wb.DeleteExpiredArtifacts(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&worker.QorJobArtifact{}).Count(&count)
+	if count != 0 {
+		t.Errorf("want expired artifact deleted, got %d", count)
+	}
+	if _, err = os.Stat(filepath.Join(dir, a.Path)); !os.IsNotExist(err) {
+		t.Errorf("want artifact file deleted, got %v", err)
+	}
+}
+
+func TestJobArtifactsOfSameNameAndPermission(t *testing.T) {
+	cleanData()
+	db.Exec("delete from qor_job_artifacts")
+	ctx := context.Background()
+
+	qpb := presets.New().DataOperator(gorm2op.DataOperator(db)).
+		Permission(perm.New().Policies(
+			perm.PolicyFor(perm.Anybody).WhoAre(perm.Allowed).ToDo(perm.Anything).On("*"),
+			perm.PolicyFor("guest").WhoAre(perm.Denied).ToDo(presets.PermGet).On("*:workers:*"),
+		).SubjectsFunc(func(r *http.Request) []string {
+			return []string{r.Header.Get("X-Role")}
+		}))
+	wb := worker.NewWithQueue(db, worker.NewMemoryQueue()).
+		ArtifactStorage(filesystem.New(t.TempDir()))
+	wb.Configure(qpb)
+	wb.NewJob("sameNameArtifactJob").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			for _, content := range []string{"a", "b"} {
+				if err := job.AddArtifact("report.csv", strings.NewReader(content)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	wb.Listen()
+	defer wb.Shutdown(ctx)
+
+	j, err := wb.AddJob(ctx, "sameNameArtifactJob", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusDone)
+
+	var artifacts []*worker.QorJobArtifact
+	db.Where("qor_job_id = ?", j.ID).Order("id").Find(&artifacts)
+	if len(artifacts) != 2 || artifacts[0].Path == artifacts[1].Path {
+		t.Fatalf("want 2 artifacts in their own files, but got %#+v", artifacts)
+	}
+	download := func(a *worker.QorJobArtifact, role string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/workers/%d?__worker_artifact__=%d", j.ID, a.ID), nil)
+		r.Header.Set("X-Role", role)
+		w := httptest.NewRecorder()
+		qpb.ServeHTTP(w, r)
+		return w
+	}
+	for i, want := range []string{"a", "b"} {
+		if w := download(artifacts[i], ""); w.Body.String() != want {
+			t.Errorf("want the artifact %d content %q, but got %q", i, want, w.Body.String())
+		}
+	}
+	if w := download(artifacts[0], "guest"); w.Code != http.StatusForbidden {
+		t.Errorf("want the artifact of the job not permitted to get forbidden, but got %d", w.Code)
+	}
+}
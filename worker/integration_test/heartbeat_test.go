@@ -0,0 +1,191 @@
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/worker"
+	"gorm.io/gorm"
+)
+
+func TestJobTimeoutAndReaper(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+	wb := worker.NewWithQueue(db, worker.NewMemoryQueue()).
+		HeartbeatTimeout(time.Minute).
+		ReaperInterval(time.Hour)
+	wb.NewJob("timeoutJob").
+		Timeout(50 * time.Millisecond).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			<-ctx.Done()
+			return nil
+		})
+	var reapedRuns int32
+	wb.NewJob("reapRetryJob").
+		RetryPolicy(&worker.RetryPolicy{MaxAttempts: 2, InitialBackoff: 200 * time.Millisecond}).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			atomic.AddInt32(&reapedRuns, 1)
+			return nil
+		})
+	wb.NewJob("reapFailJob").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			return nil
+		})
+	wb.Listen()
+	defer wb.Shutdown(ctx)
+
+	// timeout cancels the handler
+	j, err := wb.AddJob(ctx, "timeoutJob", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusException)
+	if inst := mustGetJobInstance(t, j.ID); !strings.Contains(inst.ProgressText, worker.ErrJobTimeout.Error()) {
+		t.Errorf("want timeout error, got %q", inst.ProgressText)
+	}
+
+	// jobs left running by a crashed worker
+	crashed := func(name string, heartbeatAt time.Time) (*worker.QorJob, *worker.QorJobInstance) {
+		j := &worker.QorJob{Job: name, Status: worker.JobStatusRunning}
+		if err := db.Create(j).Error; err != nil {
+			t.Fatal(err)
+		}
+		inst := &worker.QorJobInstance{
+			Model:       gorm.Model{UpdatedAt: heartbeatAt},
+			QorJobID:    j.ID,
+			Job:         name,
+			Status:      worker.JobStatusRunning,
+			Args:        "null",
+			Context:     "{}",
+			HeartbeatAt: &heartbeatAt,
+		}
+		if err := db.Create(inst).Error; err != nil {
+			t.Fatal(err)
+		}
+		return j, inst
+	}
+	retryJob, _ := crashed("reapRetryJob", time.Now().Add(-2*time.Minute))
+	failJob, _ := crashed("reapFailJob", time.Now().Add(-2*time.Minute))
+	aliveJob, _ := crashed("reapFailJob", time.Now())
+
+	if err = wb.ReapStaleJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&reapedRuns) != 0 {
+		t.Errorf("want reaped job performed after the backoff")
+	}
+	waitJobStatus(t, retryJob.ID, worker.JobStatusDone)
+	if atomic.LoadInt32(&reapedRuns) != 1 {
+		t.Errorf("want reaped job performed again once, got %d", reapedRuns)
+	}
+	inst := mustGetJobInstance(t, retryJob.ID)
+	attempts, err := inst.GetAttempts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 || !strings.Contains(attempts[0].Error, "heartbeat expired") {
+		t.Errorf("unexpected attempts %#+v", attempts)
+	}
+	waitJobStatus(t, failJob.ID, worker.JobStatusException)
+	waitJobStatus(t, aliveJob.ID, worker.JobStatusRunning)
+}
+
+func TestReaperReplacesStaleQueueRow(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+	wb := worker.NewWithQueue(db, worker.NewGormQueue(db)).
+		HeartbeatTimeout(time.Minute).
+		ReaperInterval(time.Hour)
+	wb.NewJob("reapGormJob").
+		RetryPolicy(&worker.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Hour}).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			return nil
+		})
+
+	heartbeatAt := time.Now().Add(-2 * time.Minute)
+	j := &worker.QorJob{Job: "reapGormJob", Status: worker.JobStatusRunning}
+	if err := db.Create(j).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&worker.QorJobInstance{
+		Model:       gorm.Model{UpdatedAt: heartbeatAt},
+		QorJobID:    j.ID,
+		Job:         "reapGormJob",
+		Status:      worker.JobStatusRunning,
+		Args:        "null",
+		Context:     "{}",
+		HeartbeatAt: &heartbeatAt,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.Where("qor_job_id = ?", j.ID).Delete(&worker.QorJobQueueItem{})
+	if err := db.Create(&worker.QorJobQueueItem{QorJobID: j.ID, JobName: "reapGormJob", RunAt: heartbeatAt, LockedBy: "crashed worker", LockedAt: &heartbeatAt}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wb.ReapStaleJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusRetrying)
+	var items []*worker.QorJobQueueItem
+	db.Where("qor_job_id = ?", j.ID).Find(&items)
+	if len(items) != 1 || items[0].LockedBy != "" || items[0].RunAt.Before(time.Now().Add(50*time.Minute)) {
+		t.Errorf("want the stale row replaced by one running after the backoff, got %#+v", items)
+	}
+	db.Where("qor_job_id = ?", j.ID).Delete(&worker.QorJobQueueItem{})
+}
+
+func TestReaperRequeuesGoQueJob(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+	wb := worker.New(db).
+		HeartbeatTimeout(time.Minute).
+		ReaperInterval(time.Hour)
+	wb.NewJob("reapGoQueJob").
+		RetryPolicy(&worker.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Hour}).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			return nil
+		})
+
+	heartbeatAt := time.Now().Add(-2 * time.Minute)
+	j := &worker.QorJob{Job: "reapGoQueJob", Status: worker.JobStatusRunning}
+	if err := db.Create(j).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&worker.QorJobInstance{
+		Model:       gorm.Model{UpdatedAt: heartbeatAt},
+		QorJobID:    j.ID,
+		Job:         "reapGoQueJob",
+		Status:      worker.JobStatusRunning,
+		Args:        "null",
+		Context:     "{}",
+		HeartbeatAt: &heartbeatAt,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// the row delivered to the crashed worker, it is delivered again once the advisory lock of the worker is released
+	db.Exec("DELETE FROM goque_jobs WHERE queue = ?", "worker_reapGoQueJob")
+	if err := db.Exec("INSERT INTO goque_jobs (queue, args, run_at, retry_policy) VALUES (?, ?, ?, ?)",
+		"worker_reapGoQueJob", fmt.Sprintf(`["%d",null]`, j.ID), heartbeatAt, "{}").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wb.ReapStaleJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusRetrying)
+	var rows []struct {
+		RunAt     time.Time
+		ExpiredAt *time.Time
+	}
+	db.Raw("SELECT run_at, expired_at FROM goque_jobs WHERE queue = ? AND done_at IS NULL ORDER BY id", "worker_reapGoQueJob").Scan(&rows)
+	if len(rows) != 2 || rows[0].ExpiredAt == nil || rows[1].ExpiredAt != nil || rows[1].RunAt.Before(time.Now().Add(50*time.Minute)) {
+		t.Errorf("want the stale row expired and the job enqueued after the backoff, got %#+v", rows)
+	}
+	db.Exec("DELETE FROM goque_jobs WHERE queue = ?", "worker_reapGoQueJob")
+}
@@ -0,0 +1,152 @@
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/worker"
+)
+
+type dedupArgs struct {
+	To      string
+	Subject string
+}
+
+func TestJobDeduplication(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+	wb := worker.NewWithQueue(db, worker.NewMemoryQueue())
+	release := make(chan struct{})
+	releaseByTo := make(chan struct{})
+	wb.NewJob("dedupJob").
+		Resource(&dedupArgs{}).
+		DedupWindow(time.Hour).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			<-release
+			return nil
+		})
+	wb.NewJob("dedupByToJob").
+		Resource(&dedupArgs{}).
+		DedupWindow(time.Hour).
+		IdempotencyKey(func(args interface{}, context map[string]interface{}) (string, error) {
+			return args.(*dedupArgs).To, nil
+		}).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			<-releaseByTo
+			return nil
+		})
+	wb.NewJob("noDedupJob").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			return nil
+		})
+	wb.Listen()
+	defer wb.Shutdown(ctx)
+
+	j1, err := wb.AddJob(ctx, "dedupJob", &dedupArgs{To: "a@example.com", Subject: "hi"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j1.ID, worker.JobStatusRunning)
+	j2, err := wb.AddJob(ctx, "dedupJob", &dedupArgs{To: "a@example.com", Subject: "hi"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j2.ID != j1.ID || !j2.Deduplicated || j1.Deduplicated {
+		t.Errorf("want the running job returned, got %d %v", j2.ID, j2.Deduplicated)
+	}
+	j3, err := wb.AddJob(ctx, "dedupJob", &dedupArgs{To: "b@example.com", Subject: "hi"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j3.ID == j1.ID || j3.Deduplicated {
+		t.Errorf("want a new job for other arguments")
+	}
+	close(release)
+	waitJobStatus(t, j1.ID, worker.JobStatusDone)
+	waitJobStatus(t, j3.ID, worker.JobStatusDone)
+
+	// finished jobs are not deduplicated
+	j4, err := wb.AddJob(ctx, "dedupJob", &dedupArgs{To: "a@example.com", Subject: "hi"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j4.ID == j1.ID {
+		t.Errorf("want a new job once the previous one is done")
+	}
+	waitJobStatus(t, j4.ID, worker.JobStatusDone)
+
+	// custom key, and the window
+	addByTo := func(subject string) *worker.QorJob {
+		t.Helper()
+		j, err := wb.AddJob(ctx, "dedupByToJob", &dedupArgs{To: "a@example.com", Subject: subject}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+	j5 := addByTo("hi")
+	if j6 := addByTo("hello"); j6.ID != j5.ID {
+		t.Errorf("want deduplicated by the custom key, got %d and %d", j5.ID, j6.ID)
+	}
+	db.Model(&worker.QorJob{}).Where("id = ?", j5.ID).Update("created_at", time.Now().Add(-2*time.Hour))
+	if j7 := addByTo("hi"); j7.ID == j5.ID {
+		t.Errorf("want a new job out of the window")
+	}
+	close(releaseByTo)
+
+	// deduplication is off by default
+	var ids []string
+	for i := 0; i < 2; i++ {
+		j, err := wb.AddJob(ctx, "noDedupJob", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, fmt.Sprint(j.ID))
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("want two jobs without deduplication")
+	}
+}
+
+func TestJobDeduplicationAcrossBuilders(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+	// the builders share the database like the processes of a deployment
+	var wbs []*worker.Builder
+	for i := 0; i < 2; i++ {
+		wb := worker.NewWithQueue(db, worker.NewGormQueue(db))
+		wb.NewJob("dedupConcurrentJob").
+			Resource(&dedupArgs{}).
+			DedupWindow(time.Hour).
+			Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+				return nil
+			})
+		wbs = append(wbs, wb)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(wb *worker.Builder) {
+			defer wg.Done()
+			if _, err := wb.AddJob(ctx, "dedupConcurrentJob", &dedupArgs{To: "a@example.com"}, nil); err != nil {
+				errs <- err
+			}
+		}(wbs[i%2])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&worker.QorJob{}).Where("job = ?", "dedupConcurrentJob").Count(&count)
+	if count != 1 {
+		t.Errorf("want one job created by the concurrent creations, got %d", count)
+	}
+	db.Where("job_name = ?", "dedupConcurrentJob").Delete(&worker.QorJobQueueItem{})
+}
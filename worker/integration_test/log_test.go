@@ -0,0 +1,119 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/admin/presets/gorm2op"
+	"github.com/qor5/admin/worker"
+)
+
+func TestJobLogs(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+	qpb := presets.New().DataOperator(gorm2op.DataOperator(db))
+	wb := worker.NewWithQueue(db, worker.NewMemoryQueue()).
+		LogRetention(time.Hour, worker.LogLevelDebug)
+	wb.Configure(qpb)
+	wb.NewJob("logJob").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			job.Log(worker.LogLevelDebug, "connecting", "host", "db1")
+			job.AddLog("importing")
+			job.Log(worker.LogLevelWarn, "skipped", "id", 3, "reason", "duplicated")
+			job.Log(worker.LogLevelError, "failed", "id", 4, "error", errors.New("bad row"))
+			return nil
+		})
+	wb.Listen()
+	defer wb.Shutdown(ctx)
+
+	j, err := wb.AddJob(ctx, "logJob", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusDone)
+
+	type logsResponse struct {
+		Status  string
+		Logs    []*worker.JobLogEntry
+		HasMore bool
+	}
+	getLogs := func(query string) (res logsResponse) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/workers/%d?__worker_logs__=1&%s", j.ID, query), nil)
+		w := httptest.NewRecorder()
+		qpb.ServeHTTP(w, r)
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: %v", w.Body.String(), err)
+		}
+		return
+	}
+	messages := func(entries []*worker.JobLogEntry) string {
+		var ms []string
+		for _, e := range entries {
+			ms = append(ms, e.Message)
+		}
+		return strings.Join(ms, ",")
+	}
+
+	res := getLogs("")
+	if got := messages(res.Logs); got != "connecting,importing,skipped,failed" || res.Status != worker.JobStatusDone {
+		t.Fatalf("unexpected logs %s, status %s", got, res.Status)
+	}
+	if l := res.Logs[3]; l.Level != worker.LogLevelError || len(l.Fields) != 2 || l.Fields[1].Value != "bad row" {
+		t.Errorf("unexpected log %#+v", l)
+	}
+	if got := messages(getLogs("level=warn").Logs); got != "skipped,failed" {
+		t.Errorf("want warn and error logs, got %s", got)
+	}
+	if got := messages(getLogs("q=DUPLICATED").Logs); got != "skipped" {
+		t.Errorf("want logs matched by fields, got %s", got)
+	}
+	if got := messages(getLogs(fmt.Sprintf("afterID=%d", res.Logs[1].ID)).Logs); got != "skipped,failed" {
+		t.Errorf("want logs after the id, got %s", got)
+	}
+
+	// server-sent events end once the job is finished
+	r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/workers/%d?__worker_logs__=1", j.ID), nil)
+	r.Header.Set("Accept", "text/event-stream")
+	r.Header.Set("Last-Event-ID", fmt.Sprint(res.Logs[2].ID))
+	w := httptest.NewRecorder()
+	qpb.ServeHTTP(w, r)
+	want := fmt.Sprintf("id: %d\nevent: log\ndata: ", res.Logs[3].ID)
+	if body := w.Body.String(); !strings.Contains(body, want) || strings.Contains(body, "skipped") || !strings.HasSuffix(body, "event: end\ndata: done\n\n") {
+		t.Errorf("unexpected event stream %q", body)
+	}
+
+	// incremental loading in the detail page
+	r = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/workers/%d?__execute_event__=worker_loadJobLogs&jobID=%d&afterID=%d", j.ID, j.ID, res.Logs[2].ID), nil)
+	w = httptest.NewRecorder()
+	qpb.ServeHTTP(w, r)
+	er := struct {
+		VarsScript string `json:"varsScript"`
+	}{}
+	if err = json.Unmarshal(w.Body.Bytes(), &er); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(er.VarsScript, "vars.worker_logs = vars.worker_logs.concat(") ||
+		!strings.Contains(er.VarsScript, `"Message":"failed"`) ||
+		strings.Contains(er.VarsScript, `"Message":"skipped"`) {
+		t.Errorf("unexpected vars script %s", er.VarsScript)
+	}
+
+	// retention
+	db.Model(&worker.QorJobLog{}).Where("id IN ?", []uint{res.Logs[0].ID, res.Logs[1].ID}).
+		Update("created_at", time.Now().Add(-2*time.Hour))
+	if err = wb.DeleteExpiredLogs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(getLogs("").Logs); got != "importing,skipped,failed" {
+		t.Errorf("want expired debug logs deleted, got %s", got)
+	}
+}
@@ -0,0 +1,95 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/worker"
+)
+
+func TestMetricsAndHealth(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+	wb := worker.NewWithQueue(db, worker.NewMemoryQueue()).MetricsBuckets(1, 10)
+	wb.NewJob("metricsJob").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			return nil
+		})
+	wb.NewJob("metricsFailJob").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			return errors.New("boom")
+		})
+
+	getHealth := func() (h worker.Health, code int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		wb.HealthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+			t.Fatal(err)
+		}
+		return h, w.Code
+	}
+	if h, code := getHealth(); h.Status != worker.HealthStatusUnhealthy || code != http.StatusServiceUnavailable {
+		t.Errorf("want unhealthy before listening, got %s %d", h.Status, code)
+	}
+
+	wb.Listen()
+	for i := 0; i < 2; i++ {
+		j, err := wb.AddJob(ctx, "metricsJob", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitJobStatus(t, j.ID, worker.JobStatusDone)
+	}
+	j, err := wb.AddJob(ctx, "metricsFailJob", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusException)
+
+	w := httptest.NewRecorder()
+	wb.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE worker_jobs_enqueued_total counter",
+		`worker_jobs_enqueued_total{job="metricsJob"} 2`,
+		`worker_jobs_started_total{job="metricsJob"} 2`,
+		`worker_jobs_succeeded_total{job="metricsJob"} 2`,
+		`worker_jobs_failed_total{job="metricsJob"} 0`,
+		`worker_jobs_failed_total{job="metricsFailJob"} 1`,
+		`worker_jobs_running{job="metricsJob"} 0`,
+		`worker_job_run_duration_seconds_bucket{job="metricsJob",le="1"} 2`,
+		`worker_job_run_duration_seconds_bucket{job="metricsJob",le="+Inf"} 2`,
+		`worker_job_queue_lag_seconds_count{job="metricsJob"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in the metrics:\n%s", want, body)
+		}
+	}
+
+	h, code := getHealth()
+	if h.Status != worker.HealthStatusOK || code != http.StatusOK || len(h.Jobs) != 2 {
+		t.Fatalf("want healthy, got %d %#+v", code, h)
+	}
+	for _, jh := range h.Jobs {
+		if !jh.Listening || jh.Listeners != 1 {
+			t.Errorf("want %s listening, got %#+v", jh.Name, jh)
+		}
+		if jh.Name == "metricsFailJob" && (jh.LastError != "boom" || jh.LastFailedAt == nil) {
+			t.Errorf("want last error of %s, got %#+v", jh.Name, jh)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	wb.Shutdown(ctx)
+	if h, _ = getHealth(); h.Status != worker.HealthStatusUnhealthy || h.Jobs[0].Listening {
+		t.Errorf("want unhealthy after shutdown, got %#+v", h)
+	}
+}
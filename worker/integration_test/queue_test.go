@@ -0,0 +1,303 @@
+package integration_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/admin/presets/gorm2op"
+	"github.com/qor5/admin/worker"
+)
+
+type QueueScheduleJobResource struct {
+	worker.Schedule
+}
+
+func newQueueBuilder(q worker.Queue) (*worker.Builder, *presets.Builder) {
+	qpb := presets.New().DataOperator(gorm2op.DataOperator(db))
+	wb := worker.NewWithQueue(db, q)
+	wb.Configure(qpb)
+
+	wb.NewJob("queueJob").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			job.AddLog("performed")
+			return nil
+		})
+	wb.NewJob("queueLongRunningJob").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			<-ctx.Done()
+			job.AddLog("job aborted")
+			return nil
+		})
+	wb.NewJob("queueScheduleJob").
+		Resource(&QueueScheduleJobResource{}).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			job.AddLog("performed")
+			return nil
+		})
+	var retryRuns int32
+	wb.NewJob("queueRetryJob").
+		RetryPolicy(&worker.RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			if atomic.AddInt32(&retryRuns, 1) < 3 {
+				return errors.New("temporary error")
+			}
+			return nil
+		})
+	wb.NewJob("queueDeadJob").
+		RetryPolicy(&worker.RetryPolicy{MaxAttempts: 5, InitialBackoff: 10 * time.Millisecond}).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			return worker.NonRetryable(errors.New("permanent error"))
+		})
+	wb.Listen()
+	return wb, qpb
+}
+
+func waitJobStatus(t *testing.T, id uint, status string) {
+	t.Helper()
+	j := &worker.QorJob{}
+	for i := 0; i < 100; i++ {
+		if err := db.First(j, id).Error; err != nil {
+			t.Fatal(err)
+		}
+		if j.Status == status {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("want status %q, got %q", status, j.Status)
+}
+
+func abortJob(qpb *presets.Builder, job string, id uint) {
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf(`/workers/%d?__execute_event__=worker_abortJob&job=%s&jobID=%d`, id, job, id), nil)
+	qpb.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func testQueue(t *testing.T, q worker.Queue) {
+	cleanData()
+	wb, qpb := newQueueBuilder(q)
+	defer wb.Shutdown(context.Background())
+	ctx := context.Background()
+
+	// perform
+	j, err := wb.AddJob(ctx, "queueJob", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusDone)
+
+	// kill
+	j, err = wb.AddJob(ctx, "queueLongRunningJob", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusRunning)
+	abortJob(qpb, "queueLongRunningJob", j.ID)
+	waitJobStatus(t, j.ID, worker.JobStatusKilled)
+	var logCount int64
+	for i := 0; i < 100 && logCount == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+		db.Model(&worker.QorJobLog{}).Where("log = ?", "job aborted").Count(&logCount)
+	}
+	if logCount != 1 {
+		t.Errorf("want handler context cancelled after kill")
+	}
+
+	// schedule and remove
+	scheduleTime := time.Now().Add(time.Hour)
+	j, err = wb.AddJob(ctx, "queueScheduleJob", &QueueScheduleJobResource{Schedule: worker.Schedule{ScheduleTime: &scheduleTime}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusScheduled)
+	abortJob(qpb, "queueScheduleJob", j.ID)
+	waitJobStatus(t, j.ID, worker.JobStatusCancelled)
+}
+
+func mustGetJobInstance(t *testing.T, id uint) *worker.QorJobInstance {
+	t.Helper()
+	inst := &worker.QorJobInstance{}
+	if err := db.Where("qor_job_id = ?", id).Order("id desc").First(inst).Error; err != nil {
+		t.Fatal(err)
+	}
+	return inst
+}
+
+func TestJobRetry(t *testing.T) {
+	cleanData()
+	wb, qpb := newQueueBuilder(worker.NewMemoryQueue())
+	defer wb.Shutdown(context.Background())
+	ctx := context.Background()
+
+	// retried until success
+	j, err := wb.AddJob(ctx, "queueRetryJob", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusDone)
+	inst := mustGetJobInstance(t, j.ID)
+	attempts, _ := inst.GetAttempts()
+	if inst.Attempt != 3 || len(attempts) != 3 || attempts[0].Error != "temporary error" || attempts[2].Error != "" {
+		t.Errorf("unexpected attempts %s", inst.AttemptHistory)
+	}
+
+	// non retryable error goes to dead directly
+	j, err = wb.AddJob(ctx, "queueDeadJob", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusDead)
+	if inst = mustGetJobInstance(t, j.ID); inst.Attempt != 1 {
+		t.Errorf("want 1 attempt, got %d", inst.Attempt)
+	}
+
+	// bulk re-enqueue
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/workers?__execute_event__=presets_DoBulkAction&bulk_action=Reenqueue&selected_ids=%d", j.ID), nil)
+	w := httptest.NewRecorder()
+	qpb.ServeHTTP(w, r)
+	if strings.Contains(w.Body.String(), "error") {
+		t.Fatalf("re-enqueue failed: %s", w.Body.String())
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusDead)
+	var count int64
+	db.Model(&worker.QorJobInstance{}).Where("qor_job_id = ?", j.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("want a new instance after re-enqueue, got %d instances", count)
+	}
+}
+
+func TestJobConcurrencyAndPriority(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+
+	// concurrency
+	wb := worker.NewWithQueue(db, worker.NewMemoryQueue())
+	wb.Configure(presets.New().DataOperator(gorm2op.DataOperator(db)))
+	var running, maxRunning int32
+	wb.NewJob("concurrentJob").
+		Concurrency(3).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				if m := atomic.LoadInt32(&maxRunning); n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			for i := 0; i < 100 && atomic.LoadInt32(&maxRunning) < 3; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			return nil
+		})
+	wb.Listen()
+	var ids []uint
+	for i := 0; i < 3; i++ {
+		j, err := wb.AddJob(ctx, "concurrentJob", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, j.ID)
+	}
+	for _, id := range ids {
+		waitJobStatus(t, id, worker.JobStatusDone)
+	}
+	if maxRunning != 3 {
+		t.Errorf("want 3 jobs running at the same time, got %d", maxRunning)
+	}
+	wb.Shutdown(ctx)
+
+	// priority in a full pool
+	for _, q := range []worker.Queue{worker.NewMemoryQueue(), worker.NewGormQueue(db).PollInterval(20 * time.Millisecond)} {
+		testPriorityInFullPool(t, q)
+	}
+}
+
+func testPriorityInFullPool(t *testing.T, q worker.Queue) {
+	cleanData()
+	ctx := context.Background()
+	wb := worker.NewWithQueue(db, q).WorkerPoolSize(1)
+	wb.Configure(presets.New().DataOperator(gorm2op.DataOperator(db)))
+	release := make(chan struct{})
+	wb.NewJob("poolBlockingJob").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			<-release
+			return nil
+		})
+	var mutex sync.Mutex
+	var performed []string
+	for _, p := range []struct {
+		name     string
+		priority int
+	}{{"lowPriorityJob", 0}, {"highPriorityJob", 10}} {
+		name := p.name
+		wb.NewJob(name).
+			Priority(p.priority).
+			Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+				mutex.Lock()
+				performed = append(performed, name)
+				mutex.Unlock()
+				return nil
+			})
+	}
+	wb.Listen()
+	defer wb.Shutdown(ctx)
+
+	blocking, err := wb.AddJob(ctx, "poolBlockingJob", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, blocking.ID, worker.JobStatusRunning)
+	low, _ := wb.AddJob(ctx, "lowPriorityJob", nil, nil)
+	high, _ := wb.AddJob(ctx, "highPriorityJob", nil, nil)
+	time.Sleep(100 * time.Millisecond)
+	if len(performed) != 0 {
+		t.Fatalf("want jobs waiting for the pool, got %v performed", performed)
+	}
+	close(release)
+	waitJobStatus(t, low.ID, worker.JobStatusDone)
+	waitJobStatus(t, high.ID, worker.JobStatusDone)
+	if strings.Join(performed, ",") != "highPriorityJob,lowPriorityJob" {
+		t.Errorf("want high priority job performed first, got %v", performed)
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	q := worker.NewMemoryQueue()
+	testQueue(t, q)
+	if err := q.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGormQueue(t *testing.T) {
+	q := worker.NewGormQueue(db).PollInterval(50 * time.Millisecond)
+	testQueue(t, q)
+
+	// a job locked by a worker before its status changes is killed instead of cancelled
+	wb, qpb := newQueueBuilder(q)
+	defer wb.Shutdown(context.Background())
+	scheduleTime := time.Now().Add(time.Hour)
+	j, err := wb.AddJob(context.Background(), "queueScheduleJob", &QueueScheduleJobResource{Schedule: worker.Schedule{ScheduleTime: &scheduleTime}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusScheduled)
+	db.Model(&worker.QorJobQueueItem{}).Where("qor_job_id = ?", j.ID).Updates(map[string]interface{}{"locked_by": "another worker", "locked_at": time.Now()})
+	abortJob(qpb, "queueScheduleJob", j.ID)
+	waitJobStatus(t, j.ID, worker.JobStatusKilled)
+	db.Where("qor_job_id = ?", j.ID).Delete(&worker.QorJobQueueItem{})
+
+	var count int64
+	db.Model(&worker.QorJobQueueItem{}).Count(&count)
+	if count != 0 {
+		t.Errorf("want queue table empty, got %d rows", count)
+	}
+}
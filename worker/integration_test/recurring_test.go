@@ -0,0 +1,158 @@
+package integration_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/admin/presets/gorm2op"
+	"github.com/qor5/admin/worker"
+)
+
+func TestCronExpression(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2023, 1, 31, 10, 7, 30, 0, loc) // tuesday
+
+	cases := []struct {
+		expr string
+		want []string
+	}{
+		{"*/15 * * * *", []string{"2023-01-31 10:15", "2023-01-31 10:30"}},
+		{"0 9-10 * * mon-fri", []string{"2023-02-01 09:00", "2023-02-01 10:00", "2023-02-02 09:00"}},
+		{"30 2 29 2 *", []string{"2024-02-29 02:30"}},
+		{"0 0 1,15 * *", []string{"2023-02-01 00:00", "2023-02-15 00:00"}},
+		{"0 0 13 * 5", []string{"2023-02-03 00:00", "2023-02-10 00:00", "2023-02-13 00:00"}},
+		{"0 12 * * 7", []string{"2023-02-05 12:00"}},
+		{"0 0 */2 * 1", []string{"2023-02-13 00:00", "2023-02-27 00:00", "2023-03-13 00:00"}},
+		{"@monthly", []string{"2023-02-01 00:00", "2023-03-01 00:00"}},
+	}
+	for _, c := range cases {
+		ce, err := worker.ParseCronExpression(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		var got []string
+		for _, n := range ce.NextN(from, len(c.want)) {
+			got = append(got, n.Format("2006-01-02 15:04"))
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s: want %v, got %v", c.expr, c.want, got)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@every"} {
+		if _, err := worker.ParseCronExpression(expr); err == nil {
+			t.Errorf("%s: want error", expr)
+		}
+	}
+}
+
+func TestRecurringJob(t *testing.T) {
+	cleanData()
+	db.Exec("delete from qor_recurring_jobs")
+
+	q := worker.NewMemoryQueue()
+	qpb := presets.New().DataOperator(gorm2op.DataOperator(db))
+	wb := worker.NewWithQueue(db, q).RecurringCheckInterval(20 * time.Millisecond)
+	wb.Configure(qpb)
+	jb := wb.NewJob("recurringJob").
+		Recurring("0 3 * * *", "Asia/Tokyo").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			return nil
+		})
+	release := make(chan struct{})
+	wb.NewJob("recurringBlockedJob").
+		Recurring("*/5 * * * *", "").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			<-release
+			return nil
+		})
+	wb.Listen()
+	defer wb.Shutdown(context.Background())
+	defer close(release)
+
+	rj := &worker.QorRecurringJob{}
+	if err := db.Where("job = ?", "recurringJob").First(rj).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := jb.NextRuns(time.Now(), 1)[0]; rj.NextRunAt == nil || !rj.NextRunAt.Equal(want) {
+		t.Fatalf("want next run at %v, got %v", want, rj.NextRunAt)
+	}
+	if got := rj.NextRunAt.In(time.FixedZone("JST", 9*3600)); got.Hour() != 3 || got.Minute() != 0 {
+		t.Errorf("want next run at 03:00 in Asia/Tokyo, got %v", got)
+	}
+
+	// make it due
+	due := func(job string) {
+		db.Model(&worker.QorRecurringJob{}).Where("job = ?", job).Update("next_run_at", time.Now().Add(-time.Minute))
+	}
+	waitRuns := func(job string, want int64) *worker.QorRecurringJob {
+		t.Helper()
+		rj := &worker.QorRecurringJob{}
+		for i := 0; i < 100; i++ {
+			db.Where("job = ?", job).First(rj)
+			if rj.Runs == want && rj.LastQorJobID != 0 {
+				return rj
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("want %d runs, got %d", want, rj.Runs)
+		return nil
+	}
+	due("recurringJob")
+	rj = waitRuns("recurringJob", 1)
+	if rj.LastQorJobID == 0 || !rj.NextRunAt.After(time.Now()) {
+		t.Fatalf("unexpected recurring job %#+v", rj)
+	}
+	waitJobStatus(t, rj.LastQorJobID, worker.JobStatusDone)
+
+	// the second run is skipped while the first one is running
+	due("recurringBlockedJob")
+	first := waitRuns("recurringBlockedJob", 1)
+	waitJobStatus(t, first.LastQorJobID, worker.JobStatusRunning)
+	due("recurringBlockedJob")
+	second := waitRuns("recurringBlockedJob", 2)
+	if second.LastQorJobID != first.LastQorJobID {
+		t.Errorf("want overlapping run skipped")
+	}
+	time.Sleep(50 * time.Millisecond)
+	var count int64
+	db.Model(&worker.QorJob{}).Where("job = ?", "recurringBlockedJob").Count(&count)
+	if count != 1 {
+		t.Errorf("want 1 job, got %d", count)
+	}
+
+	// paused jobs are not dispatched
+	if err := wb.PauseRecurringJob("recurringJob"); err != nil {
+		t.Fatal(err)
+	}
+	due("recurringJob")
+	time.Sleep(100 * time.Millisecond)
+	if rj = waitRuns("recurringJob", 1); !rj.Paused {
+		t.Errorf("want paused")
+	}
+	if err := wb.ResumeRecurringJob("recurringJob"); err != nil {
+		t.Fatal(err)
+	}
+	db.Where("job = ?", "recurringJob").First(rj)
+	if rj.Paused || rj.NextRunAt == nil || !rj.NextRunAt.After(time.Now()) {
+		t.Errorf("want resumed from the next run, got %#+v", rj)
+	}
+
+	// upcoming runs in the admin
+	r := httptest.NewRequest(http.MethodGet, "/worker-recurring-jobs", nil)
+	w := httptest.NewRecorder()
+	qpb.ServeHTTP(w, r)
+	for _, run := range jb.NextRuns(time.Now(), 3) {
+		if want := run.Format("2006-01-02 15:04 MST"); !strings.Contains(w.Body.String(), want) {
+			t.Errorf("want upcoming run %s in the listing", want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	"github.com/qor5/admin/presets/gorm2op"
+	"github.com/qor5/admin/worker"
+)
+
+type WorkflowLocalizeResource struct {
+	N int
+}
+
+type WorkflowPublishResource struct {
+	Sum int
+}
+
+func TestWorkflow(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+	qpb := presets.New().DataOperator(gorm2op.DataOperator(db))
+	wb := worker.NewWithQueue(db, worker.NewMemoryQueue())
+	wb.Configure(qpb)
+
+	wb.NewJob("wfImport").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			return worker.SetJobOutput(job, []int{1, 2, 3})
+		})
+	wb.NewJob("wfLocalize").
+		Resource(&WorkflowLocalizeResource{}).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			jobInfo, _ := job.GetJobInfo()
+			return worker.SetJobOutput(job, jobInfo.Argument.(*WorkflowLocalizeResource).N*10)
+		})
+	published := make(chan int, 1)
+	wb.NewJob("wfPublish").
+		Resource(&WorkflowPublishResource{}).
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			jobInfo, _ := job.GetJobInfo()
+			published <- jobInfo.Argument.(*WorkflowPublishResource).Sum
+			return nil
+		})
+	wb.NewJob("wfBlocking").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			<-ctx.Done()
+			return nil
+		})
+
+	wf := wb.NewWorkflow("wfCatalogSync").PollInterval(20 * time.Millisecond)
+	wf.Step("import", "wfImport")
+	wf.Step("localize", "wfLocalize").After("import").
+		FanOut(func(wc *worker.WorkflowContext) (args []interface{}, err error) {
+			var ns []int
+			if err = wc.Output("import", &ns); err != nil {
+				return
+			}
+			for _, n := range ns {
+				args = append(args, &WorkflowLocalizeResource{N: n})
+			}
+			return
+		})
+	wf.Step("publish", "wfPublish").After("localize").
+		Args(func(wc *worker.WorkflowContext) (interface{}, error) {
+			var outputs []int
+			if err := wc.Outputs("localize", &outputs); err != nil {
+				return nil, err
+			}
+			sum := 0
+			for _, o := range outputs {
+				sum += o
+			}
+			return &WorkflowPublishResource{Sum: sum}, nil
+		})
+	wb.NewWorkflow("wfCancel").PollInterval(20*time.Millisecond).
+		Step("block", "wfBlocking")
+	wb.Listen()
+	defer wb.Shutdown(ctx)
+
+	// fan-out and join
+	j, err := wb.AddJob(ctx, "wfCatalogSync", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case sum := <-published:
+		if sum != 60 {
+			t.Errorf("want sum of the localize outputs 60, got %d", sum)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish step is not performed")
+	}
+	waitJobStatus(t, j.ID, worker.JobStatusDone)
+	if inst := mustGetJobInstance(t, j.ID); inst.Progress != 100 {
+		t.Errorf("want workflow progress 100, got %d", inst.Progress)
+	}
+	var count int64
+	db.Model(&worker.QorJobInstance{}).Where("parent_qor_job_id = ?", j.ID).Count(&count)
+	if count != 5 {
+		t.Errorf("want 5 child jobs, got %d", count)
+	}
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/workers?__execute_event__=worker_updateJobProgressing&job=wfCatalogSync&jobID=%d", j.ID), nil)
+	w := httptest.NewRecorder()
+	qpb.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "localize[2] wfLocalize") {
+		t.Errorf("want child jobs in the workflow detail")
+	}
+
+	// cancellation propagates to the children
+	j, err = wb.AddJob(ctx, "wfCancel", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := &worker.QorJobInstance{}
+	for i := 0; i < 100; i++ {
+		if db.Where("parent_qor_job_id = ?", j.ID).First(child).Error == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitJobStatus(t, child.QorJobID, worker.JobStatusRunning)
+	abortJob(qpb, "wfCancel", j.ID)
+	waitJobStatus(t, j.ID, worker.JobStatusKilled)
+	waitJobStatus(t, child.QorJobID, worker.JobStatusKilled)
+}
+
+func newPoolWorkflowBuilder() *worker.Builder {
+	wb := worker.NewWithQueue(db, worker.NewGormQueue(db).PollInterval(20*time.Millisecond)).WorkerPoolSize(1)
+	wb.Configure(presets.New().DataOperator(gorm2op.DataOperator(db)))
+	wb.NewJob("wfPoolChild").
+		Handler(func(ctx context.Context, job worker.QorJobInterface) error {
+			<-ctx.Done()
+			return nil
+		})
+	wb.NewWorkflow("wfPool").PollInterval(20*time.Millisecond).
+		Step("child", "wfPoolChild")
+	wb.Listen()
+	return wb
+}
+
+func TestWorkflowPoolAndShutdown(t *testing.T) {
+	cleanData()
+	ctx := context.Background()
+
+	// the workflow doesn't take the only slot of the pool from its child
+	wb := newPoolWorkflowBuilder()
+	j, err := wb.AddJob(ctx, "wfPool", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := &worker.QorJobInstance{}
+	for i := 0; i < 100; i++ {
+		if db.Where("parent_qor_job_id = ?", j.ID).First(child).Error == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitJobStatus(t, child.QorJobID, worker.JobStatusRunning)
+
+	// the shutdown leaves the children alone and the workflow is performed again
+	sctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	wb.Shutdown(sctx)
+	waitJobStatus(t, j.ID, worker.JobStatusRetrying)
+	waitJobStatus(t, child.QorJobID, worker.JobStatusDone)
+
+	wb = newPoolWorkflowBuilder()
+	defer wb.Shutdown(ctx)
+	waitJobStatus(t, j.ID, worker.JobStatusDone)
+	var count int64
+	db.Model(&worker.QorJobInstance{}).Where("parent_qor_job_id = ?", j.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("want the child job started once, got %d", count)
+	}
+}
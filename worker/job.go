@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"reflect"
@@ -29,6 +30,20 @@ type JobBuilder struct {
 	h              JobHandler
 	contextHandler func(*web.EventContext) map[string]interface{} //optional
 	global         bool
+	retryPolicy    *RetryPolicy
+	concurrency    int
+	rateLimit      float64
+	priority       int
+	timeout        time.Duration
+	dedupWindow    time.Duration
+	idempotencyKey IdempotencyKeyFunc
+
+	// recurring
+	cron          *CronExpression
+	timezone      string
+	location      *time.Location
+	overlapPolicy OverlapPolicy
+	recurringArgs func() interface{}
 }
 
 func newJob(b *Builder, name string) *JobBuilder {
@@ -103,6 +118,31 @@ func (jb *JobBuilder) Handler(h JobHandler) *JobBuilder {
 	return jb
 }
 
+// Global sets if the job can be created from the worker admin, default is true
+func (jb *JobBuilder) Global(b bool) *JobBuilder {
+	jb.global = b
+	return jb
+}
+
+// Concurrency sets how many runs of the job can be performed at the same time, default is 1
+func (jb *JobBuilder) Concurrency(n int) *JobBuilder {
+	jb.concurrency = n
+	return jb
+}
+
+// RateLimit sets the max number of runs started per second,
+// default is unlimited for GormQueue and MemoryQueue, and 2 for the go-que queue
+func (jb *JobBuilder) RateLimit(perSecond float64) *JobBuilder {
+	jb.rateLimit = perSecond
+	return jb
+}
+
+// Priority decides which job gets a free slot first when the worker pool of the builder is full, default is 0
+func (jb *JobBuilder) Priority(p int) *JobBuilder {
+	jb.priority = p
+	return jb
+}
+
 func (jb *JobBuilder) ContextHandler(handler func(*web.EventContext) map[string]interface{}) *JobBuilder {
 	jb.contextHandler = handler
 	return jb
@@ -165,7 +205,9 @@ func (jb *JobBuilder) getJobInstance(qorJobID uint) (*QorJobInstance, error) {
 	return inst, nil
 }
 
+// newJobInstance creates the instance in db, which is the transaction creating the job or the db of the builder
 func (jb *JobBuilder) newJobInstance(
+	db *gorm.DB,
 	r *http.Request,
 	qorJobID uint,
 	qorJobName string,
@@ -201,15 +243,27 @@ func (jb *JobBuilder) newJobInstance(
 		Job:      qorJobName,
 		Status:   JobStatusNew,
 	}
-	if jb.b.getCurrentUserIDFunc != nil {
+	if jb.b.getCurrentUserIDFunc != nil && r != nil {
 		inst.Operator = jb.b.getCurrentUserIDFunc(r)
 	}
-	err := jb.b.db.Create(&inst).Error
+	err := db.Create(&inst).Error
 	if err != nil {
 		return nil, err
 	}
 
-	return jb.getJobInstance(qorJobID)
+	created, err := getModelQorJobInstance(db, qorJobID)
+	if err != nil {
+		return nil, err
+	}
+	created.jb = jb
+	return created, nil
+}
+
+func (job *QorJobInstance) getPriority() int {
+	if job.jb == nil {
+		return 0
+	}
+	return job.jb.priority
 }
 
 type QueJobInterface interface {
@@ -223,6 +277,8 @@ type QueJobInterface interface {
 	StopRefresh()
 
 	GetHandler() JobHandler
+	GetTimeout() time.Duration
+	RecordAttempt(startedAt time.Time, err error) (retryIn time.Duration, retry bool, e error)
 }
 
 type JobInfo struct {
@@ -240,6 +296,10 @@ type QorJobInterface interface {
 	SetProgressText(string) error
 	AddLog(string) error
 	AddLogf(format string, a ...interface{}) error
+	// Log adds a log line with level and key/value pairs, e.g. job.Log(worker.LogLevelWarn, "skipped", "id", id)
+	Log(level LogLevel, msg string, keyvals ...interface{}) error
+	// AddArtifact stores a file produced by the job, it is listed with a download link on the job detail page
+	AddArtifact(name string, r io.Reader) error
 }
 
 var _ QueJobInterface = (*QorJobInstance)(nil)
@@ -271,12 +331,7 @@ func (job *QorJobInstance) GetStatus() string {
 func (job *QorJobInstance) FetchAndSetStatus() (string, error) {
 	var status string
 	{
-		db, err := job.jb.b.db.DB()
-		if err != nil {
-			return job.Status, err
-		}
-
-		err = db.QueryRow("select status from qor_job_instances where id = $1", job.ID).Scan(&status)
+		err := job.jb.b.db.Model(&QorJobInstance{}).Select("status").Where("id = ?", job.ID).Row().Scan(&status)
 		if err != nil {
 			return job.Status, err
 		}
@@ -340,14 +395,7 @@ func (job *QorJobInstance) SetProgressText(s string) error {
 }
 
 func (job *QorJobInstance) AddLog(log string) error {
-	if err := job.jb.b.db.Create(&QorJobLog{
-		QorJobInstanceID: job.ID,
-		Log:              log,
-	}).Error; err != nil {
-		return err
-	}
-
-	return nil
+	return job.Log(LogLevelInfo, log)
 }
 
 func (job *QorJobInstance) AddLogf(format string, a ...interface{}) error {
@@ -355,8 +403,13 @@ func (job *QorJobInstance) AddLogf(format string, a ...interface{}) error {
 }
 
 func (job *QorJobInstance) StartRefresh() {
+	lag := job.queueLag()
 	job.mutex.Lock()
 	defer job.mutex.Unlock()
+	if !job.running {
+		job.running = true
+		job.jb.b.metrics.started(job.jb.name, lag)
+	}
 	if !job.inRefresh {
 		job.inRefresh = true
 		job.stopRefresh = false
@@ -370,6 +423,10 @@ func (job *QorJobInstance) StartRefresh() {
 func (job *QorJobInstance) StopRefresh() {
 	job.mutex.Lock()
 	defer job.mutex.Unlock()
+	if job.running {
+		job.running = false
+		job.jb.b.metrics.stopped(job.jb.name)
+	}
 
 	err := job.callSave()
 	if err != nil {
@@ -398,17 +455,23 @@ func (job *QorJobInstance) shouldCallSave() bool {
 }
 
 func (job *QorJobInstance) callSave() error {
-	err := job.jb.b.setStatus(job.QorJobID, job.Status)
+	db := job.jb.b.db
+	if job.tx != nil {
+		db = job.tx
+	}
+	err := setQorJobStatus(db, job.QorJobID, job.Status)
 	if err != nil {
 		return err
 	}
-	return job.jb.b.db.Save(job).Error
+	return db.Save(job).Error
 }
 
 func (job *QorJobInstance) refresh() {
 	job.mutex.Lock()
 	defer job.mutex.Unlock()
 
+	heartbeatAt := job.jb.b.db.NowFunc()
+	job.HeartbeatAt = &heartbeatAt
 	err := job.callSave()
 	if err != nil {
 		log.Println(err)
@@ -0,0 +1,495 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	. "github.com/qor5/ui/vuetify"
+	"github.com/qor5/web"
+	. "github.com/theplant/htmlgo"
+)
+
+type LogLevel string
+
+const (
+	LogLevelDebug LogLevel = "debug"
+	LogLevelInfo  LogLevel = "info"
+	LogLevelWarn  LogLevel = "warn"
+	LogLevelError LogLevel = "error"
+)
+
+var logLevels = []LogLevel{LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError}
+
+var logLevelColors = map[LogLevel]string{
+	LogLevelDebug: "#9e9e9e",
+	LogLevelInfo:  "#8bc34a",
+	LogLevelWarn:  "#ffc107",
+	LogLevelError: "#f44336",
+}
+
+const (
+	logsQueryParam = "__worker_logs__"
+	logsPageSize   = 100
+)
+
+// JobLogField is a key/value of a log line
+type JobLogField struct {
+	Key   string
+	Value interface{}
+}
+
+// JobLogEntry is a log line returned by the log api of the job detail
+type JobLogEntry struct {
+	ID      uint
+	Time    time.Time
+	Level   LogLevel
+	Message string
+	Fields  []JobLogField `json:",omitempty"`
+}
+
+func (e *JobLogEntry) String() string {
+	var sb strings.Builder
+	sb.WriteString(e.Time.Format("2006-01-02 15:04:05"))
+	sb.WriteString(" ")
+	sb.WriteString(strings.ToUpper(string(e.Level)))
+	sb.WriteString(" ")
+	sb.WriteString(e.Message)
+	for _, f := range e.Fields {
+		fmt.Fprintf(&sb, " %s=%v", f.Key, f.Value)
+	}
+	return sb.String()
+}
+
+func (l *QorJobLog) entry() *JobLogEntry {
+	e := &JobLogEntry{
+		ID:      l.ID,
+		Time:    l.CreatedAt.Local(),
+		Level:   l.Level,
+		Message: l.Log,
+	}
+	if e.Level == "" {
+		e.Level = LogLevelInfo
+	}
+	if l.Fields != "" {
+		json.Unmarshal([]byte(l.Fields), &e.Fields)
+	}
+	return e
+}
+
+func (job *QorJobInstance) Log(level LogLevel, msg string, keyvals ...interface{}) error {
+	l := &QorJobLog{
+		QorJobInstanceID: job.ID,
+		Level:            level,
+		Log:              msg,
+	}
+	if len(keyvals) > 0 {
+		fields, err := json.Marshal(logFields(keyvals))
+		if err != nil {
+			return err
+		}
+		l.Fields = string(fields)
+	}
+	return job.jb.b.db.Create(l).Error
+}
+
+func logFields(keyvals []interface{}) []JobLogField {
+	fields := make([]JobLogField, 0, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 == len(keyvals) {
+			fields = append(fields, JobLogField{Key: "!BADKEY", Value: logValue(keyvals[i])})
+			break
+		}
+		fields = append(fields, JobLogField{Key: fmt.Sprint(keyvals[i]), Value: logValue(keyvals[i+1])})
+	}
+	return fields
+}
+
+// logValue keeps the values json can marshal, so that the fields can always be stored
+func logValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case error:
+		return vv.Error()
+	case time.Time:
+		return vv.Format(time.RFC3339)
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+	return v
+}
+
+// levelsFrom returns the levels at or above the min level, the logs without level are info
+func levelsFrom(min LogLevel) (levels []LogLevel) {
+	found := false
+	for _, l := range logLevels {
+		if l == min {
+			found = true
+		}
+		if found {
+			levels = append(levels, l)
+		}
+	}
+	return withEmptyLevel(levels)
+}
+
+func withEmptyLevel(levels []LogLevel) []LogLevel {
+	for _, l := range levels {
+		if l == LogLevelInfo {
+			return append(levels, "")
+		}
+	}
+	return levels
+}
+
+type jobLogQuery struct {
+	// Level is the min level, empty means all
+	Level  LogLevel
+	Search string
+	// AfterID loads the lines after it in order, otherwise the last lines before BeforeID are loaded
+	AfterID  uint
+	BeforeID uint
+}
+
+func (b *Builder) getJobLogs(instID uint, q jobLogQuery) (entries []*JobLogEntry, hasMore bool, err error) {
+	db := b.db.Where("qor_job_instance_id = ?", instID)
+	if levels := levelsFrom(q.Level); q.Level != "" && len(levels) > 0 {
+		db = db.Where("level IN ?", levels)
+	}
+	if s := strings.TrimSpace(q.Search); s != "" {
+		s = "%" + strings.ToLower(s) + "%"
+		db = db.Where("(LOWER(log) LIKE ? OR LOWER(fields) LIKE ?)", s, s)
+	}
+	if q.AfterID > 0 {
+		db = db.Where("id > ?", q.AfterID).Order("id")
+	} else {
+		if q.BeforeID > 0 {
+			db = db.Where("id < ?", q.BeforeID)
+		}
+		db = db.Order("id desc")
+	}
+
+	var logs []*QorJobLog
+	if err = db.Limit(logsPageSize + 1).Find(&logs).Error; err != nil {
+		return
+	}
+	if len(logs) > logsPageSize {
+		hasMore = true
+		logs = logs[:logsPageSize]
+	}
+	if q.AfterID == 0 {
+		for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+			logs[i], logs[j] = logs[j], logs[i]
+		}
+	}
+	entries = make([]*JobLogEntry, 0, len(logs))
+	for _, l := range logs {
+		entries = append(entries, l.entry())
+	}
+	return
+}
+
+func jobLogQueryFromRequest(r *http.Request) jobLogQuery {
+	afterID, _ := strconv.ParseUint(r.FormValue("afterID"), 10, 64)
+	beforeID, _ := strconv.ParseUint(r.FormValue("beforeID"), 10, 64)
+	return jobLogQuery{
+		Level:    LogLevel(r.FormValue("level")),
+		Search:   r.FormValue("q"),
+		AfterID:  uint(afterID),
+		BeforeID: uint(beforeID),
+	}
+}
+
+// withJobLogQuery adds the filters of the log viewer to the event
+func withJobLogQuery(e *web.VueEventTagBuilder) *web.VueEventTagBuilder {
+	return e.Query("level", web.Var("vars.worker_logLevel")).
+		Query("q", web.Var("vars.worker_logSearch"))
+}
+
+func jobInProgress(status string) bool {
+	return status == JobStatusNew || status == JobStatusScheduled || status == JobStatusRunning || status == JobStatusRetrying
+}
+
+// LogRetention sets how long job logs are kept for the levels, or for all the other levels if none is given.
+// 0 means forever which is the default.
+// example: LogRetention(30*24*time.Hour).LogRetention(24*time.Hour, LogLevelDebug)
+func (b *Builder) LogRetention(d time.Duration, levels ...LogLevel) *Builder {
+	if len(levels) == 0 {
+		b.logRetention = d
+		return b
+	}
+	if b.logLevelRetention == nil {
+		b.logLevelRetention = make(map[LogLevel]time.Duration)
+	}
+	for _, l := range levels {
+		b.logLevelRetention[l] = d
+	}
+	return b
+}
+
+// LogCleanupInterval is how often expired logs are deleted, default is 1 hour
+func (b *Builder) LogCleanupInterval(v time.Duration) *Builder {
+	b.logCleanupInterval = v
+	return b
+}
+
+func (b *Builder) hasLogRetention() bool {
+	if b.logRetention > 0 {
+		return true
+	}
+	for _, d := range b.logLevelRetention {
+		if d > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteExpiredLogs deletes the job logs older than the retention of their level
+func (b *Builder) DeleteExpiredLogs(ctx context.Context) error {
+	db := b.db.WithContext(ctx)
+	now := b.db.NowFunc()
+	var customized []LogLevel
+	for _, l := range logLevels {
+		d, ok := b.logLevelRetention[l]
+		if !ok {
+			continue
+		}
+		customized = append(customized, l)
+		if d <= 0 {
+			continue
+		}
+		if err := db.Where("level IN ? AND created_at < ?", withEmptyLevel([]LogLevel{l}), now.Add(-d)).
+			Delete(&QorJobLog{}).Error; err != nil {
+			return err
+		}
+	}
+	if b.logRetention <= 0 {
+		return nil
+	}
+	db = db.Where("created_at < ?", now.Add(-b.logRetention))
+	if len(customized) > 0 {
+		db = db.Where("level NOT IN ?", withEmptyLevel(customized))
+	}
+	return db.Delete(&QorJobLog{}).Error
+}
+
+func (b *Builder) eventLoadJobLogs(ctx *web.EventContext) (er web.EventResponse, err error) {
+	qorJobID := uint(ctx.QueryAsInt("jobID"))
+	inst, err := getModelQorJobInstance(b.db, qorJobID)
+	if err != nil {
+		return er, err
+	}
+	er.VarsScript, err = b.jobLogsVarsScript(inst.ID, jobLogQueryFromRequest(ctx.R))
+	return er, err
+}
+
+// jobLogsVarsScript loads the logs of the query into vars.worker_logs of the log viewer,
+// the new lines are appended if AfterID is given and the earlier lines are prepended if BeforeID is given
+func (b *Builder) jobLogsVarsScript(instID uint, q jobLogQuery) (string, error) {
+	entries, hasMore, err := b.getJobLogs(instID, q)
+	if err != nil {
+		return "", err
+	}
+	bEntries, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+
+	var scripts []string
+	switch {
+	case q.AfterID > 0:
+		scripts = append(scripts, fmt.Sprintf("vars.worker_logs = vars.worker_logs.concat(%s)", bEntries))
+	case q.BeforeID > 0:
+		scripts = append(scripts,
+			fmt.Sprintf("vars.worker_logs = %s.concat(vars.worker_logs)", bEntries),
+			fmt.Sprintf("vars.worker_logHasMore = %t", hasMore),
+		)
+	default:
+		scripts = append(scripts,
+			fmt.Sprintf("vars.worker_logs = %s", bEntries),
+			fmt.Sprintf("vars.worker_logHasMore = %t", hasMore),
+		)
+	}
+	if q.BeforeID == 0 && len(entries) > 0 {
+		scripts = append(scripts, fmt.Sprintf("vars.worker_logAfterID = %d", entries[len(entries)-1].ID))
+	}
+	return strings.Join(scripts, "; "), nil
+}
+
+// jobLogViewer renders the logs kept in vars.worker_logs,
+// the new lines are loaded by worker_updateJobProgressing and the filtered or earlier ones by worker_loadJobLogs
+func (b *Builder) jobLogViewer(msgr *Messages, eURL string, id uint) HTMLComponent {
+	load := func() *web.VueEventTagBuilder {
+		return withJobLogQuery(web.Plaid().EventFunc("worker_loadJobLogs").
+			URL(eURL).
+			Query("jobID", fmt.Sprint(id)))
+	}
+	reload := load().BeforeScript("vars.worker_logs = []; vars.worker_logAfterID = 0; vars.worker_logHasMore = false").Go()
+
+	levelItems := []map[string]string{{"text": msgr.LogFilterAllLevels, "value": ""}}
+	for _, l := range logLevels {
+		levelItems = append(levelItems, map[string]string{"text": strings.ToUpper(string(l)), "value": string(l)})
+	}
+	colors, _ := json.Marshal(logLevelColors)
+
+	return Div(
+		Div(Text(msgr.DetailTitleLog)).Class("text-caption"),
+		Div(
+			VSelect().Items(levelItems).ItemText("text").ItemValue("value").
+				Label(msgr.LogFilterLevel).
+				Dense(true).
+				HideDetails(true).
+				Attr("v-model", "vars.worker_logLevel").
+				Attr("@change", reload).
+				Attr("style", "max-width: 160px").
+				Class("mr-3"),
+			VTextField().Label(msgr.LogFilterSearch).
+				PrependInnerIcon("search").
+				Clearable(true).
+				Dense(true).
+				HideDetails(true).
+				Attr("v-model", "vars.worker_logSearch").
+				Attr("@change", reload),
+		).Class("d-flex align-center mb-2"),
+		VBtn(msgr.ActionLoadEarlierLogs).
+			Attr("v-if", "vars.worker_logHasMore").
+			Attr("@click", load().Query("beforeID", web.Var("vars.worker_logs.length > 0 ? vars.worker_logs[0].ID : 0")).Go()).
+			Small(true).
+			Depressed(true).
+			Class("mb-2"),
+		// column-reverse keeps the box scrolled to the latest lines
+		Div(
+			Div(
+				Div(
+					Span("{{l.Time.substr(11, 8)}}").Class("mr-2").Style("color: #9e9e9e"),
+					Span("{{l.Level.toUpperCase()}}").Class("mr-2").Attr(":style", fmt.Sprintf("{color: %s[l.Level]}", colors)),
+					Span("{{l.Message}}"),
+					Span("{{f.Key}}={{typeof f.Value === 'string' ? f.Value : JSON.stringify(f.Value)}}").
+						Attr("v-for", "f in l.Fields").
+						Class("ml-2").
+						Style("color: #90caf9"),
+				).Attr("v-for", "l in vars.worker_logs", ":key", "l.ID").Style(`
+    margin: 0;
+    margin-bottom: 4px;
+    white-space: pre-wrap;`),
+			),
+		).Class("mb-3").Style(`
+		background-color: #222;
+		color: #fff;
+		font-family: menlo,Roboto,Helvetica,Arial,sans-serif;
+		height: 300px;
+		padding: 8px;
+		overflow: auto;
+		box-sizing: border-box;
+		font-size: 12px;
+		line-height: 1.2;
+		display: flex;
+		flex-direction: column-reverse;
+		`),
+	)
+}
+
+// jobLogsHandler serves the logs of a job on the job detail url as json,
+// or as server-sent events if the request accepts text/event-stream.
+// The lines after afterID, or the Last-Event-ID of a reconnecting event source, are returned.
+func (b *Builder) jobLogsHandler(in http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get(logsQueryParam) == "" || r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, b.mb.Info().ListingHref()) {
+			in.ServeHTTP(w, r)
+			return
+		}
+
+		qorJobID, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, b.mb.Info().ListingHref()), "/"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if b.mb.Info().Verifier().Do(presets.PermGet).WithReq(r).IsAllowed() != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		q := jobLogQueryFromRequest(r)
+
+		if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			inst, err := getModelQorJobInstance(b.db, uint(qorJobID))
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			entries, hasMore, err := b.getJobLogs(inst.ID, q)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Status":  inst.Status,
+				"Logs":    entries,
+				"HasMore": hasMore,
+			})
+			return
+		}
+
+		if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+			q.AfterID = uint(id)
+		}
+		b.streamJobLogs(w, r, uint(qorJobID), q)
+	})
+}
+
+func (b *Builder) streamJobLogs(w http.ResponseWriter, r *http.Request, qorJobID uint, q jobLogQuery) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(b.logStreamInterval)
+	defer ticker.Stop()
+	for {
+		inst, err := getModelQorJobInstance(b.db, qorJobID)
+		if err != nil {
+			return
+		}
+		for {
+			entries, hasMore, err := b.getJobLogs(inst.ID, q)
+			if err != nil {
+				log.Printf("worker stream logs qor_job_id: %d, error: %v\n", qorJobID, err)
+				return
+			}
+			for _, e := range entries {
+				data, _ := json.Marshal(e)
+				fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", e.ID, data)
+			}
+			// the first query loads the last lines, the following ones load the lines after them
+			wasAfter := q.AfterID > 0
+			if len(entries) > 0 {
+				q.AfterID = entries[len(entries)-1].ID
+			}
+			if !wasAfter || !hasMore {
+				break
+			}
+		}
+		if !jobInProgress(inst.Status) {
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", inst.Status)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+type memoryQueueItem struct {
+	qorJobID uint
+	jobName  string
+	priority int
+	runAt    time.Time
+	running  bool
+}
+
+// MemoryQueue keeps the pending jobs in memory, it is meant for tests and local development,
+// the jobs are lost when the process exits.
+type MemoryQueue struct {
+	pollInterval time.Duration
+
+	mutex sync.Mutex
+	items []*memoryQueueItem
+
+	stopPolling context.CancelFunc
+	cancelRuns  context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		pollInterval: time.Millisecond * 10,
+	}
+}
+
+// PollInterval is how often an idle worker checks for due jobs, default is 10 milliseconds
+func (q *MemoryQueue) PollInterval(v time.Duration) *MemoryQueue {
+	q.pollInterval = v
+	return q
+}
+
+func (q *MemoryQueue) Add(ctx context.Context, job QueJobInterface) error {
+	qorJobID, err := qorJobIDOf(job)
+	if err != nil {
+		return err
+	}
+	runAt, err := runAtOf(job)
+	if err != nil {
+		return err
+	}
+	jobInfo, err := job.GetJobInfo()
+	if err != nil {
+		return err
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.items = append(q.items, &memoryQueueItem{
+		qorJobID: qorJobID,
+		jobName:  jobInfo.JobName,
+		priority: priorityOf(job),
+		runAt:    runAt,
+	})
+	return nil
+}
+
+// Requeue replaces the items of the job with a new one which runs at runAt, tx is not used by the memory queue
+func (q *MemoryQueue) Requeue(ctx context.Context, tx *gorm.DB, job QueJobInterface, runAt time.Time) error {
+	qorJobID, err := qorJobIDOf(job)
+	if err != nil {
+		return err
+	}
+	jobInfo, err := job.GetJobInfo()
+	if err != nil {
+		return err
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var items []*memoryQueueItem
+	for _, item := range q.items {
+		if item.qorJobID != qorJobID {
+			items = append(items, item)
+		}
+	}
+	q.items = append(items, &memoryQueueItem{
+		qorJobID: qorJobID,
+		jobName:  jobInfo.JobName,
+		priority: priorityOf(job),
+		runAt:    runAt,
+	})
+	return nil
+}
+
+// Kill marks the job as killed, the worker running it cancels the handler context
+func (q *MemoryQueue) Kill(ctx context.Context, job QueJobInterface) error {
+	return job.SetStatus(JobStatusKilled)
+}
+
+// Remove cancels a job which is not started yet,
+// a job already taken by a worker is killed instead, since it may be running at the moment
+func (q *MemoryQueue) Remove(ctx context.Context, job QueJobInterface) error {
+	qorJobID, err := qorJobIDOf(job)
+	if err != nil {
+		return err
+	}
+
+	q.mutex.Lock()
+	var items []*memoryQueueItem
+	var removed, running bool
+	for _, item := range q.items {
+		if item.qorJobID == qorJobID {
+			if !item.running {
+				removed = true
+				continue
+			}
+			running = true
+		}
+		items = append(items, item)
+	}
+	q.items = items
+	q.mutex.Unlock()
+
+	if !removed {
+		if running {
+			return q.Kill(ctx, job)
+		}
+		return fmt.Errorf("job %d is not in the queue", qorJobID)
+	}
+	return job.SetStatus(JobStatusCancelled)
+}
+
+func (q *MemoryQueue) Listen(jobDefs []*QorJobDefinition, getJob func(qorJobID uint) (QueJobInterface, error)) error {
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+	q.stopPolling = stopPolling
+	q.cancelRuns = cancelRuns
+	for _, jd := range jobDefs {
+		if jd.Handler == nil {
+			panic(fmt.Sprintf("job %s handler is nil", jd.Name))
+		}
+		jd := jd
+		limiter := jd.newRateLimiter()
+		for i := 0; i < jd.concurrency(); i++ {
+			q.wg.Add(1)
+			jd.listenerStarted()
+			go func() {
+				defer q.wg.Done()
+				defer jd.listenerStopped(nil)
+				q.loop(pollCtx, runCtx, jd, limiter, getJob)
+			}()
+		}
+	}
+	return nil
+}
+
+func (q *MemoryQueue) loop(pollCtx context.Context, runCtx context.Context, jd *QorJobDefinition, limiter *rate.Limiter, getJob func(qorJobID uint) (QueJobInterface, error)) {
+	for pollCtx.Err() == nil {
+		if item := q.lockNext(jd.Name); item != nil {
+			// the slot is taken only after an item is locked, so that polling doesn't hold a slot
+			if err := jd.acquire(pollCtx); err != nil {
+				q.mutex.Lock()
+				item.running = false
+				q.mutex.Unlock()
+				return
+			}
+			if limiter != nil {
+				limiter.Wait(runCtx)
+			}
+			q.perform(runCtx, item, getJob)
+			jd.release()
+			continue
+		}
+
+		select {
+		case <-pollCtx.Done():
+			return
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+func (q *MemoryQueue) lockNext(jobName string) *memoryQueueItem {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	var next *memoryQueueItem
+	for _, item := range q.items {
+		if item.jobName != jobName || item.running || item.runAt.After(now) {
+			continue
+		}
+		if next == nil || item.priority > next.priority || item.priority == next.priority && item.runAt.Before(next.runAt) {
+			next = item
+		}
+	}
+	if next != nil {
+		next.running = true
+	}
+	return next
+}
+
+func (q *MemoryQueue) perform(ctx context.Context, item *memoryQueueItem, getJob func(qorJobID uint) (QueJobInterface, error)) {
+	job, err := getJob(item.qorJobID)
+	if err == nil {
+		err = runJob(ctx, job)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var re *retryError
+	if errors.As(err, &re) {
+		item.runAt = time.Now().Add(re.delay)
+		item.running = false
+		return
+	}
+	if err != nil && err != errJobCancelled && err != errJobAborted && err != errJobNotRunnable {
+		log.Printf("worker queue qor_job_id: %d, error: %v\n", item.qorJobID, err)
+	}
+
+	for i, it := range q.items {
+		if it == item {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+}
+
+// Wait blocks until there are no due or running jobs, jobs scheduled in the future are not waited for
+func (q *MemoryQueue) Wait(ctx context.Context) error {
+	for {
+		if !q.busy() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+func (q *MemoryQueue) busy() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	for _, item := range q.items {
+		if item.running || !item.runAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Shutdown stops polling and waits for the running jobs, their handler contexts are cancelled once ctx is done
+func (q *MemoryQueue) Shutdown(ctx context.Context) error {
+	if q.stopPolling == nil {
+		return nil
+	}
+	q.stopPolling()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.cancelRuns()
+		return ctx.Err()
+	}
+}
@@ -17,23 +17,42 @@ type Messages struct {
 	StatusDone               string
 	StatusException          string
 	StatusKilled             string
+	StatusRetrying           string
+	StatusDead               string
 	FilterTabAll             string
 	FilterTabRunning         string
 	FilterTabScheduled       string
 	FilterTabDone            string
 	FilterTabErrors          string
+	FilterTabDead            string
 	ActionCancelJob          string
 	ActionAbortJob           string
 	ActionUpdateJob          string
 	ActionRerunJob           string
 	DetailTitleStatus        string
 	DetailTitleLog           string
+	DetailTitleAttempts      string
+	DetailTitleWorkflow      string
+	DetailTitleChildren      string
+	DetailTitleArtifacts     string
+	ArtifactExpiresAt        string
+	LogFilterLevel           string
+	LogFilterAllLevels       string
+	LogFilterSearch          string
+	ActionLoadEarlierLogs    string
 	NoticeJobCannotBeAborted string
 	NoticeJobWontBeExecuted  string
 	ScheduleTime             string
 	DateTimePickerClearText  string
 	DateTimePickerOkText     string
 	PleaseSelectJob          string
+
+	NoticeJobsWillBeReenqueued string
+
+	RecurringJobActive       string
+	RecurringJobPaused       string
+	ActionPauseRecurringJob  string
+	ActionResumeRecurringJob string
 }
 
 var Messages_en_US = &Messages{
@@ -44,23 +63,42 @@ var Messages_en_US = &Messages{
 	StatusDone:               "Done",
 	StatusException:          "Exception",
 	StatusKilled:             "Killed",
+	StatusRetrying:           "Retrying",
+	StatusDead:               "Dead",
 	FilterTabAll:             "All Jobs",
 	FilterTabRunning:         "Running",
 	FilterTabScheduled:       "Scheduled",
 	FilterTabDone:            "Done",
 	FilterTabErrors:          "Errors",
+	FilterTabDead:            "Dead",
 	ActionCancelJob:          "Cancel Job",
 	ActionAbortJob:           "Abort Job",
 	ActionUpdateJob:          "Update Job",
 	ActionRerunJob:           "Rerun Job",
 	DetailTitleStatus:        "Status",
 	DetailTitleLog:           "Log",
+	DetailTitleAttempts:      "Failed Attempts",
+	DetailTitleWorkflow:      "Workflow",
+	DetailTitleChildren:      "Child Jobs",
+	DetailTitleArtifacts:     "Artifacts",
+	ArtifactExpiresAt:        "expires at",
+	LogFilterLevel:           "Level",
+	LogFilterAllLevels:       "All",
+	LogFilterSearch:          "Search",
+	ActionLoadEarlierLogs:    "Load earlier logs",
 	NoticeJobCannotBeAborted: "This job cannot be aborted/canceled/updated due to its status change",
 	NoticeJobWontBeExecuted:  "This job won't be executed due to code being deleted/modified",
 	ScheduleTime:             "Schedule Time",
 	DateTimePickerClearText:  "Clear",
 	DateTimePickerOkText:     "OK",
 	PleaseSelectJob:          "Please select job",
+
+	NoticeJobsWillBeReenqueued: "%d jobs will be performed again with their last arguments.",
+
+	RecurringJobActive:       "Active",
+	RecurringJobPaused:       "Paused",
+	ActionPauseRecurringJob:  "Pause",
+	ActionResumeRecurringJob: "Resume",
 }
 
 var Messages_zh_CN = &Messages{
@@ -71,23 +109,42 @@ var Messages_zh_CN = &Messages{
 	StatusDone:               "完成",
 	StatusException:          "错误",
 	StatusKilled:             "中止",
+	StatusRetrying:           "重试中",
+	StatusDead:               "死信",
 	FilterTabAll:             "全部",
 	FilterTabRunning:         "运行中",
 	FilterTabScheduled:       "计划",
 	FilterTabDone:            "完成",
 	FilterTabErrors:          "错误",
+	FilterTabDead:            "死信",
 	ActionCancelJob:          "取消Job",
 	ActionAbortJob:           "中止Job",
 	ActionUpdateJob:          "更新Job",
 	ActionRerunJob:           "重跑Job",
 	DetailTitleStatus:        "状态",
 	DetailTitleLog:           "日志",
+	DetailTitleAttempts:      "失败的尝试",
+	DetailTitleWorkflow:      "工作流",
+	DetailTitleChildren:      "子Job",
+	DetailTitleArtifacts:     "产出文件",
+	ArtifactExpiresAt:        "过期时间",
+	LogFilterLevel:           "级别",
+	LogFilterAllLevels:       "全部",
+	LogFilterSearch:          "搜索",
+	ActionLoadEarlierLogs:    "加载更早的日志",
 	NoticeJobCannotBeAborted: "Job状态已经改变，不能被中止/取消/更新",
 	NoticeJobWontBeExecuted:  "Job代码被删除/修改, 这个Job不会被执行",
 	ScheduleTime:             "执行时间",
 	DateTimePickerClearText:  "清空",
 	DateTimePickerOkText:     "确定",
 	PleaseSelectJob:          "请选择Job",
+
+	NoticeJobsWillBeReenqueued: "%d 个Job将使用上次的参数重新执行。",
+
+	RecurringJobActive:       "启用",
+	RecurringJobPaused:       "暂停",
+	ActionPauseRecurringJob:  "暂停",
+	ActionResumeRecurringJob: "恢复",
 }
 
 func getTStatus(msgr *Messages, status string) string {
@@ -106,6 +163,10 @@ func getTStatus(msgr *Messages, status string) string {
 		return msgr.StatusException
 	case JobStatusKilled:
 		return msgr.StatusKilled
+	case JobStatusRetrying:
+		return msgr.StatusRetrying
+	case JobStatusDead:
+		return msgr.StatusDead
 	}
 	return status
 }
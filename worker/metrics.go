@@ -0,0 +1,382 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultMetricsBuckets are the upper bounds in seconds of the queue lag and run duration histograms
+var DefaultMetricsBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type jobMetrics struct {
+	enqueued  uint64
+	started   uint64
+	succeeded uint64
+	failed    uint64
+	running   int64
+	lag       *histogram
+	duration  *histogram
+
+	lastStartedAt   *time.Time
+	lastSucceededAt *time.Time
+	lastFailedAt    *time.Time
+	lastError       string
+}
+
+// metrics are collected in process, every process exposes the jobs it enqueued and ran
+type metrics struct {
+	mutex   sync.Mutex
+	buckets []float64
+	jobs    map[string]*jobMetrics
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		buckets: DefaultMetricsBuckets,
+		jobs:    make(map[string]*jobMetrics),
+	}
+}
+
+// job must be called with the mutex held
+func (m *metrics) job(name string) *jobMetrics {
+	jm, ok := m.jobs[name]
+	if !ok {
+		jm = &jobMetrics{
+			lag:      newHistogram(m.buckets),
+			duration: newHistogram(m.buckets),
+		}
+		m.jobs[name] = jm
+	}
+	return jm
+}
+
+func (m *metrics) enqueued(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.job(name).enqueued++
+}
+
+// started records a run, lag is negative if the run is a retry
+func (m *metrics) started(name string, lag time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	jm := m.job(name)
+	jm.started++
+	jm.running++
+	now := time.Now()
+	jm.lastStartedAt = &now
+	if lag >= 0 {
+		jm.lag.observe(lag.Seconds())
+	}
+}
+
+func (m *metrics) stopped(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.job(name).running--
+}
+
+func (m *metrics) finished(name string, d time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	jm := m.job(name)
+	jm.duration.observe(d.Seconds())
+	now := time.Now()
+	if err != nil {
+		jm.failed++
+		jm.lastFailedAt = &now
+		jm.lastError = err.Error()
+		return
+	}
+	jm.succeeded++
+	jm.lastSucceededAt = &now
+}
+
+// MetricsBuckets sets the upper bounds in seconds of the queue lag and run duration histograms,
+// it should be called before any job is run
+func (b *Builder) MetricsBuckets(buckets ...float64) *Builder {
+	sort.Float64s(buckets)
+	b.metrics.buckets = buckets
+	return b
+}
+
+func (b *Builder) enqueue(ctx context.Context, inst *QorJobInstance) error {
+	if err := b.q.Add(ctx, inst); err != nil {
+		return err
+	}
+	b.metrics.enqueued(inst.Job)
+	return nil
+}
+
+// enqueueInTx enqueues the job in tx if the queue stores the jobs in the database,
+// queued is false for the other queues, the job should be enqueued after tx is committed
+// since they may run it before its rows are visible.
+func (b *Builder) enqueueInTx(ctx context.Context, tx *gorm.DB, inst *QorJobInstance) (queued bool, err error) {
+	ta, ok := b.q.(txAdder)
+	if !ok {
+		return false, nil
+	}
+	inst.tx = tx
+	defer func() { inst.tx = nil }()
+	if err = ta.AddInTx(ctx, tx, inst); err != nil {
+		if errors.Is(err, errNotSQLTx) {
+			return false, nil
+		}
+		return false, err
+	}
+	b.metrics.enqueued(inst.Job)
+	return true, nil
+}
+
+// queueLag is how long the job waited in the queue before its first run, -1 for the retries
+func (job *QorJobInstance) queueLag() time.Duration {
+	if job.Attempt > 0 {
+		return -1
+	}
+	enqueuedAt := job.CreatedAt
+	if args, err := job.getArgument(); err == nil {
+		if scheduler, ok := args.(Scheduler); ok && scheduler.GetScheduleTime() != nil && scheduler.GetScheduleTime().After(enqueuedAt) {
+			enqueuedAt = *scheduler.GetScheduleTime()
+		}
+	}
+	lag := time.Since(enqueuedAt)
+	if lag < 0 {
+		return 0
+	}
+	return lag
+}
+
+var metricsLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func metricsLabel(job string) string {
+	return fmt.Sprintf(`{job="%s"}`, metricsLabelReplacer.Replace(job))
+}
+
+func writeMetricsHeader(w io.Writer, name string, typ string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatMetricsFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type pendingJobs struct {
+	Job    string
+	Status string
+	Count  int64
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format.
+// The counters, running gauge and histograms are of the current process,
+// the pending jobs and their oldest age are read from the database.
+func (b *Builder) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pending []*pendingJobs
+		if err := b.db.WithContext(r.Context()).Model(&QorJob{}).
+			Select("job, status, count(*) AS count").
+			Where("status IN ?", []string{JobStatusNew, JobStatusScheduled, JobStatusRetrying}).
+			Group("job, status").
+			Order("job, status").
+			Scan(&pending).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		oldest := make(map[string]time.Time)
+		for _, jb := range b.jbs {
+			var js []*QorJob
+			if err := b.db.WithContext(r.Context()).
+				Where("job = ? AND status = ?", jb.name, JobStatusNew).
+				Order("created_at").
+				Limit(1).
+				Find(&js).Error; err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if len(js) > 0 {
+				oldest[jb.name] = js[0].CreatedAt
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		writeMetricsHeader(w, "worker_jobs_pending", "gauge", "Jobs waiting to run by status.")
+		for _, p := range pending {
+			fmt.Fprintf(w, "worker_jobs_pending{job=\"%s\",status=\"%s\"} %d\n", metricsLabelReplacer.Replace(p.Job), p.Status, p.Count)
+		}
+		writeMetricsHeader(w, "worker_oldest_pending_job_age_seconds", "gauge", "Age of the oldest new job that is not started yet.")
+		now := b.db.NowFunc()
+		for _, jb := range b.jbs {
+			if createdAt, ok := oldest[jb.name]; ok {
+				fmt.Fprintf(w, "worker_oldest_pending_job_age_seconds%s %s\n", metricsLabel(jb.name), formatMetricsFloat(now.Sub(createdAt).Seconds()))
+			}
+		}
+
+		b.metrics.mutex.Lock()
+		defer b.metrics.mutex.Unlock()
+		names := make([]string, 0, len(b.metrics.jobs))
+		for name := range b.metrics.jobs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		counters := []struct {
+			name  string
+			help  string
+			value func(jm *jobMetrics) uint64
+		}{
+			{"worker_jobs_enqueued_total", "Jobs enqueued.", func(jm *jobMetrics) uint64 { return jm.enqueued }},
+			{"worker_jobs_started_total", "Job runs started, including the retries.", func(jm *jobMetrics) uint64 { return jm.started }},
+			{"worker_jobs_succeeded_total", "Job runs succeeded.", func(jm *jobMetrics) uint64 { return jm.succeeded }},
+			{"worker_jobs_failed_total", "Job runs failed, including the ones to be retried.", func(jm *jobMetrics) uint64 { return jm.failed }},
+		}
+		for _, c := range counters {
+			writeMetricsHeader(w, c.name, "counter", c.help)
+			for _, name := range names {
+				fmt.Fprintf(w, "%s%s %d\n", c.name, metricsLabel(name), c.value(b.metrics.jobs[name]))
+			}
+		}
+		writeMetricsHeader(w, "worker_jobs_running", "gauge", "Jobs running.")
+		for _, name := range names {
+			fmt.Fprintf(w, "worker_jobs_running%s %d\n", metricsLabel(name), b.metrics.jobs[name].running)
+		}
+
+		histograms := []struct {
+			name  string
+			help  string
+			value func(jm *jobMetrics) *histogram
+		}{
+			{"worker_job_queue_lag_seconds", "Time a job waited in the queue before its first run.", func(jm *jobMetrics) *histogram { return jm.lag }},
+			{"worker_job_run_duration_seconds", "Duration of the job runs.", func(jm *jobMetrics) *histogram { return jm.duration }},
+		}
+		for _, hm := range histograms {
+			writeMetricsHeader(w, hm.name, "histogram", hm.help)
+			for _, name := range names {
+				h := hm.value(b.metrics.jobs[name])
+				job := metricsLabelReplacer.Replace(name)
+				for i, bound := range h.buckets {
+					fmt.Fprintf(w, "%s_bucket{job=\"%s\",le=\"%s\"} %d\n", hm.name, job, formatMetricsFloat(bound), h.counts[i])
+				}
+				fmt.Fprintf(w, "%s_bucket{job=\"%s\",le=\"+Inf\"} %d\n", hm.name, job, h.count)
+				fmt.Fprintf(w, "%s_sum{job=\"%s\"} %s\n", hm.name, job, formatMetricsFloat(h.sum))
+				fmt.Fprintf(w, "%s_count{job=\"%s\"} %d\n", hm.name, job, h.count)
+			}
+		}
+	})
+}
+
+// JobHealth is the health of a job definition
+type JobHealth struct {
+	Name string
+	// Listening is true while the queue has loops taking the job
+	Listening       bool
+	Listeners       int
+	ListenError     string `json:",omitempty"`
+	Running         int64
+	LastStartedAt   *time.Time `json:",omitempty"`
+	LastSucceededAt *time.Time `json:",omitempty"`
+	LastFailedAt    *time.Time `json:",omitempty"`
+	LastError       string     `json:",omitempty"`
+}
+
+// Health is the response of the health handler
+type Health struct {
+	// Status is ok if the database is reachable and all the job definitions are listening
+	Status        string
+	DatabaseError string `json:",omitempty"`
+	Jobs          []*JobHealth
+}
+
+const (
+	HealthStatusOK        = "ok"
+	HealthStatusUnhealthy = "unhealthy"
+)
+
+// Health reports the listener status of every job definition
+func (b *Builder) Health(ctx context.Context) *Health {
+	h := &Health{Status: HealthStatusOK}
+	if db, err := b.db.DB(); err != nil {
+		h.DatabaseError = err.Error()
+	} else if err = db.PingContext(ctx); err != nil {
+		h.DatabaseError = err.Error()
+	}
+	if h.DatabaseError != "" {
+		h.Status = HealthStatusUnhealthy
+	}
+
+	b.metrics.mutex.Lock()
+	defer b.metrics.mutex.Unlock()
+	jds := b.jds
+	if len(jds) == 0 {
+		h.Status = HealthStatusUnhealthy
+	}
+	for _, jd := range jds {
+		listeners, err := jd.listenerStatus()
+		jh := &JobHealth{
+			Name:      jd.Name,
+			Listening: listeners > 0,
+			Listeners: listeners,
+		}
+		if err != nil {
+			jh.ListenError = err.Error()
+		}
+		if jm, ok := b.metrics.jobs[jd.Name]; ok {
+			jh.Running = jm.running
+			jh.LastStartedAt = jm.lastStartedAt
+			jh.LastSucceededAt = jm.lastSucceededAt
+			jh.LastFailedAt = jm.lastFailedAt
+			jh.LastError = jm.lastError
+		}
+		if !jh.Listening {
+			h.Status = HealthStatusUnhealthy
+		}
+		h.Jobs = append(h.Jobs, jh)
+	}
+	return h
+}
+
+// HealthHandler serves Health as json, the status code is 503 if it is unhealthy
+func (b *Builder) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := b.Health(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if h.Status != HealthStatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(h)
+	})
+}
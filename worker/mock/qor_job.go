@@ -5,6 +5,7 @@ package mock
 
 import (
 	"github.com/qor5/admin/worker"
+	"io"
 	"sync"
 )
 
@@ -18,6 +19,9 @@ var _ worker.QorJobInterface = &QorJobInterfaceMock{}
 //
 //		// make and configure a mocked worker.QorJobInterface
 //		mockedQorJobInterface := &QorJobInterfaceMock{
+//			AddArtifactFunc: func(name string, r io.Reader) error {
+//				panic("mock out the AddArtifact method")
+//			},
 //			AddLogFunc: func(s string) error {
 //				panic("mock out the AddLog method")
 //			},
@@ -27,6 +31,9 @@ var _ worker.QorJobInterface = &QorJobInterfaceMock{}
 //			GetJobInfoFunc: func() (*worker.JobInfo, error) {
 //				panic("mock out the GetJobInfo method")
 //			},
+//			LogFunc: func(level worker.LogLevel, msg string, keyvals ...interface{}) error {
+//				panic("mock out the Log method")
+//			},
 //			SetProgressFunc: func(v uint) error {
 //				panic("mock out the SetProgress method")
 //			},
@@ -40,6 +47,9 @@ var _ worker.QorJobInterface = &QorJobInterfaceMock{}
 //
 //	}
 type QorJobInterfaceMock struct {
+	// AddArtifactFunc mocks the AddArtifact method.
+	AddArtifactFunc func(name string, r io.Reader) error
+
 	// AddLogFunc mocks the AddLog method.
 	AddLogFunc func(s string) error
 
@@ -49,6 +59,9 @@ type QorJobInterfaceMock struct {
 	// GetJobInfoFunc mocks the GetJobInfo method.
 	GetJobInfoFunc func() (*worker.JobInfo, error)
 
+	// LogFunc mocks the Log method.
+	LogFunc func(level worker.LogLevel, msg string, keyvals ...interface{}) error
+
 	// SetProgressFunc mocks the SetProgress method.
 	SetProgressFunc func(v uint) error
 
@@ -57,6 +70,13 @@ type QorJobInterfaceMock struct {
 
 	// calls tracks calls to the methods.
 	calls struct {
+		// AddArtifact holds details about calls to the AddArtifact method.
+		AddArtifact []struct {
+			// Name is the name argument value.
+			Name string
+			// R is the r argument value.
+			R io.Reader
+		}
 		// AddLog holds details about calls to the AddLog method.
 		AddLog []struct {
 			// S is the s argument value.
@@ -72,6 +92,15 @@ type QorJobInterfaceMock struct {
 		// GetJobInfo holds details about calls to the GetJobInfo method.
 		GetJobInfo []struct {
 		}
+		// Log holds details about calls to the Log method.
+		Log []struct {
+			// Level is the level argument value.
+			Level worker.LogLevel
+			// Msg is the msg argument value.
+			Msg string
+			// Keyvals is the keyvals argument value.
+			Keyvals []interface{}
+		}
 		// SetProgress holds details about calls to the SetProgress method.
 		SetProgress []struct {
 			// V is the v argument value.
@@ -83,13 +112,51 @@ type QorJobInterfaceMock struct {
 			S string
 		}
 	}
+	lockAddArtifact     sync.RWMutex
 	lockAddLog          sync.RWMutex
 	lockAddLogf         sync.RWMutex
 	lockGetJobInfo      sync.RWMutex
+	lockLog             sync.RWMutex
 	lockSetProgress     sync.RWMutex
 	lockSetProgressText sync.RWMutex
 }
 
+// AddArtifact calls AddArtifactFunc.
+func (mock *QorJobInterfaceMock) AddArtifact(name string, r io.Reader) error {
+	if mock.AddArtifactFunc == nil {
+		panic("QorJobInterfaceMock.AddArtifactFunc: method is nil but QorJobInterface.AddArtifact was just called")
+	}
+	callInfo := struct {
+		Name string
+		R    io.Reader
+	}{
+		Name: name,
+		R:    r,
+	}
+	mock.lockAddArtifact.Lock()
+	mock.calls.AddArtifact = append(mock.calls.AddArtifact, callInfo)
+	mock.lockAddArtifact.Unlock()
+	return mock.AddArtifactFunc(name, r)
+}
+
+// AddArtifactCalls gets all the calls that were made to AddArtifact.
+// Check the length with:
+//
+//	len(mockedQorJobInterface.AddArtifactCalls())
+func (mock *QorJobInterfaceMock) AddArtifactCalls() []struct {
+	Name string
+	R    io.Reader
+} {
+	var calls []struct {
+		Name string
+		R    io.Reader
+	}
+	mock.lockAddArtifact.RLock()
+	calls = mock.calls.AddArtifact
+	mock.lockAddArtifact.RUnlock()
+	return calls
+}
+
 // AddLog calls AddLogFunc.
 func (mock *QorJobInterfaceMock) AddLog(s string) error {
 	if mock.AddLogFunc == nil {
@@ -185,6 +252,46 @@ func (mock *QorJobInterfaceMock) GetJobInfoCalls() []struct {
 	return calls
 }
 
+// Log calls LogFunc.
+func (mock *QorJobInterfaceMock) Log(level worker.LogLevel, msg string, keyvals ...interface{}) error {
+	if mock.LogFunc == nil {
+		panic("QorJobInterfaceMock.LogFunc: method is nil but QorJobInterface.Log was just called")
+	}
+	callInfo := struct {
+		Level   worker.LogLevel
+		Msg     string
+		Keyvals []interface{}
+	}{
+		Level:   level,
+		Msg:     msg,
+		Keyvals: keyvals,
+	}
+	mock.lockLog.Lock()
+	mock.calls.Log = append(mock.calls.Log, callInfo)
+	mock.lockLog.Unlock()
+	return mock.LogFunc(level, msg, keyvals...)
+}
+
+// LogCalls gets all the calls that were made to Log.
+// Check the length with:
+//
+//	len(mockedQorJobInterface.LogCalls())
+func (mock *QorJobInterfaceMock) LogCalls() []struct {
+	Level   worker.LogLevel
+	Msg     string
+	Keyvals []interface{}
+} {
+	var calls []struct {
+		Level   worker.LogLevel
+		Msg     string
+		Keyvals []interface{}
+	}
+	mock.lockLog.RLock()
+	calls = mock.calls.Log
+	mock.lockLog.RUnlock()
+	return calls
+}
+
 // SetProgress calls SetProgressFunc.
 func (mock *QorJobInterfaceMock) SetProgress(v uint) error {
 	if mock.SetProgressFunc == nil {
@@ -13,6 +13,11 @@ type QorJob struct {
 	Job    string
 	Status string      `sql:"default:'new'"`
 	Args   interface{} `sql:"-" gorm:"-"`
+
+	// IdempotencyKey is set if the job is deduplicated, see JobBuilder.DedupWindow
+	IdempotencyKey string `gorm:"index;size:64"`
+	// Deduplicated is true if the job returned by the creation is an existing one
+	Deduplicated bool `sql:"-" gorm:"-"`
 }
 
 type QorJobInstance struct {
@@ -30,10 +35,29 @@ type QorJobInstance struct {
 	Progress     uint
 	ProgressText string
 
+	// Attempt is the number of runs, AttemptHistory is the json of []*JobAttempt
+	Attempt        uint
+	AttemptHistory string
+
+	// the step of a workflow, ParentQorJobID is the QorJob of the workflow
+	ParentQorJobID uint `gorm:"index"`
+	WorkflowStep   string
+	WorkflowIndex  int
+	// Output is the json set by SetJobOutput
+	Output string
+
+	// HeartbeatAt is updated periodically while the job is running,
+	// the reaper recovers the running jobs whose heartbeat expired
+	HeartbeatAt *time.Time `gorm:"index"`
+
 	jb          *JobBuilder `sql:"-"`
 	mutex       sync.Mutex  `sql:"-"`
 	stopRefresh bool        `sql:"-"`
 	inRefresh   bool        `sql:"-"`
+	// running is true between StartRefresh and StopRefresh, for the running metrics
+	running bool `sql:"-"`
+	// tx saves the instance in a transaction instead of the db of the builder, e.g. while it is reaped
+	tx *gorm.DB `sql:"-"`
 }
 
 type QorJobLog struct {
@@ -41,7 +65,11 @@ type QorJobLog struct {
 	CreatedAt time.Time `gorm:"index"`
 
 	QorJobInstanceID uint `gorm:"index"`
-	Log              string
+	// Level is empty for the logs written before levels were added, they are treated as info
+	Level LogLevel `gorm:"size:16"`
+	Log   string
+	// Fields is the json of the []JobLogField
+	Fields string
 }
 
 type Scheduler interface {
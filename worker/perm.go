@@ -6,9 +6,14 @@ import "net/http"
 // permPolicy.On("*")
 // permPolicy.On("workers:upload_posts")
 const (
-	PermEdit = "perm_worker_edit"
+	PermEdit             = "perm_worker_edit"
+	PermDownloadArtifact = "perm_worker_download_artifact"
 )
 
 func editIsAllowed(r *http.Request, jobName string) error {
 	return permVerifier.Do(PermEdit).SnakeOn(jobName).WithReq(r).IsAllowed()
 }
+
+func downloadArtifactIsAllowed(r *http.Request, jobName string) error {
+	return permVerifier.Do(PermDownloadArtifact).SnakeOn(jobName).WithReq(r).IsAllowed()
+}
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// WorkerPool caps the number of jobs running at the same time across all the job definitions,
+// a free slot is given to the waiting job with the highest priority first, FIFO for equal priorities.
+type WorkerPool struct {
+	size int
+
+	mutex   sync.Mutex
+	inUse   int
+	waiters []*poolWaiter
+}
+
+type poolWaiter struct {
+	priority int
+	ready    chan struct{}
+}
+
+func NewWorkerPool(size int) *WorkerPool {
+	if size <= 0 {
+		panic("worker pool size should be greater than 0")
+	}
+	return &WorkerPool{size: size}
+}
+
+// Acquire blocks until a slot is free or ctx is done, Release must be called after the job is performed
+func (p *WorkerPool) Acquire(ctx context.Context, priority int) error {
+	p.mutex.Lock()
+	if p.inUse < p.size && len(p.waiters) == 0 {
+		p.inUse++
+		p.mutex.Unlock()
+		return nil
+	}
+	w := &poolWaiter{priority: priority, ready: make(chan struct{})}
+	p.waiters = append(p.waiters, w)
+	p.mutex.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		select {
+		case <-w.ready:
+			// the slot was handed over at the same time, give it to the next one
+			p.release()
+		default:
+			p.removeWaiter(w)
+		}
+		return ctx.Err()
+	}
+}
+
+func (p *WorkerPool) Release() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.release()
+}
+
+// release hands the slot over to the next waiter, the caller must hold the mutex
+func (p *WorkerPool) release() {
+	if len(p.waiters) == 0 {
+		p.inUse--
+		return
+	}
+	next := 0
+	for i, w := range p.waiters {
+		if w.priority > p.waiters[next].priority {
+			next = i
+		}
+	}
+	w := p.waiters[next]
+	p.waiters = append(p.waiters[:next], p.waiters[next+1:]...)
+	close(w.ready)
+}
+
+func (p *WorkerPool) removeWaiter(w *poolWaiter) {
+	for i, it := range p.waiters {
+		if it == w {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return
+		}
+	}
+}
@@ -1,12 +1,102 @@
 package worker
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
 
 //go:generate moq -pkg mock -out mock/queue.go . Queue
 
+// txAdder is implemented by the queues which store the jobs in the database of the builder,
+// so that a job is enqueued in the transaction creating it
+type txAdder interface {
+	AddInTx(ctx context.Context, tx *gorm.DB, job QueJobInterface) error
+}
+
+// errNotSQLTx is returned by AddInTx and Requeue of the queues that enqueue in a *sql.Tx only, the job is enqueued after the transaction instead
+var errNotSQLTx = errors.New("the transaction is not a *sql.Tx")
+
+// requeuer is implemented by the queues which can replace the pending delivery of a job in a transaction,
+// the reaper uses it to run a stale job again at runAt without delivering it twice
+type requeuer interface {
+	Requeue(ctx context.Context, tx *gorm.DB, job QueJobInterface, runAt time.Time) error
+}
+
 type QorJobDefinition struct {
 	Name    string
 	Handler JobHandler
+
+	// Concurrency is the max number of the job running at the same time, default is 1
+	Concurrency int
+	// RateLimit is the max number of the job started per second, 0 means the queue default
+	RateLimit float64
+	// Priority decides which job takes a free slot of Pool first, higher runs first
+	Priority int
+	// Pool caps the running jobs of all the definitions, nil means no cap
+	Pool *WorkerPool
+
+	// listeners is the number of the queue loops of the definition, reported by the health handler
+	listeners  int32
+	listenErr  error
+	errorMutex sync.Mutex
+}
+
+// listenerStarted is called by the queues before a loop of the definition starts
+func (jd *QorJobDefinition) listenerStarted() {
+	atomic.AddInt32(&jd.listeners, 1)
+}
+
+// listenerStopped is called by the queues after a loop of the definition exits, err is why it exits unexpectedly
+func (jd *QorJobDefinition) listenerStopped(err error) {
+	atomic.AddInt32(&jd.listeners, -1)
+	if err != nil {
+		jd.errorMutex.Lock()
+		jd.listenErr = err
+		jd.errorMutex.Unlock()
+	}
+}
+
+func (jd *QorJobDefinition) listenerStatus() (listeners int, err error) {
+	jd.errorMutex.Lock()
+	defer jd.errorMutex.Unlock()
+	return int(atomic.LoadInt32(&jd.listeners)), jd.listenErr
+}
+
+func (jd *QorJobDefinition) concurrency() int {
+	if jd.Concurrency <= 0 {
+		return 1
+	}
+	return jd.Concurrency
+}
+
+// newRateLimiter returns nil if the job definition is not rate limited
+func (jd *QorJobDefinition) newRateLimiter() *rate.Limiter {
+	if jd.RateLimit <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(jd.RateLimit), 1)
+}
+
+func (jd *QorJobDefinition) acquire(ctx context.Context) error {
+	if jd.Pool == nil {
+		return nil
+	}
+	return jd.Pool.Acquire(ctx, jd.Priority)
+}
+
+func (jd *QorJobDefinition) release() {
+	if jd.Pool != nil {
+		jd.Pool.Release()
+	}
 }
 
 type Queue interface {
@@ -16,3 +106,141 @@ type Queue interface {
 	Listen(jobDefs []*QorJobDefinition, getJob func(qorJobID uint) (QueJobInterface, error)) error
 	Shutdown(ctx context.Context) error
 }
+
+var (
+	errJobCancelled   = errors.New("job is cancelled")
+	errJobAborted     = errors.New("manually aborted")
+	errJobNotRunnable = errors.New("job is running or done by another worker")
+
+	// ErrJobTimeout is the error of a run that exceeds the timeout of the job
+	ErrJobTimeout = errors.New("job timeout")
+)
+
+// runJob runs the handler of a new, scheduled or retrying job and keeps its status up to date,
+// the handler context is cancelled once the job gets killed.
+// A *retryError is returned if the job should be performed again by its retry policy.
+// It is shared by the queues that run jobs in process.
+func runJob(ctx context.Context, job QueJobInterface) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			job.StopRefresh()
+			job.AddLog(string(debug.Stack()))
+			job.SetProgressText(fmt.Sprint(r))
+			job.SetStatus(JobStatusException)
+			err = fmt.Errorf("job panic: %v", r)
+		}
+	}()
+
+	if job.GetStatus() == JobStatusCancelled {
+		return errJobCancelled
+	}
+	// the job is delivered again, e.g. re-enqueued by the reaper or by a queue that lost the lock
+	if job.GetStatus() == JobStatusRunning || job.GetStatus() == JobStatusDone {
+		return errJobNotRunnable
+	}
+	if job.GetStatus() != JobStatusNew && job.GetStatus() != JobStatusScheduled && job.GetStatus() != JobStatusRetrying {
+		job.SetStatus(JobStatusKilled)
+		return errors.New("invalid job status, current status: " + job.GetStatus())
+	}
+
+	if err = job.SetStatus(JobStatusRunning); err != nil {
+		return err
+	}
+
+	var hctx context.Context
+	var cf context.CancelFunc
+	timeout := job.GetTimeout()
+	if timeout > 0 {
+		hctx, cf = context.WithTimeout(ctx, timeout)
+	} else {
+		hctx, cf = context.WithCancel(ctx)
+	}
+	defer cf()
+	hDoneC := make(chan struct{})
+	var isAborted int32
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hDoneC:
+				return
+			case <-ticker.C:
+				status, _ := job.FetchAndSetStatus()
+				if status == JobStatusKilled {
+					atomic.StoreInt32(&isAborted, 1)
+					cf()
+					return
+				}
+			}
+		}
+	}()
+
+	startedAt := time.Now()
+	job.StartRefresh()
+	err = job.GetHandler()(hctx, job)
+	job.StopRefresh()
+	close(hDoneC)
+
+	if atomic.LoadInt32(&isAborted) == 1 {
+		return errJobAborted
+	}
+	// the handler asks to be performed again, e.g. a workflow interrupted by the shutdown, it is not a failed attempt
+	var re *retryError
+	if errors.As(err, &re) {
+		if err = job.SetStatus(JobStatusRetrying); err != nil {
+			return err
+		}
+		return re
+	}
+	if timeout > 0 && ctx.Err() == nil && errors.Is(hctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %s", ErrJobTimeout, timeout)
+	}
+	if err != nil {
+		job.SetProgressText(err.Error())
+		retryIn, retry, rErr := job.RecordAttempt(startedAt, err)
+		if rErr != nil {
+			job.SetStatus(JobStatusException)
+			return rErr
+		}
+		if retry {
+			return &retryError{err: err, delay: retryIn}
+		}
+		return err
+	}
+	if _, _, err = job.RecordAttempt(startedAt, nil); err != nil {
+		return err
+	}
+	return job.SetStatus(JobStatusDone)
+}
+
+func qorJobIDOf(job QueJobInterface) (uint, error) {
+	jobInfo, err := job.GetJobInfo()
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(jobInfo.JobID, 10, 64)
+	return uint(id), err
+}
+
+// priorityOf is the priority of the job definition, the queues order the due jobs by it
+func priorityOf(job QueJobInterface) int {
+	if p, ok := job.(interface{ getPriority() int }); ok {
+		return p.getPriority()
+	}
+	return 0
+}
+
+func runAtOf(job QueJobInterface) (time.Time, error) {
+	jobInfo, err := job.GetJobInfo()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if scheduler, ok := jobInfo.Argument.(Scheduler); ok && scheduler.GetScheduleTime() != nil {
+		if err = job.SetStatus(JobStatusScheduled); err != nil {
+			return time.Time{}, err
+		}
+		return *scheduler.GetScheduleTime(), nil
+	}
+	return time.Now(), nil
+}
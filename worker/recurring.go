@@ -0,0 +1,384 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/qor5/admin/presets"
+	. "github.com/qor5/ui/vuetify"
+	vx "github.com/qor5/ui/vuetifyx"
+	"github.com/qor5/web"
+	"github.com/qor5/x/i18n"
+	. "github.com/theplant/htmlgo"
+	"gorm.io/gorm"
+)
+
+// OverlapPolicy decides what happens when a recurring job is due while its previous run is not finished
+type OverlapPolicy string
+
+const (
+	// OverlapSkip skips the run if the previous one is pending or running
+	OverlapSkip OverlapPolicy = "skip"
+	// OverlapQueue enqueues the run after a running one, but keeps at most one run pending
+	OverlapQueue OverlapPolicy = "queue"
+	// OverlapAllow always enqueues the run
+	OverlapAllow OverlapPolicy = "allow"
+)
+
+// QorRecurringJob keeps the schedule state of a recurring job, the row is shared by all the processes
+// so that a due run is enqueued only once
+type QorRecurringJob struct {
+	gorm.Model
+
+	Job      string `gorm:"uniqueIndex;size:255"`
+	Cron     string
+	Timezone string
+	Paused   bool
+
+	NextRunAt    *time.Time `gorm:"index"`
+	LastRunAt    *time.Time
+	LastQorJobID uint
+	// Runs is the number of dispatches, it is also used to claim a due run
+	Runs int64
+}
+
+// Recurring runs the job on the cron schedule, in the timezone location, e.g. Recurring("0 3 * * *", "Asia/Tokyo").
+// An empty timezone means time.Local. The runs are enqueued by the builder, so it works with all the queues.
+func (jb *JobBuilder) Recurring(cronExpr string, timezone string) *JobBuilder {
+	c, err := ParseCronExpression(cronExpr)
+	if err != nil {
+		panic(err)
+	}
+	loc := time.Local
+	if timezone != "" {
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			panic(fmt.Sprintf("job %s: %v", jb.name, err))
+		}
+	}
+	jb.cron = c
+	jb.timezone = timezone
+	jb.location = loc
+	return jb
+}
+
+// OverlapPolicy sets the overlap policy of a recurring job, default is OverlapSkip
+func (jb *JobBuilder) OverlapPolicy(p OverlapPolicy) *JobBuilder {
+	jb.overlapPolicy = p
+	return jb
+}
+
+// RecurringArgs builds the arguments of every recurring run, it should return the same type as Resource
+func (jb *JobBuilder) RecurringArgs(f func() interface{}) *JobBuilder {
+	jb.recurringArgs = f
+	return jb
+}
+
+// NextRuns returns the next n run times after from in the job timezone, nil if the job is not recurring
+func (jb *JobBuilder) NextRuns(from time.Time, n int) []time.Time {
+	if jb.cron == nil {
+		return nil
+	}
+	return jb.cron.NextN(from.In(jb.location), n)
+}
+
+func (jb *JobBuilder) nextRunAt(from time.Time) *time.Time {
+	t := jb.cron.Next(from.In(jb.location))
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// RecurringCheckInterval is how often the due recurring jobs are checked, default is 15 seconds
+func (b *Builder) RecurringCheckInterval(v time.Duration) *Builder {
+	b.recurringCheckInterval = v
+	return b
+}
+
+// PauseRecurringJob stops dispatching the recurring job until it is resumed
+func (b *Builder) PauseRecurringJob(name string) error {
+	return b.db.Model(&QorRecurringJob{}).Where("job = ?", name).
+		Update("paused", true).Error
+}
+
+// ResumeRecurringJob dispatches the recurring job again from its next run after now, missed runs are not caught up
+func (b *Builder) ResumeRecurringJob(name string) error {
+	jb := b.getJobBuilder(name)
+	if jb == nil || jb.cron == nil {
+		return fmt.Errorf("no recurring job %s", name)
+	}
+	return b.db.Model(&QorRecurringJob{}).Where("job = ?", name).
+		Updates(map[string]interface{}{
+			"paused":      false,
+			"next_run_at": jb.nextRunAt(b.db.NowFunc()),
+		}).Error
+}
+
+func (b *Builder) recurringJobBuilders() (jbs []*JobBuilder) {
+	for _, jb := range b.jbs {
+		if jb.cron != nil {
+			jbs = append(jbs, jb)
+		}
+	}
+	return
+}
+
+// syncRecurringJobs creates the rows of new recurring jobs and reschedules the ones whose cron or timezone changed
+func (b *Builder) syncRecurringJobs() error {
+	now := b.db.NowFunc()
+	for _, jb := range b.recurringJobBuilders() {
+		rj := QorRecurringJob{}
+		err := b.db.Where("job = ?", jb.name).First(&rj).Error
+		if err == gorm.ErrRecordNotFound {
+			err = b.db.Create(&QorRecurringJob{
+				Job:       jb.name,
+				Cron:      jb.cron.String(),
+				Timezone:  jb.timezone,
+				NextRunAt: jb.nextRunAt(now),
+			}).Error
+			if err != nil && b.db.Where("job = ?", jb.name).First(&rj).Error == nil {
+				// created by another process at the same time
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if rj.Cron == jb.cron.String() && rj.Timezone == jb.timezone && rj.NextRunAt != nil {
+			continue
+		}
+		if err = b.db.Model(&QorRecurringJob{}).Where("id = ?", rj.ID).
+			Updates(map[string]interface{}{
+				"cron":        jb.cron.String(),
+				"timezone":    jb.timezone,
+				"next_run_at": jb.nextRunAt(now),
+			}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Builder) startRecurring() {
+	if len(b.recurringJobBuilders()) == 0 {
+		return
+	}
+	if err := b.syncRecurringJobs(); err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	b.stops = append(b.stops, func() {
+		cancel()
+		<-done
+	})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(b.recurringCheckInterval)
+		defer ticker.Stop()
+		for {
+			if err := b.dispatchRecurringJobs(ctx); err != nil {
+				log.Printf("worker recurring jobs dispatch error: %v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// dispatchRecurringJobs enqueues the due recurring jobs, a due run is claimed by advancing next_run_at
+// with a conditional update on runs, so that only one process enqueues it
+func (b *Builder) dispatchRecurringJobs(ctx context.Context) error {
+	now := b.db.NowFunc()
+	var names []string
+	for _, jb := range b.recurringJobBuilders() {
+		names = append(names, jb.name)
+	}
+	var rjs []*QorRecurringJob
+	if err := b.db.Where("job IN ? AND paused = ? AND next_run_at <= ?", names, false, now).
+		Find(&rjs).Error; err != nil {
+		return err
+	}
+
+	for _, rj := range rjs {
+		if ctx.Err() != nil {
+			return nil
+		}
+		jb := b.getJobBuilder(rj.Job)
+		result := b.db.Model(&QorRecurringJob{}).
+			Where("id = ? AND runs = ?", rj.ID, rj.Runs).
+			Updates(map[string]interface{}{
+				"next_run_at": jb.nextRunAt(now),
+				"last_run_at": now,
+				"runs":        rj.Runs + 1,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		overlapping, err := b.hasOverlappingRun(jb)
+		if err != nil {
+			return err
+		}
+		if overlapping {
+			log.Printf("worker recurring job %s skipped, the previous run is not finished\n", jb.name)
+			continue
+		}
+
+		var args interface{}
+		if jb.recurringArgs != nil {
+			args = jb.recurringArgs()
+		}
+		j, err := b.addJob(ctx, nil, jb, args, map[string]interface{}{
+			"RecurringCron": rj.Cron,
+		})
+		if err != nil {
+			return err
+		}
+		if err = b.db.Model(&QorRecurringJob{}).Where("id = ?", rj.ID).
+			Update("last_qor_job_id", j.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Builder) hasOverlappingRun(jb *JobBuilder) (bool, error) {
+	var statuses []string
+	switch jb.overlapPolicy {
+	case OverlapAllow:
+		return false, nil
+	case OverlapQueue:
+		statuses = []string{JobStatusNew, JobStatusScheduled, JobStatusRetrying}
+	default:
+		statuses = []string{JobStatusNew, JobStatusScheduled, JobStatusRetrying, JobStatusRunning}
+	}
+	var count int64
+	err := b.db.Model(&QorJob{}).Where("job = ? AND status IN ?", jb.name, statuses).Count(&count).Error
+	return count > 0, err
+}
+
+func (b *Builder) configureRecurringJobs(pb *presets.Builder) {
+	mb := pb.Model(&QorRecurringJob{}).
+		Label("Recurring Jobs").
+		URIName("worker-recurring-jobs").
+		MenuIcon("update")
+	mb.RegisterEventFunc("worker_pauseRecurringJob", b.eventToggleRecurringJob(true))
+	mb.RegisterEventFunc("worker_resumeRecurringJob", b.eventToggleRecurringJob(false))
+
+	lb := mb.Listing("Job", "Cron", "Timezone", "Paused", "NextRunAt", "UpcomingRuns", "LastRunAt")
+	lb.NewButtonFunc(func(ctx *web.EventContext) HTMLComponent { return nil })
+	lb.Field("Job").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) HTMLComponent {
+		return Td(Text(getTJob(ctx.R, obj.(*QorRecurringJob).Job)))
+	})
+	lb.Field("Paused").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) HTMLComponent {
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nWorkerKey, Messages_en_US).(*Messages)
+		if obj.(*QorRecurringJob).Paused {
+			return Td(Text(msgr.RecurringJobPaused))
+		}
+		return Td(Text(msgr.RecurringJobActive))
+	})
+	lb.Field("NextRunAt").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) HTMLComponent {
+		rj := obj.(*QorRecurringJob)
+		if rj.Paused {
+			return Td(Text("-"))
+		}
+		return Td(Text(b.formatRecurringTime(rj.Job, rj.NextRunAt)))
+	})
+	lb.Field("LastRunAt").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) HTMLComponent {
+		rj := obj.(*QorRecurringJob)
+		return Td(Text(b.formatRecurringTime(rj.Job, rj.LastRunAt)))
+	})
+	lb.Field("UpcomingRuns").ComponentFunc(func(obj interface{}, field *presets.FieldContext, ctx *web.EventContext) HTMLComponent {
+		rj := obj.(*QorRecurringJob)
+		jb := b.getJobBuilder(rj.Job)
+		if rj.Paused || rj.NextRunAt == nil || jb == nil || jb.cron == nil {
+			return Td(Text("-"))
+		}
+		// the first one is NextRunAt
+		var runs []HTMLComponent
+		for _, t := range jb.NextRuns(*rj.NextRunAt, 3) {
+			runs = append(runs, Div(Text(t.Format("2006-01-02 15:04 MST"))))
+		}
+		return Td(runs...)
+	})
+
+	lb.RowMenu("Pause", "Resume")
+	lb.RowMenu().RowMenuItem("Pause").ComponentFunc(b.recurringJobRowMenuItem(true))
+	lb.RowMenu().RowMenuItem("Resume").ComponentFunc(b.recurringJobRowMenuItem(false))
+
+	mb.Editing("Paused").SaveFunc(func(obj interface{}, id string, ctx *web.EventContext) (err error) {
+		rj := obj.(*QorRecurringJob)
+		if err = editIsAllowed(ctx.R, rj.Job); err != nil {
+			return
+		}
+		if rj.Paused {
+			return b.PauseRecurringJob(rj.Job)
+		}
+		return b.ResumeRecurringJob(rj.Job)
+	})
+}
+
+func (b *Builder) formatRecurringTime(job string, t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	loc := time.Local
+	if jb := b.getJobBuilder(job); jb != nil && jb.location != nil {
+		loc = jb.location
+	}
+	return t.In(loc).Format("2006-01-02 15:04 MST")
+}
+
+func (b *Builder) recurringJobRowMenuItem(pause bool) vx.RowMenuItemFunc {
+	return func(obj interface{}, id string, ctx *web.EventContext) HTMLComponent {
+		rj := obj.(*QorRecurringJob)
+		if rj.Paused == pause || editIsAllowed(ctx.R, rj.Job) != nil {
+			return nil
+		}
+		msgr := i18n.MustGetModuleMessages(ctx.R, I18nWorkerKey, Messages_en_US).(*Messages)
+		icon, label, event := "pause", msgr.ActionPauseRecurringJob, "worker_pauseRecurringJob"
+		if !pause {
+			icon, label, event = "play_arrow", msgr.ActionResumeRecurringJob, "worker_resumeRecurringJob"
+		}
+		return VListItem(
+			VListItemIcon(VIcon(icon)),
+			VListItemTitle(Text(label)),
+		).Attr("@click", web.Plaid().
+			EventFunc(event).
+			Query("job", rj.Job).
+			Go())
+	}
+}
+
+func (b *Builder) eventToggleRecurringJob(pause bool) web.EventFunc {
+	return func(ctx *web.EventContext) (er web.EventResponse, err error) {
+		job := ctx.R.FormValue("job")
+		if err = editIsAllowed(ctx.R, job); err != nil {
+			return
+		}
+		if pause {
+			err = b.PauseRecurringJob(job)
+		} else {
+			err = b.ResumeRecurringJob(job)
+		}
+		if err != nil {
+			return
+		}
+		er.Reload = true
+		return
+	}
+}
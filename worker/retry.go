@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+// RetryPolicy retries a job after its handler returns an error,
+// the job is moved to JobStatusDead once the attempts are exhausted or the error is not retryable
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt
+	MaxAttempts uint
+	// InitialBackoff is the delay before the first retry, default is 10 seconds
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries, default is 1 hour
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every retry, default is 2
+	Multiplier float64
+	// Retryable classifies the errors, by default all errors are retryable except the ones wrapped by NonRetryable
+	Retryable func(err error) bool
+}
+
+func (p *RetryPolicy) backoff(attempt uint) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = 10 * time.Second
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = time.Hour
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	d := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if d > float64(max) {
+		return max
+	}
+	return time.Duration(d)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	var nr *nonRetryableError
+	if errors.As(err, &nr) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return true
+}
+
+type nonRetryableError struct {
+	err error
+}
+
+func (e *nonRetryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nonRetryableError) Unwrap() error {
+	return e.err
+}
+
+// NonRetryable wraps err returned by a job handler, so that the job is moved to dead without retrying
+func NonRetryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &nonRetryableError{err: err}
+}
+
+// retryError is returned by runJob when the job should be performed again after the delay
+type retryError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *retryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+// JobAttempt is a run of a job instance
+type JobAttempt struct {
+	Attempt    uint
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Error      string `json:",omitempty"`
+}
+
+// RetryPolicy sets the retry policy of the job, by default a failed job ends in exception
+// example: RetryPolicy(&RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Minute})
+func (jb *JobBuilder) RetryPolicy(p *RetryPolicy) *JobBuilder {
+	jb.retryPolicy = p
+	return jb
+}
+
+func (job *QorJobInstance) GetAttempts() (attempts []*JobAttempt, err error) {
+	if job.AttemptHistory == "" {
+		return
+	}
+	err = json.Unmarshal([]byte(job.AttemptHistory), &attempts)
+	return
+}
+
+// RecordAttempt records the attempt on the instance and moves a failed job to retrying, dead or exception
+func (job *QorJobInstance) RecordAttempt(startedAt time.Time, err error) (retryIn time.Duration, retry bool, e error) {
+	attempts, e := job.GetAttempts()
+	if e != nil {
+		return
+	}
+	attempt := &JobAttempt{
+		Attempt:    job.Attempt + 1,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	history, e := json.Marshal(append(attempts, attempt))
+	if e != nil {
+		return
+	}
+
+	job.mutex.Lock()
+	job.Attempt = attempt.Attempt
+	job.AttemptHistory = string(history)
+	job.mutex.Unlock()
+	job.jb.b.metrics.finished(job.jb.name, attempt.FinishedAt.Sub(startedAt), err)
+
+	if err == nil {
+		return
+	}
+
+	status := JobStatusException
+	if p := job.jb.retryPolicy; p != nil {
+		status = JobStatusDead
+		if attempt.Attempt < p.MaxAttempts && p.retryable(err) {
+			status = JobStatusRetrying
+			retryIn = p.backoff(attempt.Attempt)
+			retry = true
+		}
+	}
+	e = job.SetStatus(status)
+	return
+}
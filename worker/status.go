@@ -15,4 +15,8 @@ const (
 	JobStatusException = "exception"
 	// JobStatusKilled job status killed
 	JobStatusKilled = "killed"
+	// JobStatusRetrying job status retrying, the job failed and waits for the next attempt
+	JobStatusRetrying = "retrying"
+	// JobStatusDead job status dead, the job failed and exhausted the attempts of its retry policy
+	JobStatusDead = "dead"
 )
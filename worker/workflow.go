@@ -0,0 +1,490 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// QorWorkflowStep records a started step of a workflow run, Count is the number of child jobs of the step
+type QorWorkflowStep struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+
+	ParentQorJobID uint   `gorm:"uniqueIndex:idx_qor_workflow_step"`
+	Step           string `gorm:"uniqueIndex:idx_qor_workflow_step;size:255"`
+	Count          int
+}
+
+// WorkflowBuilder defines a DAG of jobs which runs as a job itself,
+// the workflow job enqueues the steps as child jobs once their dependencies are done.
+// The workflow job waits for its children, it occupies a worker of its own job but not a slot of the worker pool,
+// so that the children are not blocked by their waiting parents.
+//
+//	wf := w.NewWorkflow("catalogSync")
+//	wf.Step("import", "importProducts")
+//	wf.Step("localize", "localizeProduct").After("import").
+//		FanOut(func(wc *worker.WorkflowContext) ([]interface{}, error) {...})
+//	wf.Step("publish", "publishProducts").After("localize")
+type WorkflowBuilder struct {
+	b            *Builder
+	jb           *JobBuilder
+	steps        []*WorkflowStepBuilder
+	pollInterval time.Duration
+}
+
+type WorkflowStepBuilder struct {
+	name    string
+	jobName string
+	after   []string
+	argsF   func(wc *WorkflowContext) (interface{}, error)
+	fanOutF func(wc *WorkflowContext) ([]interface{}, error)
+}
+
+// NewWorkflow registers a job named name which runs the workflow
+func (b *Builder) NewWorkflow(name string) *WorkflowBuilder {
+	wf := &WorkflowBuilder{
+		b:            b,
+		pollInterval: time.Second,
+	}
+	wf.jb = b.NewJob(name).Handler(wf.run)
+	b.wfs = append(b.wfs, wf)
+	return wf
+}
+
+// Job returns the job of the workflow, e.g. to set its Resource
+func (wf *WorkflowBuilder) Job() *JobBuilder {
+	return wf.jb
+}
+
+// PollInterval is how often the workflow job checks its children, default is 1 second
+func (wf *WorkflowBuilder) PollInterval(v time.Duration) *WorkflowBuilder {
+	wf.pollInterval = v
+	return wf
+}
+
+// Step adds a step which performs the job jobName, steps without After start right away
+func (wf *WorkflowBuilder) Step(name string, jobName string) *WorkflowStepBuilder {
+	for _, s := range wf.steps {
+		if s.name == name {
+			panic(fmt.Sprintf("workflow %s step %s already exists", wf.jb.name, name))
+		}
+	}
+	s := &WorkflowStepBuilder{
+		name:    name,
+		jobName: jobName,
+	}
+	wf.steps = append(wf.steps, s)
+	return s
+}
+
+// After makes the step wait until all the child jobs of the steps are done
+func (s *WorkflowStepBuilder) After(steps ...string) *WorkflowStepBuilder {
+	s.after = append(s.after, steps...)
+	return s
+}
+
+// Args builds the arguments of the step job, e.g. from the outputs of the previous steps
+func (s *WorkflowStepBuilder) Args(f func(wc *WorkflowContext) (interface{}, error)) *WorkflowStepBuilder {
+	s.argsF = f
+	return s
+}
+
+// FanOut starts a child job for every returned argument, the steps after it wait for all of them.
+// It should return the same arguments when called again, e.g. after the workflow job is re-enqueued.
+func (s *WorkflowStepBuilder) FanOut(f func(wc *WorkflowContext) ([]interface{}, error)) *WorkflowStepBuilder {
+	s.fanOutF = f
+	return s
+}
+
+func (wf *WorkflowBuilder) validate() {
+	steps := make(map[string]*WorkflowStepBuilder)
+	for _, s := range wf.steps {
+		steps[s.name] = s
+		if wf.b.getJobBuilder(s.jobName) == nil {
+			panic(fmt.Sprintf("workflow %s step %s: no job %s", wf.jb.name, s.name, s.jobName))
+		}
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+	var visit func(s *WorkflowStepBuilder)
+	visit = func(s *WorkflowStepBuilder) {
+		switch marks[s.name] {
+		case visiting:
+			panic(fmt.Sprintf("workflow %s has a cycle at step %s", wf.jb.name, s.name))
+		case visited:
+			return
+		}
+		marks[s.name] = visiting
+		for _, a := range s.after {
+			dep, ok := steps[a]
+			if !ok {
+				panic(fmt.Sprintf("workflow %s step %s: no step %s", wf.jb.name, s.name, a))
+			}
+			visit(dep)
+		}
+		marks[s.name] = visited
+	}
+	for _, s := range wf.steps {
+		visit(s)
+	}
+}
+
+// SetJobOutput stores the output of a job, the next steps of a workflow read it from WorkflowContext
+func SetJobOutput(job QorJobInterface, v interface{}) error {
+	inst, ok := job.(interface {
+		SetOutput(v interface{}) error
+	})
+	if !ok {
+		return errors.New("job does not support output")
+	}
+	return inst.SetOutput(v)
+}
+
+func (job *QorJobInstance) SetOutput(v interface{}) error {
+	output, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	job.Output = string(output)
+	if job.shouldCallSave() {
+		return job.callSave()
+	}
+	return nil
+}
+
+// WorkflowContext gives the step arguments access to the workflow arguments and the outputs of the finished steps
+type WorkflowContext struct {
+	job      QorJobInterface
+	children []*workflowChild
+}
+
+// Job returns the workflow job, e.g. to read its arguments by GetJobInfo
+func (wc *WorkflowContext) Job() QorJobInterface {
+	return wc.job
+}
+
+// Output decodes the output of a single job step into v
+func (wc *WorkflowContext) Output(step string, v interface{}) error {
+	for _, c := range wc.children {
+		if c.Step == step && c.Index == 0 {
+			if c.Output == "" {
+				return nil
+			}
+			return json.Unmarshal([]byte(c.Output), v)
+		}
+	}
+	return fmt.Errorf("no output of step %s", step)
+}
+
+// Outputs decodes the outputs of a fan-out step into v, which should be a pointer to slice
+func (wc *WorkflowContext) Outputs(step string, v interface{}) error {
+	var outputs []json.RawMessage
+	for _, c := range wc.children {
+		if c.Step != step {
+			continue
+		}
+		output := json.RawMessage("null")
+		if c.Output != "" {
+			output = json.RawMessage(c.Output)
+		}
+		outputs = append(outputs, output)
+	}
+	data, err := json.Marshal(outputs)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+type workflowChild struct {
+	QorJobID uint
+	Job      string
+	Step     string
+	Index    int
+	Status   string
+	Progress uint
+	Output   string
+}
+
+func (c *workflowChild) finished() bool {
+	return c.Status == JobStatusDone
+}
+
+func (c *workflowChild) failed() bool {
+	switch c.Status {
+	case JobStatusException, JobStatusDead, JobStatusKilled, JobStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// getWorkflowChildren returns the child jobs ordered by step and index, with the status and output of their last instance
+func (b *Builder) getWorkflowChildren(parentID uint) (children []*workflowChild, err error) {
+	var firsts []*QorJobInstance
+	if err = b.db.Select("qor_job_id", "job", "workflow_step", "workflow_index").
+		Where("parent_qor_job_id = ?", parentID).
+		Order("workflow_step, workflow_index, id").
+		Find(&firsts).Error; err != nil {
+		return
+	}
+	seen := make(map[uint]bool)
+	for _, f := range firsts {
+		if seen[f.QorJobID] {
+			continue
+		}
+		seen[f.QorJobID] = true
+		var inst *QorJobInstance
+		if inst, err = getModelQorJobInstance(b.db, f.QorJobID); err != nil {
+			return
+		}
+		children = append(children, &workflowChild{
+			QorJobID: f.QorJobID,
+			Job:      f.Job,
+			Step:     f.WorkflowStep,
+			Index:    f.WorkflowIndex,
+			Status:   inst.Status,
+			Progress: inst.Progress,
+			Output:   inst.Output,
+		})
+	}
+	return
+}
+
+func (wf *WorkflowBuilder) run(ctx context.Context, job QorJobInterface) (err error) {
+	jobInfo, err := job.GetJobInfo()
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(jobInfo.JobID, 10, 64)
+	if err != nil {
+		return err
+	}
+	parentID := uint(id)
+
+	for {
+		var children []*workflowChild
+		if children, err = wf.b.getWorkflowChildren(parentID); err != nil {
+			return err
+		}
+		for _, c := range children {
+			if c.failed() {
+				wf.abortChildren(children)
+				return fmt.Errorf("workflow step %s job %d is %s", c.Step, c.QorJobID, c.Status)
+			}
+		}
+
+		var started []*QorWorkflowStep
+		if err = wf.b.db.Where("parent_qor_job_id = ?", parentID).Find(&started).Error; err != nil {
+			return err
+		}
+		stepCounts := make(map[string]int)
+		for _, s := range started {
+			stepCounts[s.Step] = s.Count
+		}
+
+		done := 0
+		var progress uint
+		for _, s := range wf.steps {
+			count, ok := stepCounts[s.name]
+			if !ok {
+				if wf.dependenciesDone(s, stepCounts, children) {
+					if err = wf.startStep(ctx, parentID, s, job, children); err != nil {
+						wf.abortChildren(children)
+						return err
+					}
+				}
+				continue
+			}
+			stepProgress, stepDone := stepState(s.name, count, children)
+			progress += stepProgress
+			if stepDone {
+				done++
+			}
+		}
+		if len(wf.steps) > 0 {
+			job.SetProgress(progress / uint(len(wf.steps)))
+		}
+		if done == len(wf.steps) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return wf.interrupted(ctx, parentID)
+		case <-time.After(wf.pollInterval):
+		}
+	}
+}
+
+// interrupted handles the done ctx of the workflow job, only a killed workflow cancels its children.
+// On the shutdown of the queue the children keep running, the workflow is performed again to wait for them.
+func (wf *WorkflowBuilder) interrupted(ctx context.Context, parentID uint) error {
+	inst, err := getModelQorJobInstance(wf.b.db, parentID)
+	if err != nil {
+		return err
+	}
+	if inst.Status == JobStatusKilled {
+		children, err := wf.b.getWorkflowChildren(parentID)
+		if err != nil {
+			return err
+		}
+		wf.abortChildren(children)
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ctx.Err()
+	}
+	return &retryError{err: ctx.Err()}
+}
+
+// stepState returns the progress of a started step, and if all its children are done
+func stepState(step string, count int, children []*workflowChild) (progress uint, done bool) {
+	if count == 0 {
+		return 100, true
+	}
+	var total uint
+	finished := 0
+	for _, c := range children {
+		if c.Step != step {
+			continue
+		}
+		if c.finished() {
+			finished++
+			total += 100
+		} else {
+			total += c.Progress
+		}
+	}
+	return total / uint(count), finished == count
+}
+
+func (wf *WorkflowBuilder) dependenciesDone(s *WorkflowStepBuilder, stepCounts map[string]int, children []*workflowChild) bool {
+	for _, a := range s.after {
+		count, ok := stepCounts[a]
+		if !ok {
+			return false
+		}
+		if _, done := stepState(a, count, children); !done {
+			return false
+		}
+	}
+	return true
+}
+
+// startStep enqueues the child jobs of the step, the children created before a crash are kept
+func (wf *WorkflowBuilder) startStep(ctx context.Context, parentID uint, s *WorkflowStepBuilder, job QorJobInterface, children []*workflowChild) (err error) {
+	wc := &WorkflowContext{job: job, children: children}
+	var argsList []interface{}
+	switch {
+	case s.fanOutF != nil:
+		if argsList, err = s.fanOutF(wc); err != nil {
+			return err
+		}
+	case s.argsF != nil:
+		var args interface{}
+		if args, err = s.argsF(wc); err != nil {
+			return err
+		}
+		argsList = []interface{}{args}
+	default:
+		argsList = []interface{}{nil}
+	}
+
+	exists := make(map[int]bool)
+	for _, c := range children {
+		if c.Step == s.name {
+			exists[c.Index] = true
+		}
+	}
+	jb := wf.b.mustGetJobBuilder(s.jobName)
+	for i, args := range argsList {
+		if exists[i] {
+			continue
+		}
+		if _, err = wf.b.addChildJob(ctx, jb, args, parentID, s.name, i); err != nil {
+			return err
+		}
+	}
+	return wf.b.db.Create(&QorWorkflowStep{
+		ParentQorJobID: parentID,
+		Step:           s.name,
+		Count:          len(argsList),
+	}).Error
+}
+
+func (wf *WorkflowBuilder) abortChildren(children []*workflowChild) {
+	for _, c := range children {
+		if c.finished() || c.failed() {
+			continue
+		}
+		jb := wf.b.getJobBuilder(c.Job)
+		if jb == nil {
+			continue
+		}
+		inst, err := jb.getJobInstance(c.QorJobID)
+		if err != nil {
+			continue
+		}
+		wf.b.doAbortJob(context.Background(), inst)
+	}
+}
+
+// addChildJob is addJob for the steps of a workflow, the relationship is kept on the instance.
+// The job, its instance and the relationship are written in one transaction, so that startStep never sees a half created child.
+func (b *Builder) addChildJob(ctx context.Context, jb *JobBuilder, args interface{}, parentID uint, step string, index int) (j *QorJob, err error) {
+	var enqueue func() error
+	err = b.db.Transaction(func(tx *gorm.DB) error {
+		j = &QorJob{
+			Job:    jb.name,
+			Status: JobStatusNew,
+		}
+		if err := tx.Create(j).Error; err != nil {
+			return err
+		}
+		inst, err := jb.newJobInstance(tx, nil, j.ID, jb.name, args, map[string]interface{}{
+			"WorkflowQorJobID": parentID,
+			"WorkflowStep":     step,
+		})
+		if err != nil {
+			return err
+		}
+		inst.ParentQorJobID = parentID
+		inst.WorkflowStep = step
+		inst.WorkflowIndex = index
+		err = tx.Model(&QorJobInstance{}).Where("id = ?", inst.ID).
+			Updates(map[string]interface{}{
+				"parent_qor_job_id": parentID,
+				"workflow_step":     step,
+				"workflow_index":    index,
+			}).Error
+		if err != nil {
+			return err
+		}
+		queued, err := b.enqueueInTx(ctx, tx, inst)
+		if err != nil || queued {
+			return err
+		}
+		enqueue = func() error {
+			return b.enqueue(ctx, inst)
+		}
+		return nil
+	})
+	if err != nil || enqueue == nil {
+		return
+	}
+	err = enqueue()
+	return
+}